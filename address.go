@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ValidateAddresses checks the format of every Redis address without connecting,
+// returning one error per invalid entry so a config loader can report all of them at
+// once. Accepted formats are host:port, an absolute Unix socket path, and
+// redis://, rediss:// or unix:// URLs.
+func ValidateAddresses(addrs []string) []error {
+	var errs []error
+	for i, addr := range addrs {
+		if err := validateAddress(addr); err != nil {
+			errs = append(errs, fmt.Errorf("invalid Redis address %d (%q): %w", i, addr, err))
+		}
+	}
+	return errs
+}
+
+// validateAddress checks the format of a single Redis address
+func validateAddress(addr string) error {
+	switch {
+	case addr == "":
+		return fmt.Errorf("address is empty")
+	case isRedisURL(addr):
+		if _, err := redis.ParseURL(addr); err != nil {
+			return err
+		}
+		return nil
+	case isUnixSocketPath(addr):
+		return nil
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "" {
+		return fmt.Errorf("missing host")
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil || portNumber < 1 || portNumber > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
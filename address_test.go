@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestValidateAddresses tests that every invalid entry is reported without connecting
+func TestValidateAddresses(t *testing.T) {
+	addrs := []string{
+		"localhost:6379",
+		"redis1:6379",
+		"[::1]:6379",
+		"/var/run/redis/redis.sock",
+		"redis://:secret@redis2:6379/1",
+		"rediss://redis3:6380",
+		"unix:///var/run/redis.sock",
+		"localhost",
+		":6379",
+		"redis4:http",
+		"redis5:70000",
+		"redis://redis6:6379/not-a-db",
+		"",
+	}
+
+	errs := ValidateAddresses(addrs)
+	if len(errs) != 6 {
+		t.Fatalf("Expected 6 invalid addresses, got %d: %v", len(errs), errs)
+	}
+
+	for i, invalid := range []string{`"localhost"`, `":6379"`, `"redis4:http"`, `"redis5:70000"`, `"redis://redis6:6379/not-a-db"`, `""`} {
+		if !strings.Contains(errs[i].Error(), invalid) {
+			t.Errorf("Expected error %d to mention %s, got %v", i, invalid, errs[i])
+		}
+	}
+}
+
+// TestValidateAddressesValid tests that a valid list produces no errors
+func TestValidateAddressesValid(t *testing.T) {
+	if errs := ValidateAddresses([]string{"localhost:6379", "redis://redis2:6379/0"}); len(errs) != 0 {
+		t.Errorf("Expected no errors, got %v", errs)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// adminOperatorLocal is the Fiber local holding the operator authenticated by AdminAuth
+const adminOperatorLocal = "admin_operator"
+
+// ParseAdminTokens parses a comma-separated list of operator=token pairs into a map
+// from token to operator name
+func ParseAdminTokens(spec string) (map[string]string, error) {
+	tokens := make(map[string]string)
+	for _, entry := range strings.Split(spec, ",") {
+		operator, token, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || operator == "" || token == "" {
+			return nil, fmt.Errorf("invalid admin token entry %q, expected operator=token", entry)
+		}
+		if _, ok := tokens[token]; ok {
+			return nil, fmt.Errorf("duplicate admin token for operator %s", operator)
+		}
+		tokens[token] = operator
+	}
+	return tokens, nil
+}
+
+// AdminAuth rejects requests without a known "Authorization: Bearer <token>" header
+// and records the operator owning the token for the admin handlers
+func AdminAuth(tokens map[string]string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		presented, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		if ok {
+			for token, operator := range tokens {
+				if subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1 {
+					c.Locals(adminOperatorLocal, operator)
+					return c.Next()
+				}
+			}
+		}
+
+		log.Printf("WARNING: Rejected admin request to %s from %s - invalid or missing token", c.Path(), c.IP())
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error":   "Unauthorized",
+			"message": "A valid admin token is required.",
+		})
+	}
+}
+
+// limitsRequest is the body of POST /admin/limits
+type limitsRequest struct {
+	Rate     *float64 `json:"rate"`
+	Capacity *float64 `json:"capacity"`
+}
+
+// AdminLimitsHandler changes the limiter's rate and capacity from a JSON body
+// {"rate": ..., "capacity": ...} and responds with the new effective limits.
+// It must be mounted behind AdminAuth.
+func AdminLimitsHandler(limiter *RateLimiter) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req limitsRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "Invalid request body",
+				"message": err.Error(),
+			})
+		}
+		if req.Rate == nil || req.Capacity == nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "Invalid request body",
+				"message": "Both rate and capacity are required.",
+			})
+		}
+
+		oldRate, oldCapacity := limiter.Limits()
+		if err := limiter.SetLimits(*req.Rate, *req.Capacity); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "Invalid limits",
+				"message": err.Error(),
+			})
+		}
+
+		operator, _ := c.Locals(adminOperatorLocal).(string)
+		log.Printf("INFO: Limits changed by operator %s - Rate: %v -> %v tokens/sec, Capacity: %v -> %v",
+			operator, oldRate, *req.Rate, oldCapacity, *req.Capacity)
+
+		rate, capacity := limiter.Limits()
+		return c.JSON(fiber.Map{
+			"rate":     rate,
+			"capacity": capacity,
+		})
+	}
+}
+
+// enforcementRequest is the body of POST /admin/enforcement
+type enforcementRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// AdminEnforcementHandler enables or disables enforcement cluster-wide from a JSON
+// body {"enabled": ...} and responds with the new state. It must be mounted behind
+// AdminAuth.
+func AdminEnforcementHandler(limiter *RateLimiter) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req enforcementRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "Invalid request body",
+				"message": err.Error(),
+			})
+		}
+		if req.Enabled == nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "Invalid request body",
+				"message": "enabled is required.",
+			})
+		}
+
+		if err := limiter.SetEnforcement(*req.Enabled); err != nil {
+			log.Printf("ERROR: Critical Redis Error: Failed to update enforcement - %v", err)
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"error":   "Enforcement update failed",
+				"message": err.Error(),
+			})
+		}
+
+		state := "enabled"
+		if !*req.Enabled {
+			state = "disabled"
+		}
+		operator, _ := c.Locals(adminOperatorLocal).(string)
+		log.Printf("INFO: Enforcement %s by operator %s", state, operator)
+
+		return c.JSON(fiber.Map{
+			"enabled": *req.Enabled,
+		})
+	}
+}
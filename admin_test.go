@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestParseAdminTokens tests parsing of operator=token lists
+func TestParseAdminTokens(t *testing.T) {
+	tokens, err := ParseAdminTokens("alice=secret1, bob=secret2")
+	if err != nil {
+		t.Fatalf("Error parsing admin tokens: %v", err)
+	}
+	if tokens["secret1"] != "alice" || tokens["secret2"] != "bob" || len(tokens) != 2 {
+		t.Errorf("Unexpected tokens %v", tokens)
+	}
+
+	for _, spec := range []string{"alice", "=secret", "alice=", "alice=x,bob=x"} {
+		if _, err := ParseAdminTokens(spec); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
+
+// TestSetLimitsValidation tests that invalid limits are rejected and leave the limiter unchanged
+func TestSetLimitsValidation(t *testing.T) {
+	limiter := NewRateLimiter(nil, 5.0, 10.0)
+
+	for _, limits := range [][2]float64{{0, 10}, {-1, 10}, {5, 0}, {math.NaN(), 10}, {5, math.Inf(1)}} {
+		if err := limiter.SetLimits(limits[0], limits[1]); err == nil {
+			t.Errorf("Expected an error for rate %v, capacity %v", limits[0], limits[1])
+		}
+	}
+	if rate, capacity := limiter.Limits(); rate != 5 || capacity != 10 {
+		t.Errorf("Expected limits to be unchanged, got %v/%v", rate, capacity)
+	}
+
+	if err := limiter.SetLimits(2, 4); err != nil {
+		t.Fatalf("Error setting limits: %v", err)
+	}
+	if rate, capacity := limiter.Limits(); rate != 2 || capacity != 4 {
+		t.Errorf("Expected limits 2/4, got %v/%v", rate, capacity)
+	}
+}
+
+// TestSetLimitsConcurrentAllow tests that limits can change while checks run
+func TestSetLimitsConcurrentAllow(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(5.0, 10.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := limiter.Allow("test_user_set_limits"); err != nil {
+				t.Errorf("Error calling Allow: %v", err)
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			limiter.SetLimits(float64(i+1), 10)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// TestSetRateAndCapacity tests that each setter replaces one limit only
+func TestSetRateAndCapacity(t *testing.T) {
+	limiter := NewRateLimiter(nil, 5.0, 10.0)
+
+	if err := limiter.SetRate(2); err != nil {
+		t.Fatalf("Error setting rate: %v", err)
+	}
+	if err := limiter.SetCapacity(40); err != nil {
+		t.Fatalf("Error setting capacity: %v", err)
+	}
+	if rate, capacity := limiter.Limits(); rate != 2 || capacity != 40 {
+		t.Errorf("Expected limits 2/40, got %v/%v", rate, capacity)
+	}
+
+	if err := limiter.SetRate(0); err == nil {
+		t.Error("Expected an error for rate 0")
+	}
+	if err := limiter.SetCapacity(math.NaN()); err == nil {
+		t.Error("Expected an error for capacity NaN")
+	}
+	if rate, capacity := limiter.Limits(); rate != 2 || capacity != 40 {
+		t.Errorf("Expected limits to be unchanged, got %v/%v", rate, capacity)
+	}
+}
+
+// TestSetRateConcurrentAllow tests that the rate can flip while checks read it, run
+// with -race to detect unguarded accesses. The checks fail against an unreachable
+// shard, but only after reading the limits for the script arguments.
+func TestSetRateConcurrentAllow(t *testing.T) {
+	limiter := newUnreachableLimiter()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			limiter.Allow("test_user_set_rate")
+		}()
+		go func(i int) {
+			defer wg.Done()
+			if err := limiter.SetRate(float64(i%2 + 1)); err != nil {
+				t.Errorf("Error setting rate: %v", err)
+			}
+			limiter.SetCapacity(float64(10 + i))
+		}(i)
+	}
+	wg.Wait()
+}
+
+// TestAdminLimitsEndpoint tests authentication, validation and the effect of POST /admin/limits
+func TestAdminLimitsEndpoint(t *testing.T) {
+	limiter := NewRateLimiter(nil, 5.0, 10.0)
+
+	app := fiber.New()
+	app.Post("/admin/limits", AdminAuth(map[string]string{"secret": "alice"}), AdminLimitsHandler(limiter))
+
+	post := func(token, body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest("POST", "/admin/limits", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		var decoded map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&decoded)
+		return resp.StatusCode, decoded
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		body   string
+		status int
+	}{
+		{"missing token", "", `{"rate": 1, "capacity": 2}`, fiber.StatusUnauthorized},
+		{"wrong token", "guess", `{"rate": 1, "capacity": 2}`, fiber.StatusUnauthorized},
+		{"malformed body", "secret", `{"rate":`, fiber.StatusBadRequest},
+		{"missing capacity", "secret", `{"rate": 1}`, fiber.StatusBadRequest},
+		{"negative rate", "secret", `{"rate": -1, "capacity": 2}`, fiber.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, _ := post(tt.token, tt.body); status != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, status)
+			}
+		})
+	}
+	if rate, capacity := limiter.Limits(); rate != 5 || capacity != 10 {
+		t.Fatalf("Expected rejected requests to leave limits unchanged, got %v/%v", rate, capacity)
+	}
+
+	status, body := post("secret", `{"rate": 20, "capacity": 50}`)
+	if status != fiber.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+	if body["rate"] != 20.0 || body["capacity"] != 50.0 {
+		t.Errorf("Expected the new limits in the response, got %v", body)
+	}
+	if rate, capacity := limiter.Limits(); rate != 20 || capacity != 50 {
+		t.Errorf("Expected limits 20/50, got %v/%v", rate, capacity)
+	}
+}
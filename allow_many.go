@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// defaultPipelineBatchSize is the default maximum number of checks per pipelined flush
+const defaultPipelineBatchSize = 100
+
+// AllowMany checks one request for each of the given userIDs, e.g. for a batch job
+// acting on behalf of many users, returning the results keyed by userID. The checks
+// are grouped by shard and pipelined, so each shard costs one round trip per batch of
+// up to the pipeline batch size (see WithPipelineBatchSize) instead of one per user;
+// shards are processed concurrently. Each check goes through the blocked cache,
+// fallback, lazy migration and decision log like Allow, and each batch holds one slot
+// of its shard's concurrency cap. Duplicate userIDs are checked once. A failed check
+// has no result and its error is included in the returned error; the checks of a
+// shard whose circuit breaker is open all fail with ErrCircuitOpen.
+func (rl *RateLimiter) AllowMany(userIDs []string) (map[string]*AllowResult, error) {
+	return rl.AllowManyCtx(ctx, userIDs)
+}
+
+// AllowManyCtx is like AllowMany but uses the caller's context for the Redis calls
+func (rl *RateLimiter) AllowManyCtx(ctx context.Context, userIDs []string) (map[string]*AllowResult, error) {
+	ids := make([]string, 0, len(userIDs))
+	seen := make(map[string]bool, len(userIDs))
+	for _, userID := range userIDs {
+		if !seen[userID] {
+			seen[userID] = true
+			ids = append(ids, userID)
+		}
+	}
+	results := make([]*AllowResult, len(ids))
+	errs := make([]error, len(ids))
+
+	// Group the positions of the userIDs by shard, the master owning their slot in
+	// cluster mode, on the fallback during a failover. Buckets known to be blocked are
+	// answered without a round trip.
+	manager := rl.checkManager()
+	groups := make(map[*redis.Client][]int)
+	for i, userID := range ids {
+		if rl.blockedCache != nil {
+			if cached, ok := rl.blockedCache.get(rl.bucketKey(userID), 1.0, time.Now()); ok {
+				rl.logDecision(userID, cached, nil)
+				results[i] = cached
+				continue
+			}
+		}
+		client := manager.GetClient(userID)
+		groups[client] = append(groups[client], i)
+	}
+
+	var wg sync.WaitGroup
+	for client, indexes := range groups {
+		wg.Add(1)
+		go func(client *redis.Client, indexes []int) {
+			defer wg.Done()
+			if rl.lazyMigration && manager == rl.manager {
+				for _, index := range indexes {
+					rl.migrateBucket(ctx, ids[index], rl.bucketKey(ids[index]))
+				}
+			}
+
+			shard := manager.shardIndex(ids[indexes[0]])
+			for start := 0; start < len(indexes); start += rl.pipelineBatchSize {
+				end := start + rl.pipelineBatchSize
+				if end > len(indexes) {
+					end = len(indexes)
+				}
+				rl.allowBatch(ctx, manager, shard, client, ids, indexes[start:end], results, errs)
+			}
+		}(client, indexes)
+	}
+	wg.Wait()
+
+	allowed := make(map[string]*AllowResult, len(ids))
+	for i, result := range results {
+		if result != nil {
+			allowed[ids[i]] = result
+		}
+	}
+	return allowed, errors.Join(errs...)
+}
+
+// allowBatch runs the checks of the userIDs at the given indexes in one pipelined flush
+// to client, the given shard of manager, behind the shard's circuit breaker and
+// concurrency cap. Each outcome is stored at its index in results or errs.
+func (rl *RateLimiter) allowBatch(ctx context.Context, manager *RedisShardManager, shard int, client *redis.Client, userIDs []string, indexes []int, results []*AllowResult, errs []error) {
+	var cmds []*redis.Cmd
+	err := manager.guardShard(shard, func() error {
+		release, err := rl.acquireShard(ctx, manager, shard)
+		if err != nil {
+			return err
+		}
+		defer release()
+		cmds = rl.runBatch(ctx, client, userIDs, indexes)
+
+		// The first Redis error of the batch counts for the circuit breaker
+		for _, cmd := range cmds {
+			if err := cmd.Err(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if cmds == nil {
+		// The batch wasn't sent: the circuit is open, the shard is busy or the context ended
+		log.Printf("WARNING: Rate limit checks of %d userIDs not sent to Redis - %v", len(indexes), err)
+		if errors.Is(err, ErrCircuitOpen) {
+			rl.recordCheck(manager, shard, err)
+		}
+		for _, index := range indexes {
+			errs[index] = fmt.Errorf("userID %s: %w", userIDs[index], err)
+			rl.logDecision(userIDs[index], nil, err)
+		}
+		return
+	}
+	rl.recordCheck(manager, shard, err)
+
+	for i, cmd := range cmds {
+		index := indexes[i]
+		userID := userIDs[index]
+		result, err := cmd.Result()
+		if err != nil {
+			log.Printf("ERROR: Critical Redis Error: Lua script execution failure for userID %s - %v", userID, err)
+			errs[index] = fmt.Errorf("failed to execute rate limit script for userID %s: %w", userID, err)
+			rl.logDecision(userID, nil, err)
+			continue
+		}
+
+		allowResult, err := rl.finishCheck(userID, rl.bucketKey(userID), 1.0, result)
+		if err != nil {
+			errs[index] = fmt.Errorf("userID %s: %w", userID, err)
+			continue
+		}
+		results[index] = allowResult
+	}
+}
+
+// runBatch pipelines the checks of the userIDs at the given indexes to client,
+// returning one command per index
+func (rl *RateLimiter) runBatch(ctx context.Context, client *redis.Client, userIDs []string, indexes []int) []*redis.Cmd {
+	script := rl.bucketScript(tokenBucketLuaScript)
+	args := rl.bucketArgs(1.0)
+
+	cmds := rl.pipelineChecks(ctx, client, userIDs, indexes, args, script.EvalSha)
+
+	// Commands rejected with NOSCRIPT didn't run, so they can safely be sent again
+	// with the full script, which also caches it for the next batch
+	var retry, retryIndexes []int
+	for i, cmd := range cmds {
+		if isNoScriptError(cmd.Err()) {
+			retry = append(retry, i)
+			retryIndexes = append(retryIndexes, indexes[i])
+		}
+	}
+	if len(retry) > 0 {
+		retried := rl.pipelineChecks(ctx, client, userIDs, retryIndexes, args, script.Eval)
+		for j, i := range retry {
+			cmds[i] = retried[j]
+		}
+	}
+	return cmds
+}
+
+// pipelineChecks sends the checks of the userIDs at the given indexes in one pipeline
+// using eval (EvalSha or Eval), returning one command per index
+func (rl *RateLimiter) pipelineChecks(ctx context.Context, client *redis.Client, userIDs []string, indexes []int, args []interface{},
+	eval func(ctx context.Context, c redis.Scripter, keys []string, args ...interface{}) *redis.Cmd) []*redis.Cmd {
+	pipe := client.Pipeline()
+	cmds := make([]*redis.Cmd, len(indexes))
+	for i, index := range indexes {
+		cmds[i] = eval(ctx, pipe, []string{rl.bucketKey(userIDs[index])}, args...)
+	}
+	// Per-command errors are reported through cmds
+	_, _ = pipe.Exec(ctx)
+	return cmds
+}
+
+// isNoScriptError reports whether err is Redis' NOSCRIPT error for an uncached script
+func isNoScriptError(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT")
+}
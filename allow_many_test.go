@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// TestAllowManyBatches tests that AllowMany processes more users than the pipeline
+// batch size correctly across multiple flushes
+func TestAllowManyBatches(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.001, 2.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+	WithPipelineBatchSize(7)(limiter)
+
+	// 25 users need 4 flushes on a single shard
+	userIDs := make([]string, 25)
+	for i := range userIDs {
+		userIDs[i] = fmt.Sprintf("test_many_%d", i)
+	}
+
+	// Start without the script cached so the first flush falls back to EVAL
+	for _, shard := range limiter.manager.shards {
+		shard.ScriptFlush(testCtx)
+	}
+
+	for round, expectAllowed := range []bool{true, true, false} {
+		results, err := limiter.AllowMany(userIDs)
+		if err != nil {
+			t.Fatalf("AllowMany failed: %v", err)
+		}
+		if len(results) != len(userIDs) {
+			t.Fatalf("Expected %d results, got %d", len(userIDs), len(results))
+		}
+		for _, userID := range userIDs {
+			result := results[userID]
+			if result == nil {
+				t.Fatalf("Round %d: missing result for %s", round+1, userID)
+			}
+			if result.Allowed != expectAllowed {
+				t.Errorf("Round %d: expected allowed=%v for %s, got %+v", round+1, expectAllowed, userID, result)
+			}
+		}
+	}
+
+	// Every user was charged exactly twice
+	for _, userID := range userIDs {
+		assertTokens(t, limiter, userID, 0)
+	}
+}
+
+// roundTripHook is a go-redis hook counting the round trips of a client: one per
+// single command and one per pipeline
+type roundTripHook struct {
+	commands, pipelines atomic.Int64
+}
+
+func (h *roundTripHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	h.commands.Add(1)
+	return ctx, nil
+}
+
+func (h *roundTripHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (h *roundTripHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	h.pipelines.Add(1)
+	return ctx, nil
+}
+
+func (h *roundTripHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+// TestAllowManyShards tests that AllowMany maps the results of users on the same and
+// on different shards back to their userID, with one round trip per shard
+func TestAllowManyShards(t *testing.T) {
+	_, cleanup, err := setupTestRateLimiter(0.001, 3.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	// Two shards on the same server, each with its own client and counter
+	redisAddr := os.Getenv("REDIS_ADDR")
+	if redisAddr == "" {
+		redisAddr = "localhost:6379"
+	}
+	hooks := []*roundTripHook{{}, {}}
+	manager := &RedisShardManager{}
+	for _, hook := range hooks {
+		client := redis.NewClient(&redis.Options{Addr: redisAddr})
+		defer client.Close()
+		client.AddHook(hook)
+		manager.shards = append(manager.shards, client)
+	}
+	limiter := NewRateLimiter(manager, 0.001, 3.0)
+
+	// Three users per shard, interleaved
+	var byShard [2][]string
+	for i := 0; len(byShard[0]) < 3 || len(byShard[1]) < 3; i++ {
+		userID := fmt.Sprintf("test_many_shards_%d", i)
+		if shard := manager.shardIndex(userID); len(byShard[shard]) < 3 {
+			byShard[shard] = append(byShard[shard], userID)
+		}
+	}
+	var userIDs []string
+	for i := 0; i < 3; i++ {
+		userIDs = append(userIDs, byShard[0][i], byShard[1][i])
+	}
+	// Charge the first user of each shard once beforehand, so results tell users apart
+	for _, userID := range []string{byShard[0][0], byShard[1][0]} {
+		if _, err := limiter.Allow(userID); err != nil {
+			t.Fatalf("Error calling Allow: %v", err)
+		}
+	}
+	for _, hook := range hooks {
+		hook.commands.Store(0)
+		hook.pipelines.Store(0)
+	}
+
+	results, err := limiter.AllowMany(userIDs)
+	if err != nil {
+		t.Fatalf("AllowMany failed: %v", err)
+	}
+	for _, userID := range userIDs {
+		expected := 2.0
+		if userID == byShard[0][0] || userID == byShard[1][0] {
+			expected = 1.0
+		}
+		if result := results[userID]; result == nil || !result.Allowed || math.Abs(result.Remaining-expected) > 0.01 {
+			t.Errorf("Expected %s allowed with %v remaining, got %+v", userID, expected, result)
+		}
+	}
+	for shard, hook := range hooks {
+		if pipelines, commands := hook.pipelines.Load(), hook.commands.Load(); pipelines != 1 || commands != 0 {
+			t.Errorf("Expected one round trip to shard %d, got %d pipelines and %d commands", shard, pipelines, commands)
+		}
+	}
+}
+
+// TestAllowManyPerCheck tests that AllowMany checks duplicate userIDs once, answers
+// buckets known to be blocked from the cache and logs every decision
+func TestAllowManyPerCheck(t *testing.T) {
+	base, cleanup, err := setupTestRateLimiter(1.0, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	exporter := &recordingExporter{}
+	decisionLog := NewDecisionLog(exporter, DecisionLogConfig{})
+	limiter := NewRateLimiter(base.manager, 0.01, 1.0, WithBlockedCache(10), WithDecisionLog(decisionLog))
+	blockedID, freshID := "test_many_blocked", "test_many_fresh"
+	client := limiter.manager.GetClient(blockedID)
+	client.Del(testCtx, testBucketKey(blockedID))
+	limiter.manager.GetClient(freshID).Del(testCtx, testBucketKey(freshID))
+
+	limiter.Allow(blockedID)
+	if result, err := limiter.Allow(blockedID); err != nil || result.Allowed {
+		t.Fatalf("Expected %s blocked, got %+v, %v", blockedID, result, err)
+	}
+
+	// The blocked bucket is reset behind the limiter's back, so only the cache blocks it
+	client.Del(testCtx, testBucketKey(blockedID))
+	results, err := limiter.AllowMany([]string{blockedID, freshID, freshID})
+	if err != nil {
+		t.Fatalf("AllowMany failed: %v", err)
+	}
+	if result := results[blockedID]; result == nil || result.Allowed {
+		t.Errorf("Expected the cached block of %s, got %+v", blockedID, result)
+	}
+	if exists, _ := client.Exists(testCtx, testBucketKey(blockedID)).Result(); exists != 0 {
+		t.Error("Expected the cached decision not to reach Redis")
+	}
+	if result := results[freshID]; result == nil || !result.Allowed {
+		t.Errorf("Expected %s allowed once, got %+v", freshID, result)
+	}
+
+	if err := decisionLog.Close(context.Background()); err != nil {
+		t.Fatalf("Failed to close decision log: %v", err)
+	}
+	// Two checks before AllowMany and one per distinct userID
+	if len(exporter.records) != 4 {
+		t.Errorf("Expected 4 decision records, got %d: %+v", len(exporter.records), exporter.records)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// bandwidthKeyPrefix namespaces bandwidth buckets so they never collide with
+// request-count buckets for the same client
+const bandwidthKeyPrefix = "bw:"
+
+// bandwidthAdmissionLocal is the Fiber local holding the admission result of BandwidthMiddleware
+const bandwidthAdmissionLocal = "ratelimit_bandwidth_admission"
+
+// bandwidthUserID returns the bandwidth bucket identifier for the request's client
+func bandwidthUserID(c *fiber.Ctx) string {
+	return bandwidthKeyPrefix + c.IP()
+}
+
+// BandwidthMiddleware creates a Fiber middleware that limits clients by bytes served
+// instead of request count. The limiter's rate and capacity are expressed in bytes,
+// e.g. NewRateLimiter(manager, 1<<20, 1<<20) allows 1MB/sec per client.
+//
+// The size of a response is only known once the handler has generated it, so the
+// bytes are charged after the response is produced. A single large response can
+// therefore overshoot the remaining budget; the overshoot drains the bucket and
+// subsequent requests are blocked until it refills. This makes the limit an
+// approximate cap rather than a strict one.
+func BandwidthMiddleware(limiter *RateLimiter) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Extract client identifier (IP address) in the bandwidth namespace
+		userID := bandwidthUserID(c)
+
+		// Admit the request only if at least one byte of budget is left
+		result, err := limiter.AllowN(userID, 1.0)
+		if err != nil {
+			// On error, allow the request but log the error (fail-open policy)
+			log.Printf("ERROR: Critical Redis Error: Bandwidth limiter execution failure for userID %s - %v. Falling back to Fail-Open Policy.", userID, err)
+			return c.Next()
+		}
+
+		if !result.Allowed {
+			retryAfter := retryAfterHeaderSeconds(result.RetryAfter)
+
+			c.Set(CanonicalHeaders.Limit, fmt.Sprintf("%.0f", limiter.Capacity()))
+			c.Set(CanonicalHeaders.Remaining, fmt.Sprintf("%.0f", result.Remaining))
+			c.Set(CanonicalHeaders.RetryAfter, fmt.Sprintf("%d", retryAfter))
+
+			log.Printf("INFO: Decision: BLOCKED (429) - userID: %s, Reason: Bandwidth limit exceeded, Retry-After: %d seconds", userID, retryAfter)
+
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error":   "Bandwidth limit exceeded",
+				"message": "Too much data transferred. Please try again later.",
+			})
+		}
+
+		// Make the admission available to StreamBandwidth for streamed bodies
+		c.Locals(bandwidthAdmissionLocal, result)
+
+		if err := c.Next(); err != nil {
+			return err
+		}
+
+		// Streamed bodies are charged by StreamBandwidth once the stream completes;
+		// reading the body here would buffer the whole stream in memory
+		if c.Response().IsBodyStream() {
+			return nil
+		}
+
+		// Charge the bytes actually served, minus the byte already taken on admission
+		size := float64(len(c.Response().Body()))
+		if _, err := chargeBandwidth(limiter, userID, size-1.0, result.Remaining); err != nil {
+			log.Printf("ERROR: Critical Redis Error: Bandwidth charge failure for userID %s - %v", userID, err)
+			return nil
+		}
+
+		log.Printf("INFO: Decision: CHARGED - userID: %s, Bytes: %.0f", userID, size)
+
+		return nil
+	}
+}
+
+// chargeBandwidth charges bytes against the userID's bandwidth bucket, refunding when
+// bytes is negative. If the charge overshoots the budget, whatever is left is drained
+// so following requests are blocked until the bucket refills. before is the budget
+// known to remain before the charge; the remaining budget after the charge is returned.
+func chargeBandwidth(limiter *RateLimiter, userID string, bytes, before float64) (float64, error) {
+	if bytes <= 0 {
+		if bytes < 0 {
+			if err := limiter.Refund(userID, -bytes); err != nil {
+				return before, err
+			}
+		}
+		return math.Min(limiter.Capacity(), before-bytes), nil
+	}
+
+	charge, err := limiter.AllowN(userID, bytes)
+	if err != nil {
+		return before, err
+	}
+	if charge.Allowed || charge.Remaining <= 0 {
+		return charge.Remaining, nil
+	}
+
+	// The response overshot the budget, drain whatever is left
+	if _, err := limiter.AllowN(userID, charge.Remaining); err != nil {
+		return charge.Remaining, err
+	}
+	return 0, nil
+}
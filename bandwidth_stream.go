@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// StreamBandwidth streams body as a chunked response and, once the stream has
+// completed, charges the bytes sent to the client's bandwidth bucket. It's meant
+// for handlers behind BandwidthMiddleware using the same limiter.
+//
+// The headers of a streamed response are sent before the final size is known, so
+// the remaining budget is reported as the X-RateLimit-Limit and X-RateLimit-Remaining
+// HTTP trailers when the transport supports them: HTTP/1.1 clients that announce
+// "TE: trailers". fasthttp only writes trailers for chunked HTTP/1.1 responses and
+// takes their values from the response header once the body is exhausted, and it has
+// no HTTP/2 support. For any other client the budget known before streaming is sent
+// as regular headers instead.
+func StreamBandwidth(c *fiber.Ctx, limiter *RateLimiter, body io.Reader) error {
+	userID := bandwidthUserID(c)
+
+	// Start from the admission of BandwidthMiddleware, which already charged one byte
+	before := limiter.Capacity()
+	admitted := 0.0
+	if admission, ok := c.Locals(bandwidthAdmissionLocal).(*AllowResult); ok {
+		before = admission.Remaining
+		admitted = 1.0
+	}
+
+	stream := &bandwidthStream{
+		body:     body,
+		limiter:  limiter,
+		userID:   userID,
+		admitted: admitted,
+		before:   before,
+	}
+
+	limit := fmt.Sprintf("%.0f", limiter.Capacity())
+	if supportsTrailers(c) {
+		resp := c.Response()
+		if err := resp.Header.SetTrailer(CanonicalHeaders.Limit + ", " + CanonicalHeaders.Remaining); err != nil {
+			return fmt.Errorf("failed to declare rate limit trailers: %w", err)
+		}
+		stream.setTrailer = func(remaining float64) {
+			resp.Header.Set(CanonicalHeaders.Limit, limit)
+			resp.Header.Set(CanonicalHeaders.Remaining, fmt.Sprintf("%.0f", remaining))
+		}
+	} else {
+		c.Set(CanonicalHeaders.Limit, limit)
+		c.Set(CanonicalHeaders.Remaining, fmt.Sprintf("%.0f", before))
+	}
+
+	c.Context().SetBodyStream(stream, -1)
+	return nil
+}
+
+// supportsTrailers reports whether rate limit info can be sent as trailers to the client
+func supportsTrailers(c *fiber.Ctx) bool {
+	return c.Request().Header.IsHTTP11() && strings.Contains(strings.ToLower(c.Get(fiber.HeaderTE)), "trailers")
+}
+
+// bandwidthStream counts the bytes read from body and charges them when the stream
+// ends. fasthttp reads the body stream on the goroutine that writes the response and
+// writes the trailers right after the stream returns io.EOF, so setting the trailer
+// values at EOF is safe. Close charges streams that end early, e.g. on disconnect.
+type bandwidthStream struct {
+	body       io.Reader
+	limiter    *RateLimiter
+	userID     string
+	admitted   float64
+	before     float64
+	setTrailer func(remaining float64)
+
+	written int64
+	charged bool
+}
+
+// Read reads from the wrapped body and charges the stream once it's exhausted
+func (s *bandwidthStream) Read(p []byte) (int, error) {
+	n, err := s.body.Read(p)
+	s.written += int64(n)
+	if err == io.EOF {
+		s.charge()
+	}
+	return n, err
+}
+
+// Close charges the stream if it wasn't read to the end and closes the wrapped body
+func (s *bandwidthStream) Close() error {
+	s.charge()
+	if closer, ok := s.body.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// charge charges the bytes written so far, at most once
+func (s *bandwidthStream) charge() {
+	if s.charged {
+		return
+	}
+	s.charged = true
+
+	remaining, err := chargeBandwidth(s.limiter, s.userID, float64(s.written)-s.admitted, s.before)
+	if err != nil {
+		log.Printf("ERROR: Critical Redis Error: Bandwidth charge failure for userID %s - %v", s.userID, err)
+	}
+	if s.setTrailer != nil {
+		s.setTrailer(remaining)
+	}
+
+	log.Printf("INFO: Decision: CHARGED - userID: %s, Bytes: %d (streamed)", s.userID, s.written)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestStreamBandwidthTrailers tests that streamed responses report the remaining byte
+// budget as trailers, falling back to headers for clients that don't accept trailers
+func TestStreamBandwidthTrailers(t *testing.T) {
+	// Setup: Capacity 1000 bytes, refill of 1 byte/sec so the budget doesn't recover during the test
+	limiter, cleanup, err := setupTestRateLimiter(1.0, 1000.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	app := fiber.New()
+	app.Get("/stream", BandwidthMiddleware(limiter), func(c *fiber.Ctx) error {
+		return StreamBandwidth(c, limiter, strings.NewReader(strings.Repeat("x", 600)))
+	})
+
+	userID := bandwidthKeyPrefix + testClientIP
+	client := limiter.manager.GetClient(userID)
+	client.Del(testCtx, testBucketKey(userID))
+	defer client.Del(testCtx, testBucketKey(userID))
+
+	// A client accepting trailers learns the budget left after the stream
+	req := httptest.NewRequest("GET", "/stream", nil)
+	req.Header.Set("TE", "trailers")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read body: %v", err)
+	}
+	if len(body) != 600 {
+		t.Fatalf("Expected 600 streamed bytes, got %d", len(body))
+	}
+
+	remaining, err := strconv.ParseFloat(resp.Trailer.Get("X-RateLimit-Remaining"), 64)
+	if err != nil {
+		t.Fatalf("Invalid remaining trailer %q: %v", resp.Trailer.Get("X-RateLimit-Remaining"), err)
+	}
+	if remaining < 399 || remaining > 401 {
+		t.Errorf("Expected about 400 bytes remaining in the trailer, got %.0f", remaining)
+	}
+	if resp.Trailer.Get("X-RateLimit-Limit") != "1000" {
+		t.Errorf("Expected limit trailer of 1000, got %q", resp.Trailer.Get("X-RateLimit-Limit"))
+	}
+
+	// The bucket was charged for the streamed bytes
+	tokens, err := client.HGet(testCtx, testBucketKey(userID), "tokens").Float64()
+	if err != nil {
+		t.Fatalf("Failed to read bucket state: %v", err)
+	}
+	if tokens < 399 || tokens > 401 {
+		t.Errorf("Expected about 400 bytes of budget left after streaming 600 bytes, got %.2f", tokens)
+	}
+
+	// Without trailer support the pre-stream budget is sent as a header
+	resp, err = app.Test(httptest.NewRequest("GET", "/stream", nil))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	io.ReadAll(resp.Body)
+
+	remaining, err = strconv.ParseFloat(resp.Header.Get("X-RateLimit-Remaining"), 64)
+	if err != nil {
+		t.Fatalf("Invalid remaining header %q: %v", resp.Header.Get("X-RateLimit-Remaining"), err)
+	}
+	if remaining < 398 || remaining > 400 {
+		t.Errorf("Expected about 399 bytes remaining in the fallback header, got %.0f", remaining)
+	}
+	if len(resp.Trailer) != 0 {
+		t.Errorf("Expected no trailers for a client without trailer support, got %v", resp.Trailer)
+	}
+}
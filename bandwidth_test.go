@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestBandwidthMiddleware tests that bytes served are charged after the response
+// and that exceeding the byte budget blocks subsequent requests
+func TestBandwidthMiddleware(t *testing.T) {
+	// Setup: Capacity 1000 bytes, refill of 1 byte/sec so the budget doesn't recover during the test
+	limiter, cleanup, err := setupTestRateLimiter(1.0, 1000.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	app := fiber.New()
+	app.Get("/download", BandwidthMiddleware(limiter), func(c *fiber.Ctx) error {
+		return c.SendString(strings.Repeat("x", 600))
+	})
+
+	// Clear any existing state for the test client (app.Test uses 0.0.0.0 as the remote IP)
+	userID := bandwidthKeyPrefix + "0.0.0.0"
+	client := limiter.manager.GetClient(userID)
+	client.Del(testCtx, testBucketKey(userID))
+	defer client.Del(testCtx, testBucketKey(userID))
+
+	// First response fits within the budget
+	resp, err := app.Test(httptest.NewRequest("GET", "/download", nil))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("Expected first download to succeed, got status %d", resp.StatusCode)
+	}
+
+	remaining, err := client.HGet(testCtx, testBucketKey(userID), "tokens").Float64()
+	if err != nil {
+		t.Fatalf("Failed to read bucket state: %v", err)
+	}
+	if remaining < 399 || remaining > 401 {
+		t.Errorf("Expected about 400 bytes of budget left after serving 600 bytes, got %.2f", remaining)
+	}
+
+	// Second response is admitted but overshoots the budget, draining the bucket
+	resp, err = app.Test(httptest.NewRequest("GET", "/download", nil))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("Expected second download to be admitted, got status %d", resp.StatusCode)
+	}
+
+	// Third request is blocked because the byte budget is exhausted
+	resp, err = app.Test(httptest.NewRequest("GET", "/download", nil))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusTooManyRequests {
+		t.Errorf("Expected download to be blocked after exceeding the byte budget, got status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// blockedEntry is a cached blocked decision
+type blockedEntry struct {
+	key       string    // bucket key of the decision
+	until     time.Time // expiry of the cached decision
+	retryAt   time.Time // end of the retry-after of the blocked check
+	resetAt   time.Time // when the bucket is full again, as of the blocked check
+	tokens    float64   // tokens requested by the blocked check
+	remaining float64   // tokens left in the bucket at the blocked check
+}
+
+// blockedCache remembers blocked decisions in process until their retry-after passes,
+// evicting the least recently used decision when full
+type blockedCache struct {
+	mu         sync.Mutex
+	entries    map[string]*list.Element // elements hold a *blockedEntry
+	order      *list.List               // most recently used first
+	maxEntries int
+}
+
+// newBlockedCache creates a cache holding at most maxEntries blocked decisions
+func newBlockedCache(maxEntries int) *blockedCache {
+	return &blockedCache{
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		maxEntries: maxEntries,
+	}
+}
+
+// WithBlockedCache caches up to maxEntries blocked decisions in process: once a check
+// is blocked with a retry-after, checks of the same bucket for at least as many tokens
+// are answered as blocked without a Redis round trip until the retry-after passes.
+// Only blocked decisions are cached, so the cache never lets a request through.
+//
+// The cache can over-block slightly near the end of a retry-after: refunds, resets and
+// limit changes made through this limiter drop the affected entries, but those made by
+// other instances, or a faster refill than the retry-after assumed, aren't seen until
+// the cached retry-after ends.
+func WithBlockedCache(maxEntries int) LimiterOption {
+	return func(rl *RateLimiter) {
+		if maxEntries > 0 {
+			rl.blockedCache = newBlockedCache(maxEntries)
+		}
+	}
+}
+
+// get returns the cached blocked decision of key for a check of tokens, if any
+func (bc *blockedCache) get(key string, tokens float64, now time.Time) (*AllowResult, bool) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	element, ok := bc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*blockedEntry)
+	if !now.Before(entry.until) {
+		bc.remove(element)
+		return nil, false
+	}
+	// A smaller check may already fit before the retry-after of the cached one
+	if tokens < entry.tokens {
+		return nil, false
+	}
+
+	bc.order.MoveToFront(element)
+	return &AllowResult{
+		Allowed:    false,
+		Remaining:  entry.remaining,
+		RetryAfter: max(0, entry.retryAt.Sub(now)),
+		ResetAt:    entry.resetAt,
+	}, true
+}
+
+// forget drops the cached decision of key
+func (bc *blockedCache) forget(key string) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if element, ok := bc.entries[key]; ok {
+		bc.remove(element)
+	}
+}
+
+// clear drops all cached decisions
+func (bc *blockedCache) clear() {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	clear(bc.entries)
+	bc.order.Init()
+}
+
+// remove drops the decision of element
+func (bc *blockedCache) remove(element *list.Element) {
+	delete(bc.entries, element.Value.(*blockedEntry).key)
+	bc.order.Remove(element)
+}
+
+// add caches a blocked decision of key for its retry-after, evicting expired entries,
+// or the least recently used one if none expired, when the cache is full
+func (bc *blockedCache) add(key string, tokens float64, result *AllowResult, now time.Time) {
+	bc.addFor(key, tokens, result, result.RetryAfter, now)
+}
+
+// addFor caches a blocked decision of key for ttl, which may be shorter than its
+// retry-after
+func (bc *blockedCache) addFor(key string, tokens float64, result *AllowResult, ttl time.Duration, now time.Time) {
+	if result.Allowed || ttl <= 0 {
+		return
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	entry := &blockedEntry{
+		key:       key,
+		until:     now.Add(ttl),
+		retryAt:   now.Add(result.RetryAfter),
+		resetAt:   result.ResetAt,
+		tokens:    tokens,
+		remaining: result.Remaining,
+	}
+	if element, ok := bc.entries[key]; ok {
+		element.Value = entry
+		bc.order.MoveToFront(element)
+		return
+	}
+
+	if len(bc.entries) >= bc.maxEntries {
+		for element := bc.order.Back(); element != nil; {
+			previous := element.Prev()
+			if !now.Before(element.Value.(*blockedEntry).until) {
+				bc.remove(element)
+			}
+			element = previous
+		}
+		for len(bc.entries) >= bc.maxEntries {
+			bc.remove(bc.order.Back())
+		}
+	}
+	bc.entries[key] = bc.order.PushFront(entry)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestBlockedCacheShortCircuits tests that blocked checks are answered from the cache until the retry-after passes
+func TestBlockedCacheShortCircuits(t *testing.T) {
+	base, cleanup, err := setupTestRateLimiter(1.0, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	limiter := NewRateLimiter(base.manager, 0.01, 2.0, WithBlockedCache(10))
+	userID := "test_user_blocked_cache"
+	client := limiter.manager.GetClient(userID)
+	client.Del(testCtx, testBucketKey(userID))
+
+	if _, err := limiter.AllowN(userID, 2); err != nil {
+		t.Fatalf("Error calling AllowN: %v", err)
+	}
+	blocked, err := limiter.AllowN(userID, 2)
+	if err != nil {
+		t.Fatalf("Error calling AllowN: %v", err)
+	}
+	if blocked.Allowed {
+		t.Fatal("Expected the second check to be blocked")
+	}
+
+	// Buckets refilled behind the limiter's back, e.g. by another instance, aren't seen
+	client.Del(testCtx, testBucketKey(userID))
+	cached, err := limiter.AllowN(userID, 2)
+	if err != nil {
+		t.Fatalf("Error calling AllowN: %v", err)
+	}
+	if cached.Allowed || cached.RetryAfter <= 0 || cached.RetryAfter > blocked.RetryAfter {
+		t.Errorf("Expected a cached blocked decision, got %+v", cached)
+	}
+	if exists, _ := client.Exists(testCtx, testBucketKey(userID)).Result(); exists != 0 {
+		t.Error("Expected the cached decision not to reach Redis")
+	}
+
+	// Smaller checks may fit earlier and are sent to Redis
+	if result, err := limiter.Allow(userID); err != nil || !result.Allowed {
+		t.Errorf("Expected a smaller check to reach the reset bucket, got %+v, %v", result, err)
+	}
+}
+
+// TestBlockedCacheRefund tests that a refund drops the cached decision
+func TestBlockedCacheRefund(t *testing.T) {
+	base, cleanup, err := setupTestRateLimiter(1.0, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	limiter := NewRateLimiter(base.manager, 0.01, 1.0, WithBlockedCache(10))
+	userID := "test_user_blocked_cache_refund"
+	limiter.manager.GetClient(userID).Del(testCtx, testBucketKey(userID))
+
+	limiter.Allow(userID)
+	if result, _ := limiter.Allow(userID); result.Allowed {
+		t.Fatal("Expected the second check to be blocked")
+	}
+	if err := limiter.Refund(userID, 1); err != nil {
+		t.Fatalf("Error calling Refund: %v", err)
+	}
+	if result, err := limiter.Allow(userID); err != nil || !result.Allowed {
+		t.Errorf("Expected the refunded token to be usable, got %+v, %v", result, err)
+	}
+}
+
+// TestBlockedCacheBounded tests that the cache never exceeds its size and evicts expired entries first
+func TestBlockedCacheBounded(t *testing.T) {
+	cache := newBlockedCache(3)
+	now := time.Now()
+	blocked := func(retryAfter time.Duration) *AllowResult {
+		return &AllowResult{Allowed: false, RetryAfter: retryAfter}
+	}
+
+	cache.add("expiring", 1, blocked(time.Second), now)
+	cache.add("a", 1, blocked(time.Minute), now)
+	cache.add("b", 1, blocked(time.Minute), now)
+
+	// The expired entry makes room once its retry-after passed
+	later := now.Add(2 * time.Second)
+	cache.add("c", 1, blocked(time.Minute), later)
+	for _, key := range []string{"a", "b", "c"} {
+		if _, ok := cache.get(key, 1, later); !ok {
+			t.Errorf("Expected %s to be cached", key)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		cache.add(fmt.Sprintf("user-%d", i), 1, blocked(time.Minute), later)
+	}
+	if len(cache.entries) != 3 {
+		t.Errorf("Expected 3 cached entries, got %d", len(cache.entries))
+	}
+
+	// Allowed decisions and decisions without a retry-after aren't cached
+	cache.clear()
+	cache.add("allowed", 1, &AllowResult{Allowed: true}, now)
+	cache.add("no-wait", 1, blocked(0), now)
+	if len(cache.entries) != 0 {
+		t.Errorf("Expected nothing cached, got %v", cache.entries)
+	}
+}
+
+// TestBlockedCacheLRU tests that the least recently used decision is evicted first
+func TestBlockedCacheLRU(t *testing.T) {
+	cache := newBlockedCache(2)
+	now := time.Now()
+	blocked := &AllowResult{Allowed: false, RetryAfter: time.Minute}
+
+	cache.add("a", 1, blocked, now)
+	cache.add("b", 1, blocked, now)
+	cache.get("a", 1, now)
+	cache.add("c", 1, blocked, now)
+
+	if _, ok := cache.get("b", 1, now); ok {
+		t.Error("Expected the least recently used entry to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := cache.get(key, 1, now); !ok {
+			t.Errorf("Expected %s to be cached", key)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrCircuitOpen is returned by checks of a shard whose circuit breaker is open,
+// without trying Redis
+var ErrCircuitOpen = errors.New("circuit breaker open for shard")
+
+// Default settings of SetCircuitBreaker
+const (
+	defaultBreakerWindow   = 10 * time.Second
+	defaultBreakerCooldown = 5 * time.Second
+)
+
+// BreakerState is the state of a shard's circuit breaker
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // checks reach the shard
+	BreakerOpen                         // checks fail without trying the shard
+	BreakerHalfOpen                     // one probing check reaches the shard
+)
+
+// String returns the lower case name of the state
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitBreakerConfig configures the circuit breaker of each shard
+type CircuitBreakerConfig struct {
+	Failures int           // consecutive failures within Window opening the circuit, 0 to disable
+	Window   time.Duration // time the consecutive failures must fall within (default 10s)
+	Cooldown time.Duration // time an open circuit waits before probing the shard (default 5s)
+}
+
+// circuitBreaker tracks the failures of one shard
+type circuitBreaker struct {
+	state        BreakerState
+	failures     int       // consecutive failures while closed
+	firstFailure time.Time // time of the first of the consecutive failures
+	openedAt     time.Time
+	probing      bool // a half-open probe is in flight
+}
+
+// breakers holds the circuit breakers of a manager's shards
+type breakers struct {
+	config CircuitBreakerConfig
+
+	mu     sync.Mutex
+	shards map[int]*circuitBreaker
+}
+
+// SetCircuitBreaker enables a circuit breaker on each shard, so that checks of a
+// dead shard fail at once instead of each waiting for the dial timeout. Once
+// Failures consecutive checks of a shard failed to reach it within Window, its
+// circuit opens: checks fail with ErrCircuitOpen without trying Redis, and
+// RateLimitMiddleware applies the failure mode to them. After Cooldown a single
+// check probes the shard; the circuit closes if it succeeds and opens again for
+// another cooldown if not. Only connection and failover errors count as failures,
+// not checks cancelled by their caller. A zero Failures disables the breakers
+// (default).
+func (rsm *RedisShardManager) SetCircuitBreaker(cfg CircuitBreakerConfig) {
+	if cfg.Failures <= 0 {
+		rsm.mu.Lock()
+		rsm.breakers = nil
+		rsm.mu.Unlock()
+		return
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = defaultBreakerWindow
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = defaultBreakerCooldown
+	}
+
+	rsm.mu.Lock()
+	defer rsm.mu.Unlock()
+	rsm.breakers = &breakers{config: cfg, shards: make(map[int]*circuitBreaker)}
+}
+
+// BreakerStates returns the circuit breaker state of every shard that recorded a
+// check, keyed by shard index; nil without SetCircuitBreaker
+func (rsm *RedisShardManager) BreakerStates() map[int]BreakerState {
+	b := rsm.circuitBreakers()
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	states := make(map[int]BreakerState, len(b.shards))
+	for shard, breaker := range b.shards {
+		states[shard] = breaker.state
+	}
+	return states
+}
+
+// circuitBreakers returns the breakers of the manager, nil if disabled
+func (rsm *RedisShardManager) circuitBreakers() *breakers {
+	rsm.mu.RLock()
+	defer rsm.mu.RUnlock()
+	return rsm.breakers
+}
+
+// guardShard runs fn, a command sent to the given shard, behind the shard's circuit
+// breaker: it fails at once with ErrCircuitOpen while the circuit is open, and the
+// outcome of fn is recorded otherwise. Shards are indexed as by shardIndex, by master
+// in cluster mode.
+func (rsm *RedisShardManager) guardShard(shard int, fn func() error) error {
+	b := rsm.circuitBreakers()
+	if b == nil {
+		return fn()
+	}
+	if err := b.allowShard(shard, time.Now()); err != nil {
+		return err
+	}
+	err := fn()
+	b.record(shard, err, time.Now())
+	return err
+}
+
+// guardUser is guardShard for the shard of userID, passing fn the shard's client
+func (rsm *RedisShardManager) guardUser(userID string, fn func(client *redis.Client) error) error {
+	return rsm.guardShard(rsm.shardIndex(userID), func() error {
+		return fn(rsm.GetClient(userID))
+	})
+}
+
+// runOnShard runs script on the shard of userID behind the shard's circuit breaker
+func (rsm *RedisShardManager) runOnShard(ctx context.Context, userID string, script *redis.Script, keys []string, args ...interface{}) *redis.Cmd {
+	var cmd *redis.Cmd
+	err := rsm.guardUser(userID, func(client *redis.Client) error {
+		cmd = script.Run(ctx, client, keys, args...)
+		return cmd.Err()
+	})
+	if cmd == nil {
+		cmd = redis.NewCmd(ctx)
+		cmd.SetErr(err)
+	}
+	return cmd
+}
+
+// allowShard reports whether a check may be sent to shard, returning ErrCircuitOpen
+// if its circuit is open. An open circuit past its cooldown turns half-open and lets
+// the calling check through as the probe.
+func (b *breakers) allowShard(shard int, now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	breaker, ok := b.shards[shard]
+	if !ok {
+		return nil
+	}
+	switch breaker.state {
+	case BreakerOpen:
+		if now.Sub(breaker.openedAt) < b.config.Cooldown {
+			return fmt.Errorf("shard %d: %w", shard, ErrCircuitOpen)
+		}
+		breaker.state = BreakerHalfOpen
+		breaker.probing = true
+		log.Printf("INFO: Circuit breaker of shard %d half-open, probing the shard", shard)
+	case BreakerHalfOpen:
+		if breaker.probing {
+			return fmt.Errorf("shard %d: %w", shard, ErrCircuitOpen)
+		}
+		breaker.probing = true
+	}
+	return nil
+}
+
+// record records the outcome of a check sent to shard
+func (b *breakers) record(shard int, err error, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	breaker, ok := b.shards[shard]
+	if !ok {
+		if !isFailoverError(err) {
+			return
+		}
+		breaker = &circuitBreaker{}
+		b.shards[shard] = breaker
+	}
+
+	switch {
+	case err != nil && (isContextError(err) || errors.Is(err, ErrShardBusy)):
+		// The check never got an answer through no fault of the shard; let another probe through
+		breaker.probing = false
+	case !isFailoverError(err):
+		if breaker.state != BreakerClosed {
+			log.Printf("INFO: Circuit breaker of shard %d closed, the shard recovered", shard)
+		}
+		*breaker = circuitBreaker{}
+	case breaker.state == BreakerHalfOpen:
+		breaker.state = BreakerOpen
+		breaker.openedAt = now
+		breaker.probing = false
+		log.Printf("WARNING: Circuit breaker of shard %d opened again, probe failed - %v", shard, err)
+	case breaker.state == BreakerClosed:
+		if breaker.failures == 0 || now.Sub(breaker.firstFailure) > b.config.Window {
+			breaker.failures = 0
+			breaker.firstFailure = now
+		}
+		breaker.failures++
+		if breaker.failures >= b.config.Failures {
+			breaker.state = BreakerOpen
+			breaker.openedAt = now
+			log.Printf("WARNING: Circuit breaker of shard %d opened after %d consecutive failures - %v", shard, breaker.failures, err)
+		}
+	}
+}
+
+// breakerCollector exposes the circuit breaker state of the shards of managers as a
+// Prometheus gauge, labelled by manager when there is more than one
+type breakerCollector struct {
+	managers []*RedisShardManager
+	desc     *prometheus.Desc
+}
+
+func newBreakerCollector(managers []*RedisShardManager) *breakerCollector {
+	labels := []string{"shard"}
+	if len(managers) > 1 {
+		labels = []string{"manager", "shard"}
+	}
+	return &breakerCollector{
+		managers: managers,
+		desc: prometheus.NewDesc("velocity_circuit_breaker_state",
+			"Circuit breaker state of each Redis shard: 0 closed, 1 open, 2 half-open.", labels, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (bc *breakerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- bc.desc
+}
+
+// Collect implements prometheus.Collector
+func (bc *breakerCollector) Collect(ch chan<- prometheus.Metric) {
+	for i, manager := range bc.managers {
+		for shard, state := range manager.BreakerStates() {
+			labels := []string{strconv.Itoa(shard)}
+			if len(bc.managers) > 1 {
+				labels = []string{strconv.Itoa(i), strconv.Itoa(shard)}
+			}
+			ch <- prometheus.MustNewConstMetric(bc.desc, prometheus.GaugeValue, float64(state), labels...)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestCircuitBreakerFastFail tests that checks of a shard whose circuit opened fail
+// without trying Redis
+func TestCircuitBreakerFastFail(t *testing.T) {
+	hook := &flakyHook{}
+	limiter, cleanup := newFlakyLimiter("127.0.0.1:1", hook)
+	defer cleanup()
+	limiter.manager.SetCircuitBreaker(CircuitBreakerConfig{Failures: 3, Cooldown: time.Minute})
+
+	for i := 0; i < 3; i++ {
+		if _, err := limiter.Allow("test_user_breaker"); !isDialError(err) {
+			t.Fatalf("Check %d: expected the dial error, got %v", i+1, err)
+		}
+	}
+	if state := limiter.manager.BreakerStates()[0]; state != BreakerOpen {
+		t.Fatalf("Expected the circuit open after 3 failures, got %v", state)
+	}
+
+	for i := 0; i < 5; i++ {
+		if _, err := limiter.Allow("test_user_breaker"); !errors.Is(err, ErrCircuitOpen) {
+			t.Errorf("Expected ErrCircuitOpen while open, got %v", err)
+		}
+	}
+	if attempts := hook.attempts.Load(); attempts != 3 {
+		t.Errorf("Expected no attempt while the circuit is open, got %d attempts", attempts)
+	}
+
+	// The middleware applies the failure mode to the open circuit
+	app := newTestApp(RateLimitMiddleware(limiter, WithFailureMode(FailClosed)))
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusServiceUnavailable {
+		t.Errorf("Expected 503 with FailClosed, got %d", resp.StatusCode)
+	}
+}
+
+// TestCircuitBreakerStates tests the transitions of a shard's circuit breaker
+func TestCircuitBreakerStates(t *testing.T) {
+	b := &breakers{
+		config: CircuitBreakerConfig{Failures: 2, Window: time.Second, Cooldown: 5 * time.Second},
+		shards: make(map[int]*circuitBreaker),
+	}
+	now := time.Now()
+	state := func() BreakerState {
+		if breaker, ok := b.shards[1]; ok {
+			return breaker.state
+		}
+		return BreakerClosed
+	}
+
+	b.record(1, dialError, now)
+	if state() != BreakerClosed {
+		t.Fatalf("Expected the circuit closed after 1 failure, got %v", state())
+	}
+	b.record(1, dialError, now)
+	if state() != BreakerOpen {
+		t.Fatalf("Expected the circuit open after 2 failures, got %v", state())
+	}
+	if err := b.allowShard(0, now); err != nil {
+		t.Errorf("Expected other shards unaffected, got %v", err)
+	}
+	if err := b.allowShard(1, now.Add(4*time.Second)); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen within the cooldown, got %v", err)
+	}
+
+	// A single probe after the cooldown, failing opens the circuit again
+	now = now.Add(5 * time.Second)
+	if err := b.allowShard(1, now); err != nil || state() != BreakerHalfOpen {
+		t.Fatalf("Expected a half-open probe after the cooldown, got %v (%v)", state(), err)
+	}
+	if err := b.allowShard(1, now); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected a single probe in flight, got %v", err)
+	}
+	b.record(1, dialError, now)
+	if state() != BreakerOpen {
+		t.Fatalf("Expected a failed probe to open the circuit again, got %v", state())
+	}
+
+	// A cancelled probe lets another one through
+	now = now.Add(5 * time.Second)
+	if err := b.allowShard(1, now); err != nil {
+		t.Fatalf("Expected a probe after the cooldown, got %v", err)
+	}
+	b.record(1, context.Canceled, now)
+	if err := b.allowShard(1, now); err != nil || state() != BreakerHalfOpen {
+		t.Fatalf("Expected another probe after a cancelled one, got %v (%v)", state(), err)
+	}
+
+	// A successful probe closes the circuit
+	b.record(1, nil, now)
+	if state() != BreakerClosed {
+		t.Errorf("Expected a successful probe to close the circuit, got %v", state())
+	}
+	b.record(1, dialError, now)
+	if state() != BreakerClosed {
+		t.Errorf("Expected the failures counted from zero after closing, got %v", state())
+	}
+}
+
+// TestCircuitBreakerWindow tests that only failures within the window open the circuit,
+// and that errors Redis answered with don't count
+func TestCircuitBreakerWindow(t *testing.T) {
+	b := &breakers{
+		config: CircuitBreakerConfig{Failures: 2, Window: time.Second, Cooldown: time.Second},
+		shards: make(map[int]*circuitBreaker),
+	}
+	now := time.Now()
+
+	b.record(0, dialError, now)
+	b.record(0, dialError, now.Add(2*time.Second))
+	if state := b.shards[0].state; state != BreakerClosed {
+		t.Errorf("Expected failures further apart than the window to keep the circuit closed, got %v", state)
+	}
+
+	b.record(0, redis.Nil, now.Add(2*time.Second))
+	b.record(0, dialError, now.Add(2*time.Second))
+	if state := b.shards[0].state; state != BreakerClosed {
+		t.Errorf("Expected a reply to reset the consecutive failures, got %v", state)
+	}
+}
+
+// TestCircuitBreakerRecovers tests that the circuit of a shard reachable again closes
+// on the probe after the cooldown
+func TestCircuitBreakerRecovers(t *testing.T) {
+	setup, cleanup, err := setupTestRateLimiter(1.0, 10.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	redisAddr := os.Getenv("REDIS_ADDR")
+	if redisAddr == "" {
+		redisAddr = "localhost:6379"
+	}
+	userID := "test_user_breaker_recovers"
+	setup.manager.GetClient(userID).Del(testCtx, testBucketKey(userID))
+
+	hook := &flakyHook{err: dialError, failures: 2}
+	limiter, closeClient := newFlakyLimiter(redisAddr, hook)
+	defer closeClient()
+	limiter.manager.SetCircuitBreaker(CircuitBreakerConfig{Failures: 2, Cooldown: 50 * time.Millisecond})
+
+	for i := 0; i < 2; i++ {
+		limiter.Allow(userID)
+	}
+	if _, err := limiter.Allow(userID); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected ErrCircuitOpen after 2 failures, got %v", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	result, err := limiter.Allow(userID)
+	if err != nil || !result.Allowed {
+		t.Fatalf("Expected the probe allowed, got %+v (%v)", result, err)
+	}
+	if state := limiter.manager.BreakerStates()[0]; state != BreakerClosed {
+		t.Errorf("Expected the circuit closed after the probe, got %v", state)
+	}
+	if _, err := limiter.Allow(userID); err != nil {
+		t.Errorf("Expected checks to reach Redis again, got %v", err)
+	}
+}
+
+// TestMetricsCircuitBreakerState tests that the metrics endpoint reports the breaker
+// state of each shard
+func TestMetricsCircuitBreakerState(t *testing.T) {
+	manager := &RedisShardManager{}
+	manager.SetCircuitBreaker(CircuitBreakerConfig{Failures: 1})
+	manager.breakers.record(2, dialError, time.Now())
+
+	app := fiber.New()
+	app.Get("/metrics", MetricsHandler(manager))
+	resp, err := app.Test(httptest.NewRequest("GET", "/metrics", nil))
+	if err != nil {
+		t.Fatalf("Error requesting metrics: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+
+	if expected := `velocity_circuit_breaker_state{shard="2"} 1` + "\n"; !strings.Contains(string(body), expected) {
+		t.Errorf("Expected %q in metrics, got:\n%s", expected, body)
+	}
+}
+
+// TestCircuitBreakerCoversOperations tests that every operation on a shard whose
+// circuit is open fails without trying Redis, not only Allow
+func TestCircuitBreakerCoversOperations(t *testing.T) {
+	hook := &flakyHook{}
+	limiter, cleanup := newFlakyLimiter("127.0.0.1:1", hook)
+	defer cleanup()
+	limiter.manager.SetCircuitBreaker(CircuitBreakerConfig{Failures: 1, Cooldown: time.Minute})
+	if _, err := limiter.Allow("test_user_breaker_ops"); !isDialError(err) {
+		t.Fatalf("Expected the dial error opening the circuit, got %v", err)
+	}
+
+	composite := NewCompositeLimiter(LimitDimension{Name: "a", Limiter: limiter}, LimitDimension{Name: "b", Limiter: limiter})
+	operations := map[string]func() error{
+		"AllowMany": func() error {
+			_, err := limiter.AllowMany([]string{"test_user_breaker_ops", "test_user_breaker_ops_2"})
+			return err
+		},
+		"AllowTiered": func() error {
+			_, _, err := limiter.AllowTiered("test_user_breaker_ops", []float64{2, 1})
+			return err
+		},
+		"Reserve": func() error {
+			_, err := limiter.Reserve("test_user_breaker_ops", 1)
+			return err
+		},
+		"Cancel": func() error {
+			return limiter.Cancel("abc:test_user_breaker_ops")
+		},
+		"Refund": func() error {
+			return limiter.Refund("test_user_breaker_ops", 1)
+		},
+		"PeekState": func() error {
+			_, err := limiter.PeekState("test_user_breaker_ops")
+			return err
+		},
+		"Transfer": func() error {
+			return limiter.Transfer("test_user_breaker_ops", "test_user_breaker_ops_2", 1)
+		},
+		"Composite": func() error {
+			_, err := composite.AllowCtx(testCtx, []string{"test_user_breaker_ops", "test_user_breaker_ops_2"})
+			return err
+		},
+		"Commit": func() error {
+			return limiter.Commit("abc:test_user_breaker_ops")
+		},
+		"Reset": func() error {
+			return limiter.Reset("test_user_breaker_ops")
+		},
+		"AddPenalty": func() error {
+			_, err := limiter.AddPenalty(testCtx, "test_user_breaker_ops", time.Minute)
+			return err
+		},
+		"Penalties": func() error {
+			_, err := limiter.Penalties(testCtx, "test_user_breaker_ops")
+			return err
+		},
+		"UseCourtesy": func() error {
+			_, err := limiter.UseCourtesy(testCtx, "test_user_breaker_ops", time.Minute)
+			return err
+		},
+		"ClaimSubmission": func() error {
+			_, err := limiter.ClaimSubmission(testCtx, "test_user_breaker_ops", "hash", time.Minute)
+			return err
+		},
+		"SlidingWindow": func() error {
+			_, err := NewSlidingWindowLimiter(limiter.manager, 5, time.Minute).Allow("test_user_breaker_ops")
+			return err
+		},
+		"FixedWindow": func() error {
+			_, err := NewFixedWindowLimiter(limiter.manager, 5, time.Minute).Allow("test_user_breaker_ops")
+			return err
+		},
+		"LeakyBucket": func() error {
+			_, err := NewLeakyBucketLimiter(limiter.manager, 1, 5).Allow("test_user_breaker_ops")
+			return err
+		},
+		"Distinct": func() error {
+			_, err := NewDistinctLimiter(limiter.manager, 5, time.Minute).AllowDistinct("test_user_breaker_ops", "resource")
+			return err
+		},
+	}
+	for name, operation := range operations {
+		if err := operation(); !errors.Is(err, ErrCircuitOpen) {
+			t.Errorf("%s: expected ErrCircuitOpen, got %v", name, err)
+		}
+	}
+	if attempts := hook.attempts.Load(); attempts != 1 {
+		t.Errorf("Expected no attempt while the circuit is open, got %d attempts", attempts)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// cachedLimiterMaxEntries bounds the blocked decisions held by a CachedLimiter
+const cachedLimiterMaxEntries = 10000
+
+// CachedLimiter wraps a Limiter with an in-process cache of blocked decisions, so that
+// noisy clients over their limit are turned away without a Redis round trip. Only
+// blocked decisions are cached, so the cache never lets a request through; in
+// exchange for far fewer Redis calls, a client may be over-blocked for up to the
+// cache TTL after tokens became available, e.g. through a refund, a reset or a limit
+// change. Up to 10000 decisions are cached, evicting the least recently used.
+//
+// RateLimiter has the same cache built in, see WithBlockedCache; CachedLimiter
+// brings it to any Limiter.
+type CachedLimiter struct {
+	inner       Limiter
+	negativeTTL time.Duration
+	cache       *blockedCache
+}
+
+// NewCachedLimiter wraps inner, answering the checks of a user blocked within the last
+// negativeTTL from the cache. A blocked decision is cached for negativeTTL, or for its
+// retry-after if that's shorter.
+func NewCachedLimiter(inner Limiter, negativeTTL time.Duration) *CachedLimiter {
+	return &CachedLimiter{
+		inner:       inner,
+		negativeTTL: negativeTTL,
+		cache:       newBlockedCache(cachedLimiterMaxEntries),
+	}
+}
+
+// Capacity returns the capacity of the wrapped limiter, 0 if it has none
+func (cl *CachedLimiter) Capacity() float64 {
+	if inner, ok := cl.inner.(capacityLimiter); ok {
+		return inner.Capacity()
+	}
+	return 0
+}
+
+// Rate returns the rate of the wrapped limiter, 0 if it has none
+func (cl *CachedLimiter) Rate() float64 {
+	if inner, ok := cl.inner.(refillLimiter); ok {
+		return inner.Rate()
+	}
+	return 0
+}
+
+// Allow checks a request of userID, from the cache if the user was recently blocked
+func (cl *CachedLimiter) Allow(userID string) (*AllowResult, error) {
+	return cl.AllowNCtx(ctx, userID, 1)
+}
+
+// AllowN is like Allow for a request costing n tokens
+func (cl *CachedLimiter) AllowN(userID string, n float64) (*AllowResult, error) {
+	return cl.AllowNCtx(ctx, userID, n)
+}
+
+// AllowNCtx is like AllowN, passing ctx to the wrapped limiter if it takes one
+func (cl *CachedLimiter) AllowNCtx(ctx context.Context, userID string, n float64) (*AllowResult, error) {
+	if cached, ok := cl.cache.get(userID, n, time.Now()); ok {
+		return cached, nil
+	}
+
+	result, err := allowN(ctx, cl.inner, userID, n)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Allowed {
+		ttl := cl.negativeTTL
+		if result.RetryAfter > 0 && result.RetryAfter < ttl {
+			ttl = result.RetryAfter
+		}
+		cl.cache.addFor(userID, n, result, ttl, time.Now())
+	}
+	return result, nil
+}
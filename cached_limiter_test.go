@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestCachedLimiterHit tests that checks of a recently blocked user don't reach the
+// inner limiter until the cache TTL passes
+func TestCachedLimiterHit(t *testing.T) {
+	var _ Limiter = (*CachedLimiter)(nil)
+	inner := &fakeCapacityLimiter{}
+	inner.result = AllowResult{Allowed: false, Remaining: 0.5, RetryAfter: time.Minute}
+	limiter := NewCachedLimiter(inner, 50*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		result, err := limiter.Allow("alice")
+		if err != nil {
+			t.Fatalf("Error calling Allow: %v", err)
+		}
+		if result.Allowed || result.Remaining != 0.5 || result.RetryAfter <= 50*time.Millisecond {
+			t.Errorf("Request %d: expected the blocked decision with its retry-after, got %+v", i+1, result)
+		}
+	}
+	if len(inner.requested) != 1 {
+		t.Errorf("Expected 1 check of the inner limiter, got %d", len(inner.requested))
+	}
+
+	// Larger costs don't fit either, but other users and smaller costs, which may
+	// already fit, aren't answered from the cache
+	limiter.AllowN("alice", 2)
+	limiter.Allow("bob")
+	limiter.AllowN("carol", 3)
+	limiter.Allow("carol")
+	if len(inner.requested) != 4 {
+		t.Errorf("Expected 4 checks of the inner limiter, got %d", len(inner.requested))
+	}
+	if limiter.Capacity() != 20 {
+		t.Errorf("Expected the inner capacity, got %v", limiter.Capacity())
+	}
+
+	// The decision expires after the TTL
+	time.Sleep(60 * time.Millisecond)
+	inner.result = AllowResult{Allowed: true, Remaining: 10}
+	if result, err := limiter.Allow("alice"); err != nil || !result.Allowed {
+		t.Errorf("Expected the inner limiter to be checked after the TTL, got %+v (%v)", result, err)
+	}
+}
+
+// TestCachedLimiterShortRetryAfter tests that decisions are cached no longer than their
+// retry-after, and that allowed decisions and errors aren't cached
+func TestCachedLimiterShortRetryAfter(t *testing.T) {
+	inner := &fakeLimiter{result: AllowResult{Allowed: false, RetryAfter: 20 * time.Millisecond}}
+	limiter := NewCachedLimiter(inner, time.Minute)
+
+	limiter.Allow("alice")
+	time.Sleep(30 * time.Millisecond)
+	limiter.Allow("alice")
+	if len(inner.requested) != 2 {
+		t.Errorf("Expected the decision to expire with its retry-after, got %d inner checks", len(inner.requested))
+	}
+
+	inner.result = AllowResult{Allowed: true}
+	limiter.Allow("bob")
+	limiter.Allow("bob")
+	inner.err = errors.New("connection refused")
+	if _, err := limiter.Allow("carol"); err == nil {
+		t.Error("Expected the inner error")
+	}
+	if len(inner.requested) != 5 {
+		t.Errorf("Expected allowed decisions and errors to reach the inner limiter, got %d inner checks", len(inner.requested))
+	}
+}
+
+// TestCachedLimiterResetAt tests that cached decisions keep the reset time of the
+// blocked check
+func TestCachedLimiterResetAt(t *testing.T) {
+	resetAt := time.Now().Add(5 * time.Second)
+	inner := &fakeLimiter{result: AllowResult{Allowed: false, RetryAfter: time.Second, ResetAt: resetAt}}
+	limiter := NewCachedLimiter(inner, time.Minute)
+
+	limiter.Allow("alice")
+	result, _ := limiter.Allow("alice")
+	if len(inner.requested) != 1 || !result.ResetAt.Equal(resetAt) {
+		t.Errorf("Expected the cached reset %v, got %v after %d inner checks", resetAt, result.ResetAt, len(inner.requested))
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// clientCertKeyPrefix namespaces certificate fingerprint keys so they can't collide with IP keys
+const clientCertKeyPrefix = "cert:"
+
+// ClientCertKeyFunc returns a KeyFunc for mTLS services that limits each client by the
+// SHA-256 fingerprint of its TLS client certificate, a stable identity that, unlike
+// the IP, can't be spoofed. For plain HTTP connections or TLS connections without a
+// client certificate, the key is taken from fallback (e.g. an IP KeyFunc); with a nil
+// fallback the key is empty and RateLimitMiddleware rejects the request.
+func ClientCertKeyFunc(fallback KeyFunc) KeyFunc {
+	return func(c *fiber.Ctx) string {
+		if fingerprint := clientCertFingerprint(c.Context().TLSConnectionState()); fingerprint != "" {
+			return clientCertKeyPrefix + fingerprint
+		}
+		if fallback != nil {
+			return fallback(c)
+		}
+		return ""
+	}
+}
+
+// clientCertFingerprint returns the hex SHA-256 fingerprint of the peer's leaf
+// certificate, or "" if state is nil or has no peer certificate
+func clientCertFingerprint(state *tls.ConnectionState) string {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(state.PeerCertificates[0].Raw)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestClientCertFingerprint tests the fingerprint of the peer's leaf certificate
+func TestClientCertFingerprint(t *testing.T) {
+	leaf := &x509.Certificate{Raw: []byte("leaf certificate")}
+	intermediate := &x509.Certificate{Raw: []byte("intermediate certificate")}
+	sum := sha256.Sum256(leaf.Raw)
+
+	state := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf, intermediate}}
+	if got := clientCertFingerprint(state); got != hex.EncodeToString(sum[:]) {
+		t.Errorf("Expected the leaf fingerprint, got %s", got)
+	}
+
+	if got := clientCertFingerprint(&tls.ConnectionState{}); got != "" {
+		t.Errorf("Expected no fingerprint without a client certificate, got %s", got)
+	}
+	if got := clientCertFingerprint(nil); got != "" {
+		t.Errorf("Expected no fingerprint without TLS, got %s", got)
+	}
+}
+
+// TestMiddlewareClientCertKeyWithoutTLS tests the fallback and rejection of plain HTTP requests
+func TestMiddlewareClientCertKeyWithoutTLS(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.01, 10.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	client := limiter.manager.GetClient(testClientIP)
+	client.Del(testCtx, testBucketKey(testClientIP))
+	defer client.Del(testCtx, testBucketKey(testClientIP))
+
+	// Without a fallback the request is rejected
+	app := newTestApp(RateLimitMiddleware(limiter, WithKeyFunc(ClientCertKeyFunc(nil))))
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusUnauthorized {
+		t.Errorf("Expected a request without client certificate to be rejected with 401, got %d", resp.StatusCode)
+	}
+
+	// With the IP fallback the request is limited by IP
+	app = newTestApp(RateLimitMiddleware(limiter, WithKeyFunc(ClientCertKeyFunc(ipKey))))
+	resp, err = app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("Expected request to be allowed, got status %d", resp.StatusCode)
+	}
+	if exists, _ := client.Exists(testCtx, testBucketKey(testClientIP)).Result(); exists != 1 {
+		t.Errorf("Expected the request to be charged to the IP bucket")
+	}
+}
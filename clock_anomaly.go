@@ -0,0 +1,36 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// clockAnomaliesTotal counts token bucket checks with an implausible elapsed time
+var clockAnomaliesTotal atomic.Int64
+
+// ClockAnomaliesTotal returns the number of token bucket checks so far whose elapsed
+// time since the last refill was negative or longer than the key TTL, both of which
+// indicate a jump of the Redis server clock, e.g. after a failover
+func ClockAnomaliesTotal() int64 {
+	return clockAnomaliesTotal.Load()
+}
+
+// checkClockAnomaly counts and logs an elapsed time reported by the token bucket script
+// that a correct clock can't produce: negative (now is before the stored lastRefill)
+// or longer than the key TTL (the bucket would have expired in the meantime)
+func (rl *RateLimiter) checkClockAnomaly(userID string, raw interface{}) {
+	values, ok := raw.([]interface{})
+	if !ok || len(values) < 4 {
+		return
+	}
+	elapsed, err := parseLuaNumber(values[3])
+	if err != nil {
+		return
+	}
+
+	if keyTTL := rl.bucketTTL(); elapsed < 0 || elapsed > keyTTL.Seconds() {
+		clockAnomaliesTotal.Add(1)
+		log.Printf("DEBUG: Clock anomaly - userID: %s, Elapsed: %v, Key TTL: %v", userID, time.Duration(elapsed*float64(time.Second)), keyTTL)
+	}
+}
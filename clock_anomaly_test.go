@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestClockAnomalyCounted tests that a lastRefill in the future or beyond the key TTL is counted
+func TestClockAnomalyCounted(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(1.0, 10.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	userID := "test_user_clock_anomaly"
+	key := testBucketKey(userID)
+	client := limiter.manager.GetClient(userID)
+	client.Del(testCtx, key)
+	defer client.Del(testCtx, key)
+
+	// A regular check is not an anomaly
+	before := ClockAnomaliesTotal()
+	if _, err := limiter.Allow(userID); err != nil {
+		t.Fatalf("Error calling Allow: %v", err)
+	}
+	if got := ClockAnomaliesTotal() - before; got != 0 {
+		t.Errorf("Expected no anomaly for a regular check, got %d", got)
+	}
+
+	// A lastRefill a minute ahead of the Redis server's clock
+	now := float64(time.Now().UnixNano()) / 1e9
+	client.HSet(testCtx, key, "tokens", 5, "lastRefill", fmt.Sprintf("%f", now+60))
+	if _, err := limiter.Allow(userID); err != nil {
+		t.Fatalf("Error calling Allow: %v", err)
+	}
+	if got := ClockAnomaliesTotal() - before; got != 1 {
+		t.Errorf("Expected 1 anomaly for a future lastRefill, got %d", got)
+	}
+
+	// A lastRefill older than the key TTL can't happen without the key expiring
+	client.HSet(testCtx, key, "tokens", 5, "lastRefill", fmt.Sprintf("%f", now-2*time.Hour.Seconds()))
+	if _, err := limiter.Allow(userID); err != nil {
+		t.Fatalf("Error calling Allow: %v", err)
+	}
+	if got := ClockAnomaliesTotal() - before; got != 2 {
+		t.Errorf("Expected 2 anomalies after a stale lastRefill, got %d", got)
+	}
+}
+
+// TestMetricsHandlerClockAnomalies tests that the clock anomaly counter is exposed
+func TestMetricsHandlerClockAnomalies(t *testing.T) {
+	app := fiber.New()
+	app.Get("/metrics", MetricsHandler())
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/metrics", nil))
+	if err != nil {
+		t.Fatalf("Error requesting metrics: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+
+	expected := fmt.Sprintf("ratelimit_clock_anomalies_total %d\n", ClockAnomaliesTotal())
+	if !strings.Contains(string(body), expected) {
+		t.Errorf("Expected metrics to contain %q, got %q", expected, body)
+	}
+}
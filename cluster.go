@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// clusterSlots is the number of hash slots of a Redis Cluster
+const clusterSlots = 16384
+
+// clusterMastersRefresh is how long the master list of a cluster is cached
+const clusterMastersRefresh = 10 * time.Second
+
+// NewRedisClusterManager creates a shard manager backed by a Redis Cluster, reached
+// through the given seed node addresses. The cluster places keys itself: every check
+// goes to the master owning the slot of the user's keys, so there's no client-side
+// hashing, and resharding is done with the cluster tools instead of UpdateShards.
+//
+// To keep each user's keys in one slot, userIDs are embedded in keys as a hash tag,
+// e.g. "ratelimit:tb::{alice}" (see keyUserID). Keys move to other slots than those of
+// a manager created with NewRedisShardManager, so switching an existing deployment to
+// a cluster starts every user over with a fresh bucket.
+func NewRedisClusterManager(addrs []string) (*RedisShardManager, error) {
+	return NewRedisClusterManagerWithAuth(addrs, ShardAuth{})
+}
+
+// NewRedisClusterManagerWithAuth is like NewRedisClusterManager but connects to every
+// node with the password and TLS settings of auth. A Redis Cluster only has database
+// 0, so auth.DB must be zero.
+func NewRedisClusterManagerWithAuth(addrs []string, auth ShardAuth) (*RedisShardManager, error) {
+	options, err := clusterOptions(addrs, auth)
+	if err != nil {
+		return nil, err
+	}
+	cluster := redis.NewClusterClient(options)
+
+	masters, err := clusterMasters(ctx, cluster)
+	if err != nil {
+		log.Printf("ERROR: Critical Redis Error: Connection failure to Redis Cluster at %v - %v", addrs, err)
+		cluster.Close()
+		return nil, fmt.Errorf("failed to connect to Redis Cluster at %v: %w", addrs, err)
+	}
+
+	fmt.Printf("Successfully connected to Redis Cluster with %d masters\n", len(masters))
+	return &RedisShardManager{
+		cluster:  cluster,
+		shards:   masters,
+		listedAt: time.Now(),
+	}, nil
+}
+
+// clusterOptions returns the client options of a cluster reached through the given
+// seed node addresses
+func clusterOptions(addrs []string, auth ShardAuth) (*redis.ClusterOptions, error) {
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("at least one Redis Cluster address is required")
+	}
+	if auth.DB != 0 {
+		return nil, fmt.Errorf("a Redis Cluster only has database 0, got database %d", auth.DB)
+	}
+
+	// Apply the same timeouts and retries as to independent shards
+	defaults := &redis.Options{}
+	applyClientDefaults(defaults)
+	return &redis.ClusterOptions{
+		Addrs:        addrs,
+		Password:     auth.Password,
+		TLSConfig:    auth.TLSConfig,
+		DialTimeout:  defaults.DialTimeout,
+		ReadTimeout:  defaults.ReadTimeout,
+		WriteTimeout: defaults.WriteTimeout,
+		MaxRetries:   defaults.MaxRetries,
+	}, nil
+}
+
+// clusterMasters pings every master of the cluster and returns their clients, sorted
+// by address so that shard indices are stable
+func clusterMasters(ctx context.Context, cluster *redis.ClusterClient) ([]*redis.Client, error) {
+	return listClusterMasters(ctx, cluster, func(ctx context.Context, client *redis.Client) error {
+		return client.Ping(ctx).Err()
+	})
+}
+
+// listClusterMasters returns the clients of the masters of the cluster sorted by
+// address, after running check on each if not nil
+func listClusterMasters(ctx context.Context, cluster *redis.ClusterClient, check func(ctx context.Context, client *redis.Client) error) ([]*redis.Client, error) {
+	var mu sync.Mutex
+	var masters []*redis.Client
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+		if check != nil {
+			if err := check(ctx, client); err != nil {
+				return err
+			}
+		}
+		mu.Lock()
+		masters = append(masters, client)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(masters, func(i, j int) bool {
+		return masters[i].Options().Addr < masters[j].Options().Addr
+	})
+	return masters, nil
+}
+
+// IsCluster reports whether the manager is backed by a Redis Cluster
+func (rsm *RedisShardManager) IsCluster() bool {
+	return rsm != nil && rsm.cluster != nil
+}
+
+// keyUserID returns userID as embedded in Redis keys. In cluster mode, a userID
+// without a hash tag is braced, so that all keys of the user share its slot
+// whatever prefix they have; userIDs carrying a "{...}" tag already do.
+func (rsm *RedisShardManager) keyUserID(userID string) string {
+	if !rsm.IsCluster() || hashTag(userID) != userID {
+		return userID
+	}
+	return "{" + userID + "}"
+}
+
+// clusterClient returns the client of the master owning the slot of userID's keys,
+// falling back to the first known master when the cluster state can't be loaded, so
+// the command fails with a Redis error handled by the failure mode
+func (rsm *RedisShardManager) clusterClient(userID string) *redis.Client {
+	client, err := rsm.cluster.MasterForKey(ctx, rsm.keyUserID(userID))
+	if err != nil {
+		log.Printf("ERROR: Critical Redis Error: Failed to locate the Redis Cluster master for userID %s - %v", userID, err)
+		rsm.mu.RLock()
+		defer rsm.mu.RUnlock()
+		return rsm.shards[0]
+	}
+	return client
+}
+
+// clusterShards returns the masters of the cluster, listed again once the cached list
+// is older than clusterMastersRefresh
+func (rsm *RedisShardManager) clusterShards() []*redis.Client {
+	rsm.mu.RLock()
+	masters, stale := rsm.shards, time.Since(rsm.listedAt) >= clusterMastersRefresh
+	rsm.mu.RUnlock()
+	if !stale {
+		return masters
+	}
+	return rsm.refreshClusterShards()
+}
+
+// refreshClusterShards lists the masters of the cluster again, keeping the last known
+// masters when they can't be listed. Masters aren't pinged: HealthCheck does that.
+func (rsm *RedisShardManager) refreshClusterShards() []*redis.Client {
+	masters, err := listClusterMasters(ctx, rsm.cluster, nil)
+
+	rsm.mu.Lock()
+	defer rsm.mu.Unlock()
+	rsm.listedAt = time.Now()
+	if err != nil {
+		log.Printf("WARNING: Failed to list Redis Cluster masters, using the last known %d - %v", len(rsm.shards), err)
+		return rsm.shards
+	}
+	rsm.shards = masters
+	return masters
+}
+
+// clusterShardIndex returns the index among the masters of the one owning the slot of
+// userID's keys, listing the masters again if it isn't among the cached ones, and 0 if
+// it can't be found
+func (rsm *RedisShardManager) clusterShardIndex(userID string) int {
+	addr := rsm.clusterClient(userID).Options().Addr
+	masters := rsm.clusterShards()
+	for attempt := 0; attempt < 2; attempt++ {
+		for i, master := range masters {
+			if master.Options().Addr == addr {
+				return i
+			}
+		}
+		masters = rsm.refreshClusterShards()
+	}
+	return 0
+}
+
+// colocated reports whether the keys of two userIDs can be used in one script: on the
+// same shard, or in the same slot in cluster mode, which rejects scripts whose keys
+// span slots even on one master
+func (rsm *RedisShardManager) colocated(userID, otherUserID string) bool {
+	if rsm.cluster != nil {
+		return clusterSlot(rsm.keyUserID(userID)) == clusterSlot(rsm.keyUserID(otherUserID))
+	}
+	return rsm.shardIndex(userID) == rsm.shardIndex(otherUserID)
+}
+
+// clusterSlot returns the Redis Cluster hash slot of key, honouring hash tags
+func clusterSlot(key string) int {
+	return int(crc16([]byte(hashTag(key))) % clusterSlots)
+}
+
+// crc16 computes the CRC-16/XMODEM checksum Redis Cluster hashes keys with
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
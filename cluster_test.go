@@ -0,0 +1,207 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// TestClusterSlot tests the hash slots against values computed by Redis CLUSTER KEYSLOT
+func TestClusterSlot(t *testing.T) {
+	tests := []struct {
+		key  string
+		slot int
+	}{
+		{"foo", 12182},
+		{"bar", 5061},
+		{"123456789", 12739},
+		{"ratelimit:{foo}", 12182},
+		{"{foo}:bar", 12182},
+		{"{}foo", 9500},
+	}
+	for _, tt := range tests {
+		if got := clusterSlot(tt.key); got != tt.slot {
+			t.Errorf("Expected slot %d for %q, got %d", tt.slot, tt.key, got)
+		}
+	}
+}
+
+// TestKeyUserIDCluster tests that cluster mode braces untagged userIDs so all keys of a user share a slot
+func TestKeyUserIDCluster(t *testing.T) {
+	cluster := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{"127.0.0.1:1"}})
+	defer cluster.Close()
+	manager := &RedisShardManager{cluster: cluster}
+	limiter := NewRateLimiter(manager, 5.0, 10.0, WithKeyPrefix("search"))
+
+	tests := []struct {
+		userID   string
+		expected string
+	}{
+		{"alice", "ratelimit:tb:search:{alice}"},
+		{"{team1}:alice", "ratelimit:tb:search:{team1}:alice"},
+	}
+	for _, tt := range tests {
+		if got := limiter.bucketKey(tt.userID); got != tt.expected {
+			t.Errorf("Expected key %q for %q, got %q", tt.expected, tt.userID, got)
+		}
+		slot := clusterSlot(manager.keyUserID(tt.userID))
+		for _, key := range []string{limiter.bucketKey(tt.userID), limiter.penaltyKey(tt.userID), limiter.courtesyKey(tt.userID)} {
+			if got := clusterSlot(key); got != slot {
+				t.Errorf("Expected %q in slot %d, got %d", key, slot, got)
+			}
+		}
+	}
+
+	// Independent shards keep the plain keys
+	if got := NewRateLimiter(&RedisShardManager{}, 5.0, 10.0).bucketKey("alice"); got != "ratelimit:tb::alice" {
+		t.Errorf("Expected an unbraced key without a cluster, got %q", got)
+	}
+	if err := manager.UpdateShards([]string{"localhost:6379"}); err == nil {
+		t.Errorf("Expected UpdateShards to be rejected in cluster mode")
+	}
+}
+
+// TestClusterOptions tests that the password and TLS settings apply to the cluster nodes,
+// and that databases other than 0 are rejected
+func TestClusterOptions(t *testing.T) {
+	tlsConfig, err := NewTLSConfig(false, "", "", "")
+	if err != nil {
+		t.Fatalf("NewTLSConfig failed: %v", err)
+	}
+
+	options, err := clusterOptions([]string{"10.0.0.1:6379"}, ShardAuth{Password: "secret", TLSConfig: tlsConfig})
+	if err != nil {
+		t.Fatalf("clusterOptions failed: %v", err)
+	}
+	if options.Password != "secret" || options.TLSConfig != tlsConfig {
+		t.Errorf("Expected the password and TLS config of auth, got %q and %+v", options.Password, options.TLSConfig)
+	}
+	if options.DialTimeout != 5*time.Second {
+		t.Errorf("Expected the default dial timeout, got %v", options.DialTimeout)
+	}
+
+	if _, err := NewRedisClusterManagerWithAuth([]string{"10.0.0.1:6379"}, ShardAuth{DB: 2}); err == nil || !strings.Contains(err.Error(), "database 0") {
+		t.Errorf("Expected database 2 to be rejected, got %v", err)
+	}
+}
+
+// TestInitRedisClusterManager tests that the shared Redis settings of the environment
+// apply in cluster mode, when REDIS_CLUSTER_ADDRS lists the nodes of a cluster
+func TestInitRedisClusterManager(t *testing.T) {
+	addrs := os.Getenv("REDIS_CLUSTER_ADDRS")
+	if addrs == "" {
+		t.Skip("REDIS_CLUSTER_ADDRS not set, skipping Redis Cluster test")
+	}
+	t.Setenv("REDIS_MODE", "cluster")
+	t.Setenv("REDIS_HASH_SEED", "cluster-seed")
+	t.Setenv("REDIS_CIRCUIT_BREAKER", "3")
+
+	manager := initRedisShardManager(strings.Split(addrs, ","))
+	defer manager.Close()
+	if !manager.IsCluster() {
+		t.Fatalf("Expected a cluster manager")
+	}
+	if seed := manager.HashSeed(); seed != "cluster-seed" {
+		t.Errorf("Expected REDIS_HASH_SEED applied, got %q", seed)
+	}
+	if b := manager.circuitBreakers(); b == nil || b.config.Failures != 3 {
+		t.Errorf("Expected REDIS_CIRCUIT_BREAKER applied, got %+v", b)
+	}
+
+	t.Setenv("REDIS_DB", "1")
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Expected REDIS_DB 1 to be rejected in cluster mode")
+			}
+		}()
+		initRedisShardManager(strings.Split(addrs, ","))
+	}()
+}
+
+// TestRedisClusterAllow tests checks against a Redis Cluster, when REDIS_CLUSTER_ADDRS lists its nodes
+func TestRedisClusterAllow(t *testing.T) {
+	addrs := os.Getenv("REDIS_CLUSTER_ADDRS")
+	if addrs == "" {
+		t.Skip("REDIS_CLUSTER_ADDRS not set, skipping Redis Cluster test")
+	}
+
+	manager, err := NewRedisClusterManager(strings.Split(addrs, ","))
+	if err != nil {
+		t.Fatalf("Failed to connect to Redis Cluster: %v", err)
+	}
+	limiter := NewRateLimiter(manager, 0.01, 3.0)
+
+	for _, userID := range []string{"test_cluster_a", "test_cluster_b", "test_cluster_c"} {
+		if err := limiter.Reset(userID); err != nil {
+			t.Fatalf("Reset failed: %v", err)
+		}
+		for i := 0; i < 3; i++ {
+			if result, err := limiter.Allow(userID); err != nil || !result.Allowed {
+				t.Fatalf("Expected request %d of %s to be allowed, got %+v (%v)", i+1, userID, result, err)
+			}
+		}
+		if result, err := limiter.Allow(userID); err != nil || result.Allowed {
+			t.Errorf("Expected %s to be blocked, got %+v (%v)", userID, result, err)
+		}
+	}
+
+	if err := limiter.Warmup(testCtx); err != nil {
+		t.Errorf("Warmup of the cluster masters failed: %v", err)
+	}
+
+	// Batched checks reach the master of each user's slot
+	userIDs := []string{"test_cluster_many_a", "test_cluster_many_b", "test_cluster_many_c", "test_cluster_many_d"}
+	for _, userID := range userIDs {
+		limiter.Reset(userID)
+	}
+	results, err := limiter.AllowMany(userIDs)
+	if err != nil {
+		t.Fatalf("AllowMany failed: %v", err)
+	}
+	for _, userID := range userIDs {
+		if result := results[userID]; result == nil || !result.Allowed {
+			t.Errorf("Expected %s allowed, got %+v", userID, result)
+		}
+	}
+
+	// Shards are reported by master, not by slot
+	masters := manager.Shards()
+	for _, userID := range userIDs {
+		shard := manager.shardIndex(userID)
+		if shard < 0 || shard >= len(masters) || masters[shard].Options().Addr != manager.GetClient(userID).Options().Addr {
+			t.Errorf("Expected %s on the master owning its slot, got shard %d of %d", userID, shard, len(masters))
+		}
+	}
+}
+
+// TestClusterShardsCached tests that the masters are listed again only once the cached
+// list is stale, keeping the last known masters when the cluster can't be reached
+func TestClusterShardsCached(t *testing.T) {
+	cluster := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{"127.0.0.1:1"}, DialTimeout: 100 * time.Millisecond, MaxRedirects: -1})
+	defer cluster.Close()
+	masters := []*redis.Client{
+		redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"}),
+		redis.NewClient(&redis.Options{Addr: "127.0.0.1:2"}),
+	}
+	manager := &RedisShardManager{cluster: cluster, shards: masters, listedAt: time.Now()}
+
+	if shards := manager.Shards(); len(shards) != 2 || shards[0] != masters[0] || shards[1] != masters[1] {
+		t.Fatalf("Expected the cached masters, got %v", shards)
+	}
+	listedAt := manager.listedAt
+	if manager.Shards(); manager.listedAt != listedAt {
+		t.Errorf("Expected no listing within the refresh interval")
+	}
+
+	manager.listedAt = time.Now().Add(-clusterMastersRefresh)
+	if shards := manager.Shards(); len(shards) != 2 || shards[0] != masters[0] {
+		t.Errorf("Expected the last known masters when listing fails, got %v", shards)
+	}
+	if time.Since(manager.listedAt) > time.Second {
+		t.Errorf("Expected the failed listing to be cached too")
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// LimitDimension is one named limit enforced by a CompositeLimiter
+type LimitDimension struct {
+	// Name identifies the dimension to clients, e.g. "global", "user" or "route"
+	Name string
+	// Limiter enforces the limit for this dimension
+	Limiter Limiter
+	// KeyFunc extracts the key the dimension is checked against
+	KeyFunc KeyFunc
+}
+
+// CompositeLimiter checks a request against several limit dimensions, such as a
+// global limit combined with per-user and per-route limits
+type CompositeLimiter struct {
+	dimensions []LimitDimension
+}
+
+// CompositeResult contains the result of a multi-dimension rate limit check
+type CompositeResult struct {
+	Allowed bool
+	// Dimension is the binding dimension: the one that blocked the request, or the
+	// one with the fewest remaining tokens when the request was allowed
+	Dimension LimitDimension
+	// Result is the binding dimension's individual result
+	Result *AllowResult
+}
+
+// NewCompositeLimiter creates a CompositeLimiter checking the dimensions in the given order
+func NewCompositeLimiter(dimensions ...LimitDimension) *CompositeLimiter {
+	return &CompositeLimiter{
+		dimensions: dimensions,
+	}
+}
+
+// compositeLuaScript is the Lua script for an all-or-nothing check of several buckets:
+// every bucket is refilled and checked first, and tokens are only deducted if all of
+// them can cover the request
+// KEYS = bucket keys; ARGV per bucket: rate, capacity, requested, initial, ttl,
+// ttlUnit, trackCreatedAt
+const compositeLuaScript = `
+local now = serverTime()
+local buckets = {}
+
+-- Refill and check every bucket before touching any of them
+local blocking = 0
+for i, key in ipairs(KEYS) do
+    local base = (i - 1) * 7
+    local b = {
+        rate = tonumber(ARGV[base + 1]),
+        capacity = tonumber(ARGV[base + 2]),
+        requested = tonumber(ARGV[base + 3]),
+        ttl = tonumber(ARGV[base + 5]),
+        ttlUnit = ARGV[base + 6],
+        trackCreatedAt = ARGV[base + 7] == '1',
+    }
+    local storedTokens, storedLastRefill, createdAt = readBucket(key)
+    b.tokens = tonumber(storedTokens) or tonumber(ARGV[base + 4])
+    b.createdAt = createdAt
+    if not storedTokens and b.trackCreatedAt then
+        b.createdAt = now
+    end
+    local lastRefill = tonumber(storedLastRefill) or now
+    local elapsed = now - lastRefill
+    -- lastRefill only moves forward, as in tokenBucketLuaScript
+    b.lastRefill = math.max(lastRefill, now)
+    if elapsed > 0 then
+        b.tokens = math.min(b.capacity, b.tokens + elapsed * b.rate)
+    end
+    if blocking == 0 and b.tokens < b.requested then
+        blocking = i
+    end
+    buckets[i] = b
+end
+
+-- Reject without charging any bucket, reporting the first one that can't cover the request
+local reply = {blocking == 0 and 1 or 0, blocking}
+if blocking > 0 then
+    for i, b in ipairs(buckets) do
+        reply[i + 2] = tostring(b.tokens)
+    end
+    return reply
+end
+
+for i, key in ipairs(KEYS) do
+    local b = buckets[i]
+    b.tokens = b.tokens - b.requested
+    writeBucket(key, b.tokens, b.lastRefill, b.createdAt)
+    -- Expire after the configured inactivity TTL
+    if b.ttlUnit == 'ms' then
+        redis.call('PEXPIRE', key, b.ttl)
+    else
+        redis.call('EXPIRE', key, b.ttl)
+    end
+    reply[i + 2] = tostring(b.tokens)
+end
+
+return reply
+`
+
+// AllowCtx checks keys[i] against the i-th dimension as an all-or-nothing operation:
+// the request is only charged if every dimension can cover it, and a rejected request
+// is charged to none of them. When every dimension is a token bucket RateLimiter and
+// all buckets live on the same shard (a single shard, or keys sharing a hash tag) the
+// check is one atomic Lua script. Otherwise the dimensions are checked in order and
+// the tokens taken by the dimensions before a blocking one are refunded; until the
+// refund lands, concurrent checks of those dimensions see them charged, so list the
+// dimension most likely to block first. Limiters without a Refund method, such as the
+// window limiters, keep their charge, so list them last.
+func (cl *CompositeLimiter) AllowCtx(ctx context.Context, keys []string) (*CompositeResult, error) {
+	if len(cl.dimensions) == 0 {
+		return nil, fmt.Errorf("at least one limit dimension is required")
+	}
+	if len(keys) != len(cl.dimensions) {
+		return nil, fmt.Errorf("expected %d keys, got %d", len(cl.dimensions), len(keys))
+	}
+
+	if limiters := cl.atomicLimiters(keys); limiters != nil {
+		return cl.allowAtomic(ctx, limiters, keys)
+	}
+	return cl.allowSequential(ctx, keys)
+}
+
+// atomicLimiters returns the limiters of the dimensions if one script can check them
+// all: every dimension is a RateLimiter and every bucket is on the same shard, in the
+// same storage. It returns nil otherwise.
+func (cl *CompositeLimiter) atomicLimiters(keys []string) []*RateLimiter {
+	limiters := make([]*RateLimiter, len(cl.dimensions))
+	for i, dim := range cl.dimensions {
+		rl, ok := dim.Limiter.(*RateLimiter)
+		if !ok {
+			return nil
+		}
+		limiters[i] = rl
+	}
+
+	first := limiters[0]
+	client := first.manager.GetClient(keys[0])
+	storage := first.storage.Lua()
+	for i, rl := range limiters[1:] {
+		if rl.manager.GetClient(keys[i+1]) != client || rl.storage.Lua() != storage {
+			return nil
+		}
+		// A Redis Cluster rejects scripts whose keys span slots, even on one master
+		if rl.manager.IsCluster() && clusterSlot(rl.manager.keyUserID(keys[i+1])) != clusterSlot(first.manager.keyUserID(keys[0])) {
+			return nil
+		}
+	}
+	return limiters
+}
+
+// allowAtomic checks every dimension in a single script run on the shard of keys[0],
+// limiters[i] being the limiter of the i-th dimension
+func (cl *CompositeLimiter) allowAtomic(ctx context.Context, limiters []*RateLimiter, keys []string) (*CompositeResult, error) {
+	bucketKeys := make([]string, len(keys))
+	args := make([]interface{}, 0, 7*len(keys))
+	for i, rl := range limiters {
+		bucketKeys[i] = rl.bucketKey(keys[i])
+		// Reuse the shared layout: rate, capacity, requested, initial, ttl, ttlUnit, trackCreatedAt
+		args = append(args, rl.bucketArgs(1.0)[:7]...)
+	}
+
+	script := limiters[0].bucketScript(compositeLuaScript)
+	reply, err := limiters[0].manager.runOnShard(ctx, keys[0], script, bucketKeys, args...).Result()
+	if err != nil {
+		log.Printf("ERROR: Critical Redis Error: Lua composite script execution failure for keys %v - %v", keys, err)
+		return nil, fmt.Errorf("failed to execute composite rate limit script: %w", err)
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != len(keys)+2 {
+		return nil, fmt.Errorf("unexpected result format from Lua composite script")
+	}
+	allowed, err := parseLuaNumber(values[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse allowed status: %w", err)
+	}
+	blocking, err := parseLuaNumber(values[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse blocking dimension: %w", err)
+	}
+
+	var binding *CompositeResult
+	for i, dim := range cl.dimensions {
+		remaining, err := parseLuaNumber(values[i+2])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s remaining tokens: %w", dim.Name, err)
+		}
+		result := &AllowResult{Allowed: allowed == 1, Remaining: remaining}
+
+		if allowed != 1 {
+			if i+1 == int(blocking) {
+				result.RetryAfter = limiters[i].retryAfter(remaining, 1.0, limiters[i].Rate())
+				return &CompositeResult{Allowed: false, Dimension: dim, Result: result}, nil
+			}
+			continue
+		}
+		if binding == nil || result.Remaining < binding.Result.Remaining {
+			binding = &CompositeResult{Allowed: true, Dimension: dim, Result: result}
+		}
+	}
+	if binding == nil {
+		return nil, fmt.Errorf("invalid blocking dimension %v from Lua composite script", blocking)
+	}
+
+	return binding, nil
+}
+
+// allowSequential checks the dimensions one by one, refunding the dimensions that were
+// charged before a blocking one
+func (cl *CompositeLimiter) allowSequential(ctx context.Context, keys []string) (*CompositeResult, error) {
+	var binding *CompositeResult
+	for i, dim := range cl.dimensions {
+		result, err := allowN(ctx, dim.Limiter, keys[i], 1.0)
+		if err != nil {
+			cl.refund(keys, i)
+			return nil, fmt.Errorf("failed to check %s limit: %w", dim.Name, err)
+		}
+
+		if !result.Allowed {
+			cl.refund(keys, i)
+			return &CompositeResult{
+				Allowed:   false,
+				Dimension: dim,
+				Result:    result,
+			}, nil
+		}
+
+		if binding == nil || result.Remaining < binding.Result.Remaining {
+			binding = &CompositeResult{
+				Allowed:   true,
+				Dimension: dim,
+				Result:    result,
+			}
+		}
+	}
+
+	return binding, nil
+}
+
+// refund returns the token charged to each of the first n dimensions whose limiter
+// supports refunds
+func (cl *CompositeLimiter) refund(keys []string, n int) {
+	for i, dim := range cl.dimensions[:n] {
+		rf, ok := dim.Limiter.(refundLimiter)
+		if !ok {
+			continue
+		}
+		if err := rf.Refund(keys[i], 1.0); err != nil {
+			log.Printf("WARNING: Failed to refund %s dimension for key %s - %v", dim.Name, keys[i], err)
+		}
+	}
+}
+
+// CompositeMiddleware creates a Fiber middleware enforcing every dimension of the
+// CompositeLimiter. Blocked responses name the binding dimension in the
+// Scope header and the blockedBy body field, so clients can tell whether
+// backing off individually helps.
+func CompositeMiddleware(cl *CompositeLimiter, opts ...Option) fiber.Handler {
+	options := newMiddlewareOptions(opts)
+
+	return func(c *fiber.Ctx) error {
+		// Extract the key of every dimension
+		keys := make([]string, len(cl.dimensions))
+		for i, dim := range cl.dimensions {
+			keys[i] = options.key(c, dim.KeyFunc)
+			if keys[i] == "" {
+				log.Printf("INFO: Decision: REJECTED (401) - Reason: Missing client identity for %s limit", dim.Name)
+				return c.Status(fiber.StatusUnauthorized).JSON(missingIdentityBody)
+			}
+		}
+
+		// Check all dimensions, propagating the request context to Redis
+		composite, err := cl.AllowCtx(c.UserContext(), keys)
+		if err != nil {
+			// On error, apply the configured failure mode for this kind of error
+			mode := options.requestFailureMode(c, err)
+			log.Printf("ERROR: Critical Redis Error: Composite rate limiter execution failure for keys %v - %v. Falling back to %s Policy.", keys, err, mode)
+			if mode == FailClosed {
+				return limiterUnavailable(c)
+			}
+			return limiterBypassed(c, options.Headers, BypassRedisError)
+		}
+
+		// Set rate limit headers describing the binding dimension
+		result := composite.Result
+		if capped, ok := composite.Dimension.Limiter.(capacityLimiter); ok {
+			options.Headers.set(c, options.Headers.Limit, fmt.Sprintf("%.0f", capped.Capacity()))
+		}
+		options.Headers.set(c, options.Headers.Remaining, formatRemaining(result.Remaining, options.RemainingRounding))
+		options.Headers.set(c, options.Headers.Scope, composite.Dimension.Name)
+
+		if !composite.Allowed {
+			retryAfter := retryAfterHeaderSeconds(options.retryAfter(result))
+			options.Headers.set(c, options.Headers.RetryAfter, fmt.Sprintf("%d", retryAfter))
+
+			log.Printf("INFO: Decision: BLOCKED (429) - keys: %v, Reason: %s rate limit exceeded, Retry-After: %d seconds", keys, composite.Dimension.Name, retryAfter)
+
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error":     "Rate limit exceeded",
+				"message":   "Too many requests. Please try again later.",
+				"blockedBy": composite.Dimension.Name,
+			})
+		}
+
+		log.Printf("INFO: Decision: ALLOWED - keys: %v, Scope: %s, Remaining: %.2f", keys, composite.Dimension.Name, result.Remaining)
+
+		return c.Next()
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestCompositeMiddlewareBlockedBy tests that blocked responses identify the binding dimension
+func TestCompositeMiddlewareBlockedBy(t *testing.T) {
+	// Setup: Very low rates so buckets don't refill during the test
+	globalLimiter, cleanup, err := setupTestRateLimiter(0.01, 3.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+	userLimiter := NewRateLimiter(globalLimiter.manager, 0.01, 2.0)
+
+	for _, key := range []string{"test_global", "test_user_a", "test_user_b"} {
+		globalLimiter.manager.GetClient(key).Del(testCtx, testBucketKey(key))
+	}
+
+	composite := NewCompositeLimiter(
+		LimitDimension{Name: "global", Limiter: globalLimiter, KeyFunc: func(c *fiber.Ctx) string { return "test_global" }},
+		LimitDimension{Name: "user", Limiter: userLimiter, KeyFunc: func(c *fiber.Ctx) string { return c.Get("X-User") }},
+	)
+
+	app := fiber.New()
+	app.Get("/", CompositeMiddleware(composite), func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	request := func(user string) (int, string, string) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-User", user)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		var body struct {
+			BlockedBy string `json:"blockedBy"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, resp.Header.Get("X-RateLimit-Scope"), body.BlockedBy
+	}
+
+	// User A exhausts their own limit of 2 while global still has capacity
+	for i := 0; i < 2; i++ {
+		if status, _, _ := request("test_user_a"); status != fiber.StatusOK {
+			t.Fatalf("Request %d for user A should have been allowed, got status %d", i+1, status)
+		}
+	}
+	status, scope, blockedBy := request("test_user_a")
+	if status != fiber.StatusTooManyRequests {
+		t.Fatalf("Expected user A to be blocked, got status %d", status)
+	}
+	if scope != "user" || blockedBy != "user" {
+		t.Errorf("Expected user A to be blocked by the user dimension, got scope %q and blockedBy %q", scope, blockedBy)
+	}
+
+	// User A's rejected request wasn't charged globally, leaving one global token for user B
+	if status, _, _ := request("test_user_b"); status != fiber.StatusOK {
+		t.Fatalf("Expected user B to use the last global token, got status %d", status)
+	}
+
+	// The global bucket is now drained, so user B is blocked globally
+	status, scope, blockedBy = request("test_user_b")
+	if status != fiber.StatusTooManyRequests {
+		t.Fatalf("Expected user B to be blocked, got status %d", status)
+	}
+	if scope != "global" || blockedBy != "global" {
+		t.Errorf("Expected user B to be blocked by the global dimension, got scope %q and blockedBy %q", scope, blockedBy)
+	}
+
+	// Requests without a user are rejected before any dimension is checked
+	if status, _, _ := request(""); status != fiber.StatusUnauthorized {
+		t.Errorf("Expected a request without a user to be rejected with 401, got status %d", status)
+	}
+}
+
+// TestCompositeOtherLimiters tests that dimensions enforced by other limiters are
+// checked in order, refunding the token bucket dimensions before a blocking one
+func TestCompositeOtherLimiters(t *testing.T) {
+	ipLimiter, cleanup, err := setupTestRateLimiter(0.001, 5.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+	accountLimiter := NewFixedWindowLimiter(ipLimiter.manager, 1, time.Minute)
+
+	ipLimiter.manager.GetClient("test_other_ip").Del(testCtx, testBucketKey("test_other_ip"))
+	client := accountLimiter.manager.GetClient("test_other_account")
+	client.Del(testCtx, accountLimiter.fixedWindowKey("test_other_account"))
+	defer client.Del(testCtx, accountLimiter.fixedWindowKey("test_other_account"))
+
+	composite := NewCompositeLimiter(
+		LimitDimension{Name: "ip", Limiter: ipLimiter},
+		LimitDimension{Name: "account", Limiter: accountLimiter},
+	)
+	keys := []string{"test_other_ip", "test_other_account"}
+
+	result, err := composite.AllowCtx(testCtx, keys)
+	if err != nil || !result.Allowed {
+		t.Fatalf("Expected the first request allowed, got %+v (%v)", result, err)
+	}
+	result, err = composite.AllowCtx(testCtx, keys)
+	if err != nil {
+		t.Fatalf("AllowCtx failed: %v", err)
+	}
+	if result.Allowed || result.Dimension.Name != "account" {
+		t.Errorf("Expected the second request blocked by the account dimension, got %+v", result)
+	}
+	assertTokens(t, ipLimiter, "test_other_ip", 4)
+}
+
+// TestCompositeAllOrNothing tests that a rejected request charges no dimension, both for
+// buckets checked atomically on one shard and for buckets on different shards
+func TestCompositeAllOrNothing(t *testing.T) {
+	tests := []struct {
+		name        string
+		sharedShard bool
+	}{
+		{"atomic on one shard", true},
+		{"refunded across shards", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ipLimiter, cleanup, err := setupTestRateLimiter(0.001, 5.0)
+			if err != nil {
+				t.Fatalf("Failed to setup test rate limiter: %v", err)
+			}
+			defer cleanup()
+
+			// A second manager has its own clients, which the composite treats as another shard
+			manager := ipLimiter.manager
+			if !tt.sharedShard {
+				otherLimiter, otherCleanup, err := setupTestRateLimiter(0.001, 5.0)
+				if err != nil {
+					t.Fatalf("Failed to setup test rate limiter: %v", err)
+				}
+				defer otherCleanup()
+				manager = otherLimiter.manager
+			}
+			userLimiter := NewRateLimiter(manager, 0.001, 5.0)
+			keyLimiter := NewRateLimiter(manager, 0.001, 1.0)
+
+			composite := NewCompositeLimiter(
+				LimitDimension{Name: "ip", Limiter: ipLimiter},
+				LimitDimension{Name: "user", Limiter: userLimiter},
+				LimitDimension{Name: "key", Limiter: keyLimiter},
+			)
+			keys := []string{"test_aon_ip", "test_aon_user", "test_aon_key"}
+
+			// The first request fits everywhere and drains the key dimension
+			result, err := composite.AllowCtx(testCtx, keys)
+			if err != nil {
+				t.Fatalf("AllowCtx failed: %v", err)
+			}
+			if !result.Allowed || result.Dimension.Name != "key" {
+				t.Fatalf("Expected an allowed request bound by the key dimension, got %+v", result)
+			}
+
+			// The second is rejected by the last dimension
+			result, err = composite.AllowCtx(testCtx, keys)
+			if err != nil {
+				t.Fatalf("AllowCtx failed: %v", err)
+			}
+			if result.Allowed || result.Dimension.Name != "key" {
+				t.Fatalf("Expected a request blocked by the key dimension, got %+v", result)
+			}
+			if result.Result.RetryAfter <= 0 {
+				t.Errorf("Expected a retry-after for the blocking dimension, got %v", result.Result.RetryAfter)
+			}
+
+			// Only the allowed request was charged
+			assertTokens(t, ipLimiter, "test_aon_ip", 4)
+			assertTokens(t, userLimiter, "test_aon_user", 4)
+			assertTokens(t, keyLimiter, "test_aon_key", 0)
+		})
+	}
+}
+
+// TestCompositeIPAndAccount tests that a request must pass both its IP and its account
+// limit, and that the dimension that wasn't exhausted isn't charged for a rejected
+// request, whichever dimension blocks and wherever the buckets live
+func TestCompositeIPAndAccount(t *testing.T) {
+	tests := []struct {
+		name        string
+		sharedShard bool
+		ipOver      bool
+	}{
+		{"account over, atomic", true, false},
+		{"ip over, atomic", true, true},
+		{"account over, refunded", false, false},
+		{"ip over, refunded", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ipLimiter, cleanup, err := setupTestRateLimiter(0.001, 3.0)
+			if err != nil {
+				t.Fatalf("Failed to setup test rate limiter: %v", err)
+			}
+			defer cleanup()
+
+			manager := ipLimiter.manager
+			if !tt.sharedShard {
+				otherLimiter, otherCleanup, err := setupTestRateLimiter(0.001, 3.0)
+				if err != nil {
+					t.Fatalf("Failed to setup test rate limiter: %v", err)
+				}
+				defer otherCleanup()
+				manager = otherLimiter.manager
+			}
+			accountLimiter := NewRateLimiter(manager, 0.001, 3.0)
+
+			composite := NewCompositeLimiter(
+				LimitDimension{Name: "ip", Limiter: ipLimiter},
+				LimitDimension{Name: "account", Limiter: accountLimiter},
+			)
+
+			// Exhaust one dimension through another key of the other
+			ipKey, accountKey := "test_dual_ip", "test_dual_account"
+			exhaust, blocking := []string{ipKey, "test_dual_other_account"}, "ip"
+			if !tt.ipOver {
+				exhaust, blocking = []string{"test_dual_other_ip", accountKey}, "account"
+			}
+			for i := 0; i < 3; i++ {
+				if result, err := composite.AllowCtx(testCtx, exhaust); err != nil || !result.Allowed {
+					t.Fatalf("Expected request %d to be allowed, got %+v (%v)", i+1, result, err)
+				}
+			}
+
+			result, err := composite.AllowCtx(testCtx, []string{ipKey, accountKey})
+			if err != nil {
+				t.Fatalf("AllowCtx failed: %v", err)
+			}
+			if result.Allowed || result.Dimension.Name != blocking {
+				t.Fatalf("Expected a request blocked by the %s dimension, got %+v", blocking, result)
+			}
+
+			// The dimension with tokens left kept all of them
+			if tt.ipOver {
+				assertTokens(t, ipLimiter, ipKey, 0)
+				assertTokens(t, accountLimiter, accountKey, 3)
+			} else {
+				assertTokens(t, ipLimiter, ipKey, 3)
+				assertTokens(t, accountLimiter, accountKey, 0)
+			}
+		})
+	}
+}
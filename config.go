@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config describes the Redis shards and the limits of the service, loaded from the
+// file named by CONFIG_PATH
+type Config struct {
+	RedisAddrs []string      `json:"redis_addrs"` // shard addresses, empty uses REDIS_ADDRS
+	Rate       float64       `json:"rate"`        // default tokens per second
+	Capacity   float64       `json:"capacity"`    // default bucket capacity
+	Routes     []RouteConfig `json:"routes"`      // per-route overrides of the default limit
+}
+
+// RouteConfig overrides the default limit for the requests to one path
+type RouteConfig struct {
+	Path      string  `json:"path"`
+	Rate      float64 `json:"rate"`
+	Capacity  float64 `json:"capacity"`
+	KeyPrefix string  `json:"key_prefix"` // keeps the route's buckets apart, empty shares the default buckets
+}
+
+// DefaultConfig returns the limits the service uses without a config file
+func DefaultConfig() *Config {
+	return &Config{
+		Rate:     5,
+		Capacity: 10,
+		Routes: []RouteConfig{
+			{Path: "/api/search", Rate: 2, Capacity: 10, KeyPrefix: "search"},
+			{Path: "/api/upload", Rate: 0.5, Capacity: 2, KeyPrefix: "upload"},
+		},
+	}
+}
+
+// LoadConfig reads the config file at path on top of DefaultConfig, as YAML for
+// .yaml and .yml files and as JSON for .json files. Settings missing from the file
+// keep their defaults.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var format string
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		format = "json"
+	case ".yaml", ".yml":
+		format = "yaml"
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported extension %q, expected .json, .yaml or .yml", path, ext)
+	}
+
+	cfg, err := ParseConfig(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ParseConfig parses a "json" or "yaml" config on top of DefaultConfig and validates it
+func ParseConfig(data []byte, format string) (*Config, error) {
+	if format == "yaml" {
+		// Convert the document to JSON, so both formats share the strict decoding below
+		var value interface{}
+		if err := yaml.Unmarshal(data, &value); err != nil {
+			return nil, err
+		}
+		var err error
+		if data, err = json.Marshal(value); err != nil {
+			return nil, fmt.Errorf("invalid config: %w", err)
+		}
+	} else if format != "json" {
+		return nil, fmt.Errorf("unsupported config format %q", format)
+	}
+
+	// Decode routes into an empty list, as decoding into the default routes would
+	// merge the fields of both
+	cfg := DefaultConfig()
+	defaultRoutes := cfg.Routes
+	cfg.Routes = nil
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	if cfg.Routes == nil {
+		cfg.Routes = defaultRoutes
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, nil
+}
+
+// Validate checks that every limit is positive and every route has a unique path,
+// reporting all problems at once
+func (c *Config) Validate() error {
+	var errs []error
+	if err := validateLimits(c.Rate, c.Capacity); err != nil {
+		errs = append(errs, fmt.Errorf("default limit: %w", err))
+	}
+	errs = append(errs, ValidateAddresses(c.RedisAddrs)...)
+
+	seen := make(map[string]struct{}, len(c.Routes))
+	for i, route := range c.Routes {
+		if !strings.HasPrefix(route.Path, "/") {
+			errs = append(errs, fmt.Errorf("route %d: path %q must start with /", i, route.Path))
+		} else if _, ok := seen[route.Path]; ok {
+			errs = append(errs, fmt.Errorf("route %s: duplicate path", route.Path))
+		}
+		seen[route.Path] = struct{}{}
+
+		if err := validateLimits(route.Rate, route.Capacity); err != nil {
+			errs = append(errs, fmt.Errorf("route %s: %w", route.Path, err))
+		}
+		if err := validateKeyPrefix(route.KeyPrefix); err != nil {
+			errs = append(errs, fmt.Errorf("route %s: %w", route.Path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Route returns the override of path, if any
+func (c *Config) Route(path string) (RateLimitConfig, bool) {
+	for _, route := range c.Routes {
+		if route.Path == path {
+			return RateLimitConfig{Rate: route.Rate, Capacity: route.Capacity, KeyPrefix: route.KeyPrefix}, true
+		}
+	}
+	return RateLimitConfig{}, false
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const sampleYAMLConfig = `# Limits of the staging gateway
+redis_addrs:
+  - localhost:6379
+  - "redis://localhost:6380/1"
+rate: 20
+capacity: 40
+routes:
+  - path: /api/search   # cheap reads
+    rate: 4
+    capacity: 8
+    key_prefix: search
+  - path: /api/export
+    rate: 0.1
+    capacity: 1
+`
+
+const sampleJSONConfig = `{
+	"redis_addrs": ["localhost:6379", "redis://localhost:6380/1"],
+	"rate": 20,
+	"capacity": 40,
+	"routes": [
+		{"path": "/api/search", "rate": 4, "capacity": 8, "key_prefix": "search"},
+		{"path": "/api/export", "rate": 0.1, "capacity": 1}
+	]
+}`
+
+// writeConfig writes a config file with the given name to a temporary directory
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return path
+}
+
+// TestLoadConfig tests that YAML and JSON files describe the same config and the
+// limiters built from it
+func TestLoadConfig(t *testing.T) {
+	expected := &Config{
+		RedisAddrs: []string{"localhost:6379", "redis://localhost:6380/1"},
+		Rate:       20,
+		Capacity:   40,
+		Routes: []RouteConfig{
+			{Path: "/api/search", Rate: 4, Capacity: 8, KeyPrefix: "search"},
+			{Path: "/api/export", Rate: 0.1, Capacity: 1},
+		},
+	}
+
+	for _, tt := range []struct{ name, content string }{
+		{"limits.yaml", sampleYAMLConfig},
+		{"limits.yml", sampleYAMLConfig},
+		{"limits.json", sampleJSONConfig},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadConfig(writeConfig(t, tt.name, tt.content))
+			if err != nil {
+				t.Fatalf("Failed to load config: %v", err)
+			}
+			if !reflect.DeepEqual(cfg, expected) {
+				t.Fatalf("Unexpected config %+v", cfg)
+			}
+
+			route, ok := cfg.Route("/api/search")
+			if !ok {
+				t.Fatal("Expected an override for /api/search")
+			}
+			limiter := NewRateLimiter(nil, route.Rate, route.Capacity, WithKeyPrefix(route.KeyPrefix))
+			if rate, capacity := limiter.Limits(); rate != 4 || capacity != 8 {
+				t.Errorf("Expected limits 4/8, got %v/%v", rate, capacity)
+			}
+			if key := limiter.bucketKey("alice"); key != "ratelimit:tb:search:alice" {
+				t.Errorf("Unexpected bucket key %q", key)
+			}
+			if _, ok := cfg.Route("/api/resource"); ok {
+				t.Error("Expected no override for /api/resource")
+			}
+		})
+	}
+}
+
+// TestLoadConfigDefaults tests that settings missing from the file keep their defaults
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, "limits.yaml", "rate: 8\n"))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	defaults := DefaultConfig()
+	if cfg.Rate != 8 || cfg.Capacity != defaults.Capacity || !reflect.DeepEqual(cfg.Routes, defaults.Routes) {
+		t.Errorf("Unexpected config %+v", cfg)
+	}
+}
+
+// TestLoadConfigInvalid tests that bad configs are rejected with a descriptive error
+func TestLoadConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name, file, content string
+		expected            string
+	}{
+		{"zero rate", "limits.yaml", "rate: 0\n", "default limit: rate must be a positive number"},
+		{"negative route capacity", "limits.json", `{"routes": [{"path": "/api/x", "rate": 1, "capacity": -2}]}`, "route /api/x: capacity must be a positive number"},
+		{"relative path", "limits.yaml", "routes:\n  - path: api\n    rate: 1\n    capacity: 1\n", `path "api" must start with /`},
+		{"duplicate path", "limits.json", `{"routes": [{"path": "/a", "rate": 1, "capacity": 1}, {"path": "/a", "rate": 2, "capacity": 2}]}`, "route /a: duplicate path"},
+		{"flow mapping", "limits.yaml", "routes:\n  - {path: /a}\n", "route /a: rate must be a positive number"},
+		{"unknown field", "limits.json", `{"rates": 5}`, `unknown field "rates"`},
+		{"bad address", "limits.yaml", "redis_addrs: [localhost]\n", "invalid Redis address 0"},
+		{"bad yaml", "limits.yaml", "rate: 5\n  capacity: 10\n", "yaml: line 2"},
+		{"not a mapping", "limits.yaml", "rate 5\n", "cannot unmarshal string"},
+		{"extension", "limits.toml", "rate = 5\n", "unsupported extension"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfig(writeConfig(t, tt.file, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected an error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+// TestParseConfigYAMLScalars tests comments and quoted scalars of YAML configs
+func TestParseConfigYAMLScalars(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`
+rate: 3 # it's the default
+routes:
+  - path: "/api/a#b"   # it's quoted
+    rate: 1
+    capacity: 2
+    key_prefix: 'team''s'
+  - {path: "/api/caf\u00e9", rate: 1, capacity: 2}
+`), "yaml")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	expected := []RouteConfig{
+		{Path: "/api/a#b", Rate: 1, Capacity: 2, KeyPrefix: "team's"},
+		{Path: "/api/caf\u00e9", Rate: 1, Capacity: 2},
+	}
+	if cfg.Rate != 3 || !reflect.DeepEqual(cfg.Routes, expected) {
+		t.Errorf("Unexpected config %+v", cfg)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v2"
+)
+
+// LastRequestWarning is the X-RateLimit-Warning value of a courtesy request
+const LastRequestWarning = "last-request"
+
+// CourtesyFunc reports whether the user of a request, such as a high-value account,
+// gets a courtesy request when its bucket runs out
+type CourtesyFunc func(c *fiber.Ctx, userID string) bool
+
+// courtesyKey returns the Redis key recording the given userID's courtesy request
+func (rl *RateLimiter) courtesyKey(userID string) string {
+	return fmt.Sprintf("ratelimit:courtesy:%s", rl.manager.keyUserID(userID))
+}
+
+// UseCourtesy claims the courtesy request of userID for window, reporting false if it
+// was already used within the window. The claim is atomic, so it's granted once
+// across all instances.
+func (rl *RateLimiter) UseCourtesy(ctx context.Context, userID string, window time.Duration) (bool, error) {
+	var granted bool
+	err := rl.manager.guardUser(userID, func(client *redis.Client) error {
+		var err error
+		granted, err = client.SetNX(ctx, rl.courtesyKey(userID), 1, window).Result()
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to record courtesy request: %w", err)
+	}
+	return granted, nil
+}
+
+// courtesyWindow returns the window of courtesy requests, defaulting to the time an
+// empty bucket takes to refill completely
+func (o *MiddlewareOptions) courtesyWindow(limiter *RateLimiter) time.Duration {
+	if o.CourtesyWindow > 0 {
+		return o.CourtesyWindow
+	}
+	return refillWindow(limiter)
+}
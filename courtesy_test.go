@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestMiddlewareLastRequestCourtesy tests that eligible users get one courtesy request
+// per window at 0 remaining, and everyone else is blocked at once
+func TestMiddlewareLastRequestCourtesy(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.01, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	vip, free := "test_vip_courtesy", "test_free_courtesy"
+	for _, userID := range []string{vip, free} {
+		client := limiter.manager.GetClient(userID)
+		client.Del(testCtx, limiter.courtesyKey(userID))
+		defer client.Del(testCtx, limiter.courtesyKey(userID))
+	}
+
+	eligible := func(c *fiber.Ctx, userID string) bool {
+		return strings.HasPrefix(userID, "test_vip")
+	}
+	app := newTestApp(RateLimitMiddleware(limiter,
+		WithKeyFunc(func(c *fiber.Ctx) string { return c.Get("X-User") }),
+		WithLastRequestCourtesy(eligible, time.Minute),
+	))
+	send := func(userID string) (int, string) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-User", userID)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		return resp.StatusCode, resp.Header.Get("X-RateLimit-Warning")
+	}
+
+	tests := []struct {
+		userID  string
+		status  int
+		warning string
+	}{
+		{vip, fiber.StatusOK, ""},
+		{vip, fiber.StatusOK, LastRequestWarning},
+		{vip, fiber.StatusTooManyRequests, ""},
+		{free, fiber.StatusOK, ""},
+		{free, fiber.StatusTooManyRequests, ""},
+	}
+	for i, tt := range tests {
+		status, warning := send(tt.userID)
+		if status != tt.status || warning != tt.warning {
+			t.Errorf("Request %d of %s: expected status %d and warning %q, got %d and %q", i+1, tt.userID, tt.status, tt.warning, status, warning)
+		}
+	}
+
+	// A new window grants another courtesy request
+	limiter.manager.GetClient(vip).Del(testCtx, limiter.courtesyKey(vip))
+	if status, warning := send(vip); status != fiber.StatusOK || warning != LastRequestWarning {
+		t.Errorf("Expected a courtesy request in the new window, got %d and %q", status, warning)
+	}
+}
+
+// TestMiddlewareCourtesyOnlyAtZero tests that requests blocked while tokens remain get no courtesy
+func TestMiddlewareCourtesyOnlyAtZero(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.01, 3.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	userID := "test_vip_courtesy_cost"
+	client := limiter.manager.GetClient(userID)
+	client.Del(testCtx, limiter.courtesyKey(userID))
+	defer client.Del(testCtx, limiter.courtesyKey(userID))
+
+	app := newTestApp(RateLimitMiddleware(limiter,
+		WithKeyFunc(func(c *fiber.Ctx) string { return c.Get("X-User") }),
+		WithCostFunc(func(c *fiber.Ctx) (float64, error) { return strconv.ParseFloat(c.Get("X-Cost"), 64) }),
+		WithLastRequestCourtesy(func(c *fiber.Ctx, userID string) bool { return true }, 0),
+	))
+
+	// 3 - 2 leaves 1 token, which a second request of cost 2 exceeds
+	for i, expected := range []int{fiber.StatusOK, fiber.StatusTooManyRequests} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-User", userID)
+		req.Header.Set("X-Cost", "2")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		if resp.StatusCode != expected || resp.Header.Get("X-RateLimit-Warning") != "" {
+			t.Errorf("Request %d: expected status %d without warning, got %d and %q", i+1, expected, resp.StatusCode, resp.Header.Get("X-RateLimit-Warning"))
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestCronScheduleNext tests the next run time of common schedules
+func TestCronScheduleNext(t *testing.T) {
+	// Wednesday 2024-05-15 10:17:30 UTC
+	from := time.Date(2024, 5, 15, 10, 17, 30, 0, time.UTC)
+
+	tests := []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"@daily", time.Date(2024, 5, 16, 0, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 5, 15, 10, 30, 0, 0, time.UTC)},
+		{"30 9 * * 1-5", time.Date(2024, 5, 16, 9, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 5, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 1,20 * *", time.Date(2024, 5, 20, 12, 0, 0, 0, time.UTC)},
+		// Day of month and day of week are OR'ed when both are restricted
+		{"0 0 1 * 5", time.Date(2024, 5, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+
+	for _, tt := range tests {
+		s, err := ParseCronSchedule(tt.spec)
+		if err != nil {
+			t.Fatalf("ParseCronSchedule(%q) failed: %v", tt.spec, err)
+		}
+		if got := s.Next(from); !got.Equal(tt.expected) {
+			t.Errorf("Next for %q = %v, expected %v", tt.spec, got, tt.expected)
+		}
+	}
+}
+
+// TestCronScheduleLocation tests that the schedule is evaluated in the given time zone
+func TestCronScheduleLocation(t *testing.T) {
+	loc := time.FixedZone("UTC+9", 9*60*60)
+	s, err := ParseCronSchedule("@midnight")
+	if err != nil {
+		t.Fatalf("ParseCronSchedule failed: %v", err)
+	}
+
+	next := s.Next(time.Date(2024, 5, 15, 10, 0, 0, 0, time.UTC).In(loc))
+	if expected := time.Date(2024, 5, 15, 15, 0, 0, 0, time.UTC); !next.Equal(expected) {
+		t.Errorf("Expected the next local midnight at %v, got %v", expected, next.UTC())
+	}
+}
+
+// TestParseCronScheduleInvalid tests that malformed specs are rejected
+func TestParseCronScheduleInvalid(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@often"} {
+		if _, err := ParseCronSchedule(spec); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+// WithAllowDebt lets checks draw the bucket up to maxDebt tokens below zero instead
+// of blocking them (default 0, no debt). A request is allowed as long as the tokens
+// left after it stay at or above -maxDebt, so with a maxDebt of 0.5 a request costing
+// 1 token goes through once 0.5 tokens have refilled, leaving the bucket at -0.5.
+// The debt is paid back by the refill before the bucket can cover further requests.
+//
+// This deliberately over-allows: clients landing just short of a full token aren't
+// turned away, at the price of exceeding the limit by up to maxDebt tokens per
+// bucket at any time. It applies to Allow, AllowN, AllowMany and AllowTiered;
+// reservations, transfers and composite checks never go into debt. The remaining
+// tokens reported for a bucket in debt are negative. A request costing more than the
+// capacity is blocked even when the debt would cover it.
+func WithAllowDebt(maxDebt float64) LimiterOption {
+	return func(rl *RateLimiter) {
+		rl.maxDebt = max(0, maxDebt)
+	}
+}
+
+// debtRequirement returns the tokens a bucket must hold to cover a request of
+// requested tokens, accounting for the allowed debt
+func (rl *RateLimiter) debtRequirement(requested float64) float64 {
+	return requested - rl.maxDebt
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestAllowDebtFloor tests that checks may draw the bucket down to -maxDebt and are
+// blocked below it
+func TestAllowDebtFloor(t *testing.T) {
+	base, cleanup, err := setupTestRateLimiter(1.0, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	tests := []struct {
+		name      string
+		maxDebt   float64
+		tokens    float64
+		allowed   bool
+		remaining float64
+	}{
+		{"partial token within debt", 0.5, 0.8, true, -0.2},
+		{"exactly at the floor", 0.5, 0.5, true, -0.5},
+		{"below the floor", 0.5, 0.4, false, 0.4},
+		{"already in debt", 0.5, -0.4, false, -0.4},
+		{"debt disabled", 0, 0.8, false, 0.8},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := NewRateLimiter(base.manager, 0.01, 1.0, WithAllowDebt(tt.maxDebt))
+			userID := "test_user_debt_" + strconv.Itoa(i)
+			key := limiter.bucketKey(userID)
+			now := float64(time.Now().UnixNano()) / 1e9
+			limiter.manager.GetClient(userID).HSet(testCtx, key, "tokens", tt.tokens, "lastRefill", now)
+
+			result, err := limiter.Allow(userID)
+			if err != nil {
+				t.Fatalf("Error calling Allow: %v", err)
+			}
+			if result.Allowed != tt.allowed || result.Remaining < tt.remaining-0.01 || result.Remaining > tt.remaining+0.01 {
+				t.Errorf("Expected allowed %v with %v remaining, got %+v", tt.allowed, tt.remaining, result)
+			}
+
+			// Blocked requests wait until the bucket is back above the debt floor
+			if !tt.allowed {
+				expected := time.Duration((1 - tt.maxDebt - tt.remaining) / 0.01 * float64(time.Second))
+				if diff := result.RetryAfter - expected; diff < -time.Second || diff > time.Second {
+					t.Errorf("Expected RetryAfter about %v, got %v", expected, result.RetryAfter)
+				}
+			}
+		})
+	}
+}
+
+// TestMiddlewareAllowDebtHeader tests that a bucket in debt reports 0 remaining
+func TestMiddlewareAllowDebtHeader(t *testing.T) {
+	base, cleanup, err := setupTestRateLimiter(1.0, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	limiter := NewRateLimiter(base.manager, 0.01, 1.0, WithAllowDebt(0.5))
+	userID := "test_user_debt_header"
+	now := float64(time.Now().UnixNano()) / 1e9
+	limiter.manager.GetClient(userID).HSet(testCtx, limiter.bucketKey(userID), "tokens", 0.8, "lastRefill", now)
+
+	app := newTestApp(RateLimitMiddleware(limiter, WithKeyFunc(func(c *fiber.Ctx) string { return userID })))
+	for _, request := range []string{"first", "second"} {
+		resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		if remaining := resp.Header.Get("X-RateLimit-Remaining"); remaining != "0" {
+			t.Errorf("Expected the %s request to report 0 remaining, got %q", request, remaining)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log"
+)
+
+// isDebugUser reports whether the bucket operations of userID are traced
+func (rl *RateLimiter) isDebugUser(userID string) bool {
+	_, ok := rl.debugUsers[userID]
+	return ok
+}
+
+// traceAllow logs the full bucket state of a token bucket check from the script's
+// {allowed, tokens, before, elapsed, refilled} reply
+func (rl *RateLimiter) traceAllow(userID string, requested float64, result *AllowResult, raw interface{}) {
+	values, ok := raw.([]interface{})
+	if !ok || len(values) < 5 {
+		log.Printf("DEBUG: Bucket trace - userID: %s, unexpected script reply %v", userID, raw)
+		return
+	}
+
+	var state [3]float64
+	for i := range state {
+		value, err := parseLuaNumber(values[i+2])
+		if err != nil {
+			log.Printf("DEBUG: Bucket trace - userID: %s, unexpected script reply %v - %v", userID, raw, err)
+			return
+		}
+		state[i] = value
+	}
+
+	rate, capacity := rl.Limits()
+	log.Printf("DEBUG: Bucket trace - userID: %s, Op: allow, Requested: %.4f, Allowed: %t, Tokens before: %.4f, Elapsed: %.4fs, Refilled: %.4f, Tokens after: %.4f, Rate: %.4f, Capacity: %.4f",
+		userID, requested, result.Allowed, state[0], state[1], state[2], result.Remaining, rate, capacity)
+}
+
+// traceRefund logs the bucket state after a refund from the script's tokens reply
+func (rl *RateLimiter) traceRefund(userID string, refunded float64, raw interface{}) {
+	tokens, err := parseLuaNumber(raw)
+	if err != nil {
+		log.Printf("DEBUG: Bucket trace - userID: %s, unexpected refund script reply %v - %v", userID, raw, err)
+		return
+	}
+
+	rate, capacity := rl.Limits()
+	log.Printf("DEBUG: Bucket trace - userID: %s, Op: refund, Refunded: %.4f, Tokens after: %.4f, Rate: %.4f, Capacity: %.4f",
+		userID, refunded, tokens, rate, capacity)
+}
+
+// parseAllowReply parses an {allowed, tokens} script reply for userID, logging the
+// raw reply when it can't be parsed and WithDebugScriptReplies is set
+func (rl *RateLimiter) parseAllowReply(userID string, raw interface{}) (*AllowResult, error) {
+	result, err := parseAllowResult(raw)
+	if err != nil && rl.debugReplies {
+		log.Printf("DEBUG: Unparseable script reply - userID: %s, Type: %T, Reply: %#v - %v", userID, raw, raw, err)
+	}
+	return result, err
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestDebugUsersTrace tests that bucket operations are traced for debug users only
+func TestDebugUsersTrace(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.001, 5.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+	WithDebugUsers("test_debugged")(limiter)
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	for _, userID := range []string{"test_debugged", "test_not_debugged"} {
+		if _, err := limiter.AllowN(userID, 2); err != nil {
+			t.Fatalf("AllowN failed: %v", err)
+		}
+		if err := limiter.Refund(userID, 1); err != nil {
+			t.Fatalf("Refund failed: %v", err)
+		}
+		if _, err := limiter.PeekState(userID); err != nil {
+			t.Fatalf("PeekState failed: %v", err)
+		}
+	}
+
+	output := logs.String()
+	for _, expected := range []string{
+		"userID: test_debugged, Op: allow, Requested: 2.0000, Allowed: true, Tokens before: 5.0000",
+		"Tokens after: 3.0000",
+		"userID: test_debugged, Op: refund, Refunded: 1.0000",
+		"userID: test_debugged, Op: peek, Exists: true",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected trace containing %q, got:\n%s", expected, output)
+		}
+	}
+	if strings.Contains(output, "test_not_debugged") {
+		t.Errorf("Expected no trace for other users, got:\n%s", output)
+	}
+}
+
+// TestDebugScriptReplies tests that unparseable script replies are logged with their
+// type only when enabled
+func TestDebugScriptReplies(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(1.0, 5.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	malformed := []interface{}{int64(1), []interface{}{"nested"}}
+	if _, err := limiter.parseAllowReply("test_user_replies", malformed); err == nil {
+		t.Fatal("Expected an error parsing a malformed reply")
+	}
+	if logs.Len() != 0 {
+		t.Errorf("Expected no diagnostic without the debug flag, got:\n%s", logs.String())
+	}
+
+	WithDebugScriptReplies()(limiter)
+	for _, raw := range []interface{}{malformed, "OK"} {
+		if _, err := limiter.parseAllowReply("test_user_replies", raw); err == nil {
+			t.Fatalf("Expected an error parsing %v", raw)
+		}
+	}
+	output := logs.String()
+	for _, expected := range []string{
+		`userID: test_user_replies, Type: []interface {}, Reply: []interface {}{1, []interface {}{"nested"}}`,
+		`Type: string, Reply: "OK"`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected diagnostic containing %q, got:\n%s", expected, output)
+		}
+	}
+
+	// Well-formed replies are not logged
+	logs.Reset()
+	if _, err := limiter.parseAllowReply("test_user_replies", []interface{}{int64(1), "4"}); err != nil {
+		t.Fatalf("Failed to parse a well-formed reply: %v", err)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("Expected no diagnostic for a well-formed reply, got:\n%s", logs.String())
+	}
+}
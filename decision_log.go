@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Severity numbers of the OpenTelemetry log data model
+const (
+	SeverityInfo  = 9
+	SeverityWarn  = 13
+	SeverityError = 17
+)
+
+// Decision outcomes used as the body of decision log records
+const (
+	DecisionAllowed = "allowed"
+	DecisionBlocked = "blocked"
+	DecisionError   = "error"
+)
+
+// LogRecord is a decision event in the OpenTelemetry log data model
+type LogRecord struct {
+	Timestamp      time.Time
+	SeverityNumber int
+	SeverityText   string
+	Body           string
+	Attributes     map[string]interface{} // string, bool, int, int64 or float64 values
+}
+
+// LogExporter sends batches of log records to a log backend, such as an OTel collector
+type LogExporter interface {
+	Export(ctx context.Context, records []LogRecord) error
+}
+
+// DecisionLogConfig configures a decision log, zero fields use the defaults
+type DecisionLogConfig struct {
+	QueueSize     int           // pending records before new ones are dropped (default 2048)
+	BatchSize     int           // records per export (default 512)
+	FlushInterval time.Duration // longest wait before a partial batch is exported (default 1s)
+	Timeout       time.Duration // timeout of each export (default 30s)
+}
+
+// DecisionLog emits the allowed, blocked and error decisions of a limiter as log
+// records through an exporter. Like the OTel batch processor, records are queued
+// without waiting and exported in batches from a background worker; when the queue
+// is full, new records are dropped.
+type DecisionLog struct {
+	exporter LogExporter
+	config   DecisionLogConfig
+	queue    chan LogRecord
+	done     chan struct{}
+	closing  sync.Once
+	dropped  atomic.Int64
+}
+
+// NewDecisionLog starts the worker exporting decision records through exporter
+func NewDecisionLog(exporter LogExporter, cfg DecisionLogConfig) *DecisionLog {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 2048
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 512
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+
+	d := &DecisionLog{
+		exporter: exporter,
+		config:   cfg,
+		queue:    make(chan LogRecord, cfg.QueueSize),
+		done:     make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+// WithDecisionLog emits every decision of the limiter to d, in addition to the
+// stdout logs
+func WithDecisionLog(d *DecisionLog) LimiterOption {
+	return func(rl *RateLimiter) {
+		rl.decisionLog = d
+	}
+}
+
+// emit queues a decision record without waiting, reporting whether it was queued
+func (d *DecisionLog) emit(record LogRecord) (queued bool) {
+	defer func() {
+		// Records racing with Close are dropped like those of a full queue
+		if recover() != nil {
+			queued = false
+		}
+	}()
+
+	select {
+	case d.queue <- record:
+		return true
+	default:
+		if dropped := d.dropped.Add(1); dropped == 1 || dropped%1000 == 0 {
+			log.Printf("WARNING: Decision log queue full, %d records dropped so far", dropped)
+		}
+		return false
+	}
+}
+
+// Dropped returns the number of records dropped because the queue was full
+func (d *DecisionLog) Dropped() int64 {
+	return d.dropped.Load()
+}
+
+// Close stops accepting records and waits until the queued ones are exported or ctx ends
+func (d *DecisionLog) Close(ctx context.Context) error {
+	d.closing.Do(func() {
+		close(d.queue)
+	})
+	select {
+	case <-d.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to drain decision log queue: %w", ctx.Err())
+	}
+}
+
+// run exports queued records whenever a batch is full or the flush interval passes
+func (d *DecisionLog) run() {
+	defer close(d.done)
+	ticker := time.NewTicker(d.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]LogRecord, 0, d.config.BatchSize)
+	for {
+		select {
+		case record, ok := <-d.queue:
+			if !ok {
+				d.export(batch)
+				return
+			}
+			batch = append(batch, record)
+			if len(batch) >= d.config.BatchSize {
+				d.export(batch)
+				batch = make([]LogRecord, 0, d.config.BatchSize)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				d.export(batch)
+				batch = make([]LogRecord, 0, d.config.BatchSize)
+			}
+		}
+	}
+}
+
+// export sends one batch, logging failures since decisions are never retried
+func (d *DecisionLog) export(batch []LogRecord) {
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d.config.Timeout)
+	defer cancel()
+	if err := d.exporter.Export(ctx, batch); err != nil {
+		log.Printf("WARNING: Failed to export %d decision log records - %v", len(batch), err)
+	}
+}
+
+// logDecision emits a decision of userID to the limiter's decision log, if any; a
+// non-nil err records an error decision
+func (rl *RateLimiter) logDecision(userID string, result *AllowResult, err error) {
+	if rl.decisionLog == nil {
+		return
+	}
+
+	record := LogRecord{
+		Timestamp: time.Now(),
+		Attributes: map[string]interface{}{
+			"userID": userID,
+			"shard":  rl.manager.shardIndex(userID),
+		},
+	}
+	switch {
+	case err != nil:
+		record.SeverityNumber, record.SeverityText, record.Body = SeverityError, "ERROR", DecisionError
+		record.Attributes["error"] = err.Error()
+	case result.Allowed:
+		record.SeverityNumber, record.SeverityText, record.Body = SeverityInfo, "INFO", DecisionAllowed
+	default:
+		record.SeverityNumber, record.SeverityText, record.Body = SeverityWarn, "WARN", DecisionBlocked
+		record.Attributes["retryAfter"] = result.RetryAfter.Seconds()
+	}
+	if result != nil {
+		record.Attributes["remaining"] = result.Remaining
+	}
+	rl.decisionLog.emit(record)
+}
+
+// OTLPLogExporter exports log records to an OpenTelemetry collector with OTLP/HTTP
+// in its JSON encoding
+type OTLPLogExporter struct {
+	Endpoint    string            // collector base URL, records are posted to <Endpoint>/v1/logs
+	ServiceName string            // service.name resource attribute (default velocity-rate-limiter)
+	Headers     map[string]string // extra request headers, e.g. for authentication
+	Client      *http.Client      // HTTP client used for exports (default http.DefaultClient)
+}
+
+// NewOTLPLogExporter creates an exporter posting to the collector at endpoint
+func NewOTLPLogExporter(endpoint string) *OTLPLogExporter {
+	return &OTLPLogExporter{Endpoint: endpoint}
+}
+
+// otlpValue is an OTLP AnyValue
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"` // int64 values are strings in OTLP JSON
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// otlpKeyValue is an OTLP attribute
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+// otlpLogRecord is an OTLP LogRecord
+type otlpLogRecord struct {
+	TimeUnixNano   string         `json:"timeUnixNano"`
+	SeverityNumber int            `json:"severityNumber"`
+	SeverityText   string         `json:"severityText"`
+	Body           otlpValue      `json:"body"`
+	Attributes     []otlpKeyValue `json:"attributes"`
+}
+
+// otlpScope is an OTLP InstrumentationScope
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+// otlpScopeLogs is an OTLP ScopeLogs
+type otlpScopeLogs struct {
+	Scope      otlpScope       `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+// otlpResource is an OTLP Resource
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+// otlpResourceLogs is an OTLP ResourceLogs
+type otlpResourceLogs struct {
+	Resource  otlpResource    `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+// otlpLogsRequest is the body of an OTLP ExportLogsServiceRequest
+type otlpLogsRequest struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+// toOTLPValue converts an attribute value, formatting unknown types as strings
+func toOTLPValue(value interface{}) otlpValue {
+	switch v := value.(type) {
+	case string:
+		return otlpValue{StringValue: &v}
+	case bool:
+		return otlpValue{BoolValue: &v}
+	case int:
+		s := strconv.Itoa(v)
+		return otlpValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return otlpValue{IntValue: &s}
+	case float64:
+		return otlpValue{DoubleValue: &v}
+	default:
+		s := fmt.Sprint(v)
+		return otlpValue{StringValue: &s}
+	}
+}
+
+// encode builds the OTLP JSON request of records
+func (e *OTLPLogExporter) encode(records []LogRecord) ([]byte, error) {
+	serviceName := e.ServiceName
+	if serviceName == "" {
+		serviceName = "velocity-rate-limiter"
+	}
+
+	scope := otlpScopeLogs{
+		Scope:      otlpScope{Name: "velocity-rate-limiter"},
+		LogRecords: make([]otlpLogRecord, 0, len(records)),
+	}
+	for _, record := range records {
+		otlpRecord := otlpLogRecord{
+			TimeUnixNano:   strconv.FormatInt(record.Timestamp.UnixNano(), 10),
+			SeverityNumber: record.SeverityNumber,
+			SeverityText:   record.SeverityText,
+			Body:           toOTLPValue(record.Body),
+			Attributes:     make([]otlpKeyValue, 0, len(record.Attributes)),
+		}
+		for key, value := range record.Attributes {
+			otlpRecord.Attributes = append(otlpRecord.Attributes, otlpKeyValue{Key: key, Value: toOTLPValue(value)})
+		}
+		scope.LogRecords = append(scope.LogRecords, otlpRecord)
+	}
+
+	return json.Marshal(otlpLogsRequest{ResourceLogs: []otlpResourceLogs{{
+		Resource:  otlpResource{Attributes: []otlpKeyValue{{Key: "service.name", Value: toOTLPValue(serviceName)}}},
+		ScopeLogs: []otlpScopeLogs{scope},
+	}}})
+}
+
+// Export posts records to the collector's /v1/logs endpoint
+func (e *OTLPLogExporter) Export(ctx context.Context, records []LogRecord) error {
+	body, err := e.encode(records)
+	if err != nil {
+		return fmt.Errorf("failed to encode log records: %w", err)
+	}
+
+	url := strings.TrimSuffix(e.Endpoint, "/") + "/v1/logs"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create OTLP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range e.Headers {
+		req.Header.Set(name, value)
+	}
+
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post OTLP logs: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("OTLP collector responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
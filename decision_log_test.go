@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingExporter is a LogExporter keeping the records it's given
+type recordingExporter struct {
+	mu      sync.Mutex
+	records []LogRecord
+}
+
+// Export records the batch
+func (e *recordingExporter) Export(ctx context.Context, records []LogRecord) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.records = append(e.records, records...)
+	return nil
+}
+
+// TestDecisionLog tests that allowed, blocked and error decisions are exported with
+// their severity and attributes
+func TestDecisionLog(t *testing.T) {
+	base, cleanup, err := setupTestRateLimiter(1.0, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	exporter := &recordingExporter{}
+	decisionLog := NewDecisionLog(exporter, DecisionLogConfig{})
+	limiter := NewRateLimiter(base.manager, 0.01, 1.0, WithDecisionLog(decisionLog))
+	userID := "test_user_decision_log"
+
+	limiter.Allow(userID) // allowed
+	limiter.Allow(userID) // blocked
+
+	// A canceled context makes the script fail
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	limiter.AllowCtx(canceled, userID)
+
+	if err := decisionLog.Close(context.Background()); err != nil {
+		t.Fatalf("Failed to close decision log: %v", err)
+	}
+	if len(exporter.records) != 3 {
+		t.Fatalf("Expected 3 records, got %d: %+v", len(exporter.records), exporter.records)
+	}
+
+	expected := []struct {
+		body     string
+		severity int
+		text     string
+	}{
+		{DecisionAllowed, SeverityInfo, "INFO"},
+		{DecisionBlocked, SeverityWarn, "WARN"},
+		{DecisionError, SeverityError, "ERROR"},
+	}
+	for i, record := range exporter.records {
+		if record.Body != expected[i].body || record.SeverityNumber != expected[i].severity || record.SeverityText != expected[i].text {
+			t.Errorf("Record %d: expected %+v, got %+v", i, expected[i], record)
+		}
+		if record.Attributes["userID"] != userID || record.Attributes["shard"] != limiter.manager.shardIndex(userID) {
+			t.Errorf("Record %d: unexpected attributes %v", i, record.Attributes)
+		}
+		if record.Timestamp.IsZero() {
+			t.Errorf("Record %d: expected a timestamp", i)
+		}
+	}
+	if remaining, ok := exporter.records[0].Attributes["remaining"].(float64); !ok || remaining != 0 {
+		t.Errorf("Expected 0 remaining after the allowed check, got %v", exporter.records[0].Attributes["remaining"])
+	}
+	if retryAfter, ok := exporter.records[1].Attributes["retryAfter"].(float64); !ok || retryAfter <= 0 {
+		t.Errorf("Expected a positive retryAfter on the blocked record, got %v", exporter.records[1].Attributes["retryAfter"])
+	}
+	if _, ok := exporter.records[2].Attributes["error"].(string); !ok {
+		t.Errorf("Expected an error attribute on the error record, got %v", exporter.records[2].Attributes)
+	}
+}
+
+// TestOTLPLogExporter tests the OTLP/HTTP JSON request posted to the collector
+func TestOTLPLogExporter(t *testing.T) {
+	var body map[string]interface{}
+	var path, contentType, auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, contentType, auth = r.URL.Path, r.Header.Get("Content-Type"), r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	exporter := NewOTLPLogExporter(server.URL + "/")
+	exporter.Headers = map[string]string{"Authorization": "Bearer secret"}
+	err := exporter.Export(context.Background(), []LogRecord{{
+		Timestamp:      time.Unix(0, 1700000000000000000),
+		SeverityNumber: SeverityWarn,
+		SeverityText:   "WARN",
+		Body:           DecisionBlocked,
+		Attributes:     map[string]interface{}{"userID": "test_user_otlp", "shard": 2, "retryAfter": 1.5},
+	}})
+	if err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	if path != "/v1/logs" || contentType != "application/json" || auth != "Bearer secret" {
+		t.Errorf("Unexpected request: path %q, content type %q, authorization %q", path, contentType, auth)
+	}
+
+	resource := body["resourceLogs"].([]interface{})[0].(map[string]interface{})
+	service := resource["resource"].(map[string]interface{})["attributes"].([]interface{})[0].(map[string]interface{})
+	if service["key"] != "service.name" || service["value"].(map[string]interface{})["stringValue"] != "velocity-rate-limiter" {
+		t.Errorf("Unexpected resource attribute %v", service)
+	}
+	record := resource["scopeLogs"].([]interface{})[0].(map[string]interface{})["logRecords"].([]interface{})[0].(map[string]interface{})
+	if record["timeUnixNano"] != "1700000000000000000" || record["severityNumber"] != float64(SeverityWarn) || record["severityText"] != "WARN" {
+		t.Errorf("Unexpected record %v", record)
+	}
+	if record["body"].(map[string]interface{})["stringValue"] != DecisionBlocked {
+		t.Errorf("Unexpected body %v", record["body"])
+	}
+
+	attributes := map[string]map[string]interface{}{}
+	for _, attribute := range record["attributes"].([]interface{}) {
+		kv := attribute.(map[string]interface{})
+		attributes[kv["key"].(string)] = kv["value"].(map[string]interface{})
+	}
+	if attributes["userID"]["stringValue"] != "test_user_otlp" || attributes["shard"]["intValue"] != "2" || attributes["retryAfter"]["doubleValue"] != 1.5 {
+		t.Errorf("Unexpected attributes %v", attributes)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v2"
+)
+
+// dedupPending is the stored outcome of a submission whose handler hasn't finished yet
+const dedupPending = "pending"
+
+// dedupLuaScript is the Lua script for atomically claiming a submission: it records
+// ARGV[1] at KEYS[1] for ARGV[2] milliseconds unless the key exists, in which case it
+// returns the stored outcome and the milliseconds left
+const dedupLuaScript = `
+local existing = redis.call('GET', KEYS[1])
+if existing then
+    return {0, existing, redis.call('PTTL', KEYS[1])}
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return {1}
+`
+
+// dedupScript is the precomputed dedupLuaScript, hashed once for EVALSHA
+var dedupScript = redis.NewScript(dedupLuaScript)
+
+// DedupConfig configures DedupMiddleware, zero fields use the defaults
+type DedupConfig struct {
+	Window      time.Duration // how long an identical submission counts as a duplicate (default 10s)
+	MaxBodySize int64         // largest body hashed, larger ones are rejected with 413 (default 1MB)
+	KeyFunc     KeyFunc       // extracts the submitting user (default c.IP())
+	Headers     HeaderNames   // names of the duplicate headers (default CanonicalHeaders)
+}
+
+// Duplicate describes the first of a set of identical submissions
+type Duplicate struct {
+	Status     string        // response status of the first submission, or "pending" while it runs
+	RetryAfter time.Duration // time until identical submissions are accepted again
+}
+
+// dedupKey returns the Redis key recording the submission of bodyHash by userID
+func (rl *RateLimiter) dedupKey(userID, bodyHash string) string {
+	return fmt.Sprintf("ratelimit:dedup:%s:%s", rl.manager.keyUserID(userID), bodyHash)
+}
+
+// ClaimSubmission records the submission of bodyHash by userID for window, returning
+// nil if it's the first within the window and the earlier submission otherwise
+func (rl *RateLimiter) ClaimSubmission(ctx context.Context, userID, bodyHash string, window time.Duration) (*Duplicate, error) {
+	key := rl.dedupKey(userID, bodyHash)
+
+	reply, err := rl.manager.runOnShard(ctx, userID, dedupScript, []string{key}, dedupPending, window.Milliseconds()).Slice()
+	if err != nil {
+		log.Printf("ERROR: Critical Redis Error: Lua dedup script execution failure for userID %s - %v", userID, err)
+		return nil, fmt.Errorf("failed to execute dedup script: %w", err)
+	}
+	if len(reply) == 0 {
+		return nil, fmt.Errorf("unexpected result format from Lua dedup script")
+	}
+	if claimed, _ := reply[0].(int64); claimed == 1 {
+		return nil, nil
+	}
+	if len(reply) < 3 {
+		return nil, fmt.Errorf("unexpected result format from Lua dedup script")
+	}
+
+	status, _ := reply[1].(string)
+	ttl, _ := reply[2].(int64)
+	return &Duplicate{Status: status, RetryAfter: time.Duration(max(ttl, 0)) * time.Millisecond}, nil
+}
+
+// recordSubmission stores the response status of a claimed submission, keeping its
+// TTL, or releases the claim for failed submissions so that they can be retried
+func (rl *RateLimiter) recordSubmission(ctx context.Context, userID, bodyHash string, status int) error {
+	key := rl.dedupKey(userID, bodyHash)
+
+	err := rl.manager.guardUser(userID, func(client *redis.Client) error {
+		if status >= fiber.StatusInternalServerError {
+			return client.Del(ctx, key).Err()
+		}
+		return client.SetArgs(ctx, key, strconv.Itoa(status), redis.SetArgs{Mode: "XX", KeepTTL: true}).Err()
+	})
+	if err != nil && err != redis.Nil {
+		return fmt.Errorf("failed to record submission: %w", err)
+	}
+	return nil
+}
+
+// errBodyTooLarge is returned by hashRequestBody for bodies over the size cap
+var errBodyTooLarge = errors.New("request body too large")
+
+// hashRequestBody hashes the request's method, path and body, reading at most maxSize
+// bytes of a streamed body. A consumed stream is put back as a regular body, so that
+// the handler still gets it.
+func hashRequestBody(c *fiber.Ctx, maxSize int64) (string, error) {
+	hasher := sha256.New()
+	hasher.Write([]byte(c.Method()))
+	hasher.Write([]byte{0})
+	hasher.Write([]byte(c.Path()))
+	hasher.Write([]byte{0})
+
+	if stream := c.Context().RequestBodyStream(); stream != nil {
+		if err := hashBodyStream(c, stream, hasher, maxSize); err != nil {
+			return "", err
+		}
+	} else {
+		body := c.Body()
+		if int64(len(body)) > maxSize {
+			return "", errBodyTooLarge
+		}
+		hasher.Write(body)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// hashBodyStream hashes a streamed body while buffering it, stopping after maxSize bytes
+func hashBodyStream(c *fiber.Ctx, stream io.Reader, hasher hash.Hash, maxSize int64) error {
+	var body bytes.Buffer
+	n, err := io.Copy(io.MultiWriter(&body, hasher), io.LimitReader(stream, maxSize+1))
+	if err != nil {
+		return fmt.Errorf("failed to read request body: %w", err)
+	}
+	if n > maxSize {
+		return errBodyTooLarge
+	}
+	c.Request().SetBody(body.Bytes())
+	return nil
+}
+
+// DedupMiddleware rejects identical submissions, such as a double-posted form: a
+// request whose method, path and body hash match one of the same user within the
+// window is rejected with 409 Conflict before reaching the handler. The
+// X-RateLimit-Duplicate header reports the response status of the first submission,
+// or "pending" while it's still being handled, so clients can tell that it went
+// through. Submissions whose handler fails with a 5xx release their claim and can be
+// retried at once.
+//
+// The body must be hashed before the handler runs, so streamed bodies are read up to
+// MaxBodySize while hashing and handed to the handler as a regular body; bodies over
+// the cap are rejected with 413. Redis errors let the submission through.
+func DedupMiddleware(limiter *RateLimiter, cfg DedupConfig) fiber.Handler {
+	if cfg.Window <= 0 {
+		cfg.Window = 10 * time.Second
+	}
+	if cfg.MaxBodySize <= 0 {
+		cfg.MaxBodySize = 1 << 20
+	}
+	if cfg.KeyFunc == nil {
+		cfg.KeyFunc = ipKey
+	}
+	if cfg.Headers == (HeaderNames{}) {
+		cfg.Headers = CanonicalHeaders
+	}
+
+	return func(c *fiber.Ctx) error {
+		userID := cfg.KeyFunc(c)
+
+		bodyHash, err := hashRequestBody(c, cfg.MaxBodySize)
+		if err == errBodyTooLarge {
+			log.Printf("INFO: Decision: REJECTED (413) - userID: %s, Reason: Body over the %d byte dedup limit", userID, cfg.MaxBodySize)
+			return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+				"error":   "Request body too large",
+				"message": fmt.Sprintf("Request bodies are limited to %d bytes.", cfg.MaxBodySize),
+			})
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		duplicate, err := limiter.ClaimSubmission(c.UserContext(), userID, bodyHash, cfg.Window)
+		if err != nil {
+			log.Printf("ERROR: Critical Redis Error: Dedup check failure for userID %s - %v. Falling back to Fail-Open Policy.", userID, err)
+			return c.Next()
+		}
+		if duplicate != nil {
+			retryAfter := retryAfterHeaderSeconds(duplicate.RetryAfter)
+			log.Printf("INFO: Decision: DUPLICATE (409) - userID: %s, Reason: Identical submission, Original status: %s", userID, duplicate.Status)
+			cfg.Headers.set(c, cfg.Headers.Duplicate, duplicate.Status)
+			cfg.Headers.set(c, cfg.Headers.RetryAfter, fmt.Sprintf("%d", retryAfter))
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error":   "Duplicate submission",
+				"message": "An identical request was already submitted. Please wait before resubmitting.",
+			})
+		}
+
+		handlerErr := c.Next()
+		status := c.Response().StatusCode()
+		if handlerErr != nil {
+			status = fiber.StatusInternalServerError
+			if fiberErr, ok := handlerErr.(*fiber.Error); ok {
+				status = fiberErr.Code
+			}
+		}
+		if err := limiter.recordSubmission(c.UserContext(), userID, bodyHash, status); err != nil {
+			log.Printf("WARNING: Failed to record submission outcome for userID %s - %v", userID, err)
+		}
+		return handlerErr
+	}
+}
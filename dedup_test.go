@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// newDedupApp returns an app echoing POST / bodies behind DedupMiddleware, keyed on X-User,
+// with the status of each response taken from the X-Status request header
+func newDedupApp(t *testing.T, config fiber.Config, cfg DedupConfig) (*fiber.App, *RateLimiter) {
+	limiter, cleanup, err := setupTestRateLimiter(1.0, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	t.Cleanup(cleanup)
+	t.Cleanup(func() {
+		for _, shard := range limiter.manager.Shards() {
+			if keys, err := shard.Keys(testCtx, "ratelimit:dedup:test_*").Result(); err == nil && len(keys) > 0 {
+				shard.Del(testCtx, keys...)
+			}
+		}
+	})
+
+	cfg.KeyFunc = func(c *fiber.Ctx) string { return c.Get("X-User") }
+	app := fiber.New(config)
+	app.Post("/", DedupMiddleware(limiter, cfg), func(c *fiber.Ctx) error {
+		if c.Get("X-Status") == "500" {
+			return fiber.NewError(fiber.StatusInternalServerError, "downstream failure")
+		}
+		return c.Status(fiber.StatusCreated).Send(c.Body())
+	})
+	return app, limiter
+}
+
+// postBody sends body as userID, returning the status, duplicate header and response body
+func postBody(t *testing.T, app *fiber.App, userID, body string, headers ...string) (int, string, string) {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("X-User", userID)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, resp.Header.Get("X-RateLimit-Duplicate"), string(data)
+}
+
+// TestDedupMiddleware tests that identical submissions of a user are rejected within the window
+func TestDedupMiddleware(t *testing.T) {
+	app, limiter := newDedupApp(t, fiber.Config{}, DedupConfig{Window: time.Minute})
+	userID, form := "test_user_dedup", `{"comment": "hello"}`
+
+	status, _, body := postBody(t, app, userID, form)
+	if status != fiber.StatusCreated || body != form {
+		t.Fatalf("Expected the first submission to reach the handler with its body, got %d and %q", status, body)
+	}
+	status, duplicate, _ := postBody(t, app, userID, form)
+	if status != fiber.StatusConflict || duplicate != "201" {
+		t.Errorf("Expected a 409 reporting the original 201, got %d and %q", status, duplicate)
+	}
+
+	// Other bodies and other users aren't duplicates
+	if status, _, _ := postBody(t, app, userID, `{"comment": "bye"}`); status != fiber.StatusCreated {
+		t.Errorf("Expected a different body to be accepted, got %d", status)
+	}
+	if status, _, _ := postBody(t, app, "test_user_dedup_other", form); status != fiber.StatusCreated {
+		t.Errorf("Expected another user's identical body to be accepted, got %d", status)
+	}
+
+	// Once the window ends the submission is accepted again
+	keys, _ := limiter.manager.GetClient(userID).Keys(testCtx, "ratelimit:dedup:"+userID+":*").Result()
+	if len(keys) != 2 {
+		t.Fatalf("Expected 2 dedup keys for %s, got %v", userID, keys)
+	}
+	for _, key := range keys {
+		if ttl, _ := limiter.manager.GetClient(userID).PTTL(testCtx, key).Result(); ttl <= 0 || ttl > time.Minute {
+			t.Errorf("Expected %s to expire within the window, got %v", key, ttl)
+		}
+	}
+	limiter.manager.GetClient(userID).Del(testCtx, keys...)
+	if status, _, _ := postBody(t, app, userID, form); status != fiber.StatusCreated {
+		t.Errorf("Expected the submission to be accepted after the window, got %d", status)
+	}
+}
+
+// TestDedupMiddlewareFailedSubmission tests that failed submissions can be retried at once
+func TestDedupMiddlewareFailedSubmission(t *testing.T) {
+	app, _ := newDedupApp(t, fiber.Config{}, DedupConfig{})
+	userID, form := "test_user_dedup_failed", "amount=10"
+
+	if status, _, _ := postBody(t, app, userID, form, "X-Status", "500"); status != fiber.StatusInternalServerError {
+		t.Fatalf("Expected the failing submission to return 500, got %d", status)
+	}
+	if status, _, _ := postBody(t, app, userID, form); status != fiber.StatusCreated {
+		t.Errorf("Expected the retry of a failed submission to be accepted, got %d", status)
+	}
+}
+
+// TestDedupMiddlewareStreamedBody tests that streamed bodies are hashed within the size
+// cap and still reach the handler
+func TestDedupMiddlewareStreamedBody(t *testing.T) {
+	app, _ := newDedupApp(t, fiber.Config{StreamRequestBody: true}, DedupConfig{MaxBodySize: 64})
+	userID := "test_user_dedup_stream"
+	form := strings.Repeat("a", 64)
+
+	status, _, body := postBody(t, app, userID, form)
+	if status != fiber.StatusCreated || body != form {
+		t.Fatalf("Expected the streamed body to reach the handler, got %d and %q", status, body)
+	}
+	if status, _, _ := postBody(t, app, userID, form); status != fiber.StatusConflict {
+		t.Errorf("Expected the identical streamed body to be rejected, got %d", status)
+	}
+	if status, _, _ := postBody(t, app, userID, form+"a"); status != fiber.StatusRequestEntityTooLarge {
+		t.Errorf("Expected a body over the cap to be rejected with 413, got %d", status)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// distinctLuaScript is the Lua script for atomically counting a resource in a user's
+// HyperLogLog and checking the distinct count against the limit
+// KEYS[1] = HyperLogLog, KEYS[2] = scratch key; ARGV[1] = resourceID, ARGV[2] = limit, ARGV[3] = window in milliseconds
+const distinctLuaScript = `
+local key = KEYS[1]
+local resource = ARGV[1]
+local limit = tonumber(ARGV[2])
+local window = tonumber(ARGV[3])
+
+local count = redis.call('PFCOUNT', key)
+if count < limit then
+    redis.call('PFADD', key, resource)
+    -- The window starts with the first resource
+    if redis.call('PTTL', key) < 0 then
+        redis.call('PEXPIRE', key, window)
+    end
+    return {1, redis.call('PFCOUNT', key), redis.call('PTTL', key)}
+end
+
+-- At the limit only resources already counted pass. Whether the resource is new is
+-- tested by adding it to a scratch copy, so a blocked resource is never counted.
+local probe = KEYS[2]
+redis.call('PFMERGE', probe, key)
+local new = redis.call('PFADD', probe, resource)
+redis.call('DEL', probe)
+return {1 - new, count, redis.call('PTTL', key)}
+`
+
+// distinctScript is the precomputed distinctLuaScript, hashed once for EVALSHA
+var distinctScript = redis.NewScript(distinctLuaScript)
+
+// DistinctLimiter limits the number of distinct resources each user touches within a
+// window, e.g. distinct account IDs accessed per hour. Resources are counted in a
+// per-user HyperLogLog, so memory stays at 12KB per user regardless of the limit, at
+// the cost of a standard error of 0.81% in the count.
+type DistinctLimiter struct {
+	manager *RedisShardManager
+	limit   int64         // maximum distinct resources per window
+	window  time.Duration // fixed window starting with the user's first resource
+}
+
+// NewDistinctLimiter creates a limiter allowing each user up to limit distinct
+// resources per window
+func NewDistinctLimiter(manager *RedisShardManager, limit int64, window time.Duration) *DistinctLimiter {
+	return &DistinctLimiter{
+		manager: manager,
+		limit:   limit,
+		window:  window,
+	}
+}
+
+// distinctKey returns the Redis key of the given userID's HyperLogLog
+func (dl *DistinctLimiter) distinctKey(userID string) string {
+	return fmt.Sprintf("ratelimit:distinct:%s", dl.manager.keyUserID(userID))
+}
+
+// probeKey returns the scratch key used to test the given userID's HyperLogLog
+func (dl *DistinctLimiter) probeKey(userID string) string {
+	return fmt.Sprintf("ratelimit:distinct:probe:%s", dl.manager.keyUserID(userID))
+}
+
+// AllowDistinct records that userID touched resourceID and checks whether it's within
+// the limit. Resources already counted in the current window are always allowed; a new
+// resource is blocked once the user reached the limit and isn't counted. Remaining is
+// the number of new resources left in the window and RetryAfter, when blocked, the
+// time until the window ends.
+func (dl *DistinctLimiter) AllowDistinct(userID, resourceID string) (*AllowResult, error) {
+	return dl.AllowDistinctCtx(ctx, userID, resourceID)
+}
+
+// AllowDistinctCtx is like AllowDistinct but uses the caller's context for the Redis call
+func (dl *DistinctLimiter) AllowDistinctCtx(ctx context.Context, userID, resourceID string) (*AllowResult, error) {
+	// Round the window up to whole milliseconds, so it's never 0
+	windowMillis := max(1, (dl.window + time.Millisecond - 1).Milliseconds())
+
+	values, err := dl.manager.runOnShard(ctx, userID, distinctScript, []string{dl.distinctKey(userID), dl.probeKey(userID)}, resourceID, dl.limit, windowMillis).Int64Slice()
+	if err != nil {
+		log.Printf("ERROR: Critical Redis Error: Lua distinct script execution failure for userID %s - %v", userID, err)
+		return nil, fmt.Errorf("failed to execute distinct script: %w", err)
+	}
+	if len(values) != 3 {
+		return nil, fmt.Errorf("unexpected distinct script result: %v", values)
+	}
+
+	result := &AllowResult{
+		Allowed:   values[0] == 1,
+		Remaining: float64(max(0, dl.limit-values[1])),
+	}
+	if !result.Allowed {
+		result.RetryAfter = time.Duration(values[2]) * time.Millisecond
+	}
+	return result, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestAllowDistinct tests that new resources are blocked over the limit while counted ones still pass
+func TestAllowDistinct(t *testing.T) {
+	base, cleanup, err := setupTestRateLimiter(1.0, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	limiter := NewDistinctLimiter(base.manager, 5, time.Hour)
+	userID := "test_user_distinct"
+	client := limiter.manager.GetClient(userID)
+	client.Del(testCtx, limiter.distinctKey(userID))
+	defer client.Del(testCtx, limiter.distinctKey(userID))
+
+	for i := 0; i < 5; i++ {
+		result, err := limiter.AllowDistinct(userID, fmt.Sprintf("account-%d", i))
+		if err != nil {
+			t.Fatalf("Error calling AllowDistinct: %v", err)
+		}
+		if !result.Allowed || result.Remaining != float64(4-i) {
+			t.Errorf("Resource %d: expected allowed with %d remaining, got %+v", i, 4-i, result)
+		}
+	}
+
+	// A sixth distinct resource is blocked until the window ends, also when retried
+	for i := 0; i < 2; i++ {
+		result, err := limiter.AllowDistinct(userID, "account-5")
+		if err != nil {
+			t.Fatalf("Error calling AllowDistinct: %v", err)
+		}
+		if result.Allowed {
+			t.Errorf("Attempt %d: expected a new resource over the limit to be blocked", i+1)
+		}
+		if result.RetryAfter <= 59*time.Minute || result.RetryAfter > time.Hour {
+			t.Errorf("Expected a retry-after of about an hour, got %v", result.RetryAfter)
+		}
+	}
+
+	// Resources already counted are still allowed
+	result, err := limiter.AllowDistinct(userID, "account-2")
+	if err != nil {
+		t.Fatalf("Error calling AllowDistinct: %v", err)
+	}
+	if !result.Allowed || result.Remaining != 0 {
+		t.Errorf("Expected a counted resource to be allowed with 0 remaining, got %+v", result)
+	}
+	if count, _ := client.PFCount(testCtx, limiter.distinctKey(userID)).Result(); count != 5 {
+		t.Errorf("Expected the blocked resource not to be counted, count is %d", count)
+	}
+}
+
+// TestAllowDistinctWindow tests that the window starts with the first resource
+func TestAllowDistinctWindow(t *testing.T) {
+	base, cleanup, err := setupTestRateLimiter(1.0, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	limiter := NewDistinctLimiter(base.manager, 2, 90*time.Second)
+	userID := "test_user_distinct_window"
+	client := limiter.manager.GetClient(userID)
+	client.Del(testCtx, limiter.distinctKey(userID))
+	defer client.Del(testCtx, limiter.distinctKey(userID))
+
+	if _, err := limiter.AllowDistinct(userID, "a"); err != nil {
+		t.Fatalf("Error calling AllowDistinct: %v", err)
+	}
+	ttl, err := client.PTTL(testCtx, limiter.distinctKey(userID)).Result()
+	if err != nil {
+		t.Fatalf("Failed to read TTL: %v", err)
+	}
+	if ttl <= 89*time.Second || ttl > 90*time.Second {
+		t.Errorf("Expected a TTL of 90s, got %v", ttl)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// enforcementKey is the Redis key of the cluster-wide enforcement flag, which only
+// exists while enforcement is disabled. Its hash tag pins it to one cluster slot
+// apart from the buckets.
+const enforcementKey = "ratelimit:{_control}:enforcement"
+
+// defaultEnforcementCacheTTL is how long the enforcement flag is cached in process
+const defaultEnforcementCacheTTL = time.Second
+
+// maxEnforcementBackoff caps the wait between reads of the flag while Redis fails
+const maxEnforcementBackoff = 30 * time.Second
+
+// enforcementCache remembers the enforcement flag read from Redis
+type enforcementCache struct {
+	mu         sync.Mutex
+	enabled    bool
+	known      bool      // the flag was read or set at least once
+	nextCheck  time.Time // time the flag is read again
+	failures   int       // consecutive failed reads, doubling the wait before the next
+	refreshing bool      // a read is in flight
+}
+
+// WithEnforcementCacheTTL sets how long the enforcement flag is cached in process
+// (default 1s), which is the longest an instance keeps the previous state after
+// SetEnforcement was called on another instance
+func WithEnforcementCacheTTL(ttl time.Duration) LimiterOption {
+	return func(rl *RateLimiter) {
+		rl.enforcementCacheTTL = ttl
+	}
+}
+
+// SetEnforcement enables or disables rate limiting cluster-wide, e.g. for a maintenance
+// window: while disabled, the middleware of every instance lets all requests through.
+// The flag is stored in Redis, so it survives restarts and applies to every limiter
+// and instance sharing the shards; instances other than this one pick it up within
+// their enforcement cache TTL.
+func (rl *RateLimiter) SetEnforcement(enabled bool) error {
+	client := rl.manager.GetClient(enforcementKey)
+
+	var err error
+	if enabled {
+		err = client.Del(ctx, enforcementKey).Err()
+	} else {
+		err = client.Set(ctx, enforcementKey, "disabled", 0).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update enforcement flag: %w", err)
+	}
+
+	rl.enforcement.mu.Lock()
+	rl.enforcement.enabled, rl.enforcement.known = enabled, true
+	rl.enforcement.nextCheck = time.Now().Add(rl.enforcementCacheTTL)
+	rl.enforcement.failures = 0
+	rl.enforcement.mu.Unlock()
+	return nil
+}
+
+// EnforcementEnabled reports whether rate limiting is enforced, reading the flag from
+// Redis at most once per enforcement cache TTL. A single caller reads it while the
+// others use the cached state. If the flag can't be read, the last known state is
+// kept, and limiting stays enforced if there is none; reads are then retried with an
+// exponential backoff capped at 30s.
+func (rl *RateLimiter) EnforcementEnabled(ctx context.Context) bool {
+	cache := &rl.enforcement
+	cache.mu.Lock()
+	if cache.refreshing || time.Now().Before(cache.nextCheck) {
+		enabled := cache.enabled || !cache.known
+		cache.mu.Unlock()
+		return enabled
+	}
+	cache.refreshing = true
+	cache.mu.Unlock()
+
+	err := rl.manager.GetClient(enforcementKey).Get(ctx, enforcementKey).Err()
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.refreshing = false
+	switch {
+	case err == redis.Nil || err == nil:
+		cache.enabled, cache.known = err == redis.Nil, true
+		cache.failures = 0
+		cache.nextCheck = time.Now().Add(rl.enforcementCacheTTL)
+	case isContextError(err):
+		// The caller gave up, not Redis; let the next caller read the flag
+	default:
+		log.Printf("WARNING: Failed to read enforcement flag - %v", err)
+		base := rl.enforcementCacheTTL
+		if base <= 0 {
+			base = defaultEnforcementCacheTTL
+		}
+		backoff := base << cache.failures
+		if backoff <= 0 || backoff > maxEnforcementBackoff {
+			backoff = maxEnforcementBackoff
+		} else {
+			cache.failures++
+		}
+		cache.nextCheck = time.Now().Add(backoff)
+	}
+	return cache.enabled || !cache.known
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestMiddlewareEnforcementDisabled tests that requests bypass limiting while
+// enforcement is disabled and are limited again once it's re-enabled
+func TestMiddlewareEnforcementDisabled(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.01, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	client := limiter.manager.GetClient(enforcementKey)
+	client.Del(testCtx, enforcementKey, limiter.bucketKey(testClientIP))
+	defer client.Del(testCtx, enforcementKey, limiter.bucketKey(testClientIP))
+
+	app := newTestApp(RateLimitMiddleware(limiter))
+	send := func() (int, string, string) {
+		resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		return resp.StatusCode, resp.Header.Get(BypassHeader), resp.Header.Get("X-RateLimit-Remaining")
+	}
+
+	// Enforced by default
+	send()
+	if status, bypass, _ := send(); status != fiber.StatusTooManyRequests || bypass != "" {
+		t.Fatalf("Expected the drained bucket to block by default, got %d with bypass %q", status, bypass)
+	}
+
+	if err := limiter.SetEnforcement(false); err != nil {
+		t.Fatalf("Error disabling enforcement: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		status, bypass, remaining := send()
+		if status != fiber.StatusOK || bypass != BypassMaintenance || remaining != "" {
+			t.Errorf("Request %d: expected a bypass without quota headers, got %d, bypass %q, remaining %q", i+1, status, bypass, remaining)
+		}
+	}
+
+	if err := limiter.SetEnforcement(true); err != nil {
+		t.Fatalf("Error enabling enforcement: %v", err)
+	}
+	if status, bypass, _ := send(); status != fiber.StatusTooManyRequests || bypass != "" {
+		t.Errorf("Expected limiting after re-enabling, got %d with bypass %q", status, bypass)
+	}
+}
+
+// TestEnforcementCachePropagation tests that other limiters see a change once their cache expires
+func TestEnforcementCachePropagation(t *testing.T) {
+	base, cleanup, err := setupTestRateLimiter(1.0, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	client := base.manager.GetClient(enforcementKey)
+	client.Del(testCtx, enforcementKey)
+	defer client.Del(testCtx, enforcementKey)
+
+	other := NewRateLimiter(base.manager, 1.0, 1.0, WithEnforcementCacheTTL(50*time.Millisecond))
+	if !other.EnforcementEnabled(testCtx) {
+		t.Fatalf("Expected enforcement to be enabled by default")
+	}
+
+	if err := base.SetEnforcement(false); err != nil {
+		t.Fatalf("Error disabling enforcement: %v", err)
+	}
+	if base.EnforcementEnabled(testCtx) {
+		t.Errorf("Expected the disabling limiter to see the change at once")
+	}
+	if !other.EnforcementEnabled(testCtx) {
+		t.Errorf("Expected the other limiter to keep its cached state")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if other.EnforcementEnabled(testCtx) {
+		t.Errorf("Expected the other limiter to see the change after its cache TTL")
+	}
+}
+
+// TestEnforcementReadBackoff tests that an unreadable flag keeps limiting enforced and
+// is retried with a growing backoff rather than on every check
+func TestEnforcementReadBackoff(t *testing.T) {
+	hook := &flakyHook{}
+	limiter, cleanup := newFlakyLimiter("127.0.0.1:1", hook, WithEnforcementCacheTTL(50*time.Millisecond))
+	defer cleanup()
+
+	check := func(expected int64) {
+		t.Helper()
+		for i := 0; i < 5; i++ {
+			if !limiter.EnforcementEnabled(testCtx) {
+				t.Fatalf("Expected enforcement kept without a readable flag")
+			}
+		}
+		if attempts := hook.attempts.Load(); attempts != expected {
+			t.Fatalf("Expected %d reads of the flag, got %d", expected, attempts)
+		}
+	}
+
+	check(1)
+	time.Sleep(60 * time.Millisecond)
+	check(2)
+	// The second failure doubles the wait
+	time.Sleep(60 * time.Millisecond)
+	check(2)
+	time.Sleep(50 * time.Millisecond)
+	check(3)
+}
+
+// TestAdminEnforcementEndpoint tests validation and the effect of POST /admin/enforcement
+func TestAdminEnforcementEndpoint(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(1.0, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+	defer limiter.manager.GetClient(enforcementKey).Del(testCtx, enforcementKey)
+
+	app := fiber.New()
+	app.Post("/admin/enforcement", AdminAuth(map[string]string{"secret": "alice"}), AdminEnforcementHandler(limiter))
+	post := func(token, body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest("POST", "/admin/enforcement", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		var decoded map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&decoded)
+		return resp.StatusCode, decoded
+	}
+
+	if status, _ := post("guess", `{"enabled": false}`); status != fiber.StatusUnauthorized {
+		t.Errorf("Expected status 401 for a wrong token, got %d", status)
+	}
+	if status, _ := post("secret", `{}`); status != fiber.StatusBadRequest {
+		t.Errorf("Expected status 400 without enabled, got %d", status)
+	}
+	if !limiter.EnforcementEnabled(testCtx) {
+		t.Fatalf("Expected rejected requests to leave enforcement enabled")
+	}
+
+	status, body := post("secret", `{"enabled": false}`)
+	if status != fiber.StatusOK || body["enabled"] != false {
+		t.Fatalf("Expected status 200 with enabled false, got %d and %v", status, body)
+	}
+	if limiter.EnforcementEnabled(testCtx) {
+		t.Errorf("Expected enforcement to be disabled")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// defaultFailoverRetryInterval is the default delay between checks while holding a request
+const defaultFailoverRetryInterval = 50 * time.Millisecond
+
+// failoverErrorPrefixes are the Redis error replies sent while a failover is in progress
+var failoverErrorPrefixes = []string{"READONLY", "LOADING", "MASTERDOWN", "CLUSTERDOWN", "TRYAGAIN"}
+
+// FailoverHold configures how long a request is held while Redis fails over
+type FailoverHold struct {
+	MaxWait  time.Duration // total time a request is held before the failure mode applies
+	Interval time.Duration // delay between retries of the check
+}
+
+// isFailoverError reports whether err is a transient error expected while a new
+// master takes over: a lost connection, or a reply from a replica or a loading server
+func isFailoverError(err error) bool {
+	if err == nil || isContextError(err) {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, redis.ErrClosed) {
+		return true
+	}
+	var redisErr redis.Error
+	if errors.As(err, &redisErr) {
+		for _, prefix := range failoverErrorPrefixes {
+			if strings.HasPrefix(redisErr.Error(), prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hold retries check after it failed with err, for as long as it fails with failover
+// errors but at most MaxWait or until ctx ends. It returns the last outcome, so a
+// request still failing after the hold falls back to the failure mode.
+func (h *FailoverHold) hold(ctx context.Context, userID string, err error, check func() (*AllowResult, error)) (*AllowResult, error) {
+	if !isFailoverError(err) {
+		return nil, err
+	}
+
+	interval := h.Interval
+	if interval <= 0 {
+		interval = defaultFailoverRetryInterval
+	}
+	deadline := time.NewTimer(h.MaxWait)
+	defer deadline.Stop()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Printf("WARNING: Holding request for userID %s up to %v during Redis failover - %v", userID, h.MaxWait, err)
+	var result *AllowResult
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-deadline.C:
+			return result, err
+		case <-ticker.C:
+			if result, err = check(); !isFailoverError(err) {
+				return result, err
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// FailureMode decides what happens to a request when the limiter can't reach a decision
+type FailureMode int
+
+const (
+	// FailOpen lets the request through without rate limiting
+	FailOpen FailureMode = iota
+	// FailClosed rejects the request with 503 Service Unavailable
+	FailClosed
+)
+
+// String returns the policy name used in log messages
+func (m FailureMode) String() string {
+	switch m {
+	case FailOpen:
+		return "Fail-Open"
+	case FailClosed:
+		return "Fail-Closed"
+	default:
+		return "Unknown"
+	}
+}
+
+// parseFailureMode parses a failure mode name as sent in an override header
+func parseFailureMode(value string) (FailureMode, bool) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "fail-open", "open":
+		return FailOpen, true
+	case "fail-closed", "closed":
+		return FailClosed, true
+	default:
+		return FailOpen, false
+	}
+}
+
+// isContextError reports whether err was caused by the caller's context being
+// cancelled or running past its deadline, as opposed to a Redis failure
+func isContextError(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
+
+// limiterUnavailable rejects the request because the rate limit couldn't be verified
+func limiterUnavailable(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusServiceUnavailable).JSON(limiterUnavailableBody)
+}
+
+// BypassHeader marks responses that weren't rate limited, with the reason as its
+// value, in the canonical header set
+const BypassHeader = "X-RateLimit-Bypass"
+
+// BypassRedisError is the BypassHeader value of requests let through because the
+// rate limit couldn't be verified
+const BypassRedisError = "redis-error"
+
+// BypassMaintenance is the BypassHeader value of requests let through because
+// enforcement is disabled with SetEnforcement
+const BypassMaintenance = "maintenance"
+
+// limiterBypassed lets the request through without a verified rate limit. No quota
+// headers are sent, since any values would be made up; the bypass header tells
+// clients to ignore quota information cached from earlier responses.
+func limiterBypassed(c *fiber.Ctx, headers HeaderNames, reason string) error {
+	for _, name := range headers.bypassHeaders() {
+		headers.del(c, name)
+	}
+	headers.set(c, headers.Bypass, reason)
+	return c.Next()
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestAllowCtxCancelled tests that a cancelled context aborts the check with a context error
+func TestAllowCtxCancelled(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(5.0, 10.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = limiter.AllowCtx(cancelledCtx, "test_user_cancelled")
+	if err == nil {
+		t.Fatal("Expected an error when calling AllowCtx with a cancelled context")
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected error to wrap context.Canceled, got %v", err)
+	}
+}
+
+// TestAllowCtxDeadline tests that a check against a Redis server that never replies
+// returns once the caller's deadline expires instead of hanging
+func TestAllowCtxDeadline(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		// Accept connections but never answer them
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	client := redis.NewClient(&redis.Options{Addr: listener.Addr().String()})
+	limiter := NewRateLimiter(&RedisShardManager{shards: []*redis.Client{client}}, 5.0, 10.0)
+	defer client.Close()
+
+	deadlineCtx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = limiter.AllowCtx(deadlineCtx, "test_user_deadline")
+	if err == nil {
+		t.Fatal("Expected an error from an unresponsive Redis server")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the check to return at the deadline, took %v", elapsed)
+	}
+
+	// An already cancelled context fails before anything is sent
+	cancelledCtx, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	if _, err := limiter.AllowCtx(cancelledCtx, "test_user_deadline"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected error to wrap context.Canceled, got %v", err)
+	}
+}
+
+// TestFailureModeFor tests that context errors and Redis errors are classified independently
+func TestFailureModeFor(t *testing.T) {
+	options := newMiddlewareOptions([]Option{
+		WithFailureMode(FailOpen),
+		WithTimeoutFailureMode(FailClosed),
+	})
+
+	tests := []struct {
+		name     string
+		err      error
+		expected FailureMode
+	}{
+		{"connection error", errors.New("dial tcp 127.0.0.1:6379: connect: connection refused"), FailOpen},
+		{"deadline exceeded", fmt.Errorf("failed to execute rate limit script: %w", context.DeadlineExceeded), FailClosed},
+		{"cancelled", fmt.Errorf("failed to execute rate limit script: %w", context.Canceled), FailClosed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := options.failureModeFor(tt.err); got != tt.expected {
+				t.Errorf("Expected %s for %v, got %s", tt.expected, tt.err, got)
+			}
+		})
+	}
+}
+
+// TestMiddlewareFailureModeLimiterError tests the response to a failing limiter under each failure mode
+func TestMiddlewareFailureModeLimiterError(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		expected int
+	}{
+		{"default fails open", nil, fiber.StatusOK},
+		{"fail open", []Option{WithFailureMode(FailOpen)}, fiber.StatusOK},
+		{"fail closed", []Option{WithFailureMode(FailClosed)}, fiber.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := &fakeLimiter{err: errors.New("failed to execute rate limit script: connection refused")}
+			app := newTestApp(RateLimitMiddleware(limiter, tt.opts...))
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			if resp.StatusCode != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, resp.StatusCode)
+			}
+			if tt.expected == fiber.StatusServiceUnavailable {
+				body, _ := io.ReadAll(resp.Body)
+				if !strings.Contains(string(body), "Rate limiter unavailable") {
+					t.Errorf("Expected a body describing the unavailable limiter, got %s", body)
+				}
+			}
+		})
+	}
+}
+
+// TestMiddlewareCancelledContext tests the middleware's timeout failure mode with a cancelled request context
+func TestMiddlewareCancelledContext(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(5.0, 10.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	tests := []struct {
+		name     string
+		opts     []Option
+		expected int
+	}{
+		{"default fails open", nil, fiber.StatusOK},
+		{"timeout fails closed", []Option{WithTimeoutFailureMode(FailClosed)}, fiber.StatusServiceUnavailable},
+		{"redis errors fail closed only", []Option{WithFailureMode(FailClosed)}, fiber.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Use(func(c *fiber.Ctx) error {
+				cancelledCtx, cancel := context.WithCancel(context.Background())
+				cancel()
+				c.SetUserContext(cancelledCtx)
+				return c.Next()
+			})
+			app.Get("/", RateLimitMiddleware(limiter, tt.opts...), func(c *fiber.Ctx) error {
+				return c.SendString("ok")
+			})
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			if resp.StatusCode != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, resp.StatusCode)
+			}
+		})
+	}
+}
+
+// newUnreachableLimiter creates a limiter whose only shard can't be reached, so every
+// check fails with a Redis connection error
+func newUnreachableLimiter() *RateLimiter {
+	client := redis.NewClient(&redis.Options{
+		Addr:        "127.0.0.1:1",
+		DialTimeout: 100 * time.Millisecond,
+		MaxRetries:  -1,
+	})
+	return NewRateLimiter(&RedisShardManager{shards: []*redis.Client{client}}, 5.0, 10.0)
+}
+
+// TestMiddlewareFailureModeOverride tests that only trusted sources can override the failure mode
+func TestMiddlewareFailureModeOverride(t *testing.T) {
+	limiter := newUnreachableLimiter()
+
+	tests := []struct {
+		name     string
+		trusted  []string
+		header   string
+		expected int
+	}{
+		{"trusted source fails closed", []string{"0.0.0.0/32"}, "fail-closed", fiber.StatusServiceUnavailable},
+		{"trusted single IP fails closed", []string{"0.0.0.0"}, "fail-closed", fiber.StatusServiceUnavailable},
+		{"untrusted source is ignored", []string{"10.0.0.0/8"}, "fail-closed", fiber.StatusOK},
+		{"no header keeps global mode", []string{"0.0.0.0/32"}, "", fiber.StatusOK},
+		{"unknown value keeps global mode", []string{"0.0.0.0/32"}, "sometimes", fiber.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(RateLimitMiddleware(limiter, WithFailureModeOverride("X-Failure-Mode", tt.trusted)))
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				req.Header.Set("X-Failure-Mode", tt.header)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			if resp.StatusCode != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, resp.StatusCode)
+			}
+		})
+	}
+}
+
+// TestMiddlewareFailOpenHeaders tests that fail-open responses carry the bypass header and no quota headers
+func TestMiddlewareFailOpenHeaders(t *testing.T) {
+	limiter := newUnreachableLimiter()
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		// Quota headers of a limiter placed earlier in the chain must not leak through either
+		c.Set("X-RateLimit-Remaining", "0")
+		return c.Next()
+	})
+	app.Get("/", RateLimitMiddleware(limiter), func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get(BypassHeader); got != BypassRedisError {
+		t.Errorf("Expected %s: %s, got %q", BypassHeader, BypassRedisError, got)
+	}
+	for _, header := range []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Retry-After"} {
+		if got := resp.Header.Get(header); got != "" {
+			t.Errorf("Expected no %s header, got %q", header, got)
+		}
+	}
+}
+
+// TestMiddlewareNoBypassHeader tests that verified responses don't carry the bypass header
+func TestMiddlewareNoBypassHeader(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(5.0, 10.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+	limiter.manager.GetClient(testClientIP).Del(testCtx, testBucketKey(testClientIP))
+	defer limiter.manager.GetClient(testClientIP).Del(testCtx, testBucketKey(testClientIP))
+
+	app := newTestApp(RateLimitMiddleware(limiter))
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if got := resp.Header.Get(BypassHeader); got != "" {
+		t.Errorf("Expected no %s header, got %q", BypassHeader, got)
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") == "" {
+		t.Error("Expected the X-RateLimit-Remaining header")
+	}
+}
+
+// TestFailoverHoldRecovers tests that a held check succeeds once Redis is back
+func TestFailoverHoldRecovers(t *testing.T) {
+	hold := &FailoverHold{MaxWait: time.Second, Interval: time.Millisecond}
+	failover := fmt.Errorf("failed to execute rate limit script: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")})
+
+	attempts := 0
+	result, err := hold.hold(context.Background(), "test_user_hold", failover, func() (*AllowResult, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, failover
+		}
+		return &AllowResult{Allowed: true, Remaining: 4}, nil
+	})
+	if err != nil || result == nil || !result.Allowed {
+		t.Fatalf("Expected the held check to succeed, got %+v, %v", result, err)
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 retries, got %d", attempts)
+	}
+}
+
+// TestFailoverHoldErrors tests which errors are held and that the hold ends with the context
+func TestFailoverHoldErrors(t *testing.T) {
+	hold := &FailoverHold{MaxWait: time.Hour, Interval: time.Millisecond}
+	retried := func() (*AllowResult, error) {
+		t.Error("Expected no retry")
+		return nil, nil
+	}
+
+	// Permanent errors aren't held
+	scriptErr := errors.New("ERR Error running script")
+	if _, err := hold.hold(context.Background(), "test_user_hold", scriptErr, retried); err != scriptErr {
+		t.Errorf("Expected the script error to be returned at once, got %v", err)
+	}
+
+	// A cancelled request ends the hold with the context error
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := hold.hold(ctx, "test_user_hold", redis.ErrClosed, func() (*AllowResult, error) {
+		return nil, redis.ErrClosed
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the hold to end with the request deadline, got %v", err)
+	}
+
+	for _, tt := range []struct {
+		err      error
+		failover bool
+	}{
+		{redis.ErrClosed, true},
+		{&net.OpError{Op: "read", Err: errors.New("connection reset")}, true},
+		{scriptErr, false},
+		{context.Canceled, false},
+		{nil, false},
+	} {
+		if got := isFailoverError(tt.err); got != tt.failover {
+			t.Errorf("isFailoverError(%v) = %v, expected %v", tt.err, got, tt.failover)
+		}
+	}
+}
+
+// TestMiddlewareFailoverHoldFallsBack tests that a request held past MaxWait gets the failure mode
+func TestMiddlewareFailoverHoldFallsBack(t *testing.T) {
+	limiter := newUnreachableLimiter()
+	app := newTestApp(RateLimitMiddleware(limiter, WithFailureMode(FailClosed), WithFailoverHold(100*time.Millisecond, 10*time.Millisecond)))
+
+	start := time.Now()
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected the request to be held for 100ms, returned after %v", elapsed)
+	}
+	if resp.StatusCode != fiber.StatusServiceUnavailable {
+		t.Errorf("Expected the fail-closed status 503 after the hold, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Regions reported by FallbackStatus
+const (
+	ServingPrimary  = "primary"
+	ServingFallback = "fallback"
+)
+
+// defaultFallbackProbeInterval is the time between probes of a failed primary
+const defaultFallbackProbeInterval = 5 * time.Second
+
+// fallbackProbeTimeout bounds the ping of each primary shard during a probe
+const fallbackProbeTimeout = time.Second
+
+// fallbackState tracks which manager serves the checks of a limiter
+type fallbackState struct {
+	manager       *RedisShardManager
+	threshold     int
+	probeInterval time.Duration
+
+	mu        sync.Mutex
+	failures  map[int]int // consecutive failed checks of each primary shard
+	active    bool        // checks are served by the fallback
+	since     time.Time   // time of the last switch
+	lastProbe time.Time
+	probing   bool
+}
+
+// FallbackStatus describes which Redis deployment serves the checks of a limiter
+type FallbackStatus struct {
+	Serving  string    // ServingPrimary or ServingFallback
+	Since    time.Time // time of the last switch, zero if checks never switched
+	Failures int       // consecutive failed checks of the primary's least failing shard
+}
+
+// WithFallbackManager switches checks to the fallback manager, e.g. a standby Redis
+// in another region, once threshold consecutive checks failed on every shard of the
+// primary; a single failing shard is left to the failure mode and its circuit
+// breaker. While on the fallback, the primary's shards are pinged every probeInterval
+// (default 5s) and checks switch back as soon as all of them respond. Checks
+// cancelled by their caller don't count as failures.
+//
+// The fallback holds its own buckets, so limits start over with fresh buckets on
+// every switch in either direction: users get up to a full burst again after a
+// failover. Only Allow and its variants switch; refunds, peeks and other bucket
+// operations always use the primary.
+func WithFallbackManager(fallback *RedisShardManager, threshold int, probeInterval time.Duration) LimiterOption {
+	return func(rl *RateLimiter) {
+		if probeInterval <= 0 {
+			probeInterval = defaultFallbackProbeInterval
+		}
+		rl.fallback = &fallbackState{
+			manager:       fallback,
+			threshold:     max(1, threshold),
+			probeInterval: probeInterval,
+			failures:      make(map[int]int),
+		}
+	}
+}
+
+// FallbackStatus reports which manager currently serves checks, always the primary
+// without WithFallbackManager
+func (rl *RateLimiter) FallbackStatus() FallbackStatus {
+	if rl.fallback == nil {
+		return FallbackStatus{Serving: ServingPrimary}
+	}
+	shards := len(rl.manager.Shards())
+	f := rl.fallback
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	status := FallbackStatus{Serving: ServingPrimary, Since: f.since, Failures: f.leastFailures(shards)}
+	if f.active {
+		status.Serving = ServingFallback
+	}
+	return status
+}
+
+// ActiveManager returns the manager currently serving checks
+func (rl *RateLimiter) ActiveManager() *RedisShardManager {
+	if rl.fallback == nil {
+		return rl.manager
+	}
+	f := rl.fallback
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.active {
+		return f.manager
+	}
+	return rl.manager
+}
+
+// checkManager returns the manager the next check runs on, starting a background
+// probe of the primary when one is due while on the fallback
+func (rl *RateLimiter) checkManager() *RedisShardManager {
+	if rl.fallback == nil {
+		return rl.manager
+	}
+	f := rl.fallback
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.active {
+		return rl.manager
+	}
+	if !f.probing && time.Since(f.lastProbe) >= f.probeInterval {
+		f.probing = true
+		f.lastProbe = time.Now()
+		go rl.probePrimary()
+	}
+	return f.manager
+}
+
+// recordCheck counts the outcome of a check run on the given shard of manager,
+// switching to the fallback once every shard of the primary failed threshold
+// consecutive checks
+func (rl *RateLimiter) recordCheck(manager *RedisShardManager, shard int, err error) {
+	if rl.fallback == nil || manager != rl.manager || (err != nil && isContextError(err)) {
+		return
+	}
+	f := rl.fallback
+	if err == nil {
+		f.mu.Lock()
+		delete(f.failures, shard)
+		f.mu.Unlock()
+		return
+	}
+
+	shards := len(manager.Shards())
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[shard]++
+	if failures := f.leastFailures(shards); !f.active && failures >= f.threshold {
+		f.active = true
+		f.since = time.Now()
+		f.lastProbe = f.since
+		log.Printf("WARNING: Every primary Redis shard failed %d consecutive checks, switching to the fallback Redis. Limits start over on the fallback.", failures)
+	}
+}
+
+// leastFailures returns the consecutive failed checks of the least failing of the
+// primary's shards, f.mu held
+func (f *fallbackState) leastFailures(shards int) int {
+	least := 0
+	for shard := 0; shard < shards; shard++ {
+		if failures := f.failures[shard]; shard == 0 || failures < least {
+			least = failures
+		}
+	}
+	return least
+}
+
+// probePrimary pings every shard of the primary, switching checks back to it if
+// they all respond
+func (rl *RateLimiter) probePrimary() {
+	f := rl.fallback
+	healthy := true
+	for _, client := range rl.manager.Shards() {
+		probeCtx, cancel := context.WithTimeout(context.Background(), fallbackProbeTimeout)
+		err := client.Ping(probeCtx).Err()
+		cancel()
+		if err != nil {
+			healthy = false
+			break
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.probing = false
+	if healthy && f.active {
+		f.active = false
+		f.failures = make(map[int]int)
+		f.since = time.Now()
+		log.Printf("INFO: Primary Redis recovered, switching back from the fallback Redis. Limits start over on the primary.")
+	}
+}
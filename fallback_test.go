@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// TestFallbackManager tests that checks switch to the fallback after consecutive
+// primary failures and back once the primary responds again
+func TestFallbackManager(t *testing.T) {
+	base, cleanup, err := setupTestRateLimiter(1.0, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	limiter := newUnreachableLimiter()
+	primary := limiter.manager
+	WithFallbackManager(base.manager, 2, 10*time.Millisecond)(limiter)
+	userID := "test_user_fallback"
+
+	// Cancelled checks don't count towards the threshold
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	limiter.AllowCtx(cancelled, userID)
+	if status := limiter.FallbackStatus(); status.Failures != 0 {
+		t.Errorf("Expected cancelled checks not to count, got %+v", status)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := limiter.Allow(userID); err == nil {
+			t.Fatalf("Expected check %d to fail on the unreachable primary", i+1)
+		}
+	}
+	if status := limiter.FallbackStatus(); status.Serving != ServingFallback || status.Since.IsZero() {
+		t.Fatalf("Expected checks to switch to the fallback, got %+v", status)
+	}
+	if limiter.ActiveManager() != base.manager {
+		t.Error("Expected the fallback to be the active manager")
+	}
+
+	// The fallback serves checks with its own, fresh buckets
+	result, err := limiter.Allow(userID)
+	if err != nil || !result.Allowed || result.Remaining < 8.99 {
+		t.Fatalf("Expected the fallback to allow with a fresh bucket, got %+v (%v)", result, err)
+	}
+
+	// A probe during a check notices the recovered primary and switches back
+	primary.mu.Lock()
+	primary.shards = base.manager.Shards()
+	primary.mu.Unlock()
+	deadline := time.Now().Add(time.Second)
+	for limiter.FallbackStatus().Serving != ServingPrimary {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected checks to switch back to the primary, got %+v", limiter.FallbackStatus())
+		}
+		time.Sleep(20 * time.Millisecond)
+		if _, err := limiter.Allow(userID); err != nil {
+			t.Fatalf("Error calling Allow: %v", err)
+		}
+	}
+	if status := limiter.FallbackStatus(); status.Failures != 0 || limiter.ActiveManager() != primary {
+		t.Errorf("Expected a reset primary after recovery, got %+v", status)
+	}
+}
+
+// TestFallbackNeedsEveryShardFailing tests that failures of a single primary shard
+// don't switch checks to the fallback while another shard still responds
+func TestFallbackNeedsEveryShardFailing(t *testing.T) {
+	base, cleanup, err := setupTestRateLimiter(1.0, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	unreachable := newUnreachableLimiter().manager.shards[0]
+	defer unreachable.Close()
+	shards := []*redis.Client{base.manager.Shards()[0], unreachable}
+	primary := &RedisShardManager{shards: shards, ids: shardIDs(shards)}
+	limiter := NewRateLimiter(primary, 5.0, 10.0, WithFallbackManager(base.manager, 2, time.Minute))
+
+	// Find a user on each shard
+	var users [2]string
+	for i := 0; users[0] == "" || users[1] == ""; i++ {
+		userID := fmt.Sprintf("test_user_fallback_shard_%d", i)
+		users[primary.shardIndex(userID)] = userID
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := limiter.Allow(users[1]); err == nil {
+			t.Fatalf("Expected check %d to fail on the unreachable shard", i+1)
+		}
+	}
+	if status := limiter.FallbackStatus(); status.Serving != ServingPrimary || status.Failures != 0 {
+		t.Fatalf("Expected the primary to keep serving with one healthy shard, got %+v", status)
+	}
+
+	// Once the other shard fails too, every shard is failing
+	primary.mu.Lock()
+	primary.shards[0] = unreachable
+	primary.mu.Unlock()
+	for i := 0; i < 2; i++ {
+		limiter.Allow(users[0])
+	}
+	if status := limiter.FallbackStatus(); status.Serving != ServingFallback || status.Failures != 2 {
+		t.Errorf("Expected checks to switch to the fallback, got %+v", status)
+	}
+}
+
+// TestFallbackStatusWithoutFallback tests that limiters without a fallback always
+// report the primary
+func TestFallbackStatusWithoutFallback(t *testing.T) {
+	limiter := newUnreachableLimiter()
+	for i := 0; i < 3; i++ {
+		limiter.Allow("test_user_no_fallback")
+	}
+	if status := limiter.FallbackStatus(); status.Serving != ServingPrimary || limiter.ActiveManager() != limiter.manager {
+		t.Errorf("Expected the primary to serve, got %+v", status)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// fixedWindowLuaScript is the Lua script for atomically counting a request in the
+// user's counter of the current window if it fits in the limit. Windows are picked by
+// the Redis server's time; the counter records its window and starts over in the next.
+// KEYS[1] = counter; ARGV[1] = limit, ARGV[2] = requests, ARGV[3] = window in milliseconds
+const fixedWindowLuaScript = `
+local key = KEYS[1]
+local limit = tonumber(ARGV[1])
+local requested = tonumber(ARGV[2])
+local windowMillis = tonumber(ARGV[3])
+
+local now = math.floor(serverTime() * 1000)
+local window = math.floor(now / windowMillis)
+local untilEnd = (window + 1) * windowMillis - now
+
+local stored = redis.call('HMGET', key, 'window', 'count')
+local count = 0
+if tonumber(stored[1]) == window then
+    count = tonumber(stored[2]) or 0
+end
+if count + requested > limit then
+    return {0, count, untilEnd}
+end
+
+count = count + requested
+redis.call('HSET', key, 'window', string.format('%d', window), 'count', count)
+-- Only the first request of the window sets the expiry
+if count == requested then
+    redis.call('PEXPIRE', key, untilEnd)
+end
+return {1, count, untilEnd}
+`
+
+// fixedWindowScript is the precomputed fixedWindowLuaScript, hashed once for EVALSHA
+var fixedWindowScript = redis.NewScript(serverTimeLuaScript + fixedWindowLuaScript)
+
+// FixedWindowLimiter allows each user up to limit requests per fixed window, e.g. 100
+// requests per clock minute. It keeps a single counter per user, expiring with the
+// window, making it the cheapest limiter for high-throughput, low-precision
+// limits. Windows are aligned to the Unix epoch, not to the user's first request, so
+// a client can send limit requests at the end of one window and limit more at the
+// start of the next: up to twice the limit within one window's length.
+type FixedWindowLimiter struct {
+	manager *RedisShardManager
+	limit   int64         // maximum requests per window
+	window  time.Duration // length of each window
+}
+
+// NewFixedWindowLimiter creates a limiter allowing each user up to limit requests in
+// each window. It panics unless limit and window are positive.
+func NewFixedWindowLimiter(manager *RedisShardManager, limit int, window time.Duration) *FixedWindowLimiter {
+	if limit <= 0 || window <= 0 {
+		panic(fmt.Sprintf("fixed window limit and window must be positive, got %d per %v", limit, window))
+	}
+	return &FixedWindowLimiter{
+		manager: manager,
+		limit:   int64(limit),
+		window:  window,
+	}
+}
+
+// fixedWindowKey returns the Redis key of the given userID's counter
+func (fw *FixedWindowLimiter) fixedWindowKey(userID string) string {
+	return fmt.Sprintf("ratelimit:fw:%s", fw.manager.keyUserID(userID))
+}
+
+// Capacity returns the number of requests allowed per window
+func (fw *FixedWindowLimiter) Capacity() float64 {
+	return float64(fw.limit)
+}
+
+// Allow checks whether a request of userID fits in the current window, counting it
+// if so. Remaining is the number of requests left in the window and RetryAfter, when
+// blocked, the time until the next window starts.
+func (fw *FixedWindowLimiter) Allow(userID string) (*AllowResult, error) {
+	return fw.AllowNCtx(ctx, userID, 1)
+}
+
+// AllowN is like Allow for n requests at once, n must be a whole number
+func (fw *FixedWindowLimiter) AllowN(userID string, n float64) (*AllowResult, error) {
+	return fw.AllowNCtx(ctx, userID, n)
+}
+
+// AllowCtx is like Allow but uses the caller's context for the Redis call
+func (fw *FixedWindowLimiter) AllowCtx(ctx context.Context, userID string) (*AllowResult, error) {
+	return fw.AllowNCtx(ctx, userID, 1)
+}
+
+// AllowNCtx is like AllowN but uses the caller's context for the Redis call
+func (fw *FixedWindowLimiter) AllowNCtx(ctx context.Context, userID string, n float64) (*AllowResult, error) {
+	if n <= 0 || n != math.Trunc(n) {
+		return nil, fmt.Errorf("request count must be a positive whole number, got %v", n)
+	}
+
+	// Round the window up to whole milliseconds, so it's never 0
+	windowMillis := max(1, (fw.window + time.Millisecond - 1).Milliseconds())
+
+	values, err := fw.manager.runOnShard(ctx, userID, fixedWindowScript, []string{fw.fixedWindowKey(userID)}, fw.limit, int64(n), windowMillis).Int64Slice()
+	if err != nil {
+		log.Printf("ERROR: Critical Redis Error: Lua fixed window script execution failure for userID %s - %v", userID, err)
+		return nil, fmt.Errorf("failed to execute fixed window script: %w", err)
+	}
+	if len(values) != 3 {
+		return nil, fmt.Errorf("unexpected fixed window script result: %v", values)
+	}
+
+	result := &AllowResult{
+		Allowed:   values[0] == 1,
+		Remaining: float64(max(0, fw.limit-values[1])),
+	}
+	if !result.Allowed {
+		result.RetryAfter = time.Duration(values[2]) * time.Millisecond
+	}
+	return result, nil
+}
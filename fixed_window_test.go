@@ -0,0 +1,133 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// newTestFixedWindowLimiter creates a fixed window limiter on the test Redis and waits
+// for the start of a window, so the test isn't split by a window boundary
+func newTestFixedWindowLimiter(t *testing.T, limit int, window time.Duration) *FixedWindowLimiter {
+	base, cleanup, err := setupTestRateLimiter(1.0, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	t.Cleanup(cleanup)
+	limiter := NewFixedWindowLimiter(base.manager, limit, window)
+	serverTime, err := base.manager.shards[0].Time(testCtx).Result()
+	if err != nil {
+		t.Fatalf("TIME failed: %v", err)
+	}
+	time.Sleep(window - time.Duration(serverTime.UnixNano()%int64(window)))
+	return limiter
+}
+
+// TestFixedWindowAt tests the alignment of windows to the epoch on the Redis server's clock
+func TestFixedWindowAt(t *testing.T) {
+	base, cleanup, err := setupTestRateLimiter(1.0, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+	limiter := NewFixedWindowLimiter(base.manager, 10, time.Minute)
+	key := limiter.fixedWindowKey("alice")
+	if key != "ratelimit:fw:alice" {
+		t.Errorf("Unexpected key %q", key)
+	}
+
+	client := limiter.manager.GetClient("alice")
+	client.Del(testCtx, key)
+	defer client.Del(testCtx, key)
+	script := redis.NewScript(stubServerTime(3*60+15) + fixedWindowLuaScript)
+	values, err := script.Run(testCtx, client, []string{key}, 10, 1, time.Minute.Milliseconds()).Int64Slice()
+	if err != nil {
+		t.Fatalf("Fixed window script failed: %v", err)
+	}
+	if len(values) != 3 || values[0] != 1 || values[2] != 45000 {
+		t.Errorf("Expected an allowed request with 45s left in the window, got %v", values)
+	}
+	if window, _ := client.HGet(testCtx, key, "window").Result(); window != "3" {
+		t.Errorf("Expected the counter of window 3, got %q", window)
+	}
+}
+
+// TestFixedWindowReset tests that the counter starts over at the window boundary
+func TestFixedWindowReset(t *testing.T) {
+	var _ Limiter = (*FixedWindowLimiter)(nil)
+	userID := "test_user_fixed_reset"
+	limiter := newTestFixedWindowLimiter(t, 3, 300*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		result, err := limiter.Allow(userID)
+		if err != nil {
+			t.Fatalf("Error calling Allow: %v", err)
+		}
+		if !result.Allowed || result.Remaining != float64(2-i) {
+			t.Errorf("Request %d: expected allowed with %d remaining, got %+v", i+1, 2-i, result)
+		}
+	}
+
+	result, err := limiter.Allow(userID)
+	if err != nil {
+		t.Fatalf("Error calling Allow: %v", err)
+	}
+	if result.Allowed || result.RetryAfter <= 0 || result.RetryAfter > 300*time.Millisecond {
+		t.Fatalf("Expected a blocked request waiting for the next window, got %+v", result)
+	}
+
+	time.Sleep(result.RetryAfter + 10*time.Millisecond)
+	if result, err := limiter.Allow(userID); err != nil || !result.Allowed || result.Remaining != 2 {
+		t.Errorf("Expected a fresh counter in the next window, got %+v (%v)", result, err)
+	}
+}
+
+// TestFixedWindowExpireOnce tests that only the first request of a window sets the
+// expiry of its counter
+func TestFixedWindowExpireOnce(t *testing.T) {
+	userID := "test_user_fixed_expire"
+	limiter := newTestFixedWindowLimiter(t, 10, 2*time.Second)
+	key := limiter.fixedWindowKey(userID)
+	client := limiter.manager.GetClient(userID)
+	defer client.Del(testCtx, key)
+
+	if _, err := limiter.Allow(userID); err != nil {
+		t.Fatalf("Error calling Allow: %v", err)
+	}
+	first, err := client.PTTL(testCtx, key).Result()
+	if err != nil || first <= 0 || first > 2*time.Second {
+		t.Fatalf("Expected the counter to expire with the window, got %v (%v)", first, err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if _, err := limiter.Allow(userID); err != nil {
+		t.Fatalf("Error calling Allow: %v", err)
+	}
+	second, err := client.PTTL(testCtx, key).Result()
+	if err != nil || second > first-150*time.Millisecond {
+		t.Errorf("Expected the expiry to be kept, got %v after %v (%v)", second, first, err)
+	}
+}
+
+// TestFixedWindowInvalid tests that a non-positive limit or window is rejected
+func TestFixedWindowInvalid(t *testing.T) {
+	for _, tt := range []struct {
+		limit  int
+		window time.Duration
+	}{
+		{0, time.Minute},
+		{-1, time.Minute},
+		{10, 0},
+		{10, -time.Second},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected a panic for %d per %v", tt.limit, tt.window)
+				}
+			}()
+			NewFixedWindowLimiter(nil, tt.limit, tt.window)
+		}()
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// defaultGlobalKey is the default Redis key of the shared bucket checked by AllowGlobal
+const defaultGlobalKey = "ratelimit:global"
+
+// WithGlobalKey sets the Redis key of the shared bucket checked by AllowGlobal (default
+// "ratelimit:global"). Limiters enforcing different system-wide limits need distinct keys.
+func WithGlobalKey(key string) LimiterOption {
+	return func(rl *RateLimiter) {
+		rl.globalKey = key
+	}
+}
+
+// AllowGlobal checks a request costing n tokens against the single bucket shared by
+// all clients, e.g. a system-wide limit or emergency brake, independently of any
+// per-user bucket. The bucket uses the limiter's rate and capacity, so a global limit
+// usually gets its own RateLimiter. Its key is routed with GetClient like a userID,
+// so it always lives on the same, deterministic shard. In cluster mode the key is
+// braced into a hash tag, e.g. "{ratelimit:global}", to route it the same way.
+func (rl *RateLimiter) AllowGlobal(n float64) (*AllowResult, error) {
+	return rl.AllowGlobalCtx(ctx, n)
+}
+
+// AllowGlobalCtx is like AllowGlobal but uses the caller's context for the Redis call
+func (rl *RateLimiter) AllowGlobalCtx(ctx context.Context, n float64) (*AllowResult, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("requested tokens must be positive, got %v", n)
+	}
+
+	return rl.allowKey(ctx, rl.globalKey, rl.manager.keyUserID(rl.globalKey), n)
+}
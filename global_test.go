@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// TestAllowGlobal tests that the global bucket is shared and independent of user buckets
+func TestAllowGlobal(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.001, 3.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+	WithGlobalKey("ratelimit:test_global")(limiter)
+
+	// Two requests costing 2 and 1 exhaust the global bucket
+	for i, n := range []float64{2, 1} {
+		result, err := limiter.AllowGlobal(n)
+		if err != nil {
+			t.Fatalf("AllowGlobal failed: %v", err)
+		}
+		if !result.Allowed {
+			t.Fatalf("Global request %d should have been allowed", i+1)
+		}
+	}
+	result, err := limiter.AllowGlobal(1)
+	if err != nil {
+		t.Fatalf("AllowGlobal failed: %v", err)
+	}
+	if result.Allowed {
+		t.Errorf("Expected the exhausted global bucket to block")
+	}
+
+	// The configured key is used as is and user buckets are unaffected
+	client := limiter.manager.GetClient("ratelimit:test_global")
+	if exists, _ := client.Exists(testCtx, "ratelimit:test_global").Result(); exists != 1 {
+		t.Errorf("Expected the global bucket at the configured key")
+	}
+	userResult, err := limiter.Allow("test_global_user")
+	if err != nil {
+		t.Fatalf("Allow failed: %v", err)
+	}
+	if !userResult.Allowed {
+		t.Errorf("Expected user requests to be independent of the global bucket")
+	}
+}
+
+// TestAllowGlobalInvalidCost tests that non-positive costs are rejected before reaching Redis
+func TestAllowGlobalInvalidCost(t *testing.T) {
+	limiter := NewRateLimiter(nil, 5.0, 10.0)
+	for _, n := range []float64{0, -1} {
+		if _, err := limiter.AllowGlobal(n); err == nil {
+			t.Errorf("Expected an error for a cost of %v", n)
+		}
+	}
+}
+
+// TestGlobalKeyRouting tests that the global bucket always routes to the same shard
+func TestGlobalKeyRouting(t *testing.T) {
+	manager := &RedisShardManager{shards: make([]*redis.Client, 5)}
+	limiter := NewRateLimiter(manager, 1, 10)
+	if limiter.globalKey != defaultGlobalKey {
+		t.Errorf("Expected the default global key %s, got %s", defaultGlobalKey, limiter.globalKey)
+	}
+
+	shard := manager.shardIndex(limiter.globalKey)
+	for i := 0; i < 10; i++ {
+		if got := manager.shardIndex(limiter.globalKey); got != shard {
+			t.Fatalf("Expected the global key to route to shard %d, got %d", shard, got)
+		}
+	}
+}
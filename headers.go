@@ -0,0 +1,129 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HeaderNames is the set of response header names the middlewares report limits
+// with. Headers with an empty name are not sent.
+type HeaderNames struct {
+	Limit      string // capacity of the bucket
+	Remaining  string // tokens left after the request
+	RetryAfter string // seconds until a blocked request can be retried
+	Reset      string // seconds until the bucket is full again
+	Combined   string // limit, remaining and reset as one structured field
+	Warning    string // grace and courtesy requests let through over the limit
+	Bypass     string // reason a request wasn't rate limited
+	Scope      string // binding dimension of a composite limit
+	Duplicate  string // status of the original request of a duplicate submission
+	// PreserveCase sends the names exactly as written. By default fasthttp normalizes
+	// header names on the wire, e.g. X-RateLimit-Limit is sent as X-Ratelimit-Limit.
+	// It disables normalization for the whole response, so the handler's own headers
+	// are sent as written too.
+	PreserveCase bool
+}
+
+// CanonicalHeaders are the X-RateLimit-* headers, the default
+var CanonicalHeaders = HeaderNames{
+	Limit:      "X-RateLimit-Limit",
+	Remaining:  "X-RateLimit-Remaining",
+	RetryAfter: "X-RateLimit-Retry-After",
+	Warning:    "X-RateLimit-Warning",
+	Bypass:     BypassHeader,
+	Scope:      "X-RateLimit-Scope",
+	Duplicate:  "X-RateLimit-Duplicate",
+}
+
+// IETFHeaders are the RateLimit-* headers of the IETF httpapi draft, with the
+// standard Retry-After header for blocked requests and the combined RateLimit
+// field, e.g. "limit=10, remaining=4, reset=2". Headers without a draft
+// equivalent keep the RateLimit- prefix.
+var IETFHeaders = HeaderNames{
+	Limit:      "RateLimit-Limit",
+	Remaining:  "RateLimit-Remaining",
+	RetryAfter: "Retry-After",
+	Reset:      "RateLimit-Reset",
+	Combined:   "RateLimit",
+	Warning:    "RateLimit-Warning",
+	Bypass:     "RateLimit-Bypass",
+	Scope:      "RateLimit-Scope",
+	Duplicate:  "RateLimit-Duplicate",
+}
+
+// PrefixHeaders returns the canonical header set with X-RateLimit- replaced by
+// prefix, e.g. PrefixHeaders("X-Quota-") sends X-Quota-Limit and X-Quota-Remaining
+func PrefixHeaders(prefix string) HeaderNames {
+	rename := func(name string) string {
+		if name == "" {
+			return ""
+		}
+		return prefix + strings.TrimPrefix(name, "X-RateLimit-")
+	}
+	c := CanonicalHeaders
+	return HeaderNames{
+		Limit:      rename(c.Limit),
+		Remaining:  rename(c.Remaining),
+		RetryAfter: rename(c.RetryAfter),
+		Reset:      rename(c.Reset),
+		Combined:   rename(c.Combined),
+		Warning:    rename(c.Warning),
+		Bypass:     rename(c.Bypass),
+		Scope:      rename(c.Scope),
+		Duplicate:  rename(c.Duplicate),
+	}
+}
+
+// Lowercase returns the header set with lowercase names sent as written, for
+// clients and proxies matching header names case-sensitively
+func (h HeaderNames) Lowercase() HeaderNames {
+	return HeaderNames{
+		Limit:        strings.ToLower(h.Limit),
+		Remaining:    strings.ToLower(h.Remaining),
+		RetryAfter:   strings.ToLower(h.RetryAfter),
+		Reset:        strings.ToLower(h.Reset),
+		Combined:     strings.ToLower(h.Combined),
+		Warning:      strings.ToLower(h.Warning),
+		Bypass:       strings.ToLower(h.Bypass),
+		Scope:        strings.ToLower(h.Scope),
+		Duplicate:    strings.ToLower(h.Duplicate),
+		PreserveCase: true,
+	}
+}
+
+// HeaderStyle selects one of the predefined header sets
+type HeaderStyle int
+
+const (
+	// HeaderStyleLegacy sends the X-RateLimit-* headers (CanonicalHeaders)
+	HeaderStyleLegacy HeaderStyle = iota
+	// HeaderStyleDraft sends the IETF draft RateLimit-* headers (IETFHeaders)
+	HeaderStyleDraft
+)
+
+// Headers returns the header set of the style
+func (s HeaderStyle) Headers() HeaderNames {
+	if s == HeaderStyleDraft {
+		return IETFHeaders
+	}
+	return CanonicalHeaders
+}
+
+// set sets the header name of the response, skipping unnamed headers
+func (h HeaderNames) set(c *fiber.Ctx, name, value string) {
+	if name == "" {
+		return
+	}
+	if h.PreserveCase {
+		c.Response().Header.DisableNormalizing()
+	}
+	c.Set(name, value)
+}
+
+// del removes the header name from the response
+func (h HeaderNames) del(c *fiber.Ctx, name string) {
+	if name != "" {
+		c.Response().Header.Del(name)
+	}
+}
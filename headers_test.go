@@ -0,0 +1,220 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// TestMiddlewareHeaderNames tests that allowed and blocked responses report limits
+// with the configured header set only
+func TestMiddlewareHeaderNames(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.01, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	tests := []struct {
+		name    string
+		headers HeaderNames
+		other   HeaderNames
+	}{
+		{"canonical", CanonicalHeaders, IETFHeaders},
+		{"ietf", IETFHeaders, CanonicalHeaders},
+		{"prefix", PrefixHeaders("X-Quota-"), CanonicalHeaders},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := "test_user_headers_" + tt.name
+			app := newTestApp(RateLimitMiddleware(limiter,
+				WithKeyFunc(func(c *fiber.Ctx) string { return userID }),
+				WithHeaderNames(tt.headers),
+			))
+
+			for i, status := range []int{fiber.StatusOK, fiber.StatusTooManyRequests} {
+				resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+				if err != nil {
+					t.Fatalf("Request failed: %v", err)
+				}
+				if resp.StatusCode != status {
+					t.Fatalf("Request %d: expected status %d, got %d", i+1, status, resp.StatusCode)
+				}
+				if resp.Header.Get(tt.headers.Limit) != "1" || resp.Header.Get(tt.headers.Remaining) != "0" {
+					t.Errorf("Request %d: expected %s 1 and %s 0, got %v", i+1, tt.headers.Limit, tt.headers.Remaining, resp.Header)
+				}
+				if resp.Header.Get(tt.other.Limit) != "" {
+					t.Errorf("Request %d: unexpected %s header", i+1, tt.other.Limit)
+				}
+				if blocked := status == fiber.StatusTooManyRequests; blocked != (resp.Header.Get(tt.headers.RetryAfter) != "") {
+					t.Errorf("Request %d: unexpected %s header %q", i+1, tt.headers.RetryAfter, resp.Header.Get(tt.headers.RetryAfter))
+				}
+			}
+		})
+	}
+}
+
+// TestPrefixHeaders tests the names derived from a custom prefix
+func TestPrefixHeaders(t *testing.T) {
+	headers := PrefixHeaders("X-Quota-")
+	if headers.Limit != "X-Quota-Limit" || headers.RetryAfter != "X-Quota-Retry-After" || headers.Bypass != "X-Quota-Bypass" {
+		t.Errorf("Unexpected prefixed headers %+v", headers)
+	}
+	if headers.PreserveCase {
+		t.Error("Expected prefixed headers to be normalized")
+	}
+}
+
+// TestMiddlewareLowercaseHeaders tests that lowercase header names are sent as
+// written instead of being normalized by fasthttp
+func TestMiddlewareLowercaseHeaders(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(1.0, 5.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	for _, tt := range []struct {
+		headers  HeaderNames
+		expected string
+	}{
+		{CanonicalHeaders, "X-Ratelimit-Limit: 5"},
+		{CanonicalHeaders.Lowercase(), "x-ratelimit-limit: 5"},
+	} {
+		userID := "test_user_headers_case"
+		app := newTestApp(RateLimitMiddleware(limiter,
+			WithKeyFunc(func(c *fiber.Ctx) string { return userID }),
+			WithHeaderNames(tt.headers),
+		))
+
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/")
+		app.Handler()(ctx)
+		if raw := ctx.Response.Header.String(); !strings.Contains(raw, tt.expected+"\r\n") {
+			t.Errorf("Expected %q in the response headers, got:\n%s", tt.expected, raw)
+		}
+	}
+}
+
+// fakeRefillLimiter is a fakeCapacityLimiter refilling 4 tokens per second
+type fakeRefillLimiter struct {
+	fakeCapacityLimiter
+}
+
+func (f *fakeRefillLimiter) Rate() float64 {
+	return 4
+}
+
+// TestMiddlewareHeaderStyle tests the headers of each style, including the reset
+// of a bucket with fractional tokens left
+func TestMiddlewareHeaderStyle(t *testing.T) {
+	tests := []struct {
+		name      string
+		style     HeaderStyle
+		remaining float64
+		want      map[string]string
+		absent    []string
+	}{
+		{
+			name: "legacy", style: HeaderStyleLegacy, remaining: 7.5,
+			want:   map[string]string{"X-RateLimit-Limit": "20", "X-RateLimit-Remaining": "7"},
+			absent: []string{"RateLimit-Limit", "RateLimit-Reset", "RateLimit"},
+		},
+		{
+			// 12.5 tokens missing at 4 tokens per second take 3.125s, rounded up
+			name: "draft fractional", style: HeaderStyleDraft, remaining: 7.5,
+			want: map[string]string{
+				"RateLimit-Limit":     "20",
+				"RateLimit-Remaining": "7",
+				"RateLimit-Reset":     "4",
+				"RateLimit":           "limit=20, remaining=7, reset=4",
+			},
+			absent: []string{"X-RateLimit-Limit"},
+		},
+		{
+			name: "draft full", style: HeaderStyleDraft, remaining: 20,
+			want: map[string]string{"RateLimit-Reset": "0", "RateLimit": "limit=20, remaining=20, reset=0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := &fakeRefillLimiter{}
+			limiter.result = AllowResult{Allowed: true, Remaining: tt.remaining}
+			app := newTestApp(RateLimitMiddleware(limiter, WithHeaderStyle(tt.style)))
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			for name, value := range tt.want {
+				if got := resp.Header.Get(name); got != value {
+					t.Errorf("Expected %s %q, got %q", name, value, got)
+				}
+			}
+			for _, name := range tt.absent {
+				if got := resp.Header.Get(name); got != "" {
+					t.Errorf("Unexpected %s header %q", name, got)
+				}
+			}
+		})
+	}
+}
+
+// TestMiddlewareResetTimestamp tests that the reset header carries the Unix time of
+// the result's ResetAt, rounded up, or of the computed reset without one, while the
+// combined field keeps the seconds
+func TestMiddlewareResetTimestamp(t *testing.T) {
+	limiter := &fakeRefillLimiter{}
+	limiter.result = AllowResult{Allowed: true, Remaining: 7.5, ResetAt: time.Unix(1700000000, 2e8)}
+	app := newTestApp(RateLimitMiddleware(limiter, WithHeaderStyle(HeaderStyleDraft), WithResetTimestamp()))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if got := resp.Header.Get("RateLimit-Reset"); got != "1700000001" {
+		t.Errorf("Expected RateLimit-Reset 1700000001, got %q", got)
+	}
+	if got := resp.Header.Get("RateLimit"); got != "limit=20, remaining=7, reset=4" {
+		t.Errorf("Expected the combined field to keep the seconds, got %q", got)
+	}
+
+	// 12.5 tokens missing at 4 tokens per second are refilled 3.125s from now
+	limiter.result = AllowResult{Allowed: true, Remaining: 7.5}
+	before := time.Now()
+	resp, err = app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	reset, err := strconv.ParseInt(resp.Header.Get("RateLimit-Reset"), 10, 64)
+	if err != nil {
+		t.Fatalf("Expected a Unix time in RateLimit-Reset: %v", err)
+	}
+	if earliest := before.Add(3125 * time.Millisecond).Unix(); reset < earliest || reset > earliest+2 {
+		t.Errorf("Expected RateLimit-Reset about %d, got %d", earliest, reset)
+	}
+}
+
+// TestMiddlewareResetWithoutRate tests that limiters without a refill rate send no
+// reset headers
+func TestMiddlewareResetWithoutRate(t *testing.T) {
+	limiter := &fakeCapacityLimiter{}
+	limiter.result = AllowResult{Allowed: true, Remaining: 5}
+	app := newTestApp(RateLimitMiddleware(limiter, WithHeaderStyle(HeaderStyleDraft)))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if resp.Header.Get("RateLimit-Remaining") != "5" {
+		t.Errorf("Expected RateLimit-Remaining 5, got %q", resp.Header.Get("RateLimit-Remaining"))
+	}
+	if resp.Header.Get("RateLimit-Reset") != "" || resp.Header.Get("RateLimit") != "" {
+		t.Errorf("Unexpected reset headers %v", resp.Header)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v2"
+)
+
+// healthCheckTimeout bounds the ping of each shard during a health check
+const healthCheckTimeout = time.Second
+
+// HealthCheck pings every shard at once and returns the outcome of each, keyed by
+// shard index: nil for a shard that responded, the error otherwise. Each ping is
+// bounded by ctx and by one second.
+func (rsm *RedisShardManager) HealthCheck(ctx context.Context) map[int]error {
+	shards := rsm.Shards()
+	results := make(map[int]error, len(shards))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i, client := range shards {
+		wg.Add(1)
+		go func(i int, client *redis.Client) {
+			defer wg.Done()
+			pingCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			err := client.Ping(pingCtx).Err()
+			cancel()
+
+			mu.Lock()
+			results[i] = err
+			mu.Unlock()
+		}(i, client)
+	}
+	wg.Wait()
+	return results
+}
+
+// RedisHealthHandler reports the Redis connectivity of manager's shards, answering
+// 200 when at least quorum shards respond to a ping and 503 otherwise, with the
+// indices of the failing shards. A quorum of zero or less requires every shard.
+func RedisHealthHandler(manager *RedisShardManager, quorum int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		results := manager.HealthCheck(c.UserContext())
+
+		failing := []int{}
+		for shard, err := range results {
+			if err != nil {
+				failing = append(failing, shard)
+			}
+		}
+		sort.Ints(failing)
+
+		required := len(results)
+		if quorum > 0 && quorum < required {
+			required = quorum
+		}
+		healthy := len(results) - len(failing)
+
+		status, code := "ok", fiber.StatusOK
+		if healthy < required {
+			status, code = "unavailable", fiber.StatusServiceUnavailable
+		}
+		return c.Status(code).JSON(fiber.Map{
+			"status":         status,
+			"shards":         len(results),
+			"healthy":        healthy,
+			"quorum":         required,
+			"failing_shards": failing,
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v2"
+)
+
+// newPongServer starts a fake Redis server answering PONG to every command and
+// returns its address
+func newPongServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					// Skip the lines of a command sent as a RESP array
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					var n int
+					if _, err := fmt.Sscanf(line, "*%d", &n); err == nil {
+						for i := 0; i < 2*n; i++ {
+							if _, err := reader.ReadString('\n'); err != nil {
+								return
+							}
+						}
+					}
+					if _, err := conn.Write([]byte("+PONG\r\n")); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// newHealthManager returns a manager of a healthy shard, an unreachable one and
+// another healthy one
+func newHealthManager(t *testing.T) *RedisShardManager {
+	addr := newPongServer(t)
+	var shards []*redis.Client
+	for _, shardAddr := range []string{addr, "127.0.0.1:1", addr} {
+		client := redis.NewClient(&redis.Options{Addr: shardAddr, DialTimeout: 100 * time.Millisecond, MaxRetries: -1})
+		t.Cleanup(func() { client.Close() })
+		shards = append(shards, client)
+	}
+	return &RedisShardManager{shards: shards}
+}
+
+// TestHealthCheck tests that every shard is reported by index, with the error of the
+// unreachable one
+func TestHealthCheck(t *testing.T) {
+	results := newHealthManager(t).HealthCheck(context.Background())
+
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 shards reported, got %v", results)
+	}
+	for shard, err := range results {
+		if unhealthy := shard == 1; (err != nil) != unhealthy {
+			t.Errorf("Shard %d: expected unhealthy %v, got %v", shard, unhealthy, err)
+		}
+	}
+}
+
+// TestRedisHealthHandler tests the status of the Redis health endpoint with one shard
+// down, requiring all shards or a quorum
+func TestRedisHealthHandler(t *testing.T) {
+	manager := newHealthManager(t)
+	tests := []struct {
+		name     string
+		quorum   int
+		expected int
+		status   string
+	}{
+		{"all shards", 0, fiber.StatusServiceUnavailable, "unavailable"},
+		{"quorum met", 2, fiber.StatusOK, "ok"},
+		{"quorum missed", 3, fiber.StatusServiceUnavailable, "unavailable"},
+	}
+	for _, tt := range tests {
+		app := fiber.New()
+		app.Get("/health/redis", RedisHealthHandler(manager, tt.quorum))
+		resp, err := app.Test(httptest.NewRequest("GET", "/health/redis", nil))
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", tt.name, err)
+		}
+		if resp.StatusCode != tt.expected {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.expected, resp.StatusCode)
+		}
+
+		var body struct {
+			Status  string `json:"status"`
+			Healthy int    `json:"healthy"`
+			Failing []int  `json:"failing_shards"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("%s: failed to decode body: %v", tt.name, err)
+		}
+		if body.Status != tt.status || body.Healthy != 2 || !reflect.DeepEqual(body.Failing, []int{1}) {
+			t.Errorf("%s: expected status %s, 2 healthy and shard 1 failing, got %+v", tt.name, tt.status, body)
+		}
+	}
+}
+
+// TestRedisHealthHandlerHealthy tests that the endpoint answers 200 with no failing
+// shards when every shard responds
+func TestRedisHealthHandlerHealthy(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: newPongServer(t)})
+	defer client.Close()
+
+	app := fiber.New()
+	app.Get("/health/redis", RedisHealthHandler(&RedisShardManager{shards: []*redis.Client{client}}, 0))
+	resp, err := app.Test(httptest.NewRequest("GET", "/health/redis", nil))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != fiber.StatusOK || !strings.Contains(string(body), `"failing_shards":[]`) {
+		t.Errorf("Expected 200 with no failing shards, got %d %s", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HTTPKeyFunc extracts the rate limit key of a net/http request
+type HTTPKeyFunc func(r *http.Request) string
+
+// remoteAddrKey is the default HTTPKeyFunc, limiting clients by the IP address of
+// r.RemoteAddr
+func remoteAddrKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// HTTPHeaderKeyFunc returns an HTTPKeyFunc limiting clients by the value of the named
+// request header, hashed like HeaderKeyFunc for Fiber
+func HTTPHeaderKeyFunc(header string) HTTPKeyFunc {
+	return func(r *http.Request) string {
+		return headerKey(r.Header.Get(header))
+	}
+}
+
+// WithHTTPKeyFunc sets how HTTPMiddleware extracts the rate limit key from a request
+// (default the IP address of r.RemoteAddr). Requests for which fn returns an empty
+// key are rejected with 401 Unauthorized.
+func WithHTTPKeyFunc(fn HTTPKeyFunc) Option {
+	return func(o *MiddlewareOptions) {
+		o.HTTPKeyFunc = fn
+	}
+}
+
+// WithHTTPRoute sets the route label of the metrics of HTTPMiddleware decisions
+// (default empty). net/http has no registered route path to take it from, and
+// labelling by request path would create a series per URL.
+func WithHTTPRoute(route string) Option {
+	return func(o *MiddlewareOptions) {
+		o.HTTPRoute = route
+	}
+}
+
+// HTTPMiddleware is RateLimitMiddleware for net/http, and routers built on it such
+// as chi or gorilla/mux. It sends the same headers and bodies: 401 for requests
+// without a key, 429 for requests over the limit and, in FailClosed mode, 503 when
+// the limit can't be verified. Options reading the Fiber context (WithKeyFunc,
+// WithCostFunc, WithFailureModeOverride, WithLastRequestCourtesy) and those keeping
+// state next to the buckets (soft limits, violation grace, tarpitting, block webhooks
+// and panic refunds) are ignored; every request costs 1 token.
+func HTTPMiddleware(limiter Limiter, opts ...Option) func(http.Handler) http.Handler {
+	options := newMiddlewareOptions(opts)
+	keyFunc := options.HTTPKeyFunc
+	if keyFunc == nil {
+		keyFunc = remoteAddrKey
+	}
+	rl, _ := limiter.(*RateLimiter)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID := keyFunc(r)
+			if options.KeyNormalizer != nil {
+				userID = options.KeyNormalizer(userID)
+			}
+			if userID == "" {
+				log.Printf("INFO: Decision: REJECTED (401) - Reason: Missing client identity")
+				writeJSON(w, http.StatusUnauthorized, missingIdentityBody)
+				return
+			}
+
+			// Let everything through while enforcement is disabled for maintenance
+			if rl != nil && !rl.EnforcementEnabled(r.Context()) {
+				log.Printf("INFO: Decision: BYPASSED - userID: %s, Reason: Enforcement disabled", userID)
+				httpBypassed(w, options.Headers, BypassMaintenance)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			result, err := allowN(r.Context(), limiter, userID, 1)
+			allowDuration.Observe(time.Since(start).Seconds())
+			recordDecision(options.HTTPRoute, result, err)
+			if err != nil {
+				mode := options.failureModeFor(err)
+				log.Printf("ERROR: Critical Redis Error: Rate limiter execution failure for userID %s - %v. Falling back to %s Policy.", userID, err, mode)
+				if mode == FailClosed {
+					writeJSON(w, http.StatusServiceUnavailable, limiterUnavailableBody)
+					return
+				}
+				httpBypassed(w, options.Headers, BypassRedisError)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			limit, headers := options.limitHeaders(limiter, result, 1)
+			for _, h := range headers {
+				setHTTPHeader(w, options.Headers, h.name, h.value)
+			}
+
+			if !result.Allowed {
+				retryAfter := retryAfterHeaderSeconds(options.retryAfter(result))
+				if options.Headers.RetryAfter != "" {
+					setHTTPHeader(w, options.Headers, options.Headers.RetryAfter, strconv.Itoa(retryAfter))
+				}
+				log.Printf("INFO: Decision: BLOCKED (429) - userID: %s, Reason: Rate limit exceeded, Retry-After: %d seconds", userID, retryAfter)
+				writeJSON(w, http.StatusTooManyRequests, rateLimitExceededBody)
+				return
+			}
+
+			log.Printf("INFO: Decision: ALLOWED - userID: %s, Remaining: %.2f, Limit: %.0f", userID, result.Remaining, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// setHTTPHeader sets the header name of the response, keeping its case as written
+// for header sets preserving case
+func setHTTPHeader(w http.ResponseWriter, headers HeaderNames, name, value string) {
+	if headers.PreserveCase {
+		w.Header()[name] = []string{value}
+		return
+	}
+	w.Header().Set(name, value)
+}
+
+// httpBypassed marks a request let through without a verified rate limit, like
+// limiterBypassed
+func httpBypassed(w http.ResponseWriter, headers HeaderNames, reason string) {
+	for _, name := range headers.bypassHeaders() {
+		if name != "" {
+			w.Header().Del(name)
+		}
+	}
+	if headers.Bypass != "" {
+		setHTTPHeader(w, headers, headers.Bypass, reason)
+	}
+}
+
+// writeJSON writes body as the JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("WARNING: Failed to write response body - %v", err)
+	}
+}
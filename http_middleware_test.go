@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// serveHTTPMiddleware sends a request from remoteAddr through HTTPMiddleware, returning the
+// response and whether the handler ran
+func serveHTTPMiddleware(t *testing.T, limiter Limiter, remoteAddr string, opts ...Option) (*httptest.ResponseRecorder, bool) {
+	t.Helper()
+	handled := false
+	handler := HTTPMiddleware(limiter, opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled = true
+		w.Write([]byte("ok"))
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = remoteAddr
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec, handled
+}
+
+// TestHTTPMiddleware tests the allowed and blocked responses of the net/http middleware
+func TestHTTPMiddleware(t *testing.T) {
+	t.Run("allowed", func(t *testing.T) {
+		limiter := &fakeRefillLimiter{}
+		limiter.result = AllowResult{Allowed: true, Remaining: 12}
+		rec, handled := serveHTTPMiddleware(t, limiter, "192.0.2.1:4321")
+
+		if rec.Code != http.StatusOK || !handled {
+			t.Fatalf("Expected the handler to run with 200, got %d", rec.Code)
+		}
+		if rec.Header().Get("X-RateLimit-Limit") != "20" || rec.Header().Get("X-RateLimit-Remaining") != "12" {
+			t.Errorf("Unexpected limit headers %v", rec.Header())
+		}
+		if rec.Header().Get("X-RateLimit-Retry-After") != "" {
+			t.Errorf("Unexpected retry-after header on an allowed request")
+		}
+		if len(limiter.requested) != 1 || limiter.requested[0] != 1 {
+			t.Errorf("Expected one check of 1 token, got %v", limiter.requested)
+		}
+	})
+
+	t.Run("blocked", func(t *testing.T) {
+		limiter := &fakeRefillLimiter{}
+		limiter.result = AllowResult{Allowed: false, Remaining: 0.5, RetryAfter: 1500 * time.Millisecond}
+		rec, handled := serveHTTPMiddleware(t, limiter, "192.0.2.1:4321", WithHeaderStyle(HeaderStyleDraft))
+
+		if rec.Code != http.StatusTooManyRequests || handled {
+			t.Fatalf("Expected 429 without running the handler, got %d", rec.Code)
+		}
+		want := map[string]string{
+			"RateLimit-Limit":     "20",
+			"RateLimit-Remaining": "0",
+			"RateLimit-Reset":     "5",
+			"RateLimit":           "limit=20, remaining=0, reset=5",
+			"Retry-After":         "2",
+		}
+		for name, value := range want {
+			if got := rec.Header().Get(name); got != value {
+				t.Errorf("Expected %s %q, got %q", name, value, got)
+			}
+		}
+
+		var body map[string]string
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Failed to decode body %q: %v", rec.Body.String(), err)
+		}
+		if body["error"] != "Rate limit exceeded" {
+			t.Errorf("Unexpected body %v", body)
+		}
+	})
+}
+
+// TestHTTPMiddlewareKeyFunc tests the default key from r.RemoteAddr and custom keys
+func TestHTTPMiddlewareKeyFunc(t *testing.T) {
+	if key := remoteAddrKey(&http.Request{RemoteAddr: "[2001:db8::1]:80"}); key != "2001:db8::1" {
+		t.Errorf("Expected the IPv6 host, got %q", key)
+	}
+
+	limiter := &fakeCapacityLimiter{}
+	limiter.result = AllowResult{Allowed: true, Remaining: 3}
+	rec, handled := serveHTTPMiddleware(t, limiter, "192.0.2.1:4321", WithHTTPKeyFunc(HTTPHeaderKeyFunc("X-API-Key")))
+	if rec.Code != http.StatusUnauthorized || handled {
+		t.Errorf("Expected 401 for a request without the key header, got %d", rec.Code)
+	}
+}
+
+// TestHTTPMiddlewareFailureMode tests that limiter errors follow the failure mode
+func TestHTTPMiddlewareFailureMode(t *testing.T) {
+	limiter := &fakeCapacityLimiter{}
+	limiter.err = errors.New("connection refused")
+
+	rec, handled := serveHTTPMiddleware(t, limiter, "192.0.2.1:4321")
+	if rec.Code != http.StatusOK || !handled || rec.Header().Get(BypassHeader) != BypassRedisError {
+		t.Errorf("Expected a bypassed request in fail-open mode, got %d %v", rec.Code, rec.Header())
+	}
+
+	rec, handled = serveHTTPMiddleware(t, limiter, "192.0.2.1:4321", WithFailureMode(FailClosed))
+	if rec.Code != http.StatusServiceUnavailable || handled {
+		t.Errorf("Expected 503 in fail-closed mode, got %d", rec.Code)
+	}
+}
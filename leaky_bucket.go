@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// leakyBucketLuaScript is the Lua script for atomically leaking a user's bucket down
+// to the time of the Redis server in milliseconds and adding the request to it if
+// there's room
+// KEYS[1] = bucket; ARGV[1] = leak rate per second, ARGV[2] = capacity,
+// ARGV[3] = requests
+const leakyBucketLuaScript = `
+local key = KEYS[1]
+local leakRate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local now = math.floor(serverTime() * 1000)
+local requested = tonumber(ARGV[3])
+
+local state = redis.call('HMGET', key, 'level', 'last_leak')
+local level = tonumber(state[1]) or 0
+local lastLeak = tonumber(state[2]) or now
+
+-- Leak what drained since the last request; a clock going backwards leaks nothing
+local elapsed = math.max(0, now - lastLeak)
+level = math.max(0, level - elapsed * leakRate / 1000)
+
+-- A request larger than the capacity never fits, however long it waits
+if requested > capacity then
+    return {0, tostring(level), -1}
+end
+
+if level + requested > capacity then
+    -- The request fits once enough has leaked
+    local excess = level + requested - capacity
+    return {0, tostring(level), math.ceil(excess / leakRate * 1000)}
+end
+
+level = level + requested
+redis.call('HSET', key, 'level', tostring(level), 'last_leak', now)
+-- The bucket is empty, and its key redundant, once the level has leaked
+redis.call('PEXPIRE', key, math.ceil(level / leakRate * 1000))
+return {1, tostring(level), 0}
+`
+
+// leakyBucketScript is the precomputed leakyBucketLuaScript on top of serverTime,
+// hashed once for EVALSHA
+var leakyBucketScript = redis.NewScript(serverTimeLuaScript + leakyBucketLuaScript)
+
+// LeakyBucketLimiter queues each user's requests in a bucket leaking at a constant
+// rate, e.g. for upstreams that need a smoothed request rate. A request is allowed if
+// it fits in the bucket after leaking and blocked when the bucket is full. The level
+// and the time of the last leak are kept in a per-user hash.
+type LeakyBucketLimiter struct {
+	manager  *RedisShardManager
+	leakRate float64 // requests drained per second
+	capacity float64 // maximum bucket level
+}
+
+// NewLeakyBucketLimiter creates a limiter leaking leakRate requests per second from
+// buckets holding up to capacity requests. It panics unless leakRate and capacity
+// are positive.
+func NewLeakyBucketLimiter(manager *RedisShardManager, leakRate, capacity float64) *LeakyBucketLimiter {
+	if leakRate <= 0 || capacity <= 0 {
+		panic(fmt.Sprintf("leaky bucket leak rate and capacity must be positive, got %v per second and %v", leakRate, capacity))
+	}
+	return &LeakyBucketLimiter{
+		manager:  manager,
+		leakRate: leakRate,
+		capacity: capacity,
+	}
+}
+
+// leakyBucketKey returns the Redis key of the given userID's bucket
+func (lb *LeakyBucketLimiter) leakyBucketKey(userID string) string {
+	return fmt.Sprintf("ratelimit:lb:%s", lb.manager.keyUserID(userID))
+}
+
+// Capacity returns the maximum bucket level
+func (lb *LeakyBucketLimiter) Capacity() float64 {
+	return lb.capacity
+}
+
+// Rate returns the leak rate in requests per second
+func (lb *LeakyBucketLimiter) Rate() float64 {
+	return lb.leakRate
+}
+
+// Allow checks whether a request of userID fits in its bucket, adding it if so.
+// Remaining is the room left in the bucket and RetryAfter, when blocked, the time
+// until enough has leaked. Requests larger than the capacity never fit and are
+// blocked with a zero RetryAfter.
+func (lb *LeakyBucketLimiter) Allow(userID string) (*AllowResult, error) {
+	return lb.AllowNCtx(ctx, userID, 1)
+}
+
+// AllowN is like Allow for n requests at once
+func (lb *LeakyBucketLimiter) AllowN(userID string, n float64) (*AllowResult, error) {
+	return lb.AllowNCtx(ctx, userID, n)
+}
+
+// AllowCtx is like Allow but uses the caller's context for the Redis call
+func (lb *LeakyBucketLimiter) AllowCtx(ctx context.Context, userID string) (*AllowResult, error) {
+	return lb.AllowNCtx(ctx, userID, 1)
+}
+
+// AllowNCtx is like AllowN but uses the caller's context for the Redis call
+func (lb *LeakyBucketLimiter) AllowNCtx(ctx context.Context, userID string, n float64) (*AllowResult, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("requested tokens must be positive, got %v", n)
+	}
+
+	values, err := lb.manager.runOnShard(ctx, userID, leakyBucketScript, []string{lb.leakyBucketKey(userID)}, lb.leakRate, lb.capacity, n).Slice()
+	if err != nil {
+		log.Printf("ERROR: Critical Redis Error: Lua leaky bucket script execution failure for userID %s - %v", userID, err)
+		return nil, fmt.Errorf("failed to execute leaky bucket script: %w", err)
+	}
+	if len(values) != 3 {
+		return nil, fmt.Errorf("unexpected leaky bucket script result: %v", values)
+	}
+	allowed, _ := values[0].(int64)
+	levelValue, _ := values[1].(string)
+	level, err := strconv.ParseFloat(levelValue, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected leaky bucket level %v: %w", values[1], err)
+	}
+	wait, _ := values[2].(int64)
+
+	result := &AllowResult{
+		Allowed:   allowed == 1,
+		Remaining: math.Max(0, lb.capacity-level),
+	}
+	if !result.Allowed && wait >= 0 {
+		result.RetryAfter = time.Duration(wait) * time.Millisecond
+	}
+	return result, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestLeakyBucketLimiter creates a leaky bucket limiter on the test Redis, deleting
+// the bucket of userID before and after the test
+func newTestLeakyBucketLimiter(t *testing.T, userID string, leakRate, capacity float64) *LeakyBucketLimiter {
+	base, cleanup, err := setupTestRateLimiter(1.0, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	limiter := NewLeakyBucketLimiter(base.manager, leakRate, capacity)
+	client := limiter.manager.GetClient(userID)
+	client.Del(testCtx, limiter.leakyBucketKey(userID))
+	t.Cleanup(func() {
+		client.Del(testCtx, limiter.leakyBucketKey(userID))
+		cleanup()
+	})
+	return limiter
+}
+
+// TestLeakyBucketBurst tests that a burst beyond the capacity is rejected
+func TestLeakyBucketBurst(t *testing.T) {
+	var _ Limiter = (*LeakyBucketLimiter)(nil)
+	userID := "test_user_leaky_burst"
+	limiter := newTestLeakyBucketLimiter(t, userID, 1, 5)
+
+	for i := 0; i < 5; i++ {
+		result, err := limiter.Allow(userID)
+		if err != nil {
+			t.Fatalf("Error calling Allow: %v", err)
+		}
+		if !result.Allowed {
+			t.Fatalf("Request %d: expected allowed, got %+v", i+1, result)
+		}
+	}
+
+	result, err := limiter.Allow(userID)
+	if err != nil {
+		t.Fatalf("Error calling Allow: %v", err)
+	}
+	if result.Allowed || result.Remaining >= 1 {
+		t.Fatalf("Expected the request over the capacity to be blocked, got %+v", result)
+	}
+	if result.RetryAfter <= 0 || result.RetryAfter > time.Second {
+		t.Errorf("Expected a retry-after of up to 1s, got %v", result.RetryAfter)
+	}
+
+	// A request larger than the capacity never fits, so no wait is suggested
+	if result, err := limiter.AllowN("test_user_leaky_large", 6); err != nil || result.Allowed || result.RetryAfter != 0 {
+		t.Errorf("Expected a request over the capacity to be blocked without a retry-after, got %+v (%v)", result, err)
+	}
+}
+
+// TestNewLeakyBucketLimiterInvalid tests that non-positive leak rates and capacities are rejected
+func TestNewLeakyBucketLimiterInvalid(t *testing.T) {
+	for _, tt := range []struct{ leakRate, capacity float64 }{{0, 5}, {-1, 5}, {1, 0}, {1, -5}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected a panic for leak rate %v and capacity %v", tt.leakRate, tt.capacity)
+				}
+			}()
+			NewLeakyBucketLimiter(&RedisShardManager{}, tt.leakRate, tt.capacity)
+		}()
+	}
+}
+
+// TestLeakyBucketDrain tests that a full bucket drains at exactly the leak rate
+func TestLeakyBucketDrain(t *testing.T) {
+	userID := "test_user_leaky_drain"
+	limiter := newTestLeakyBucketLimiter(t, userID, 10, 5)
+
+	if result, err := limiter.AllowN(userID, 5); err != nil || !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("Expected the bucket to fill, got %+v (%v)", result, err)
+	}
+
+	// 10 per second leak one request every 100ms
+	result, err := limiter.Allow(userID)
+	if err != nil {
+		t.Fatalf("Error calling Allow: %v", err)
+	}
+	if result.Allowed || result.RetryAfter <= 0 || result.RetryAfter > 100*time.Millisecond {
+		t.Fatalf("Expected to wait up to 100ms for a full bucket, got %+v", result)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if result, err := limiter.Allow(userID); err != nil || !result.Allowed {
+			t.Fatalf("Request %d: expected allowed after 2 requests leaked, got %+v (%v)", i+1, result, err)
+		}
+	}
+	if result, err := limiter.Allow(userID); err != nil || result.Allowed {
+		t.Errorf("Expected a third request to be blocked, got %+v (%v)", result, err)
+	}
+}
+
+// TestLeakyBucketServerTime tests that the bucket leaks from the Redis server's time
+func TestLeakyBucketServerTime(t *testing.T) {
+	userID := "test_user_leaky_server_time"
+	limiter := newTestLeakyBucketLimiter(t, userID, 1, 5)
+	if _, err := limiter.Allow(userID); err != nil {
+		t.Fatalf("Error calling Allow: %v", err)
+	}
+
+	client := limiter.manager.GetClient(userID)
+	serverTime, err := client.Time(testCtx).Result()
+	if err != nil {
+		t.Fatalf("TIME failed: %v", err)
+	}
+	lastLeak, err := client.HGet(testCtx, limiter.leakyBucketKey(userID), "last_leak").Float64()
+	if err != nil {
+		t.Fatalf("Failed to read last_leak: %v", err)
+	}
+	if drift := float64(serverTime.UnixMilli()) - lastLeak; drift < 0 || drift > 1000 {
+		t.Errorf("Expected last_leak to be the Redis server time, %vms behind TIME", drift)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+)
+
+// Limiter is a rate limiting algorithm enforced by RateLimitMiddleware, such as the
+// token bucket of RateLimiter or SlidingWindowLimiter
+type Limiter interface {
+	Allow(userID string) (*AllowResult, error)
+	AllowN(userID string, n float64) (*AllowResult, error)
+}
+
+// contextLimiter is implemented by limiters propagating the caller's context to their
+// backend, so that cancelled requests abort the check
+type contextLimiter interface {
+	AllowNCtx(ctx context.Context, userID string, n float64) (*AllowResult, error)
+}
+
+// capacityLimiter is implemented by limiters reporting their limit
+type capacityLimiter interface {
+	Capacity() float64
+}
+
+// refillLimiter is implemented by limiters refilling at a constant rate
+type refillLimiter interface {
+	Rate() float64
+}
+
+// refundLimiter is implemented by limiters that can return charged tokens
+type refundLimiter interface {
+	Refund(userID string, tokens float64) error
+}
+
+// allowN checks n tokens of userID against limiter, with ctx if the limiter takes one
+func allowN(ctx context.Context, limiter Limiter, userID string, n float64) (*AllowResult, error) {
+	if cl, ok := limiter.(contextLimiter); ok {
+		return cl.AllowNCtx(ctx, userID, n)
+	}
+	return limiter.AllowN(userID, n)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Limits returns the limiter's current rate (tokens per second) and capacity
+func (rl *RateLimiter) Limits() (rate, capacity float64) {
+	rl.limitsMu.RLock()
+	defer rl.limitsMu.RUnlock()
+	return rl.rate, rl.capacity
+}
+
+// Rate returns the limiter's current rate in tokens per second
+func (rl *RateLimiter) Rate() float64 {
+	rate, _ := rl.Limits()
+	return rate
+}
+
+// Capacity returns the limiter's current bucket capacity
+func (rl *RateLimiter) Capacity() float64 {
+	_, capacity := rl.Limits()
+	return capacity
+}
+
+// SetLimits replaces the limiter's rate and capacity together, so no check sees one
+// without the other. Existing buckets keep their tokens; a bucket above a lowered
+// capacity is cut down to it on its next refill.
+func (rl *RateLimiter) SetLimits(rate, capacity float64) error {
+	return rl.updateLimits(func(_, _ float64) (float64, float64) {
+		return rate, capacity
+	})
+}
+
+// SetRate replaces the limiter's rate, keeping its capacity, e.g. to shed load during
+// an incident. Checks in flight finish with the previous rate.
+func (rl *RateLimiter) SetRate(rate float64) error {
+	return rl.updateLimits(func(_, capacity float64) (float64, float64) {
+		return rate, capacity
+	})
+}
+
+// SetCapacity replaces the limiter's capacity, keeping its rate. Lowering it doesn't
+// block users at once: existing buckets keep their tokens, and a bucket above the new
+// capacity is cut down to it on its next refill.
+func (rl *RateLimiter) SetCapacity(capacity float64) error {
+	return rl.updateLimits(func(rate, _ float64) (float64, float64) {
+		return rate, capacity
+	})
+}
+
+// updateLimits replaces the limiter's limits with those update derives from the
+// current ones, atomically with respect to other updates
+func (rl *RateLimiter) updateLimits(update func(rate, capacity float64) (float64, float64)) error {
+	rl.limitsMu.Lock()
+	rate, capacity := update(rl.rate, rl.capacity)
+	if err := validateLimits(rate, capacity); err != nil {
+		rl.limitsMu.Unlock()
+		return err
+	}
+	rl.rate = rate
+	rl.capacity = capacity
+	rl.limitsMu.Unlock()
+
+	if rl.blockedCache != nil {
+		rl.blockedCache.clear()
+	}
+	warnRateAboveCapacity(rate, capacity)
+	return nil
+}
+
+// validateLimits checks that rate and capacity are positive, finite numbers
+func validateLimits(rate, capacity float64) error {
+	if !(rate > 0) || math.IsInf(rate, 0) {
+		return fmt.Errorf("rate must be a positive number, got %v", rate)
+	}
+	if !(capacity > 0) || math.IsInf(capacity, 0) {
+		return fmt.Errorf("capacity must be a positive number, got %v", capacity)
+	}
+	return nil
+}
@@ -2,11 +2,18 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"hash/fnv"
 	"log"
+	"math"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -18,70 +25,533 @@ var (
 	rateLimiter *RateLimiter
 )
 
+// shutdownTimeout bounds the time in-flight requests get to finish on shutdown
+const shutdownTimeout = 10 * time.Second
+
 // RedisShardManager manages multiple Redis shards for horizontal scaling
 type RedisShardManager struct {
-	shards []*redis.Client
+	mu       sync.RWMutex // guards the shard set against UpdateShards
+	shards   []*redis.Client
+	ids      []uint64        // rendezvous hashing id of each shard, see shardIDs
+	replicas []*redis.Client // optional read replica per shard, nil entries for none
+
+	previous    []*redis.Client // shard set replaced by the last UpdateShards
+	previousIDs []uint64        // ids of the previous shards
+	updatedAt   time.Time       // time of the last UpdateShards
+	seed        string          // salt hashed before every userID, empty for none
+
+	cluster  *redis.ClusterClient // Redis Cluster placing keys itself, nil for independent shards
+	listedAt time.Time            // time the masters of the cluster were last listed
+
+	breakers *breakers // circuit breaker of each shard, nil if disabled
 }
 
-// NewRedisShardManager creates a new shard manager and connects to all Redis instances
+// NewRedisShardManager creates a new shard manager and connects to all Redis instances.
+// Addresses are host:port addresses, Unix socket paths or redis://, rediss:// and
+// unix:// URLs, which carry their own credentials, database and TLS setting.
 func NewRedisShardManager(addresses []string) (*RedisShardManager, error) {
+	return NewRedisShardManagerWithAuth(addresses, ShardAuth{})
+}
+
+// ShardAuth holds the connection settings of shards given as a host:port address or
+// socket path. Shards given as URLs take them from their URL instead.
+type ShardAuth struct {
+	Password  string      // password sent with AUTH, empty for none
+	DB        int         // database selected on connect
+	TLSConfig *tls.Config // TLS settings, nil for plaintext connections
+}
+
+// NewRedisShardManagerWithAuth is like NewRedisShardManager but applies auth to every
+// shard given as a host:port address or socket path. URL entries keep the settings of
+// their URL, so shards hosted by different providers can each have their own.
+func NewRedisShardManagerWithAuth(addresses []string, auth ShardAuth) (*RedisShardManager, error) {
+	options, err := shardOptions(addresses, auth)
+	if err != nil {
+		return nil, err
+	}
+	return newRedisShardManager(options)
+}
+
+// shardOptions returns the client options of the given shard addresses, applying auth
+// to the entries that aren't URLs
+func shardOptions(addresses []string, auth ShardAuth) ([]*redis.Options, error) {
 	if len(addresses) == 0 {
 		return nil, fmt.Errorf("at least one Redis address is required")
 	}
 
-	shards := make([]*redis.Client, len(addresses))
+	options := make([]*redis.Options, len(addresses))
 	for i, addr := range addresses {
-		client := redis.NewClient(&redis.Options{
-			Addr:         addr,
-			Password:     "", // no password set
-			DB:           0,  // use default DB
-			DialTimeout:  5 * time.Second,
-			ReadTimeout:  3 * time.Second,
-			WriteTimeout: 3 * time.Second,
-		})
+		opt, err := addressOptions(addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse Redis URL for shard %d: %w", i, err)
+		}
+		if !isRedisURL(addr) {
+			opt.Password = auth.Password
+			opt.DB = auth.DB
+			opt.TLSConfig = auth.TLSConfig
+		}
+		options[i] = opt
+	}
+	return options, nil
+}
 
-		// Test the connection
-		_, err := client.Ping(ctx).Result()
+// NewRedisShardManagerFromURLs creates a new shard manager from redis:// or rediss:// URLs,
+// taking the scheme, credentials, host, database and TLS settings of each shard from its URL
+func NewRedisShardManagerFromURLs(urls []string) (*RedisShardManager, error) {
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("at least one Redis URL is required")
+	}
+
+	options := make([]*redis.Options, len(urls))
+	for i, url := range urls {
+		opt, err := redis.ParseURL(url)
 		if err != nil {
-			log.Printf("ERROR: Critical Redis Error: Connection failure to Redis shard at %s - %v", addr, err)
-			return nil, fmt.Errorf("failed to connect to Redis at %s: %w", addr, err)
+			return nil, fmt.Errorf("failed to parse Redis URL for shard %d: %w", i, err)
 		}
+		options[i] = opt
+	}
 
-		shards[i] = client
-		fmt.Printf("Successfully connected to Redis shard %d at %s\n", i, addr)
+	return newRedisShardManager(options)
+}
+
+// newRedisShardManager connects to every shard described by options
+func newRedisShardManager(options []*redis.Options) (*RedisShardManager, error) {
+	shards, err := connectShards(options)
+	if err != nil {
+		return nil, err
 	}
 
 	return &RedisShardManager{
 		shards: shards,
+		ids:    shardIDs(shards),
 	}, nil
 }
 
-// GetClient returns the Redis client for the given userID using consistent hashing
+// connectShards connects to every shard described by options, closing the
+// connections already opened if one of them fails. The scripts of limiters with the
+// default bucket storage are then preloaded into every shard.
+func connectShards(options []*redis.Options) ([]*redis.Client, error) {
+	shards := make([]*redis.Client, len(options))
+	for i, opt := range options {
+		// Apply our timeouts and retries unless the shard configuration overrides them
+		applyClientDefaults(opt)
+
+		client := redis.NewClient(opt)
+
+		// Test the connection
+		_, err := client.Ping(ctx).Result()
+		if err != nil {
+			log.Printf("ERROR: Critical Redis Error: Connection failure to Redis shard at %s - %v", opt.Addr, err)
+			client.Close()
+			closeClients(shards[:i])
+			return nil, fmt.Errorf("failed to connect to Redis at %s: %w", opt.Addr, err)
+		}
+
+		shards[i] = client
+		fmt.Printf("Successfully connected to Redis shard %d at %s\n", i, opt.Addr)
+	}
+
+	preloadScripts(shards)
+	return shards, nil
+}
+
+// applyClientDefaults sets the timeouts that opt leaves at zero to our defaults, and
+// turns off the retries of go-redis unless opt sets them. go-redis retries commands
+// whose reply was lost, which would run a script that may have charged a bucket
+// again; WithRetry only retries checks that never reached Redis.
+func applyClientDefaults(opt *redis.Options) {
+	if opt.MaxRetries == 0 {
+		opt.MaxRetries = -1
+	}
+	if opt.DialTimeout == 0 {
+		opt.DialTimeout = 5 * time.Second
+	}
+	if opt.ReadTimeout == 0 {
+		opt.ReadTimeout = 3 * time.Second
+	}
+	if opt.WriteTimeout == 0 {
+		opt.WriteTimeout = 3 * time.Second
+	}
+}
+
+// isRedisURL reports whether addr is a redis://, rediss:// or unix:// URL rather than
+// a bare host:port or socket path
+func isRedisURL(addr string) bool {
+	return strings.HasPrefix(addr, "redis://") || strings.HasPrefix(addr, "rediss://") || strings.HasPrefix(addr, "unix://")
+}
+
+// isUnixSocketPath reports whether addr is a bare Unix socket path
+func isUnixSocketPath(addr string) bool {
+	return strings.HasPrefix(addr, "/")
+}
+
+// GetClient returns the Redis client for the given userID using rendezvous hashing
 func (rsm *RedisShardManager) GetClient(userID string) *redis.Client {
+	if rsm.cluster != nil {
+		return rsm.clusterClient(userID)
+	}
+	rsm.mu.RLock()
+	defer rsm.mu.RUnlock()
+	return rsm.shards[shardFor(rsm.seed, userID, rsm.currentShardIDs())]
+}
+
+// Shards returns the current shard set. In cluster mode, these are the masters sorted
+// by address, listed again at most every 10 seconds.
+func (rsm *RedisShardManager) Shards() []*redis.Client {
+	if rsm.cluster != nil {
+		return rsm.clusterShards()
+	}
+	rsm.mu.RLock()
+	defer rsm.mu.RUnlock()
+	return rsm.shards
+}
+
+// Close closes the connections to every shard, including read replicas and the
+// shards replaced by the last UpdateShards, or the cluster in cluster mode. Checks
+// made after Close fail with a Redis error. The errors of all connections are
+// returned together.
+func (rsm *RedisShardManager) Close() error {
+	if rsm.cluster != nil {
+		return rsm.cluster.Close()
+	}
+
+	rsm.mu.RLock()
+	defer rsm.mu.RUnlock()
+	var errs []error
+	for _, clients := range [][]*redis.Client{rsm.shards, rsm.replicas, rsm.previous} {
+		for _, client := range clients {
+			if client == nil {
+				continue
+			}
+			if err := client.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("failed to close Redis connection to %s: %w", client.Options().Addr, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// shardIndex returns the index of the shard owning the given userID, that of its
+// master among Shards() in cluster mode
+func (rsm *RedisShardManager) shardIndex(userID string) int {
+	if rsm.cluster != nil {
+		return rsm.clusterShardIndex(userID)
+	}
+	rsm.mu.RLock()
+	defer rsm.mu.RUnlock()
+	return shardFor(rsm.seed, userID, rsm.currentShardIDs())
+}
+
+// SetHashSeed sets a salt hashed before every userID when picking its shard, to try
+// other distributions of an unlucky key set without adding shards. The empty seed
+// (default) keeps the unsalted distribution. Changing the seed remaps nearly every
+// userID to another shard, where it starts over with a fresh bucket, so do it in a
+// maintenance window.
+func (rsm *RedisShardManager) SetHashSeed(seed string) {
+	rsm.mu.Lock()
+	defer rsm.mu.Unlock()
+	rsm.seed = seed
+}
+
+// HashSeed returns the salt hashed before every userID
+func (rsm *RedisShardManager) HashSeed() string {
+	rsm.mu.RLock()
+	defer rsm.mu.RUnlock()
+	return rsm.seed
+}
+
+// shardFor returns the index of the shard owning the given userID among the shards
+// with the given ids (see shardIDs), salting the hash with seed. It uses rendezvous
+// (highest random weight) hashing: every shard gets a score mixed from the userID's
+// hash and the shard's id, and the highest score wins. As scores don't depend on the
+// shard's position, adding or removing a shard only moves the ~1/n of userIDs the
+// shard scores highest for, and reordering the shards moves none, where modulo
+// hashing would remap nearly all of them.
+func shardFor(seed, userID string, ids []uint64) int {
 	// Hash the userID to get a consistent value
-	hash := fnv.New32a()
-	hash.Write([]byte(userID))
-	hashValue := hash.Sum32()
+	hash := fnv.New64a()
+	hash.Write([]byte(seed))
+	hash.Write([]byte(hashTag(userID)))
+	hashValue := hash.Sum64()
+
+	best, bestScore := 0, uint64(0)
+	for i, id := range ids {
+		if score := mix64(hashValue ^ id); i == 0 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+// shardIDs returns the rendezvous hashing id of each shard, the hash of its address
+// and database. Shards sharing both, e.g. in tests, are told apart by their rank.
+func shardIDs(shards []*redis.Client) []uint64 {
+	ids := make([]uint64, len(shards))
+	seen := make(map[string]int, len(shards))
+	for i, client := range shards {
+		name := ""
+		if client != nil {
+			opt := client.Options()
+			name = fmt.Sprintf("%s/%d", opt.Addr, opt.DB)
+		}
+		ids[i] = shardID(fmt.Sprintf("%s#%d", name, seen[name]))
+		seen[name]++
+	}
+	return ids
+}
 
-	// Use modulo operation to map to a shard
-	shardIndex := int(hashValue) % len(rsm.shards)
-	return rsm.shards[shardIndex]
+// shardID hashes the stable name of a shard into its rendezvous hashing id
+func shardID(name string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(name))
+	return mix64(hash.Sum64())
+}
+
+// currentShardIDs returns the ids of the current shards, computing them for managers
+// whose shards were set directly; callers hold rsm.mu
+func (rsm *RedisShardManager) currentShardIDs() []uint64 {
+	if len(rsm.ids) == len(rsm.shards) {
+		return rsm.ids
+	}
+	return shardIDs(rsm.shards)
+}
+
+// mix64 is the splitmix64 finalizer, spreading every input bit over the whole output
+// so that scores of related inputs are independent
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// hashTag returns the part of userID that determines its shard. Like Redis Cluster,
+// if userID contains a non-empty "{...}" section only that section is hashed, so
+// e.g. "{team1}:alice" and "{team1}:bob" are always colocated.
+func hashTag(userID string) string {
+	if start := strings.IndexByte(userID, '{'); start >= 0 {
+		if end := strings.IndexByte(userID[start+1:], '}'); end > 0 {
+			return userID[start+1 : start+1+end]
+		}
+	}
+	return userID
 }
 
 // RateLimiter represents a distributed rate limiter using Token Bucket algorithm
 type RateLimiter struct {
-	manager  *RedisShardManager
-	rate     float64 // tokens per second
-	capacity float64 // maximum bucket capacity
+	manager    *RedisShardManager
+	limitsMu   sync.RWMutex   // guards rate and capacity against SetLimits
+	rate       float64        // tokens per second
+	capacity   float64        // maximum bucket capacity
+	retryAfter RetryAfterFunc // computes the wait time for blocked requests
+
+	initialTokens float64       // tokens a brand-new bucket starts with
+	keyTTL        time.Duration // inactivity expiry of bucket keys, 0 derives it from the limits
+
+	trackCreatedAt bool // record when each bucket was first initialized
+
+	writeSkipThreshold float64 // refill below which allowed requests skip the full write-back
+
+	pipelineBatchSize int // maximum commands per pipelined flush in AllowMany
+
+	debugUsers   map[string]struct{} // userIDs whose bucket operations are traced
+	debugReplies bool                // log the raw script reply when it can't be parsed
+
+	globalKey string // Redis key of the shared bucket checked by AllowGlobal
+
+	reservationTTL time.Duration // time before an unresolved reservation is committed
+
+	tokenPrecision int // decimals stored token counts are rounded to, negative for none
+
+	maxDebt float64 // tokens a bucket may be drawn below zero, 0 disables debt
+
+	compiledScripts map[string]*redis.Script // bucket scripts on top of the storage, by source
+
+	blockedCache *blockedCache // in-process blocked decisions, nil when disabled
+	decisionLog  *DecisionLog  // exported decision records, nil when disabled
+	storage      BucketStorage // layout of the buckets in Redis
+
+	enforcement         enforcementCache // cached cluster-wide enforcement flag
+	enforcementCacheTTL time.Duration
+
+	keyPrefix string // namespace separating this limiter's buckets from other limiters
+
+	lazyMigration bool // move buckets stranded on their previous shard by UpdateShards
+
+	fallback *fallbackState // standby manager taking over checks, nil when disabled
+
+	semaphores *shardSemaphores // per-shard cap of checks in flight, nil when unlimited
+
+	maxRetries   int           // retries of checks failing to reach Redis, 0 disables retries
+	retryBackoff time.Duration // wait before the first retry, doubled after each
+}
+
+// LimiterOption configures optional RateLimiter behavior
+type LimiterOption func(*RateLimiter)
+
+// WithRetryAfterFunc overrides the function used to compute the retry-after time
+func WithRetryAfterFunc(fn RetryAfterFunc) LimiterOption {
+	return func(rl *RateLimiter) {
+		rl.retryAfter = fn
+	}
+}
+
+// WithInitialTokens sets the number of tokens a brand-new bucket starts with (default capacity).
+// Starting buckets empty (0) prevents a never-seen key from bursting immediately, which is
+// useful on anti-abuse endpoints such as signup; the bucket then fills over time at the rate.
+// A brand-new bucket is created by its first check with the initial tokens and a lastRefill
+// of that check's time: the first check gets no refill, and refill accrues from then on.
+func WithInitialTokens(tokens float64) LimiterOption {
+	return func(rl *RateLimiter) {
+		rl.initialTokens = tokens
+	}
+}
+
+// WithKeyTTL sets how long an inactive bucket key lives in Redis, overriding the
+// default derived from the limits (see bucketTTL). Whole-second TTLs are applied with
+// EXPIRE, sub-second TTLs with PEXPIRE. It panics if ttl isn't positive.
+func WithKeyTTL(ttl time.Duration) LimiterOption {
+	if ttl <= 0 {
+		panic(fmt.Sprintf("key TTL must be positive, got %v", ttl))
+	}
+	return func(rl *RateLimiter) {
+		rl.keyTTL = ttl
+	}
+}
+
+// WithKeyPrefix namespaces the limiter's bucket keys as "ratelimit:tb:<prefix>:<userID>",
+// so limiters guarding different routes keep independent buckets for the same client.
+// It panics if prefix contains ':', which would let one prefix pass for another.
+func WithKeyPrefix(prefix string) LimiterOption {
+	if err := validateKeyPrefix(prefix); err != nil {
+		panic(err.Error())
+	}
+	return func(rl *RateLimiter) {
+		rl.keyPrefix = prefix
+	}
+}
+
+// WithTokenPrecision rounds the token count written by checks and refunds to the
+// given number of decimal places (default: no rounding). Rounding bounds the float
+// error that long-lived buckets could otherwise accumulate over many refills, at the
+// cost of up to half a unit of the last decimal per write: with 2 decimals, refills
+// below 0.005 tokens between checks are lost. Choose enough decimals to represent the
+// smallest refill expected between checks, i.e. the rate times the shortest interval.
+func WithTokenPrecision(decimals int) LimiterOption {
+	return func(rl *RateLimiter) {
+		rl.tokenPrecision = decimals
+	}
+}
+
+// WithCreatedAt records a createdAt timestamp in each bucket hash when it's first
+// initialized, exposed through PeekState to audit how long buckets persist. It's
+// opt-in to avoid the extra hash field for users who don't need it.
+func WithCreatedAt() LimiterOption {
+	return func(rl *RateLimiter) {
+		rl.trackCreatedAt = true
+	}
+}
+
+// WithWriteSkipThreshold reduces Redis writes for "mostly allowed" workloads. When the
+// refill owed to a bucket is below threshold tokens and the bucket can cover the request
+// without it, the script only decrements the tokens field instead of writing tokens,
+// lastRefill and the TTL. No tokens are lost: lastRefill isn't advanced, so the pending
+// refill is credited by the next full write. The tradeoff is accuracy of the reported
+// remaining tokens, which lag by up to threshold tokens, and that the key TTL is only
+// refreshed on full writes, so threshold/rate must stay well below the key TTL.
+// Disabled (0) by default.
+func WithWriteSkipThreshold(threshold float64) LimiterOption {
+	return func(rl *RateLimiter) {
+		rl.writeSkipThreshold = threshold
+	}
+}
+
+// WithPipelineBatchSize sets the maximum number of checks AllowMany sends to a shard
+// in a single pipelined flush (default 100). Larger groups are split into several
+// flushes so a huge batch neither buffers unbounded replies nor blocks the shard.
+func WithPipelineBatchSize(size int) LimiterOption {
+	return func(rl *RateLimiter) {
+		if size > 0 {
+			rl.pipelineBatchSize = size
+		}
+	}
+}
+
+// WithDebugUsers logs every bucket operation of the given userIDs with the full
+// bucket state, for diagnosing why a specific user is throttled without raising the
+// log level for everyone else
+func WithDebugUsers(userIDs ...string) LimiterOption {
+	return func(rl *RateLimiter) {
+		if rl.debugUsers == nil {
+			rl.debugUsers = make(map[string]struct{}, len(userIDs))
+		}
+		for _, userID := range userIDs {
+			rl.debugUsers[userID] = struct{}{}
+		}
+	}
+}
+
+// WithDebugScriptReplies logs the raw reply of the bucket scripts, with its Go
+// type, whenever it can't be parsed, to diagnose "unexpected result format" errors.
+// Successful checks are never logged.
+func WithDebugScriptReplies() LimiterOption {
+	return func(rl *RateLimiter) {
+		rl.debugReplies = true
+	}
 }
 
 // NewRateLimiter creates a new RateLimiter instance
-func NewRateLimiter(manager *RedisShardManager, rate, capacity float64) *RateLimiter {
-	return &RateLimiter{
-		manager:  manager,
-		rate:     rate,
-		capacity: capacity,
+func NewRateLimiter(manager *RedisShardManager, rate, capacity float64, opts ...LimiterOption) *RateLimiter {
+	rl := &RateLimiter{
+		manager:    manager,
+		rate:       rate,
+		capacity:   capacity,
+		retryAfter: DefaultRetryAfter,
+
+		initialTokens: capacity,
+
+		pipelineBatchSize: defaultPipelineBatchSize,
+		globalKey:         defaultGlobalKey,
+		reservationTTL:    defaultReservationTTL,
+		tokenPrecision:    -1,
+		storage:           HashStorage{},
+
+		enforcementCacheTTL: defaultEnforcementCacheTTL,
+	}
+	for _, opt := range opts {
+		opt(rl)
 	}
+	rl.compileScripts()
+
+	warnRateAboveCapacity(rate, capacity)
+	return rl
+}
+
+// warnRateAboveCapacity logs a warning for a rate above the capacity, which is valid
+// but often unintended: an empty bucket is full again in under a second
+func warnRateAboveCapacity(rate, capacity float64) {
+	if rate > capacity {
+		log.Printf("WARNING: Rate (%.2f tokens/sec) exceeds capacity (%.2f tokens): an empty bucket refills completely within %v, so requests are effectively limited by capacity per burst and retry-after waits are sub-second (reported as 1 second)",
+			rate, capacity, time.Duration(capacity/rate*float64(time.Second)))
+	}
+}
+
+// NewRateLimiterPer creates a rate limiter allowing count tokens per period, e.g.
+// 1000 per time.Hour, converted to the tokens-per-second rate used by the scripts.
+// It panics if period isn't positive.
+func NewRateLimiterPer(manager *RedisShardManager, count int, period time.Duration, capacity float64, opts ...LimiterOption) *RateLimiter {
+	return NewRateLimiter(manager, ratePer(count, period), capacity, opts...)
+}
+
+// ratePer converts count tokens per period to tokens per second. Dividing the
+// nanosecond counts directly avoids the rounding of period.Seconds() for long periods.
+func ratePer(count int, period time.Duration) float64 {
+	if period <= 0 {
+		panic(fmt.Sprintf("rate period must be positive, got %v", period))
+	}
+	return float64(count) * float64(time.Second) / float64(period)
 }
 
 // tokenBucketLuaScript is the Lua script for atomic token bucket operations
@@ -89,98 +559,460 @@ const tokenBucketLuaScript = `
 local key = KEYS[1]
 local rate = tonumber(ARGV[1])
 local capacity = tonumber(ARGV[2])
-local now = tonumber(ARGV[3])
-local requested = tonumber(ARGV[4])
+local now = serverTime()
+local requested = tonumber(ARGV[3])
+local initial = tonumber(ARGV[4])
+local ttl = tonumber(ARGV[5])
+local ttlUnit = ARGV[6]
+local trackCreatedAt = ARGV[7] == '1'
+local writeSkipThreshold = tonumber(ARGV[8])
+local precision = tonumber(ARGV[9])
+local maxDebt = tonumber(ARGV[10])
+
+-- Round a token count to the configured number of decimals, negative keeps it as is
+local function round(value)
+    if precision < 0 then
+        return value
+    end
+    local scale = 10 ^ precision
+    return math.floor(value * scale + 0.5) / scale
+end
 
--- Get current state from Redis hash
-local bucket = redis.call('HMGET', key, 'tokens', 'lastRefill')
-local tokens = tonumber(bucket[1]) or capacity
-local lastRefill = tonumber(bucket[2]) or now
+-- Get current state from storage, new buckets start with the initial tokens and
+-- lastRefill = now, so they get no refill until the next call
+local storedTokens, storedLastRefill, createdAt = readBucket(key)
+local isNew = not storedTokens
+local tokens = tonumber(storedTokens) or initial
+local lastRefill = tonumber(storedLastRefill) or now
+local before = tokens
+if isNew and trackCreatedAt then
+    createdAt = now
+end
 
 -- Calculate elapsed time in seconds
 local elapsed = now - lastRefill
 
+-- When the refill owed is negligible and the bucket can cover the request without
+-- it, only record the consumption: lastRefill stays put so the refill is credited
+-- on a later call, and the TTL isn't refreshed
+if writeSkipThreshold > 0 and not isNew and elapsed * rate < writeSkipThreshold and tokens >= requested then
+    tokens = round(tokens - requested)
+    writeBucket(key, tokens, storedLastRefill, createdAt)
+    return {1, tostring(tokens), tostring(before), tostring(elapsed), '0'}
+end
+
 -- Refill tokens based on elapsed time and rate
+local refilled = 0
 if elapsed > 0 then
     local tokensToAdd = elapsed * rate
-    tokens = math.min(capacity, tokens + tokensToAdd)
+    refilled = math.min(capacity, tokens + tokensToAdd) - tokens
+    tokens = tokens + refilled
 end
 
--- Check if we can consume a token
+-- Check if we can consume the tokens, letting the bucket go as far as maxDebt below zero.
+-- A request costing more than the capacity can never be covered by refill, so even
+-- debt doesn't let it through.
 local allowed = 0
-if tokens >= requested then
+if requested <= capacity and tokens - requested >= -maxDebt then
     tokens = tokens - requested
     allowed = 1
 end
+tokens = round(tokens)
 
--- Update the bucket state atomically
-redis.call('HMSET', key, 'tokens', tokens, 'lastRefill', now)
-redis.call('EXPIRE', key, 3600) -- Expire after 1 hour of inactivity
+-- Update the bucket state atomically. lastRefill never moves backwards: a clock that
+-- went back refilled nothing, and rewinding lastRefill would credit that time twice.
+writeBucket(key, tokens, math.max(lastRefill, now), createdAt)
+-- Expire after the configured inactivity TTL
+if ttlUnit == 'ms' then
+    redis.call('PEXPIRE', key, ttl)
+else
+    redis.call('EXPIRE', key, ttl)
+end
 
-return {allowed, tokens}
+-- Return numbers as strings, Redis would truncate a Lua number to an integer.
+-- The tokens before the request, elapsed time and refill are used for tracing.
+return {allowed, tostring(tokens), tostring(before), tostring(elapsed), tostring(refilled)}
 `
 
+// bucketArgs returns the ARGV shared by the token bucket scripts. The time isn't
+// among them: scripts read it from the Redis server, see serverTimeLuaScript.
+func (rl *RateLimiter) bucketArgs(tokens float64) []interface{} {
+	ttl, ttlUnit := keyExpiry(rl.bucketTTL())
+	trackCreatedAt := "0"
+	if rl.trackCreatedAt {
+		trackCreatedAt = "1"
+	}
+	rate, capacity := rl.Limits()
+	return []interface{}{rate, capacity, tokens, rl.initialTokens, ttl, ttlUnit, trackCreatedAt, rl.writeSkipThreshold, rl.tokenPrecision, rl.maxDebt}
+}
+
+// bucketKeyNamespace starts the keys of token buckets, apart from the limiter's other keys
+const bucketKeyNamespace = "ratelimit:tb:"
+
+// validateKeyPrefix checks that prefix can't be mistaken for part of another prefix
+func validateKeyPrefix(prefix string) error {
+	if strings.Contains(prefix, ":") {
+		return fmt.Errorf("key prefix %q must not contain ':'", prefix)
+	}
+	return nil
+}
+
+// bucketKey returns the Redis key of the given userID's bucket,
+// "ratelimit:tb:<prefix>:<userID>" with an empty prefix without WithKeyPrefix
+func (rl *RateLimiter) bucketKey(userID string) string {
+	return bucketKeyNamespace + rl.keyPrefix + ":" + rl.manager.keyUserID(userID)
+}
+
+// keyTTLMargin is how long bucket keys outlive the refill of an empty bucket by default
+const keyTTLMargin = 10 * time.Second
+
+// bucketTTL returns the inactivity expiry of bucket keys: the TTL set with WithKeyTTL
+// or, by default, the time an empty bucket (or one in full debt) takes to refill
+// completely, in whole seconds, plus keyTTLMargin. By then the bucket is full and its
+// key redundant, as a missing bucket starts with the initial tokens; with
+// WithInitialTokens below the capacity, set a longer TTL to keep idle buckets full.
+// Limits changed with SetLimits apply from the next write of each key.
+func (rl *RateLimiter) bucketTTL() time.Duration {
+	if rl.keyTTL > 0 {
+		return rl.keyTTL
+	}
+	rate, capacity := rl.Limits()
+	if rate <= 0 {
+		return time.Hour
+	}
+	// Cap the refill so very slow rates can't overflow the duration
+	refill := math.Min(math.Ceil((capacity+rl.maxDebt)/rate), math.MaxInt32)
+	return time.Duration(refill)*time.Second + keyTTLMargin
+}
+
+// keyExpiry converts a key TTL into the value and unit passed to the scripts.
+// Whole seconds use EXPIRE ("s"); anything with a sub-second part uses PEXPIRE ("ms")
+// so that short TTLs aren't rounded down to 0, which would disable expiry. The
+// millisecond value is rounded up so it's never 0 either. ttl must be positive.
+func keyExpiry(ttl time.Duration) (int64, string) {
+	if ttl >= time.Second && ttl%time.Second == 0 {
+		return int64(ttl / time.Second), "s"
+	}
+	if ttl < time.Millisecond {
+		return 1, "ms"
+	}
+	millis := int64(ttl / time.Millisecond)
+	if ttl%time.Millisecond != 0 {
+		millis++
+	}
+	return millis, "ms"
+}
+
 // AllowResult contains the result of a rate limit check
 type AllowResult struct {
-	Allowed   bool
-	Remaining float64 // remaining tokens after the check
+	Allowed    bool
+	Remaining  float64       // remaining tokens after the check
+	RetryAfter time.Duration // wait until the request can succeed, 0 if allowed or if no wait lets it through
+	ResetAt    time.Time     // when the bucket is full again if nothing else is consumed, zero if unknown
+	Stale      bool          // approximated from a replica, must not be used for enforcement
+
+	// Refill of the check, for diagnosing clock skew and refill rates; zero when the
+	// reply doesn't carry it
+	RefilledTokens float64 // tokens added to the bucket since the previous check
+	Elapsed        float64 // seconds since the previous refill, negative if the clock went backwards
 }
 
 // Allow checks if a request from the given userID should be allowed
 // Returns AllowResult with allowed status and remaining tokens, and an error if something went wrong
 func (rl *RateLimiter) Allow(userID string) (*AllowResult, error) {
-	// Get the appropriate Redis shard for this userID
-	client := rl.manager.GetClient(userID)
+	return rl.AllowNCtx(ctx, userID, 1.0)
+}
+
+// AllowN checks if a request from the given userID costing the given number of tokens should be allowed,
+// e.g. 5 for a bulk export and 1 for a ping. tokens must be positive; a request costing more
+// than the capacity is always blocked.
+func (rl *RateLimiter) AllowN(userID string, tokens float64) (*AllowResult, error) {
+	return rl.AllowNCtx(ctx, userID, tokens)
+}
+
+// AllowCtx is like Allow but uses the caller's context for the Redis call,
+// so cancellations and deadlines abort the check
+func (rl *RateLimiter) AllowCtx(ctx context.Context, userID string) (*AllowResult, error) {
+	return rl.AllowNCtx(ctx, userID, 1.0)
+}
+
+// AllowNCtx is like AllowN but uses the caller's context for the Redis call
+func (rl *RateLimiter) AllowNCtx(ctx context.Context, userID string, tokens float64) (*AllowResult, error) {
+	if tokens <= 0 {
+		return nil, fmt.Errorf("requested tokens must be positive, got %v", tokens)
+	}
 
 	// Create a unique key for this user
-	key := fmt.Sprintf("ratelimit:%s", userID)
+	return rl.allowKey(ctx, userID, rl.bucketKey(userID), tokens)
+}
+
+// allowKey runs the token bucket script on the bucket at key, on the shard that owns userID
+func (rl *RateLimiter) allowKey(ctx context.Context, userID, key string, tokens float64) (*AllowResult, error) {
+	// Answer checks of a bucket known to be blocked without a round trip
+	if rl.blockedCache != nil {
+		if cached, ok := rl.blockedCache.get(key, tokens, time.Now()); ok {
+			rl.logDecision(userID, cached, nil)
+			return cached, nil
+		}
+	}
 
-	// Get current timestamp in seconds (with millisecond precision)
-	now := float64(time.Now().UnixNano()) / 1e9
+	// Get the appropriate Redis shard for this userID, on the fallback during a failover
+	manager := rl.checkManager()
+	client := manager.GetClient(userID)
+	if rl.lazyMigration && manager == rl.manager {
+		rl.migrateBucket(ctx, userID, key)
+	}
 
-	// Execute the Lua script atomically on the selected shard
-	script := redis.NewScript(tokenBucketLuaScript)
-	result, err := script.Run(ctx, client, []string{key}, rl.rate, rl.capacity, now, 1.0).Result()
+	// Execute the Lua script atomically on the selected shard, once a slot of its
+	// concurrency cap is free, failing at once while its circuit breaker is open
+	shard := manager.shardIndex(userID)
+	script := rl.bucketScript(tokenBucketLuaScript)
+	var result interface{}
+	err := manager.guardShard(shard, func() error {
+		var err error
+		result, err = rl.runScript(ctx, manager, shard, client, userID, script, []string{key}, rl.bucketArgs(tokens)...)
+		return err
+	})
+	if errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrShardBusy) {
+		log.Printf("WARNING: Rate limit check for userID %s not sent to Redis - %v", userID, err)
+		if errors.Is(err, ErrCircuitOpen) {
+			rl.recordCheck(manager, shard, err)
+		}
+		rl.logDecision(userID, nil, err)
+		return nil, err
+	}
+	rl.recordCheck(manager, shard, err)
 	if err != nil {
-		log.Printf("ERROR: Critical Redis Error: Lua script execution failure for userID %s - %v. Falling back to Fail-Open Policy.", userID, err)
+		log.Printf("ERROR: Critical Redis Error: Lua script execution failure for userID %s - %v", userID, err)
+		rl.logDecision(userID, nil, err)
 		return nil, fmt.Errorf("failed to execute rate limit script: %w", err)
 	}
 
-	// Parse the result (Lua script returns {allowed, tokens})
+	return rl.finishCheck(userID, key, tokens, result)
+}
+
+// finishCheck turns the token bucket script's reply to a check of the bucket at key
+// into its result, caching blocks and logging the decision
+func (rl *RateLimiter) finishCheck(userID, key string, tokens float64, result interface{}) (*AllowResult, error) {
+	allowResult, err := rl.parseAllowReply(userID, result)
+	if err != nil {
+		rl.logDecision(userID, nil, err)
+		return nil, err
+	}
+	allowResult.ResetAt = rl.resetAt(allowResult.Remaining, time.Now())
+	if !allowResult.Allowed {
+		// When blocked, remaining tokens are what we had before (we didn't consume)
+		allowResult.RetryAfter = rl.retryAfter(allowResult.Remaining, rl.debtRequirement(tokens), rl.Rate())
+		if rl.blockedCache != nil {
+			rl.blockedCache.add(key, tokens, allowResult, time.Now())
+		}
+	}
+	rl.checkClockAnomaly(userID, result)
+	if rl.isDebugUser(userID) {
+		rl.traceAllow(userID, tokens, allowResult, result)
+	}
+	rl.logDecision(userID, allowResult, nil)
+
+	return allowResult, nil
+}
+
+// parseAllowResult parses the {allowed, tokens} reply of the token bucket script,
+// along with the elapsed time and refill of replies carrying them
+func parseAllowResult(result interface{}) (*AllowResult, error) {
 	resultArray, ok := result.([]interface{})
 	if !ok || len(resultArray) < 2 {
 		return nil, fmt.Errorf("unexpected result format from Lua script")
 	}
 
-	// Parse allowed status (can be int64 or float64)
-	var allowed int64
-	switch v := resultArray[0].(type) {
+	// Parse allowed status
+	allowed, err := parseLuaNumber(resultArray[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse allowed status: %w", err)
+	}
+
+	// Parse remaining tokens
+	remaining, err := parseLuaNumber(resultArray[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse remaining tokens: %w", err)
+	}
+
+	allowResult := &AllowResult{
+		Allowed:   allowed == 1,
+		Remaining: remaining,
+	}
+
+	// Parse the refill, replies of {allowed, tokens, before, elapsed, refilled}
+	if len(resultArray) >= 5 {
+		if allowResult.Elapsed, err = parseLuaNumber(resultArray[3]); err != nil {
+			return nil, fmt.Errorf("failed to parse elapsed time: %w", err)
+		}
+		if allowResult.RefilledTokens, err = parseLuaNumber(resultArray[4]); err != nil {
+			return nil, fmt.Errorf("failed to parse refilled tokens: %w", err)
+		}
+	}
+	return allowResult, nil
+}
+
+// parseLuaNumber converts a number returned by a Lua script into a float64. Depending
+// on the script, the Redis version and the protocol (RESP2/RESP3), go-redis surfaces
+// numbers as int64, float64 or a string (e.g. from tostring), so all three are accepted.
+func parseLuaNumber(v interface{}) (float64, error) {
+	switch n := v.(type) {
 	case int64:
-		allowed = v
+		return float64(n), nil
 	case float64:
-		allowed = int64(v)
+		return n, nil
+	case string:
+		parsed, err := strconv.ParseFloat(n, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid numeric string %q: %w", n, err)
+		}
+		return parsed, nil
 	default:
-		return nil, fmt.Errorf("failed to parse allowed status: unexpected type")
+		return 0, fmt.Errorf("unexpected type %T", v)
 	}
+}
 
-	// Parse remaining tokens (can be int64 or float64)
-	var remaining float64
-	switch v := resultArray[1].(type) {
-	case int64:
-		remaining = float64(v)
-	case float64:
-		remaining = v
+// tokenRefundLuaScript is the Lua script for atomically returning tokens to a bucket
+const tokenRefundLuaScript = `
+local key = KEYS[1]
+local rate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local now = serverTime()
+local refunded = tonumber(ARGV[3])
+local initial = tonumber(ARGV[4])
+local ttl = tonumber(ARGV[5])
+local ttlUnit = ARGV[6]
+local trackCreatedAt = ARGV[7] == '1'
+local precision = tonumber(ARGV[9])
+
+-- Get current state from storage, new buckets start with the initial tokens
+local storedTokens, storedLastRefill, createdAt = readBucket(key)
+local tokens = tonumber(storedTokens) or initial
+local lastRefill = tonumber(storedLastRefill) or now
+if not storedTokens and trackCreatedAt then
+    createdAt = now
+end
+
+-- Apply the refill owed since the last update before adding the refund
+local elapsed = now - lastRefill
+if elapsed > 0 then
+    tokens = math.min(capacity, tokens + elapsed * rate)
+end
+
+-- Return the tokens without exceeding capacity, rounded as in tokenBucketLuaScript
+tokens = math.min(capacity, tokens + refunded)
+if precision >= 0 then
+    local scale = 10 ^ precision
+    tokens = math.floor(tokens * scale + 0.5) / scale
+end
+
+writeBucket(key, tokens, math.max(lastRefill, now), createdAt)
+-- Expire after the configured inactivity TTL
+if ttlUnit == 'ms' then
+    redis.call('PEXPIRE', key, ttl)
+else
+    redis.call('EXPIRE', key, ttl)
+end
+
+return tostring(tokens)
+`
+
+// Refund returns previously consumed tokens to the given userID's bucket, capped at capacity.
+// The refill owed up to now is applied before the refund and lastRefill moves to now, so
+// an immediate retry sees the restored balance without being credited that refill twice.
+func (rl *RateLimiter) Refund(userID string, tokens float64) error {
+	key := rl.bucketKey(userID)
+	if rl.blockedCache != nil {
+		rl.blockedCache.forget(key)
+	}
+	script := rl.bucketScript(tokenRefundLuaScript)
+	result, err := rl.manager.runOnShard(ctx, userID, script, []string{key}, rl.bucketArgs(tokens)...).Result()
+	if err != nil {
+		log.Printf("ERROR: Critical Redis Error: Lua refund script execution failure for userID %s - %v", userID, err)
+		return fmt.Errorf("failed to execute refund script: %w", err)
+	}
+	if rl.isDebugUser(userID) {
+		rl.traceRefund(userID, tokens, result)
+	}
+
+	return nil
+}
+
+// initRedisShardManager connects to the given shard addresses, or to those of the
+// environment when there are none
+func initRedisShardManager(addresses []string) *RedisShardManager {
+	if len(addresses) == 0 {
+		addresses = redisAddressesFromEnv()
+	}
+
+	// Report every malformed entry at once before connecting to anything
+	if errs := ValidateAddresses(addresses); len(errs) > 0 {
+		panic(fmt.Sprintf("Invalid Redis address configuration: %v", errors.Join(errs...)))
+	}
+
+	// Credentials and database of the shards not configured with a URL
+	db := 0
+	if dbEnv := os.Getenv("REDIS_DB"); dbEnv != "" {
+		var err error
+		if db, err = strconv.Atoi(dbEnv); err != nil || db < 0 {
+			panic(fmt.Sprintf("Invalid REDIS_DB %q, expected a non-negative database number", dbEnv))
+		}
+	}
+
+	tlsConfig, err := TLSConfigFromEnv()
+	if err != nil {
+		panic(fmt.Sprintf("Invalid Redis TLS configuration: %v", err))
+	}
+
+	auth := ShardAuth{
+		Password:  os.Getenv("REDIS_PASSWORD"),
+		DB:        db,
+		TLSConfig: tlsConfig,
+	}
+
+	// In cluster mode the addresses are seed nodes and the cluster places the keys
+	var manager *RedisShardManager
+	switch mode := os.Getenv("REDIS_MODE"); mode {
+	case "", "shard":
+		if manager, err = NewRedisShardManagerWithAuth(addresses, auth); err != nil {
+			panic(fmt.Sprintf("Failed to initialize Redis shard manager: %v", err))
+		}
+	case "cluster":
+		if manager, err = NewRedisClusterManagerWithAuth(addresses, auth); err != nil {
+			panic(fmt.Sprintf("Failed to initialize Redis Cluster manager: %v", err))
+		}
 	default:
-		return nil, fmt.Errorf("failed to parse remaining tokens: unexpected type")
+		panic(fmt.Sprintf("Invalid REDIS_MODE %q, expected shard or cluster", mode))
 	}
+	manager.SetHashSeed(os.Getenv("REDIS_HASH_SEED"))
 
-	return &AllowResult{
-		Allowed:   allowed == 1,
-		Remaining: remaining,
-	}, nil
+	// Optional circuit breaker, opening after the given number of consecutive failures
+	if failuresEnv := os.Getenv("REDIS_CIRCUIT_BREAKER"); failuresEnv != "" {
+		failures, err := strconv.Atoi(failuresEnv)
+		if err != nil || failures < 0 {
+			panic(fmt.Sprintf("Invalid REDIS_CIRCUIT_BREAKER %q, expected a non-negative number of failures", failuresEnv))
+		}
+		manager.SetCircuitBreaker(CircuitBreakerConfig{Failures: failures})
+	}
+
+	// Optional read replicas, one comma-separated entry per shard (empty for none)
+	if replicaAddrsEnv := os.Getenv("REDIS_REPLICA_ADDRS"); replicaAddrsEnv != "" {
+		replicaAddrs := strings.Split(replicaAddrsEnv, ",")
+		for i := range replicaAddrs {
+			replicaAddrs[i] = strings.TrimSpace(replicaAddrs[i])
+		}
+		if err := manager.AttachReplicas(replicaAddrs); err != nil {
+			panic(fmt.Sprintf("Failed to attach Redis replicas: %v", err))
+		}
+	}
+
+	return manager
 }
 
-func initRedisShardManager() *RedisShardManager {
+// redisAddressesFromEnv returns the comma-separated shard addresses of REDIS_ADDRS,
+// or the single REDIS_ADDR, defaulting to a local Redis instance
+func redisAddressesFromEnv() []string {
 	// Get Redis addresses from environment variable (comma-separated)
 	// Default to single Redis instance for backward compatibility
 	redisAddrsEnv := os.Getenv("REDIS_ADDRS")
@@ -206,76 +1038,244 @@ func initRedisShardManager() *RedisShardManager {
 	if len(addresses) == 0 {
 		addresses = []string{"localhost:6379"}
 	}
+	return addresses
+}
 
-	manager, err := NewRedisShardManager(addresses)
-	if err != nil {
-		panic(fmt.Sprintf("Failed to initialize Redis shard manager: %v", err))
-	}
+// RateLimitExceededLocal is the Fiber local set to true on requests that exceeded the
+// limit but were let through by WithSoftLimit
+const RateLimitExceededLocal = "ratelimit_exceeded"
 
-	return manager
-}
+// RateLimitMiddleware creates a Fiber middleware that applies rate limiting. Any
+// Limiter can be enforced; the features keeping their state next to the buckets of a
+// *RateLimiter (maintenance windows, courtesy requests, block webhooks, tarpitting,
+// violation grace and panic refunds) are skipped for other limiters, and the limit
+// header is only sent by limiters with a Capacity method.
+func RateLimitMiddleware(limiter Limiter, opts ...Option) fiber.Handler {
+	options := newMiddlewareOptions(opts)
+	rl, _ := limiter.(*RateLimiter)
+
+	return func(c *fiber.Ctx) (err error) {
+		// Turn panics of the limiter or the handlers behind it into a 500, refunding the
+		// tokens charged for the request if configured
+		var userID string
+		var charged float64
+		defer func() {
+			if r := recover(); r != nil {
+				err = options.recoverPanic(c, rl, userID, charged, r)
+			}
+		}()
+
+		// Extract client identifier (IP address by default)
+		userID = options.key(c, options.KeyFunc)
+		if userID == "" {
+			log.Printf("INFO: Decision: REJECTED (401) - Reason: Missing client identity")
+			return c.Status(fiber.StatusUnauthorized).JSON(missingIdentityBody)
+		}
 
-// RateLimitMiddleware creates a Fiber middleware that applies rate limiting
-func RateLimitMiddleware(limiter *RateLimiter) fiber.Handler {
-	return func(c *fiber.Ctx) error {
-		// Extract client identifier (IP address)
-		userID := c.IP()
+		// Let everything through while enforcement is disabled for maintenance
+		if rl != nil && !rl.EnforcementEnabled(c.UserContext()) {
+			log.Printf("INFO: Decision: BYPASSED - userID: %s, Reason: Enforcement disabled", userID)
+			return limiterBypassed(c, options.Headers, BypassMaintenance)
+		}
+
+		// Compute the request's token cost
+		cost := 1.0
+		if options.CostFunc != nil {
+			var err error
+			cost, err = options.CostFunc(c)
+			if err == nil && cost <= 0 {
+				err = fmt.Errorf("cost must be positive, got %v", cost)
+			}
+			if err != nil {
+				log.Printf("INFO: Decision: REJECTED (400) - userID: %s, Reason: Invalid request cost - %v", userID, err)
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error":   "Invalid request cost",
+					"message": err.Error(),
+				})
+			}
+		}
 
-		// Check rate limit
-		result, err := limiter.Allow(userID)
+		// Check rate limit, propagating the request context to Redis
+		check := func() (*AllowResult, error) {
+			start := time.Now()
+			defer func() { allowDuration.Observe(time.Since(start).Seconds()) }()
+			return allowN(c.UserContext(), limiter, userID, cost)
+		}
+		result, err := check()
+		if err != nil && options.FailoverHold != nil {
+			result, err = options.FailoverHold.hold(c.UserContext(), userID, err, check)
+		}
+		recordDecision(c.Route().Path, result, err)
 		if err != nil {
-			// On error, allow the request but log the error (fail-open policy)
-			log.Printf("ERROR: Critical Redis Error: Rate limiter execution failure for userID %s - %v. Falling back to Fail-Open Policy.", userID, err)
-			return c.Next()
+			// On error, apply the configured failure mode for this kind of error
+			mode := options.requestFailureMode(c, err)
+			log.Printf("ERROR: Critical Redis Error: Rate limiter execution failure for userID %s - %v. Falling back to %s Policy.", userID, err, mode)
+			if mode == FailClosed {
+				return limiterUnavailable(c)
+			}
+			return limiterBypassed(c, options.Headers, BypassRedisError)
 		}
 
 		// Set rate limit headers
-		limit := limiter.capacity
+		limit, headers := options.limitHeaders(limiter, result, cost)
+		for _, h := range headers {
+			options.Headers.set(c, h.name, h.value)
+		}
 		remaining := result.Remaining
-		c.Set("X-RateLimit-Limit", fmt.Sprintf("%.0f", limit))
-		c.Set("X-RateLimit-Remaining", fmt.Sprintf("%.0f", remaining))
 
 		if !result.Allowed {
+			// Let eligible users at exactly 0 remaining through once per window
+			if rl != nil && options.Courtesy != nil && formatRemaining(remaining, options.RemainingRounding) == "0" && options.Courtesy(c, userID) {
+				granted, err := rl.UseCourtesy(c.UserContext(), userID, options.courtesyWindow(rl))
+				if err != nil {
+					log.Printf("WARNING: Failed to record courtesy request for userID %s - %v", userID, err)
+				} else if granted {
+					log.Printf("INFO: Decision: COURTESY - userID: %s, Reason: Last request before rate limiting", userID)
+					options.Headers.set(c, options.Headers.Warning, LastRequestWarning)
+					return c.Next()
+				}
+			}
+
+			// Let the first violations of the window through with a warning, before
+			// anything treats the request as blocked
+			if rl != nil && options.ViolationGrace > 0 {
+				violations, err := rl.AddViolation(c.UserContext(), userID, options.violationWindow(rl))
+				if err != nil {
+					log.Printf("WARNING: Failed to record violation for userID %s - %v", userID, err)
+				} else if violations <= int64(options.ViolationGrace) {
+					log.Printf("INFO: Decision: GRACE - userID: %s, Reason: Rate limit exceeded, Violation: %d of %d", userID, violations, options.ViolationGrace)
+					options.Headers.set(c, options.Headers.Warning, fmt.Sprintf("rate limit exceeded, %d of %d grace requests used", violations, options.ViolationGrace))
+					return c.Next()
+				}
+			}
+
 			// Calculate retry-after time in seconds
-			// When blocked, remaining tokens are what we had before (we didn't consume)
-			// We need (1 - remaining) tokens to be refilled
-			// At rate tokens/sec, we need (1 - remaining) / rate seconds
-			tokensNeeded := 1.0 - result.Remaining
-			if tokensNeeded < 0 {
-				tokensNeeded = 1.0
+			retryAfter := retryAfterHeaderSeconds(options.retryAfter(result))
+
+			options.Headers.set(c, options.Headers.RetryAfter, fmt.Sprintf("%d", retryAfter))
+
+			// Report the block to the external sink in the background
+			if rl != nil && options.BlockWebhook != nil {
+				options.BlockWebhook.notify(rl, userID)
 			}
-			retryAfterSeconds := tokensNeeded / limiter.rate
-			// Round up to at least 1 second for practical purposes
-			if retryAfterSeconds < 1.0 {
-				retryAfterSeconds = 1.0
+
+			// Record the penalty that grows the tarpit delay of repeat offenders
+			if rl != nil && options.Tarpit != nil {
+				if _, err := rl.AddPenalty(c.UserContext(), userID, options.Tarpit.Window); err != nil {
+					log.Printf("WARNING: Failed to record penalty for userID %s - %v", userID, err)
+				}
 			}
-			retryAfter := int(retryAfterSeconds)
 
-			c.Set("X-RateLimit-Retry-After", fmt.Sprintf("%d", retryAfter))
+			// In soft limit mode, flag the request and let the handler decide how to degrade
+			if options.SoftLimit {
+				log.Printf("INFO: Decision: SOFT-BLOCKED - userID: %s, Reason: Rate limit exceeded, Retry-After: %d seconds", userID, retryAfter)
+				c.Locals(RateLimitExceededLocal, true)
+				return c.Next()
+			}
 
 			// Log blocked request with structured information
 			log.Printf("INFO: Decision: BLOCKED (429) - userID: %s, Reason: Rate limit exceeded, Retry-After: %d seconds", userID, retryAfter)
 
-			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
-				"error":   "Rate limit exceeded",
-				"message": "Too many requests. Please try again later.",
-			})
+			return c.Status(fiber.StatusTooManyRequests).JSON(rateLimitExceededBody)
 		}
 
 		// Log allowed request with structured information
 		log.Printf("INFO: Decision: ALLOWED - userID: %s, Remaining: %.2f, Limit: %.0f", userID, remaining, limit)
 
+		// Delay repeat offenders before handing the request on
+		if rl != nil && options.Tarpit != nil {
+			penalties, err := rl.Penalties(c.UserContext(), userID)
+			if err != nil {
+				log.Printf("WARNING: Failed to read penalties for userID %s - %v", userID, err)
+			} else if delay := options.Tarpit.delay(penalties); delay > 0 {
+				log.Printf("INFO: Tarpit: delaying userID %s by %v for %d penalties", userID, delay, penalties)
+				if err := tarpitWait(c.UserContext(), delay); err != nil {
+					// The caller gave up on the request, don't hand it on
+					log.Printf("INFO: Tarpit: delay of userID %s cut short - %v", userID, err)
+					return limiterUnavailable(c)
+				}
+			}
+		}
+
 		// Request allowed, proceed to next handler
+		charged = cost
 		return c.Next()
 	}
 }
 
+// servedRoutes are the rate limited paths of the service, which config routes can override
+var servedRoutes = map[string]struct{}{
+	"/api/resource": {},
+	"/api/search":   {},
+	"/api/upload":   {},
+}
+
 func main() {
-	// Initialize Redis shard manager
-	shardManager := initRedisShardManager()
+	// Load the limits, from the file named by CONFIG_PATH if set
+	cfg := DefaultConfig()
+	if path := os.Getenv("CONFIG_PATH"); path != "" {
+		var err error
+		if cfg, err = LoadConfig(path); err != nil {
+			panic(fmt.Sprintf("Failed to load config: %v", err))
+		}
+	}
+
+	// Keep buckets in process memory instead of Redis for local development
+	memory := os.Getenv("REDIS_ADDRS") == MemoryAddress && len(cfg.RedisAddrs) == 0
+	var shardManager *RedisShardManager
+	var managers []*RedisShardManager
+	var memoryLimiter *InMemoryLimiter
+	var decisionLog *DecisionLog
+	if memory {
+		memoryLimiter = NewInMemoryLimiter(cfg.Rate, cfg.Capacity)
+		log.Printf("WARNING: REDIS_ADDRS=%s keeps rate limits in process memory, they are neither shared nor persisted", MemoryAddress)
+	} else {
+		// Initialize Redis shard manager, every manager is closed on shutdown
+		shardManager = initRedisShardManager(cfg.RedisAddrs)
+		managers = []*RedisShardManager{shardManager}
+
+		// Initialize Rate Limiter with the default limit, 5 req/sec and capacity of 10 unless configured
+		var limiterOpts []LimiterOption
+		if debugUsers := os.Getenv("DEBUG_USERS"); debugUsers != "" {
+			limiterOpts = append(limiterOpts, WithDebugUsers(strings.Split(debugUsers, ",")...))
+		}
+		if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint != "" {
+			decisionLog = NewDecisionLog(NewOTLPLogExporter(endpoint), DecisionLogConfig{})
+			limiterOpts = append(limiterOpts, WithDecisionLog(decisionLog))
+		}
+		if fallbackAddrs := os.Getenv("REDIS_FALLBACK_ADDRS"); fallbackAddrs != "" {
+			addresses := strings.Split(fallbackAddrs, ",")
+			for i := range addresses {
+				addresses[i] = strings.TrimSpace(addresses[i])
+			}
+			fallback, err := NewRedisShardManager(addresses)
+			if err != nil {
+				panic(fmt.Sprintf("Failed to initialize fallback Redis shard manager: %v", err))
+			}
+			managers = append(managers, fallback)
+			limiterOpts = append(limiterOpts, WithFallbackManager(fallback, 5, 0))
+		}
+		rateLimiter = NewRateLimiter(shardManager, cfg.Rate, cfg.Capacity, limiterOpts...)
 
-	// Initialize Rate Limiter with 5 req/sec rate and capacity of 10
-	rateLimiter = NewRateLimiter(shardManager, 5.0, 10.0)
+		// Preload the Lua scripts, failing fast if a shard can't be used
+		if err := rateLimiter.Warmup(ctx); err != nil {
+			panic(fmt.Sprintf("Failed to warm up Redis shards: %v", err))
+		}
+
+		// Optionally reset all buckets on a cron schedule, e.g. "0 0 * * *" for midnight
+		if spec := os.Getenv("RESET_SCHEDULE"); spec != "" {
+			loc := time.Local
+			if tz := os.Getenv("RESET_TIMEZONE"); tz != "" {
+				var err error
+				if loc, err = time.LoadLocation(tz); err != nil {
+					panic(fmt.Sprintf("Invalid RESET_TIMEZONE: %v", err))
+				}
+			}
+			if err := rateLimiter.ScheduleReset(ctx, spec, loc, "*"); err != nil {
+				panic(fmt.Sprintf("Invalid RESET_SCHEDULE: %v", err))
+			}
+		}
+	}
 
 	// Create Fiber app
 	app := fiber.New(fiber.Config{
@@ -284,12 +1284,42 @@ func main() {
 
 	// Health check endpoint
 	app.Get("/health", func(c *fiber.Ctx) error {
+		redisStatus := MemoryAddress
+		if rateLimiter != nil {
+			redisStatus = rateLimiter.FallbackStatus().Serving
+		}
 		return c.JSON(fiber.Map{
 			"status":  "ok",
 			"service": "velocity-rate-limiter",
+			"redis":   redisStatus,
 		})
 	})
 
+	// Redis readiness endpoint, requiring REDIS_HEALTH_QUORUM shards (default all) to respond
+	if shardManager != nil {
+		quorum := 0
+		if quorumEnv := os.Getenv("REDIS_HEALTH_QUORUM"); quorumEnv != "" {
+			var err error
+			if quorum, err = strconv.Atoi(quorumEnv); err != nil || quorum < 0 {
+				panic(fmt.Sprintf("Invalid REDIS_HEALTH_QUORUM %q, expected a non-negative number of shards", quorumEnv))
+			}
+		}
+		app.Get("/health/redis", RedisHealthHandler(shardManager, quorum))
+	}
+
+	// Metrics endpoint
+	app.Get("/metrics", MetricsHandler(managers...))
+
+	// Runtime limit changes, only enabled when admin tokens are configured
+	if spec := os.Getenv("ADMIN_TOKENS"); spec != "" && !memory {
+		tokens, err := ParseAdminTokens(spec)
+		if err != nil {
+			panic(fmt.Sprintf("Invalid ADMIN_TOKENS: %v", err))
+		}
+		app.Post("/admin/limits", AdminAuth(tokens), AdminLimitsHandler(rateLimiter))
+		app.Post("/admin/enforcement", AdminAuth(tokens), AdminEnforcementHandler(rateLimiter))
+	}
+
 	// Basic root endpoint
 	app.Get("/", func(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{
@@ -297,23 +1327,87 @@ func main() {
 		})
 	})
 
+	// Optionally report blocked users to an external sink
+	var middlewareOpts []Option
+	if url := os.Getenv("BLOCK_WEBHOOK_URL"); url != "" {
+		middlewareOpts = append(middlewareOpts, WithBlockWebhook(NewBlockWebhook(WebhookConfig{URL: url})))
+	}
+
+	// Routes overridden in the config get their own limiter, the others share the default one
+	routeLimit := func(path string) fiber.Handler {
+		if memory {
+			if route, ok := cfg.Route(path); ok {
+				return RateLimitMiddleware(NewInMemoryLimiter(route.Rate, route.Capacity), middlewareOpts...)
+			}
+			return RateLimitMiddleware(memoryLimiter, middlewareOpts...)
+		}
+		if route, ok := cfg.Route(path); ok {
+			return RateLimitWithConfig(shardManager, route, middlewareOpts...)
+		}
+		return RateLimitMiddleware(rateLimiter, middlewareOpts...)
+	}
+	for _, route := range cfg.Routes {
+		if _, ok := servedRoutes[route.Path]; !ok {
+			log.Printf("WARNING: Config route %s is not served, its limit is unused", route.Path)
+		}
+	}
+
 	// Rate limited endpoint with middleware
-	app.Get("/api/resource", RateLimitMiddleware(rateLimiter), func(c *fiber.Ctx) error {
+	app.Get("/api/resource", routeLimit("/api/resource"), func(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{
 			"message": "Resource accessed successfully",
 			"data":    "This is a protected resource",
 		})
 	})
 
+	// Routes with their own limits and independent buckets by default
+	app.Get("/api/search", routeLimit("/api/search"), func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{
+			"message": "Search completed successfully",
+		})
+	})
+	app.Post("/api/upload", routeLimit("/api/upload"), func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{
+			"message": "Upload accepted",
+		})
+	})
+
 	// Start server on port 3000
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "3000"
 	}
 
+	// Stop accepting requests on SIGINT/SIGTERM, then flush the decision log and
+	// close the Redis connections
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-signals
+		log.Printf("INFO: Received %v, shutting down", sig)
+
+		if err := app.ShutdownWithTimeout(shutdownTimeout); err != nil {
+			log.Printf("WARNING: Server shutdown did not complete - %v", err)
+		}
+		if decisionLog != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			if err := decisionLog.Close(ctx); err != nil {
+				log.Printf("WARNING: Decision records lost on shutdown - %v", err)
+			}
+			cancel()
+		}
+		for _, manager := range managers {
+			if err := manager.Close(); err != nil {
+				log.Printf("WARNING: Failed to close Redis connections - %v", err)
+			}
+		}
+	}()
+
 	fmt.Printf("Server starting on port %s\n", port)
 	if err := app.Listen(":" + port); err != nil {
 		panic(fmt.Sprintf("Failed to start server: %v", err))
 	}
+	<-shutdownDone
 }
-
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// MemoryAddress is the REDIS_ADDRS value keeping buckets in process memory instead
+// of Redis, for local development
+const MemoryAddress = "memory"
+
+// memorySweepInterval is the minimum time between two evictions of idle buckets
+const memorySweepInterval = time.Minute
+
+// memoryBucket is the state of one user's bucket in an InMemoryLimiter
+type memoryBucket struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+// InMemoryLimiter is a token bucket limiter keeping its buckets in process memory,
+// with the refill math of the Redis script. Buckets aren't shared between instances
+// and are lost on restart, so it's meant for local development and tests of handlers
+// without a Redis server. Buckets full again are evicted at most once per minute,
+// during a check, which bounds memory to the users active within the time it takes
+// to refill a bucket.
+type InMemoryLimiter struct {
+	rate     float64 // tokens per second
+	capacity float64 // maximum bucket capacity
+
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+// NewInMemoryLimiter creates an in-memory limiter with the given rate (tokens per
+// second) and capacity
+func NewInMemoryLimiter(rate, capacity float64) *InMemoryLimiter {
+	return &InMemoryLimiter{
+		rate:      rate,
+		capacity:  capacity,
+		buckets:   make(map[string]*memoryBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Capacity returns the bucket capacity
+func (ml *InMemoryLimiter) Capacity() float64 {
+	return ml.capacity
+}
+
+// Rate returns the rate in tokens per second
+func (ml *InMemoryLimiter) Rate() float64 {
+	return ml.rate
+}
+
+// Allow checks if a request from the given userID should be allowed
+func (ml *InMemoryLimiter) Allow(userID string) (*AllowResult, error) {
+	return ml.AllowN(userID, 1)
+}
+
+// AllowN checks if a request from the given userID costing the given number of
+// tokens should be allowed. tokens must be positive; a request costing more than the
+// capacity is always blocked.
+func (ml *InMemoryLimiter) AllowN(userID string, tokens float64) (*AllowResult, error) {
+	if tokens <= 0 {
+		return nil, fmt.Errorf("requested tokens must be positive, got %v", tokens)
+	}
+
+	now := time.Now()
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	ml.sweep(now)
+
+	// New buckets start full
+	bucket, ok := ml.buckets[userID]
+	if !ok {
+		bucket = &memoryBucket{tokens: ml.capacity, lastRefill: now}
+		ml.buckets[userID] = bucket
+	}
+
+	// Refill tokens based on elapsed time and rate; a clock going backwards refills
+	// nothing and leaves lastRefill in place
+	elapsed := now.Sub(bucket.lastRefill).Seconds()
+	refilled := 0.0
+	if elapsed > 0 {
+		refilled = math.Min(ml.capacity, bucket.tokens+elapsed*ml.rate) - bucket.tokens
+		bucket.tokens += refilled
+		bucket.lastRefill = now
+	}
+
+	result := &AllowResult{Remaining: bucket.tokens, RefilledTokens: refilled, Elapsed: elapsed}
+	if tokens <= ml.capacity && bucket.tokens >= tokens {
+		bucket.tokens -= tokens
+		result.Allowed = true
+		result.Remaining = bucket.tokens
+	} else {
+		result.RetryAfter = DefaultRetryAfter(bucket.tokens, tokens, ml.rate)
+	}
+	result.ResetAt = now.Add(timeToFull(result.Remaining, ml.capacity, ml.rate))
+	return result, nil
+}
+
+// sweep evicts the buckets that are full again, at most once per memorySweepInterval.
+// A full bucket behaves like a missing one, so eviction doesn't change any decision.
+func (ml *InMemoryLimiter) sweep(now time.Time) {
+	if now.Sub(ml.lastSweep) < memorySweepInterval {
+		return
+	}
+	ml.lastSweep = now
+	for userID, bucket := range ml.buckets {
+		if now.Sub(bucket.lastRefill) >= timeToFull(bucket.tokens, ml.capacity, ml.rate) {
+			delete(ml.buckets, userID)
+		}
+	}
+}
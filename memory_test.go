@@ -0,0 +1,139 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestInMemoryConcurrency mirrors TestRateLimitConcurrency: exactly the capacity is
+// allowed out of 100 concurrent requests
+func TestInMemoryConcurrency(t *testing.T) {
+	var _ Limiter = (*InMemoryLimiter)(nil)
+	limiter := NewInMemoryLimiter(1000.0, 10.0)
+
+	var allowedCount int64
+	var wg sync.WaitGroup
+	wg.Add(100)
+	for i := 0; i < 100; i++ {
+		go func() {
+			defer wg.Done()
+			result, err := limiter.Allow("test_user_concurrent")
+			if err != nil {
+				t.Errorf("Error calling Allow: %v", err)
+				return
+			}
+			if result.Allowed {
+				atomic.AddInt64(&allowedCount, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// At 1000 tokens per second the slowest goroutines may see a token or two refilled
+	if count := atomic.LoadInt64(&allowedCount); count < 10 || count > 12 {
+		t.Errorf("Expected about 10 allowed requests, but got %d", count)
+	}
+}
+
+// TestInMemoryRefill mirrors TestRateLimitRefill: an empty bucket refills at the rate
+func TestInMemoryRefill(t *testing.T) {
+	limiter := NewInMemoryLimiter(5.0, 10.0)
+	userID := "test_user_refill"
+
+	for i := 0; i < 10; i++ {
+		if result, err := limiter.Allow(userID); err != nil || !result.Allowed {
+			t.Fatalf("Request %d should have been allowed, got %+v (%v)", i+1, result, err)
+		}
+	}
+	result, err := limiter.Allow(userID)
+	if err != nil {
+		t.Fatalf("Error calling Allow: %v", err)
+	}
+	if result.Allowed || result.RetryAfter <= 0 || result.RetryAfter > 200*time.Millisecond {
+		t.Fatalf("Expected a blocked request waiting up to 200ms, got %+v", result)
+	}
+
+	// 1 second refills 5 tokens at 5 req/sec
+	time.Sleep(time.Second)
+	for i := 0; i < 5; i++ {
+		if result, err := limiter.Allow(userID); err != nil || !result.Allowed {
+			t.Errorf("Request %d should have been allowed after refill, got %+v (%v)", i+1, result, err)
+		}
+	}
+	if result, err := limiter.Allow(userID); err != nil || result.Allowed {
+		t.Errorf("Request should have been blocked after consuming the refilled tokens, got %+v (%v)", result, err)
+	}
+}
+
+// TestInMemoryAllowN tests costs, invalid costs and costs above the capacity
+func TestInMemoryAllowN(t *testing.T) {
+	limiter := NewInMemoryLimiter(0.001, 10.0)
+
+	if result, err := limiter.AllowN("test_user_cost", 4); err != nil || !result.Allowed || result.Remaining != 6 {
+		t.Errorf("Expected 4 tokens to leave 6, got %+v (%v)", result, err)
+	}
+	if result, err := limiter.AllowN("test_user_cost", 11); err != nil || result.Allowed {
+		t.Errorf("Expected a cost above the capacity to be blocked, got %+v (%v)", result, err)
+	}
+	if _, err := limiter.AllowN("test_user_cost", 0); err == nil {
+		t.Error("Expected an error for a cost of 0")
+	}
+}
+
+// TestInMemorySweep tests that only buckets full again are evicted, once per interval
+func TestInMemorySweep(t *testing.T) {
+	limiter := NewInMemoryLimiter(10.0, 10.0)
+	now := limiter.lastSweep.Add(memorySweepInterval)
+	limiter.buckets["test_user_idle"] = &memoryBucket{tokens: 0, lastRefill: now.Add(-2 * time.Second)}
+	limiter.buckets["test_user_busy"] = &memoryBucket{tokens: 5, lastRefill: now.Add(-100 * time.Millisecond)}
+
+	limiter.sweep(now.Add(-time.Second))
+	if len(limiter.buckets) != 2 {
+		t.Fatalf("Expected no sweep before the interval, got %d buckets", len(limiter.buckets))
+	}
+
+	// The idle bucket refilled 20 tokens since, the busy one only 1
+	limiter.sweep(now)
+	if _, ok := limiter.buckets["test_user_idle"]; ok {
+		t.Error("Expected the idle bucket to be evicted")
+	}
+	if _, ok := limiter.buckets["test_user_busy"]; !ok {
+		t.Error("Expected the busy bucket to be kept")
+	}
+}
+
+// TestInMemoryRefilledTokens tests that results report the refill since the previous check
+func TestInMemoryRefilledTokens(t *testing.T) {
+	limiter := NewInMemoryLimiter(10.0, 10.0)
+	limiter.AllowN("test_user_refilled", 10)
+	time.Sleep(100 * time.Millisecond)
+
+	result, _ := limiter.Allow("test_user_refilled")
+	if result.Elapsed < 0.1 || math.Abs(result.RefilledTokens-10*result.Elapsed) > 1e-9 {
+		t.Errorf("Expected a refill of rate * elapsed, got %+v", result)
+	}
+}
+
+// TestInMemoryResetAt tests that the reset time moves later as tokens are consumed,
+// including for the last token and blocked checks
+func TestInMemoryResetAt(t *testing.T) {
+	limiter := NewInMemoryLimiter(2.0, 4.0)
+
+	var previous time.Time
+	for i := 1; i <= 5; i++ {
+		start := time.Now()
+		result, _ := limiter.Allow("test_user_reset_at")
+		// The bucket misses i tokens, at most 4, refilled at 2 per second
+		missing := time.Duration(min(i, 4)) * 500 * time.Millisecond
+		if result.ResetAt.Before(start.Add(missing-10*time.Millisecond)) || result.ResetAt.After(time.Now().Add(missing)) {
+			t.Errorf("Check %d: expected a reset %v from now, got %v", i, missing, result.ResetAt.Sub(start))
+		}
+		if i <= 4 && !result.ResetAt.After(previous) {
+			t.Errorf("Check %d: expected the reset to move later than %v, got %v", i, previous, result.ResetAt)
+		}
+		previous = result.ResetAt
+	}
+}
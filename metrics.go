@@ -0,0 +1,68 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// allowDurationBuckets are the upper bounds, in seconds, of the check duration histogram
+var allowDurationBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+var (
+	// requestsAllowed counts the allowed decisions of the middlewares by route
+	requestsAllowed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "velocity_requests_allowed_total",
+		Help: "Requests allowed by the rate limit middleware.",
+	}, []string{"route"})
+
+	// requestsBlocked counts the blocked decisions of the middlewares by route
+	requestsBlocked = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "velocity_requests_blocked_total",
+		Help: "Requests over the limit in the rate limit middleware.",
+	}, []string{"route"})
+
+	// redisErrors counts the failed checks of the middlewares by route
+	redisErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "velocity_redis_errors_total",
+		Help: "Rate limit checks of the middleware failing with a Redis error.",
+	}, []string{"route"})
+
+	// allowDuration is the time spent in each check of the middlewares, including
+	// the retries of a failover hold
+	allowDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "velocity_allow_duration_seconds",
+		Help:    "Time spent checking the rate limit in the middleware.",
+		Buckets: allowDurationBuckets,
+	})
+
+	// clockAnomalies exposes ClockAnomaliesTotal
+	clockAnomalies = prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "ratelimit_clock_anomalies_total",
+		Help: "Token bucket checks with a negative or implausibly large elapsed time.",
+	}, func() float64 {
+		return float64(ClockAnomaliesTotal())
+	})
+)
+
+// recordDecision counts the outcome of a middleware check of route: a Redis error if
+// err is set, an allowed or blocked request otherwise
+func recordDecision(route string, result *AllowResult, err error) {
+	switch {
+	case err != nil:
+		redisErrors.WithLabelValues(route).Inc()
+	case result.Allowed:
+		requestsAllowed.WithLabelValues(route).Inc()
+	default:
+		requestsBlocked.WithLabelValues(route).Inc()
+	}
+}
+
+// MetricsHandler serves the limiter metrics in the Prometheus exposition format,
+// along with the circuit breaker state of the shards of managers
+func MetricsHandler(managers ...*RedisShardManager) fiber.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(requestsAllowed, requestsBlocked, redisErrors, allowDuration, clockAnomalies, newBreakerCollector(managers))
+	return adaptor.HTTPHandler(promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// TestMetricsHandlerDecisions tests that the middleware counts allowed, blocked and failed checks by route
+func TestMetricsHandlerDecisions(t *testing.T) {
+	ok := func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	}
+	app := fiber.New()
+	app.Get("/test_metrics/allowed", RateLimitMiddleware(&fakeLimiter{result: AllowResult{Allowed: true, Remaining: 1}}), ok)
+	app.Get("/test_metrics/blocked", RateLimitMiddleware(&fakeLimiter{result: AllowResult{RetryAfter: time.Second}}), ok)
+	app.Get("/test_metrics/error", RateLimitMiddleware(&fakeLimiter{err: errors.New("connection refused")}), ok)
+	app.Get("/metrics", MetricsHandler())
+
+	for path, n := range map[string]int{"/test_metrics/allowed": 3, "/test_metrics/blocked": 2, "/test_metrics/error": 1} {
+		for i := 0; i < n; i++ {
+			if _, err := app.Test(httptest.NewRequest("GET", path, nil)); err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+		}
+	}
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/metrics", nil))
+	if err != nil {
+		t.Fatalf("Error requesting metrics: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+
+	for _, expected := range []string{
+		`velocity_requests_allowed_total{route="/test_metrics/allowed"} 3` + "\n",
+		`velocity_requests_blocked_total{route="/test_metrics/blocked"} 2` + "\n",
+		`velocity_redis_errors_total{route="/test_metrics/error"} 1` + "\n",
+		"# TYPE velocity_allow_duration_seconds histogram\n",
+		`velocity_allow_duration_seconds_bucket{le="+Inf"} `,
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("Expected metrics to contain %q, got %q", expected, body)
+		}
+	}
+	if strings.Contains(string(body), `velocity_requests_allowed_total{route="/test_metrics/blocked"}`) {
+		t.Errorf("Expected blocked requests not to be counted as allowed, got %q", body)
+	}
+}
+
+// recoveringLimiter is a Limiter failing its first checks with a failover error
+type recoveringLimiter struct {
+	failures int
+	calls    int
+}
+
+func (r *recoveringLimiter) Allow(userID string) (*AllowResult, error) {
+	return r.AllowN(userID, 1)
+}
+
+func (r *recoveringLimiter) AllowN(userID string, n float64) (*AllowResult, error) {
+	r.calls++
+	if r.calls <= r.failures {
+		return nil, redis.ErrClosed
+	}
+	return &AllowResult{Allowed: true, Remaining: 1}, nil
+}
+
+// TestMetricsAllowDurationFailoverHold tests that every check of a held request is
+// observed in the duration histogram
+func TestMetricsAllowDurationFailoverHold(t *testing.T) {
+	observations := func() uint64 {
+		var m dto.Metric
+		if err := allowDuration.Write(&m); err != nil {
+			t.Fatalf("Failed to read the histogram: %v", err)
+		}
+		return m.GetHistogram().GetSampleCount()
+	}
+
+	limiter := &recoveringLimiter{failures: 2}
+	app := newTestApp(RateLimitMiddleware(limiter, WithFailoverHold(time.Second, time.Millisecond)))
+	before := observations()
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusOK || limiter.calls != 3 {
+		t.Fatalf("Expected the request allowed on the third check, got %d after %d checks", resp.StatusCode, limiter.calls)
+	}
+	if got := observations() - before; got != 3 {
+		t.Errorf("Expected 3 checks observed, got %d", got)
+	}
+}
+
+// TestHTTPMiddlewareMetrics tests that the net/http middleware counts its decisions
+// under the route set by WithHTTPRoute
+func TestHTTPMiddlewareMetrics(t *testing.T) {
+	count := func(counter *prometheus.CounterVec, route string) float64 {
+		var m dto.Metric
+		if err := counter.WithLabelValues(route).Write(&m); err != nil {
+			t.Fatalf("Failed to read the counter: %v", err)
+		}
+		return m.GetCounter().GetValue()
+	}
+	const route = "/test_http_metrics"
+	allowed, blocked, failed := count(requestsAllowed, route), count(requestsBlocked, route), count(redisErrors, route)
+
+	serveHTTPMiddleware(t, &fakeLimiter{result: AllowResult{Allowed: true, Remaining: 1}}, "10.0.0.1:1234", WithHTTPRoute(route))
+	serveHTTPMiddleware(t, &fakeLimiter{result: AllowResult{RetryAfter: time.Second}}, "10.0.0.1:1234", WithHTTPRoute(route))
+	serveHTTPMiddleware(t, &fakeLimiter{result: AllowResult{RetryAfter: time.Second}}, "10.0.0.1:1234", WithHTTPRoute(route))
+	serveHTTPMiddleware(t, &fakeLimiter{err: errors.New("connection refused")}, "10.0.0.1:1234", WithHTTPRoute(route))
+
+	if got := count(requestsAllowed, route) - allowed; got != 1 {
+		t.Errorf("Expected 1 allowed request counted, got %v", got)
+	}
+	if got := count(requestsBlocked, route) - blocked; got != 2 {
+		t.Errorf("Expected 2 blocked requests counted, got %v", got)
+	}
+	if got := count(redisErrors, route) - failed; got != 1 {
+		t.Errorf("Expected 1 Redis error counted, got %v", got)
+	}
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// MiddlewareOptions holds the configuration for RateLimitMiddleware
+type MiddlewareOptions struct {
+	// FailureMode applies to Redis errors such as connection or script failures
+	FailureMode FailureMode
+	// TimeoutFailureMode applies when the request context is cancelled or its deadline expires
+	TimeoutFailureMode FailureMode
+	// RemainingRounding controls how the remaining tokens are rounded in the header
+	RemainingRounding RemainingRounding
+	// RetryAfterJitter adds random jitter to the retry-after header of blocked requests
+	RetryAfterJitter bool
+	// RemainingReporting selects whether the remaining header is reported before or after the request is charged
+	RemainingReporting RemainingReporting
+	// KeyFunc extracts the rate limit key of a request, requests with an empty key are rejected
+	KeyFunc KeyFunc
+	// HTTPKeyFunc extracts the rate limit key of HTTPMiddleware requests, nil uses r.RemoteAddr
+	HTTPKeyFunc HTTPKeyFunc
+	// HTTPRoute is the route label of the metrics of HTTPMiddleware decisions
+	HTTPRoute string
+	// KeyNormalizer maps equivalent keys to the same bucket, nil uses keys as extracted
+	KeyNormalizer KeyNormalizer
+	// CostFunc computes the token cost of a request, nil charges 1 token
+	CostFunc CostFunc
+	// FailureModeHeader names the request header overriding the failure mode, empty disables overrides
+	FailureModeHeader string
+	// FailureModeTrustedNets are the source networks allowed to override the failure mode
+	FailureModeTrustedNets []*net.IPNet
+	// SoftLimit runs the handler for would-be blocked requests, flagging them in Locals
+	SoftLimit bool
+	// ViolationGrace is the number of over-limit requests per window let through with a warning, 0 is strict
+	ViolationGrace int
+	// ViolationWindow is the window of the grace counter, 0 uses the time to refill the bucket
+	ViolationWindow time.Duration
+	// PanicRefund refunds the tokens charged for a request whose handler panics
+	PanicRefund bool
+	// Tarpit delays allowed responses of repeat offenders, nil disables tarpitting
+	Tarpit *TarpitConfig
+	// BlockWebhook reports blocked users to an external sink, nil disables reporting
+	BlockWebhook *BlockWebhook
+	// FailoverHold retries checks failing during a Redis failover, nil applies the failure mode at once
+	FailoverHold *FailoverHold
+	// Courtesy selects the users allowed one last request at 0 remaining, nil disables courtesy requests
+	Courtesy CourtesyFunc
+	// CourtesyWindow is the window in which a user gets one courtesy request, 0 uses the time to refill the bucket
+	CourtesyWindow time.Duration
+	// Headers names the limit headers of responses
+	Headers HeaderNames
+	// ResetTimestamp sends the reset header as the Unix time the bucket is full again, instead of the seconds until then
+	ResetTimestamp bool
+}
+
+// Option configures RateLimitMiddleware
+type Option func(*MiddlewareOptions)
+
+// WithFailureMode sets the policy for Redis errors (default FailOpen)
+func WithFailureMode(mode FailureMode) Option {
+	return func(o *MiddlewareOptions) {
+		o.FailureMode = mode
+	}
+}
+
+// WithTimeoutFailureMode sets the policy for cancelled or timed out requests (default FailOpen),
+// independently of the policy for Redis errors
+func WithTimeoutFailureMode(mode FailureMode) Option {
+	return func(o *MiddlewareOptions) {
+		o.TimeoutFailureMode = mode
+	}
+}
+
+// WithRemainingRounding sets how the fractional remaining tokens are rounded in the
+// X-RateLimit-Remaining header (default RemainingFloor)
+func WithRemainingRounding(rounding RemainingRounding) Option {
+	return func(o *MiddlewareOptions) {
+		o.RemainingRounding = rounding
+	}
+}
+
+// WithRemainingReporting sets whether the X-RateLimit-Remaining header reports the
+// quota left after the request was charged (RemainingPostConsumption, default) or the
+// quota available before it (RemainingPreConsumption)
+func WithRemainingReporting(reporting RemainingReporting) Option {
+	return func(o *MiddlewareOptions) {
+		o.RemainingReporting = reporting
+	}
+}
+
+// WithRetryAfterJitter sends AllowResult.BackoffWithJitter instead of the exact wait
+// in the retry-after header, spreading the retries of clients blocked together
+func WithRetryAfterJitter() Option {
+	return func(o *MiddlewareOptions) {
+		o.RetryAfterJitter = true
+	}
+}
+
+// WithKeyFunc sets how the rate limit key is extracted from a request (default c.IP(),
+// also used for a nil fn). Requests for which fn returns an empty key are rejected
+// with 401 Unauthorized.
+func WithKeyFunc(fn KeyFunc) Option {
+	return func(o *MiddlewareOptions) {
+		o.KeyFunc = fn
+	}
+}
+
+// WithKeyNormalizer sets how extracted keys are normalized before they select a bucket
+// (default NormalizeKey, which trims and lowercases). Pass nil to use keys verbatim,
+// e.g. when keying on case-sensitive API tokens.
+func WithKeyNormalizer(fn KeyNormalizer) Option {
+	return func(o *MiddlewareOptions) {
+		o.KeyNormalizer = fn
+	}
+}
+
+// WithCostFunc charges each request the number of tokens computed by fn, e.g. a
+// weighted score of payload size and priority headers. Requests for which fn fails
+// or returns a non-positive cost are rejected with 400 Bad Request.
+func WithCostFunc(fn CostFunc) Option {
+	return func(o *MiddlewareOptions) {
+		o.CostFunc = fn
+	}
+}
+
+// WithFailureModeOverride lets trusted clients choose the failure mode of their own
+// requests through the given header, set to "fail-open" or "fail-closed" (e.g. a
+// security scanner that must never be fail-open'd). Only requests whose source IP is
+// within trustedSources (IPs or CIDRs) may override; the header is ignored for
+// everyone else, who keep the globally configured failure mode. The source IP is
+// c.IP(), so behind a proxy this relies on Fiber's trusted proxy configuration.
+func WithFailureModeOverride(header string, trustedSources []string) Option {
+	nets := make([]*net.IPNet, 0, len(trustedSources))
+	for _, source := range trustedSources {
+		if !strings.Contains(source, "/") {
+			if ip := net.ParseIP(source); ip != nil && ip.To4() != nil {
+				source += "/32"
+			} else {
+				source += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(source)
+		if err != nil {
+			log.Printf("WARNING: Ignoring invalid failure mode override source %q - %v", source, err)
+			continue
+		}
+		nets = append(nets, ipNet)
+	}
+
+	return func(o *MiddlewareOptions) {
+		o.FailureModeHeader = header
+		o.FailureModeTrustedNets = nets
+	}
+}
+
+// WithSoftLimit never blocks requests: a request that would have been rate limited
+// still reaches the handler, with c.Locals(RateLimitExceededLocal) set to true so the
+// handler can degrade gracefully (e.g. serve stale or cheaper data). The rate limit
+// headers, including the retry-after header, are set as for a blocked request.
+func WithSoftLimit() Option {
+	return func(o *MiddlewareOptions) {
+		o.SoftLimit = true
+	}
+}
+
+// WithViolationGrace tolerates bursty clients: the first n requests exceeding the limit
+// within window are still served, with an X-RateLimit-Warning header, and only further
+// violations get 429. Violations are counted atomically in Redis, so the grace is
+// shared by all instances. A zero window uses the time an empty bucket takes to refill.
+// The default grace of 0 is strict.
+func WithViolationGrace(n int, window time.Duration) Option {
+	return func(o *MiddlewareOptions) {
+		o.ViolationGrace = n
+		o.ViolationWindow = window
+	}
+}
+
+// WithTarpit delays the allowed responses of users that repeatedly exceed the limit.
+// Every blocked request adds a penalty to a per-user counter in Redis that is kept
+// for cfg.Window; once a user holds more than cfg.Threshold penalties, each allowed
+// response is delayed by cfg.Step per penalty above the threshold, capped at
+// cfg.MaxDelay. The delay ends early if the request context is cancelled or its
+// deadline expires, and the request is then rejected with 503 without reaching the
+// handler. Fiber doesn't cancel the request context when the client disconnects, so
+// only a deadline set on it, e.g. by a timeout middleware, cuts the delay short.
+//
+// A delayed request occupies its handler goroutine and connection for the whole
+// delay, so a long MaxDelay lets aggressive clients tie up server resources; keep it
+// short and pair it with connection limits.
+func WithTarpit(cfg TarpitConfig) Option {
+	return func(o *MiddlewareOptions) {
+		o.Tarpit = &cfg
+	}
+}
+
+// WithBlockWebhook reports every request over the limit to the webhook, which
+// posts an event once a user collected its MinBlocks within the window
+func WithBlockWebhook(w *BlockWebhook) Option {
+	return func(o *MiddlewareOptions) {
+		o.BlockWebhook = w
+	}
+}
+
+// WithFailoverHold holds requests whose check fails with a transient failover error,
+// such as a dropped connection or a READONLY reply from a demoted master, retrying
+// every interval (default 50ms) for up to maxWait while a new master takes over.
+// Checks still failing afterwards, or failing with other errors, fall back to the
+// failure mode. The hold ends early when the request context does, which applies
+// the timeout failure mode. Keep maxWait short, since held requests tie up handlers.
+func WithFailoverHold(maxWait, interval time.Duration) Option {
+	return func(o *MiddlewareOptions) {
+		o.FailoverHold = &FailoverHold{MaxWait: maxWait, Interval: interval}
+	}
+}
+
+// WithHeaderNames sets the names of the limit headers (default CanonicalHeaders),
+// e.g. IETFHeaders for the RateLimit-* draft headers, PrefixHeaders for a custom
+// prefix or CanonicalHeaders.Lowercase for exact lowercase names
+func WithHeaderNames(names HeaderNames) Option {
+	return func(o *MiddlewareOptions) {
+		o.Headers = names
+	}
+}
+
+// WithHeaderStyle sets the limit headers to one of the predefined sets (default
+// HeaderStyleLegacy), like WithHeaderNames(style.Headers())
+func WithHeaderStyle(style HeaderStyle) Option {
+	return WithHeaderNames(style.Headers())
+}
+
+// WithResetTimestamp sends the reset header (RateLimit-Reset with HeaderStyleDraft) as
+// the Unix time at which the bucket is full again, AllowResult.ResetAt rounded up to
+// whole seconds, instead of the draft's seconds until then, for clients scheduling
+// against the wall clock. The combined RateLimit field keeps the seconds.
+func WithResetTimestamp() Option {
+	return func(o *MiddlewareOptions) {
+		o.ResetTimestamp = true
+	}
+}
+
+// WithLastRequestCourtesy allows one extra request of users for which eligible returns
+// true once their remaining tokens reach 0, e.g. high-value accounts of a key tier,
+// instead of blocking it at once. The courtesy request carries an
+// "X-RateLimit-Warning: last-request" header and is granted once per window, recorded
+// in Redis so that all instances share it; until the window passes, further requests
+// at the boundary are blocked. Requests blocked while tokens remain, e.g. because their
+// cost exceeds them, never get a courtesy. A zero window uses the time an empty bucket
+// takes to refill. Courtesy requests are disabled by default.
+func WithLastRequestCourtesy(eligible CourtesyFunc, window time.Duration) Option {
+	return func(o *MiddlewareOptions) {
+		o.Courtesy = eligible
+		o.CourtesyWindow = window
+	}
+}
+
+// newMiddlewareOptions applies opts on top of the defaults
+func newMiddlewareOptions(opts []Option) *MiddlewareOptions {
+	options := &MiddlewareOptions{
+		FailureMode:        FailOpen,
+		TimeoutFailureMode: FailOpen,
+		RemainingRounding:  RemainingFloor,
+		RemainingReporting: RemainingPostConsumption,
+		KeyFunc:            ipKey,
+		KeyNormalizer:      NormalizeKey,
+		Headers:            CanonicalHeaders,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+// failureModeFor returns the policy that applies to the given limiter error
+func (o *MiddlewareOptions) failureModeFor(err error) FailureMode {
+	if isContextError(err) {
+		return o.TimeoutFailureMode
+	}
+	return o.FailureMode
+}
+
+// requestFailureMode returns the policy for the given limiter error, honoring a
+// failure mode override sent by a trusted source
+func (o *MiddlewareOptions) requestFailureMode(c *fiber.Ctx, err error) FailureMode {
+	if o.FailureModeHeader != "" {
+		if value := c.Get(o.FailureModeHeader); value != "" && o.isTrustedSource(c.IP()) {
+			if mode, ok := parseFailureMode(value); ok {
+				return mode
+			}
+		}
+	}
+	return o.failureModeFor(err)
+}
+
+// retryAfter returns the wait to advertise for a blocked request
+func (o *MiddlewareOptions) retryAfter(result *AllowResult) time.Duration {
+	if o.RetryAfterJitter {
+		return result.BackoffWithJitter(nil)
+	}
+	return result.RetryAfter
+}
+
+// isTrustedSource reports whether ip may override the failure mode
+func (o *MiddlewareOptions) isTrustedSource(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range o.FailureModeTrustedNets {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyFunc extracts the rate limit key for a request
+type KeyFunc func(c *fiber.Ctx) string
+
+// ipKey is the default KeyFunc, limiting clients by IP address
+func ipKey(c *fiber.Ctx) string {
+	return c.IP()
+}
+
+// headerKeyPrefix namespaces header keys so they can't collide with IP keys
+const headerKeyPrefix = "header:"
+
+// HeaderKeyFunc returns a KeyFunc limiting clients by the value of the named request
+// header, e.g. "Authorization" or "X-API-Key". The value is keyed by its SHA-256 hash,
+// so secrets such as bearer tokens never reach Redis key names or logs, and values
+// differing only in case get their own buckets whatever the KeyNormalizer. Requests
+// without the header have an empty key and are rejected.
+func HeaderKeyFunc(header string) KeyFunc {
+	return func(c *fiber.Ctx) string {
+		return headerKey(c.Get(header))
+	}
+}
+
+// headerKey returns the key of a header value, its hex SHA-256 hash, or "" for an
+// empty value
+func headerKey(value string) string {
+	if value == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(value))
+	return headerKeyPrefix + hex.EncodeToString(sum[:])
+}
+
+// KeyNormalizer maps a rate limit key to its canonical form
+type KeyNormalizer func(key string) string
+
+// NormalizeKey is the default KeyNormalizer, trimming surrounding whitespace and
+// lowercasing so that e.g. "User@Example.com " and "user@example.com" share a bucket.
+// IP addresses are unaffected: c.IP() already formats IPv6 addresses in lowercase.
+func NormalizeKey(key string) string {
+	return strings.ToLower(strings.TrimSpace(key))
+}
+
+// key extracts and normalizes the rate limit key of a request with fn, or c.IP() if fn is nil
+func (o *MiddlewareOptions) key(c *fiber.Ctx, fn KeyFunc) string {
+	if fn == nil {
+		fn = func(c *fiber.Ctx) string { return c.IP() }
+	}
+	key := fn(c)
+	if o.KeyNormalizer != nil {
+		key = o.KeyNormalizer(key)
+	}
+	return key
+}
+
+// CostFunc computes the token cost of a request, returning an error for malformed input
+type CostFunc func(c *fiber.Ctx) (float64, error)
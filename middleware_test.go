@@ -0,0 +1,493 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// testClientIP is the remote address Fiber's app.Test uses for requests
+const testClientIP = "0.0.0.0"
+
+// newTestApp creates a Fiber app serving "ok" on "/" behind the given middleware
+func newTestApp(middleware fiber.Handler) *fiber.App {
+	app := fiber.New()
+	app.Get("/", middleware, func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+	return app
+}
+
+// TestMiddlewareRetryAfterHeader tests that the retry-after header of a blocked request
+// equals max(1, (requested - remaining) / rate) seconds, rounded up
+func TestMiddlewareRetryAfterHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		rate     float64
+		capacity float64
+	}{
+		{"clamped to one second", 5.0, 2.0},
+		{"exact two seconds", 0.5, 2.0},
+		{"fractional seconds round up", 0.3, 3.0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter, cleanup, err := setupTestRateLimiter(tt.rate, tt.capacity)
+			if err != nil {
+				t.Fatalf("Failed to setup test rate limiter: %v", err)
+			}
+			defer cleanup()
+
+			client := limiter.manager.GetClient(testClientIP)
+			client.Del(testCtx, testBucketKey(testClientIP))
+			defer client.Del(testCtx, testBucketKey(testClientIP))
+
+			app := newTestApp(RateLimitMiddleware(limiter))
+
+			// Exhaust the bucket
+			for i := 0; i < int(tt.capacity); i++ {
+				resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+				if err != nil {
+					t.Fatalf("Request failed: %v", err)
+				}
+				if resp.StatusCode != fiber.StatusOK {
+					t.Fatalf("Request %d should have been allowed, got status %d", i+1, resp.StatusCode)
+				}
+			}
+
+			// The next request is blocked with the bucket (almost) empty
+			resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			if resp.StatusCode != fiber.StatusTooManyRequests {
+				t.Fatalf("Expected request to be blocked, got status %d", resp.StatusCode)
+			}
+
+			retryAfter, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Retry-After"))
+			if err != nil {
+				t.Fatalf("Invalid retry-after header %q: %v", resp.Header.Get("X-RateLimit-Retry-After"), err)
+			}
+
+			// Remaining is just above 0 due to refill during the test, so the wait is just below 1/rate
+			expected := int(math.Max(1, math.Ceil(1.0/tt.rate)))
+			if retryAfter != expected {
+				t.Errorf("Expected retry-after of %d seconds, got %d", expected, retryAfter)
+			}
+		})
+	}
+}
+
+// TestMiddlewareRemainingRounding tests that the remaining header follows the rounding option
+func TestMiddlewareRemainingRounding(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		expected string
+	}{
+		{"default floors", nil, "8"},
+		{"ceil", []Option{WithRemainingRounding(RemainingCeil)}, "9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Setup: Slow refill so remaining stays just above a whole number
+			limiter, cleanup, err := setupTestRateLimiter(0.01, 10.0)
+			if err != nil {
+				t.Fatalf("Failed to setup test rate limiter: %v", err)
+			}
+			defer cleanup()
+
+			client := limiter.manager.GetClient(testClientIP)
+			client.Del(testCtx, testBucketKey(testClientIP))
+			defer client.Del(testCtx, testBucketKey(testClientIP))
+
+			// Start from 9.5 tokens so the request leaves 8.5
+			client.HSet(testCtx, testBucketKey(testClientIP), "tokens", 9.5, "lastRefill", float64(time.Now().UnixNano())/1e9)
+
+			app := newTestApp(RateLimitMiddleware(limiter, tt.opts...))
+			resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			if got := resp.Header.Get("X-RateLimit-Remaining"); got != tt.expected {
+				t.Errorf("Expected remaining header %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestMiddlewareRemainingReporting tests that the remaining header of the first request
+// on a fresh bucket reflects the reporting option
+func TestMiddlewareRemainingReporting(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		expected []string
+	}{
+		{"default post-consumption", nil, []string{"1", "0", "0"}},
+		{"pre-consumption", []Option{WithRemainingReporting(RemainingPreConsumption)}, []string{"2", "1", "0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter, cleanup, err := setupTestRateLimiter(0.01, 2.0)
+			if err != nil {
+				t.Fatalf("Failed to setup test rate limiter: %v", err)
+			}
+			defer cleanup()
+
+			client := limiter.manager.GetClient(testClientIP)
+			client.Del(testCtx, testBucketKey(testClientIP))
+			defer client.Del(testCtx, testBucketKey(testClientIP))
+
+			// Two allowed requests, then a blocked one which reports the same in both modes
+			app := newTestApp(RateLimitMiddleware(limiter, tt.opts...))
+			for i, expected := range tt.expected {
+				resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+				if err != nil {
+					t.Fatalf("Request failed: %v", err)
+				}
+				if got := resp.Header.Get("X-RateLimit-Remaining"); got != expected {
+					t.Errorf("Request %d: expected remaining header %s, got %s", i+1, expected, got)
+				}
+			}
+		})
+	}
+}
+
+// TestMiddlewareCostFunc tests that the middleware charges the computed cost and
+// rejects requests whose cost can't be computed
+func TestMiddlewareCostFunc(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.01, 10.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	client := limiter.manager.GetClient(testClientIP)
+	client.Del(testCtx, testBucketKey(testClientIP))
+	defer client.Del(testCtx, testBucketKey(testClientIP))
+
+	// Weighted score of a size header (per KB) and a priority header
+	weighted := func(c *fiber.Ctx) (float64, error) {
+		size, err := strconv.ParseFloat(c.Get("X-Payload-Size", "0"), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid X-Payload-Size: %w", err)
+		}
+		priority, err := strconv.ParseFloat(c.Get("X-Priority", "1"), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid X-Priority: %w", err)
+		}
+		return size/1024 + priority, nil
+	}
+
+	app := newTestApp(RateLimitMiddleware(limiter, WithCostFunc(weighted)))
+
+	request := func(size, priority string) *http.Response {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Payload-Size", size)
+		req.Header.Set("X-Priority", priority)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		return resp
+	}
+
+	// 2KB at priority 2 costs 4 tokens
+	resp := request("2048", "2")
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("Expected request to be allowed, got status %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("X-RateLimit-Remaining"); got != "6" {
+		t.Errorf("Expected 6 tokens remaining after a cost of 4, got %s", got)
+	}
+
+	// Malformed input fails the request without charging tokens
+	resp = request("lots", "2")
+	if resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("Expected malformed cost input to be rejected with 400, got status %d", resp.StatusCode)
+	}
+
+	// A cost of 7 exceeds the 6 tokens left
+	resp = request("5120", "2")
+	if resp.StatusCode != fiber.StatusTooManyRequests {
+		t.Errorf("Expected request costing 7 to be blocked, got status %d", resp.StatusCode)
+	}
+}
+
+// TestMiddlewareSoftLimit tests that soft limit mode runs the handler for would-be
+// blocked requests and flags them through Locals
+func TestMiddlewareSoftLimit(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.01, 2.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	client := limiter.manager.GetClient(testClientIP)
+	client.Del(testCtx, testBucketKey(testClientIP))
+	defer client.Del(testCtx, testBucketKey(testClientIP))
+
+	app := fiber.New()
+	app.Get("/", RateLimitMiddleware(limiter, WithSoftLimit()), func(c *fiber.Ctx) error {
+		if exceeded, _ := c.Locals(RateLimitExceededLocal).(bool); exceeded {
+			return c.SendString("stale")
+		}
+		return c.SendString("fresh")
+	})
+
+	for i, expected := range []string{"fresh", "fresh", "stale", "stale"} {
+		resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		if resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("Request %d should have reached the handler, got status %d", i+1, resp.StatusCode)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != expected {
+			t.Errorf("Request %d: expected body %q, got %q", i+1, expected, body)
+		}
+		if expected == "stale" && resp.Header.Get("X-RateLimit-Retry-After") == "" {
+			t.Errorf("Request %d: expected a retry-after header on a soft-blocked request", i+1)
+		}
+	}
+}
+
+// TestMiddlewareKeyNormalization tests that case and whitespace variants of a key share a
+// bucket by default and get separate buckets without normalization
+func TestMiddlewareKeyNormalization(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.01, 2.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	userKey := func(c *fiber.Ctx) string {
+		return c.Get("X-User")
+	}
+	variants := []string{"test_User@Example.com", "  test_user@example.COM", "TEST_USER@EXAMPLE.COM"}
+	defer func() {
+		for _, key := range variants {
+			limiter.manager.GetClient(key).Del(testCtx, testBucketKey(key))
+		}
+	}()
+
+	tests := []struct {
+		name     string
+		opts     []Option
+		keys     []string
+		expected []int
+	}{
+		{"default normalizes", nil,
+			[]string{"test_user@example.com"},
+			[]int{fiber.StatusOK, fiber.StatusOK, fiber.StatusTooManyRequests}},
+		{"nil normalizer keeps variants apart", []Option{WithKeyNormalizer(nil)},
+			variants,
+			[]int{fiber.StatusOK, fiber.StatusOK, fiber.StatusOK}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range append(tt.keys, variants...) {
+				limiter.manager.GetClient(key).Del(testCtx, testBucketKey(key))
+			}
+
+			app := newTestApp(RateLimitMiddleware(limiter, append(tt.opts, WithKeyFunc(userKey))...))
+			for i, variant := range variants {
+				req := httptest.NewRequest("GET", "/", nil)
+				req.Header.Set("X-User", variant)
+				resp, err := app.Test(req)
+				if err != nil {
+					t.Fatalf("Request failed: %v", err)
+				}
+				if resp.StatusCode != tt.expected[i] {
+					t.Errorf("Request %d (%q): expected status %d, got %d", i+1, variant, tt.expected[i], resp.StatusCode)
+				}
+			}
+		})
+	}
+}
+
+// TestMiddlewareNilKeyFunc tests that a nil KeyFunc falls back to the client IP
+func TestMiddlewareNilKeyFunc(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.01, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	// app.Test uses 0.0.0.0 as the remote IP
+	key := "0.0.0.0"
+	limiter.manager.GetClient(key).Del(testCtx, testBucketKey(key))
+	defer limiter.manager.GetClient(key).Del(testCtx, testBucketKey(key))
+
+	app := newTestApp(RateLimitMiddleware(limiter, WithKeyFunc(nil)))
+	for i, expected := range []int{fiber.StatusOK, fiber.StatusTooManyRequests} {
+		resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		if resp.StatusCode != expected {
+			t.Errorf("Request %d: expected %d, got %d", i+1, expected, resp.StatusCode)
+		}
+	}
+}
+
+// TestMiddlewareHeaderKeyFunc tests that API tokens sent from the same IP, including
+// tokens differing only in case, get independent buckets keyed by their hash
+func TestMiddlewareHeaderKeyFunc(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.01, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	tokens := []string{"test_token_a", "test_token_b", "TEST_TOKEN_A"}
+	for _, token := range tokens {
+		key := headerKey(token)
+		limiter.manager.GetClient(key).Del(testCtx, testBucketKey(key))
+		defer limiter.manager.GetClient(key).Del(testCtx, testBucketKey(key))
+	}
+
+	app := newTestApp(RateLimitMiddleware(limiter, WithKeyFunc(HeaderKeyFunc("Authorization"))))
+	request := func(token string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		return resp.StatusCode
+	}
+
+	for _, token := range tokens {
+		if status := request(token); status != fiber.StatusOK {
+			t.Errorf("First request with %q: expected 200, got %d", token, status)
+		}
+	}
+	for _, token := range tokens {
+		if status := request(token); status != fiber.StatusTooManyRequests {
+			t.Errorf("Second request with %q: expected 429, got %d", token, status)
+		}
+	}
+	if status := request(""); status != fiber.StatusUnauthorized {
+		t.Errorf("Request without a token: expected 401, got %d", status)
+	}
+
+	key := headerKey("test_token_a")
+	if strings.Contains(key, "test_token_a") || !strings.HasPrefix(key, headerKeyPrefix) {
+		t.Errorf("Expected the token hashed into the key, got %q", key)
+	}
+	if exists, _ := limiter.manager.GetClient(key).Exists(testCtx, testBucketKey(key)).Result(); exists != 1 {
+		t.Errorf("Expected the bucket of the hashed token, got none at %q", testBucketKey(key))
+	}
+}
+
+// TestNormalizeKey tests the default key normalization
+func TestNormalizeKey(t *testing.T) {
+	tests := map[string]string{
+		" User@Example.com\t": "user@example.com",
+		"2001:DB8::1":         "2001:db8::1",
+		"192.168.0.1":         "192.168.0.1",
+		"   ":                 "",
+	}
+	for key, expected := range tests {
+		if got := NormalizeKey(key); got != expected {
+			t.Errorf("NormalizeKey(%q) = %q, expected %q", key, got, expected)
+		}
+	}
+}
+
+// fakeLimiter is a Limiter returning a fixed result, or err if set, and recording the requested tokens
+type fakeLimiter struct {
+	result    AllowResult
+	err       error
+	requested []float64
+}
+
+func (f *fakeLimiter) Allow(userID string) (*AllowResult, error) {
+	return f.AllowN(userID, 1)
+}
+
+func (f *fakeLimiter) AllowN(userID string, n float64) (*AllowResult, error) {
+	f.requested = append(f.requested, n)
+	if f.err != nil {
+		return nil, f.err
+	}
+	result := f.result
+	return &result, nil
+}
+
+// fakeCapacityLimiter is a fakeLimiter reporting its capacity
+type fakeCapacityLimiter struct {
+	fakeLimiter
+}
+
+func (f *fakeCapacityLimiter) Capacity() float64 {
+	return 20
+}
+
+// TestMiddlewareCustomLimiter tests that the middleware enforces any Limiter, charging
+// the request cost and setting the headers from its result
+func TestMiddlewareCustomLimiter(t *testing.T) {
+	var _ Limiter = (*RateLimiter)(nil)
+	var _ Limiter = (*SlidingWindowLimiter)(nil)
+
+	allowed := &fakeLimiter{result: AllowResult{Allowed: true, Remaining: 7.5}}
+	blocked := &fakeLimiter{result: AllowResult{Remaining: 1, RetryAfter: 2500 * time.Millisecond}}
+	withCapacity := &fakeCapacityLimiter{fakeLimiter{result: AllowResult{Allowed: true, Remaining: 4}}}
+
+	tests := []struct {
+		name       string
+		limiter    Limiter
+		fake       *fakeLimiter
+		status     int
+		limit      string
+		remaining  string
+		retryAfter string
+	}{
+		{"allowed", allowed, allowed, fiber.StatusOK, "", "7", ""},
+		{"blocked", blocked, blocked, fiber.StatusTooManyRequests, "", "1", "3"},
+		{"with capacity", withCapacity, &withCapacity.fakeLimiter, fiber.StatusOK, "20", "4", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			costFunc := WithCostFunc(func(c *fiber.Ctx) (float64, error) { return 3, nil })
+			app := newTestApp(RateLimitMiddleware(tt.limiter, costFunc))
+			resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, resp.StatusCode)
+			}
+			for header, expected := range map[string]string{
+				"X-RateLimit-Limit":       tt.limit,
+				"X-RateLimit-Remaining":   tt.remaining,
+				"X-RateLimit-Retry-After": tt.retryAfter,
+			} {
+				if got := resp.Header.Get(header); got != expected {
+					t.Errorf("Expected %s %q, got %q", header, expected, got)
+				}
+			}
+			if len(tt.fake.requested) != 1 || tt.fake.requested[0] != 3 {
+				t.Errorf("Expected one check for the request cost of 3, got %v", tt.fake.requested)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// bucketTakeLuaScript is the Lua script for atomically reading and deleting a stranded
+// bucket, returning its {tokens, lastRefill, createdAt} or an empty reply if it's missing
+const bucketTakeLuaScript = `
+local tokens, lastRefill, createdAt = readBucket(KEYS[1])
+if not tokens then
+    return {}
+end
+redis.call('DEL', KEYS[1])
+return {tokens, lastRefill or '', createdAt or ''}
+`
+
+// bucketMergeLuaScript is the Lua script for atomically merging a migrated bucket into
+// its new shard. ARGV[1] and ARGV[2] are the key TTL and its unit, followed by the
+// tokens, lastRefill and createdAt of the migrated bucket.
+const bucketMergeLuaScript = `
+local key = KEYS[1]
+local ttl = tonumber(ARGV[1])
+local ttlUnit = ARGV[2]
+local migrated = tonumber(ARGV[3])
+local createdAt = ARGV[5] ~= '' and ARGV[5]
+
+local currentTokens, currentLastRefill, currentCreatedAt = readBucket(key)
+local current = tonumber(currentTokens)
+if current then
+    -- The bucket was already recreated on this shard, keep the lower balance
+    if migrated and migrated < current then
+        writeBucket(key, ARGV[3], currentLastRefill, currentCreatedAt)
+    end
+else
+    writeBucket(key, ARGV[3], ARGV[4], createdAt)
+end
+
+if ttlUnit == 'ms' then
+    redis.call('PEXPIRE', key, ttl)
+else
+    redis.call('EXPIRE', key, ttl)
+end
+return 1
+`
+
+// WithLazyMigration moves buckets stranded by UpdateShards: for a key TTL after a
+// topology change, every check first looks for the user's bucket on the shard that
+// owned it before the change and merges it into the new shard. Without it, users
+// who moved start over with a fresh bucket, briefly doubling their allowance.
+//
+// Users whose shard didn't change pay nothing. Users who moved pay two extra round
+// trips per check until the window ends, also after their bucket was migrated, since
+// a missing old bucket isn't remembered.
+func WithLazyMigration() LimiterOption {
+	return func(rl *RateLimiter) {
+		rl.lazyMigration = true
+	}
+}
+
+// UpdateShards replaces the shard set with the given addresses (host:port addresses,
+// Unix socket paths or URLs). The new set is connected before it's swapped in, so a
+// failure leaves the current shards in place. Read replicas no longer match the
+// shards and are detached. The replaced shards stay connected until the next update
+// so limiters with WithLazyMigration can move the buckets stranded on them.
+func (rsm *RedisShardManager) UpdateShards(addresses []string) error {
+	if rsm.cluster != nil {
+		return fmt.Errorf("the shards of a Redis Cluster are managed by the cluster")
+	}
+	if len(addresses) == 0 {
+		return fmt.Errorf("at least one Redis address is required")
+	}
+
+	options := make([]*redis.Options, len(addresses))
+	for i, addr := range addresses {
+		opt, err := addressOptions(addr)
+		if err != nil {
+			return fmt.Errorf("failed to parse Redis URL for shard %d: %w", i, err)
+		}
+		options[i] = opt
+	}
+	shards, err := connectShards(options)
+	if err != nil {
+		return err
+	}
+
+	rsm.mu.Lock()
+	retired := append(append([]*redis.Client{}, rsm.previous...), rsm.replicas...)
+	replaced := len(rsm.shards)
+	rsm.previous, rsm.previousIDs = rsm.shards, rsm.currentShardIDs()
+	rsm.shards, rsm.ids = shards, shardIDs(shards)
+	rsm.replicas = nil
+	rsm.updatedAt = time.Now()
+	if rsm.breakers != nil {
+		rsm.breakers = &breakers{config: rsm.breakers.config, shards: make(map[int]*circuitBreaker)}
+	}
+	rsm.mu.Unlock()
+
+	closeClients(retired)
+	log.Printf("INFO: Updated shard set from %d to %d shards", replaced, len(shards))
+	return nil
+}
+
+// previousClient returns the shard that owned userID before the last UpdateShards, or
+// nil if there was no update within window or userID stayed on the same shard
+func (rsm *RedisShardManager) previousClient(userID string, window time.Duration) *redis.Client {
+	rsm.mu.RLock()
+	defer rsm.mu.RUnlock()
+
+	if len(rsm.previous) == 0 || time.Since(rsm.updatedAt) > window {
+		return nil
+	}
+	previousIDs := rsm.previousIDs
+	if len(previousIDs) != len(rsm.previous) {
+		previousIDs = shardIDs(rsm.previous)
+	}
+	previous := rsm.previous[shardFor(rsm.seed, userID, previousIDs)]
+	current := rsm.shards[shardFor(rsm.seed, userID, rsm.currentShardIDs())]
+	if sameShard(previous, current) {
+		return nil
+	}
+	return previous
+}
+
+// sameShard reports whether two clients connect to the same Redis database
+func sameShard(a, b *redis.Client) bool {
+	optA, optB := a.Options(), b.Options()
+	return optA.Network == optB.Network && optA.Addr == optB.Addr && optA.DB == optB.DB
+}
+
+// closeClients closes the given clients, skipping nil entries
+func closeClients(clients []*redis.Client) {
+	for _, client := range clients {
+		if client != nil {
+			client.Close()
+		}
+	}
+}
+
+// migrateBucket moves userID's bucket at key from the shard that owned it before the
+// last UpdateShards to its current shard. Migration is best effort: failures are logged
+// and the check proceeds on the new shard.
+func (rl *RateLimiter) migrateBucket(ctx context.Context, userID, key string) {
+	previous := rl.manager.previousClient(userID, rl.bucketTTL())
+	if previous == nil {
+		return
+	}
+
+	// The previous shard set has no circuit breakers, they track the current set only;
+	// a dead previous shard costs one failed read per check until the migration ends
+	taken, err := rl.bucketScript(bucketTakeLuaScript).Run(ctx, previous, []string{key}).StringSlice()
+	if err != nil {
+		log.Printf("WARNING: Failed to read stranded bucket for userID %s - %v", userID, err)
+		return
+	}
+	if len(taken) == 0 {
+		return
+	}
+
+	ttl, ttlUnit := keyExpiry(rl.bucketTTL())
+	args := []interface{}{ttl, ttlUnit}
+	for _, field := range taken {
+		args = append(args, field)
+	}
+	if err := rl.manager.runOnShard(ctx, userID, rl.bucketScript(bucketMergeLuaScript), []string{key}, args...).Err(); err != nil {
+		log.Printf("WARNING: Failed to migrate bucket for userID %s - %v", userID, err)
+		return
+	}
+	log.Printf("INFO: Migrated bucket for userID %s after shard update", userID)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// movedUserID returns a test userID moving to database 1 when it's added next to
+// database 0 of the local server
+func movedUserID(t *testing.T) string {
+	ids := shardIDs([]*redis.Client{
+		redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 0}),
+		redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 1}),
+	})
+	for i := 0; i < 100; i++ {
+		userID := fmt.Sprintf("test_user_migration_%d", i)
+		if shardFor("", userID, ids) == 1 {
+			return userID
+		}
+	}
+	t.Fatal("No userID moving to the second shard found")
+	return ""
+}
+
+// TestLazyMigrationAfterUpdateShards tests that a moved user keeps their consumed tokens only with lazy migration
+func TestLazyMigrationAfterUpdateShards(t *testing.T) {
+	for _, migrate := range []bool{false, true} {
+		t.Run(fmt.Sprintf("migrate=%v", migrate), func(t *testing.T) {
+			manager, err := NewRedisShardManagerFromURLs([]string{"redis://localhost:6379/0"})
+			if err != nil {
+				t.Fatalf("Failed to create shard manager: %v", err)
+			}
+
+			var opts []LimiterOption
+			if migrate {
+				opts = append(opts, WithLazyMigration())
+			}
+			limiter := NewRateLimiter(manager, 0.01, 5.0, opts...)
+
+			userID := movedUserID(t)
+			key := limiter.bucketKey(userID)
+			manager.GetClient(userID).Del(testCtx, key)
+
+			for i := 0; i < 4; i++ {
+				if _, err := limiter.Allow(userID); err != nil {
+					t.Fatalf("Error calling Allow: %v", err)
+				}
+			}
+
+			// Add a second shard, a separate database of the same server
+			if err := manager.UpdateShards([]string{"redis://localhost:6379/0", "redis://localhost:6379/1"}); err != nil {
+				t.Fatalf("Failed to update shards: %v", err)
+			}
+			newClient := manager.GetClient(userID)
+			defer newClient.Del(testCtx, key)
+			newClient.Del(testCtx, key)
+
+			result, err := limiter.Allow(userID)
+			if err != nil {
+				t.Fatalf("Error calling Allow after update: %v", err)
+			}
+
+			expected := 4.0
+			if migrate {
+				expected = 0.0
+			}
+			if result.Remaining < expected || result.Remaining > expected+0.01 {
+				t.Errorf("Expected %.0f remaining tokens, got %f", expected, result.Remaining)
+			}
+			if migrate {
+				if n, _ := manager.previous[0].Exists(testCtx, key).Result(); n != 0 {
+					t.Error("Expected the stranded bucket to be removed from the old shard")
+				}
+			} else {
+				manager.previous[0].Del(testCtx, key)
+			}
+		})
+	}
+}
+
+// TestLazyMigrationKeepsLowerBalance tests that a bucket recreated on the new shard isn't topped up by migration
+func TestLazyMigrationKeepsLowerBalance(t *testing.T) {
+	manager, err := NewRedisShardManagerFromURLs([]string{"redis://localhost:6379/0"})
+	if err != nil {
+		t.Fatalf("Failed to create shard manager: %v", err)
+	}
+	limiter := NewRateLimiter(manager, 0.01, 5.0, WithLazyMigration())
+
+	userID := movedUserID(t)
+	key := limiter.bucketKey(userID)
+	oldClient := manager.GetClient(userID)
+	oldClient.Del(testCtx, key)
+	oldClient.HSet(testCtx, key, "tokens", 4, "lastRefill", 0)
+
+	if err := manager.UpdateShards([]string{"redis://localhost:6379/0", "redis://localhost:6379/1"}); err != nil {
+		t.Fatalf("Failed to update shards: %v", err)
+	}
+	newClient := manager.GetClient(userID)
+	defer newClient.Del(testCtx, key)
+	newClient.HSet(testCtx, key, "tokens", 1, "lastRefill", 0)
+
+	limiter.migrateBucket(testCtx, userID, key)
+	if tokens, _ := newClient.HGet(testCtx, key, "tokens").Float64(); tokens != 1 {
+		t.Errorf("Expected the lower balance of 1 token to be kept, got %v", tokens)
+	}
+}
+
+// TestUpdateShardsFailureKeepsShards tests that a failed update leaves the shard set unchanged
+func TestUpdateShardsFailureKeepsShards(t *testing.T) {
+	manager, err := NewRedisShardManager([]string{"localhost:6379"})
+	if err != nil {
+		t.Fatalf("Failed to create shard manager: %v", err)
+	}
+	shards := manager.Shards()
+
+	if err := manager.UpdateShards([]string{"localhost:6379", "localhost:1"}); err == nil {
+		t.Fatal("Expected an error for an unreachable shard")
+	}
+	if len(manager.Shards()) != 1 || manager.Shards()[0] != shards[0] {
+		t.Error("Expected the shard set to be unchanged after a failed update")
+	}
+	if err := manager.UpdateShards(nil); err == nil {
+		t.Error("Expected an error for an empty shard set")
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log"
+	"runtime/debug"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// WithPanicRefund refunds the tokens charged for a request whose handler panics, so
+// a crashing endpoint doesn't eat into its clients' quota. Off by default, because a
+// request that panicked may still have done the expensive work the limit protects.
+func WithPanicRefund() Option {
+	return func(o *MiddlewareOptions) {
+		o.PanicRefund = true
+	}
+}
+
+// recoverPanic handles a panic recovered in RateLimitMiddleware: it logs the panic
+// with the userID and stack, optionally refunds the tokens charged to limiter (nil for
+// limiters other than RateLimiter), and responds with 500 Internal Server Error
+func (o *MiddlewareOptions) recoverPanic(c *fiber.Ctx, limiter *RateLimiter, userID string, charged float64, recovered interface{}) error {
+	log.Printf("ERROR: Panic recovered in rate limited request - userID: %s, Panic: %v\n%s", userID, recovered, debug.Stack())
+
+	if o.PanicRefund && charged > 0 && limiter != nil {
+		if err := limiter.Refund(userID, charged); err != nil {
+			log.Printf("WARNING: Failed to refund %.2f tokens to userID %s after panic - %v", charged, userID, err)
+		} else {
+			log.Printf("INFO: Refunded %.2f tokens to userID %s after panic", charged, userID)
+		}
+	}
+
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error":   "Internal server error",
+		"message": "The request could not be completed.",
+	})
+}
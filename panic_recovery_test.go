@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestMiddlewarePanicRecovery tests that a panicking handler yields a logged 500 and
+// that the charged token is refunded only when configured
+func TestMiddlewarePanicRecovery(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []Option
+		remaining float64
+	}{
+		{"keeps the charge by default", nil, 9},
+		{"refunds when configured", []Option{WithPanicRefund()}, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter, cleanup, err := setupTestRateLimiter(0.001, 10.0)
+			if err != nil {
+				t.Fatalf("Failed to setup test rate limiter: %v", err)
+			}
+			defer cleanup()
+
+			client := limiter.manager.GetClient(testClientIP)
+			client.Del(testCtx, testBucketKey(testClientIP))
+			defer client.Del(testCtx, testBucketKey(testClientIP))
+
+			app := fiber.New()
+			app.Get("/", RateLimitMiddleware(limiter, tt.opts...), func(c *fiber.Ctx) error {
+				panic("handler bug")
+			})
+
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+			log.SetOutput(os.Stderr)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+
+			if resp.StatusCode != fiber.StatusInternalServerError {
+				t.Errorf("Expected status 500, got %d", resp.StatusCode)
+			}
+			if !strings.Contains(logs.String(), "userID: "+testClientIP+", Panic: handler bug") {
+				t.Errorf("Expected the panic to be logged with the userID, got %q", logs.String())
+			}
+			assertTokens(t, limiter, testClientIP, tt.remaining)
+		})
+	}
+}
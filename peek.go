@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+)
+
+// tokenPeekLuaScript is the read-only variant of the token bucket script: it applies
+// the refill math but never writes the bucket back. Values are returned as strings
+// because Redis truncates Lua numbers to integers in replies.
+const tokenPeekLuaScript = `
+local key = KEYS[1]
+local rate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local now = serverTime()
+local initial = tonumber(ARGV[3])
+
+-- Get current state from storage, missing buckets report the initial tokens
+local storedTokens, storedLastRefill, createdAt = readBucket(key)
+local exists = 0
+if storedTokens then
+    exists = 1
+end
+local tokens = tonumber(storedTokens) or initial
+local lastRefill = tonumber(storedLastRefill) or now
+
+-- Apply the refill owed since the last update without storing it
+local elapsed = now - lastRefill
+if elapsed > 0 then
+    tokens = math.min(capacity, tokens + elapsed * rate)
+end
+
+return {exists, tostring(tokens), storedLastRefill or '', createdAt or ''}
+`
+
+// BucketState is a read-only snapshot of a user's bucket
+type BucketState struct {
+	Exists     bool      // false if the user has no bucket yet
+	Tokens     float64   // available tokens, including the refill owed up to now
+	LastRefill time.Time // zero if the bucket doesn't exist
+	CreatedAt  time.Time // zero unless the limiter was created WithCreatedAt
+}
+
+// PeekState reports the given userID's bucket state without consuming tokens or
+// modifying the bucket
+func (rl *RateLimiter) PeekState(userID string) (*BucketState, error) {
+	key := rl.bucketKey(userID)
+	rate, capacity := rl.Limits()
+	script := rl.bucketScript(tokenPeekLuaScript)
+	result, err := rl.manager.runOnShard(ctx, userID, script, []string{key}, rate, capacity, rl.initialTokens).Result()
+	if err != nil {
+		log.Printf("ERROR: Critical Redis Error: Lua peek script execution failure for userID %s - %v", userID, err)
+		return nil, fmt.Errorf("failed to execute peek script: %w", err)
+	}
+
+	// Parse the result (Lua script returns {exists, tokens, lastRefill, createdAt})
+	resultArray, ok := result.([]interface{})
+	if !ok || len(resultArray) < 4 {
+		return nil, fmt.Errorf("unexpected result format from Lua peek script")
+	}
+
+	exists, err := parseLuaNumber(resultArray[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse bucket existence: %w", err)
+	}
+
+	tokens, err := parseLuaNumber(resultArray[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse peeked tokens: %w", err)
+	}
+
+	lastRefill, err := parseBucketTime(resultArray[2])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse lastRefill: %w", err)
+	}
+	createdAt, err := parseBucketTime(resultArray[3])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse createdAt: %w", err)
+	}
+
+	state := &BucketState{
+		Exists:     exists == 1,
+		Tokens:     tokens,
+		LastRefill: lastRefill,
+		CreatedAt:  createdAt,
+	}
+	if rl.isDebugUser(userID) {
+		log.Printf("DEBUG: Bucket trace - userID: %s, Op: peek, Exists: %t, Tokens: %.4f, Last refill: %s", userID, state.Exists, state.Tokens, state.LastRefill.Format(time.RFC3339Nano))
+	}
+
+	return state, nil
+}
+
+// Peek returns the tokens currently available to the given userID, including the
+// refill owed up to now, without consuming any or modifying the bucket, e.g. to show
+// "you have X requests left"
+func (rl *RateLimiter) Peek(userID string) (float64, error) {
+	state, err := rl.PeekState(userID)
+	if err != nil {
+		return 0, err
+	}
+	return state.Tokens, nil
+}
+
+// parseBucketTime converts a stored Unix timestamp in seconds into a time.Time,
+// returning the zero time for an empty (missing) field
+func parseBucketTime(v interface{}) (time.Time, error) {
+	s, _ := v.(string)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, int64(seconds*1e9)), nil
+}
+
+// PeekStale approximates the given userID's bucket from the shard's read replica
+// (see GetReplicaClient), applying the refill owed up to now in Go, to offload
+// high-volume reads such as dashboards from the primaries. The result is marked
+// Stale: it may lag by the replication delay, so it must never be used to enforce
+// limits. Allowed reports whether a 1-token request would currently fit.
+func (rl *RateLimiter) PeekStale(userID string) (*AllowResult, error) {
+	client := rl.manager.GetReplicaClient(userID)
+
+	bucket, err := rl.storage.Read(ctx, client, rl.bucketKey(userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bucket from replica: %w", err)
+	}
+
+	// Missing buckets hold the initial tokens
+	tokens := rl.initialTokens
+	if bucket != nil {
+		if tokens, err = parseLuaNumber(bucket.Tokens); err != nil {
+			return nil, fmt.Errorf("failed to parse tokens: %w", err)
+		}
+		lastRefill, err := parseBucketTime(bucket.LastRefill)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse lastRefill: %w", err)
+		}
+		if elapsed := time.Since(lastRefill).Seconds(); !lastRefill.IsZero() && elapsed > 0 {
+			rate, capacity := rl.Limits()
+			tokens = math.Min(capacity, tokens+elapsed*rate)
+		}
+	}
+
+	return &AllowResult{
+		Allowed:   tokens >= 1,
+		Remaining: tokens,
+		Stale:     true,
+	}, nil
+}
+
+// resetAt returns when a bucket left with remaining tokens at now is full again, if no
+// further tokens are consumed
+func (rl *RateLimiter) resetAt(remaining float64, now time.Time) time.Time {
+	rate, capacity := rl.Limits()
+	return now.Add(timeToFull(remaining, capacity, rate))
+}
+
+// TimeToFull returns how long the given userID's bucket takes to refill to capacity
+// if no further tokens are consumed, e.g. to show when a user has full quota again.
+// It reads the bucket like PeekState without modifying it; a full bucket returns 0.
+func (rl *RateLimiter) TimeToFull(userID string) (time.Duration, error) {
+	state, err := rl.PeekState(userID)
+	if err != nil {
+		return 0, err
+	}
+	rate, capacity := rl.Limits()
+	return timeToFull(state.Tokens, capacity, rate), nil
+}
+
+// timeToFull returns the time a bucket holding tokens takes to refill to capacity at rate
+func timeToFull(tokens, capacity, rate float64) time.Duration {
+	if tokens >= capacity || rate <= 0 {
+		return 0
+	}
+	// Round up to the nanosecond, so a bucket reported full after the wait really is
+	return time.Duration(math.Ceil((capacity - tokens) / rate * float64(time.Second)))
+}
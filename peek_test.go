@@ -0,0 +1,246 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// TestPeekStateReadOnly tests that peeking reports the bucket state without modifying it
+func TestPeekStateReadOnly(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.01, 10.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	userID := "test_user_peek_state"
+	limiter.manager.GetClient(userID).Del(testCtx, testBucketKey(userID))
+
+	// A user without a bucket reports a full, non-existent bucket
+	state, err := limiter.PeekState(userID)
+	if err != nil {
+		t.Fatalf("Error calling PeekState: %v", err)
+	}
+	if state.Exists || state.Tokens != 10 || !state.LastRefill.IsZero() {
+		t.Errorf("Expected a missing full bucket, got %+v", state)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := limiter.Allow(userID); err != nil {
+			t.Fatalf("Error calling Allow: %v", err)
+		}
+	}
+
+	state, err = limiter.PeekState(userID)
+	if err != nil {
+		t.Fatalf("Error calling PeekState: %v", err)
+	}
+	if !state.Exists || state.Tokens < 7 || state.Tokens > 7.1 {
+		t.Errorf("Expected an existing bucket with about 7 tokens, got %+v", state)
+	}
+	if !state.CreatedAt.IsZero() {
+		t.Errorf("Expected no createdAt without WithCreatedAt, got %v", state.CreatedAt)
+	}
+
+	// Peeking didn't write the bucket back
+	again, err := limiter.PeekState(userID)
+	if err != nil {
+		t.Fatalf("Error calling PeekState: %v", err)
+	}
+	if !again.LastRefill.Equal(state.LastRefill) {
+		t.Errorf("Expected PeekState not to move lastRefill, got %v then %v", state.LastRefill, again.LastRefill)
+	}
+}
+
+// TestPeek tests that peeking neither consumes tokens nor affects the next Allow
+func TestPeek(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.0001, 10.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	userID := "test_user_peek"
+	limiter.manager.GetClient(userID).Del(testCtx, testBucketKey(userID))
+
+	if _, err := limiter.AllowN(userID, 4); err != nil {
+		t.Fatalf("Error calling AllowN: %v", err)
+	}
+
+	first, err := limiter.Peek(userID)
+	if err != nil {
+		t.Fatalf("Error calling Peek: %v", err)
+	}
+	second, err := limiter.Peek(userID)
+	if err != nil {
+		t.Fatalf("Error calling Peek: %v", err)
+	}
+	if first < 6 || first > 6.01 || second-first > 0.001 {
+		t.Errorf("Expected two peeks of about 6 tokens, got %v and %v", first, second)
+	}
+
+	result, err := limiter.Allow(userID)
+	if err != nil {
+		t.Fatalf("Error calling Allow: %v", err)
+	}
+	if !result.Allowed || result.Remaining < 5 || result.Remaining > 5.01 {
+		t.Errorf("Expected Allow to see the peeked tokens minus 1, got %+v", result)
+	}
+}
+
+// TestPeekStateCreatedAt tests that createdAt is set once when the bucket is initialized
+func TestPeekStateCreatedAt(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.01, 10.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	tracked := NewRateLimiter(limiter.manager, 0.01, 10.0, WithCreatedAt())
+	userID := "test_user_created_at"
+	limiter.manager.GetClient(userID).Del(testCtx, testBucketKey(userID))
+
+	before := time.Now().Add(-time.Second)
+	if _, err := tracked.Allow(userID); err != nil {
+		t.Fatalf("Error calling Allow: %v", err)
+	}
+
+	state, err := tracked.PeekState(userID)
+	if err != nil {
+		t.Fatalf("Error calling PeekState: %v", err)
+	}
+	createdAt := state.CreatedAt
+	if createdAt.Before(before) || createdAt.After(time.Now()) {
+		t.Fatalf("Expected createdAt to be set on initialization, got %v", createdAt)
+	}
+
+	// Later requests don't move createdAt
+	time.Sleep(10 * time.Millisecond)
+	if _, err := tracked.Allow(userID); err != nil {
+		t.Fatalf("Error calling Allow: %v", err)
+	}
+	state, err = tracked.PeekState(userID)
+	if err != nil {
+		t.Fatalf("Error calling PeekState: %v", err)
+	}
+	if !state.CreatedAt.Equal(createdAt) {
+		t.Errorf("Expected createdAt to stay %v, got %v", createdAt, state.CreatedAt)
+	}
+	if !state.LastRefill.After(createdAt) {
+		t.Errorf("Expected lastRefill %v to advance past createdAt %v", state.LastRefill, createdAt)
+	}
+}
+
+// TestPeekStale tests the approximate, stale-marked result read through GetReplicaClient
+func TestPeekStale(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(1.0, 10.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	// The test server stands in for the replica of its own shard
+	redisAddr := os.Getenv("REDIS_ADDR")
+	if redisAddr == "" {
+		redisAddr = "localhost:6379"
+	}
+	if err := limiter.manager.AttachReplicas([]string{redisAddr}); err != nil {
+		t.Fatalf("AttachReplicas failed: %v", err)
+	}
+
+	// Missing buckets report the initial tokens
+	result, err := limiter.PeekStale("test_peek_stale")
+	if err != nil {
+		t.Fatalf("PeekStale failed: %v", err)
+	}
+	if !result.Stale || !result.Allowed || result.Remaining != 10 {
+		t.Errorf("Expected a stale full bucket, got %+v", result)
+	}
+
+	// 4 tokens consumed 2 seconds ago have been refilled by 2 since
+	client := limiter.manager.GetClient("test_peek_stale")
+	client.HSet(testCtx, testBucketKey("test_peek_stale"), "tokens", 6, "lastRefill", float64(time.Now().Add(-2*time.Second).UnixNano())/1e9)
+
+	result, err = limiter.PeekStale("test_peek_stale")
+	if err != nil {
+		t.Fatalf("PeekStale failed: %v", err)
+	}
+	if !result.Stale || result.Remaining < 8 || result.Remaining > 8.1 {
+		t.Errorf("Expected about 8 stale tokens, got %+v", result)
+	}
+}
+
+// TestGetReplicaClientFallback tests that shards without a replica are read from the primary
+func TestGetReplicaClientFallback(t *testing.T) {
+	primary := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})
+	manager := &RedisShardManager{shards: []*redis.Client{primary}}
+	if manager.GetReplicaClient("user") != primary {
+		t.Errorf("Expected the primary without replicas")
+	}
+
+	if err := manager.AttachReplicas([]string{""}); err != nil {
+		t.Fatalf("AttachReplicas failed: %v", err)
+	}
+	if manager.GetReplicaClient("user") != primary {
+		t.Errorf("Expected the primary for a shard with an empty replica entry")
+	}
+
+	if err := manager.AttachReplicas([]string{"a:1", "b:1"}); err == nil {
+		t.Errorf("Expected an error for a replica count not matching the shard count")
+	}
+}
+
+// TestTimeToFull tests the refill time of a partially consumed bucket without consuming tokens
+func TestTimeToFull(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(2.0, 10.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	userID := "test_user_time_to_full"
+	limiter.manager.GetClient(userID).Del(testCtx, testBucketKey(userID))
+
+	// A new bucket is full
+	if d, err := limiter.TimeToFull(userID); err != nil || d != 0 {
+		t.Errorf("Expected a new bucket to be full, got %v, %v", d, err)
+	}
+
+	// 4 tokens short at 2 tokens/sec take 2 seconds, minus the refill since the check
+	if _, err := limiter.AllowN(userID, 4); err != nil {
+		t.Fatalf("Error calling AllowN: %v", err)
+	}
+	d, err := limiter.TimeToFull(userID)
+	if err != nil {
+		t.Fatalf("Error calling TimeToFull: %v", err)
+	}
+	if d <= 1900*time.Millisecond || d > 2*time.Second {
+		t.Errorf("Expected about 2s to full, got %v", d)
+	}
+
+	// Reading is side-effect free
+	assertTokens(t, limiter, userID, 6)
+}
+
+// TestTimeToFullMath tests the refill time calculation
+func TestTimeToFullMath(t *testing.T) {
+	tests := []struct {
+		tokens, capacity, rate float64
+		expected               time.Duration
+	}{
+		{0, 10, 1, 10 * time.Second},
+		{7.5, 10, 5, 500 * time.Millisecond},
+		{1600, 3600, 1000.0 / 3600, 2 * time.Hour},
+		{10, 10, 1, 0},
+		{12, 10, 1, 0},
+	}
+	for _, tt := range tests {
+		got := timeToFull(tt.tokens, tt.capacity, tt.rate)
+		if diff := got - tt.expected; diff < 0 || diff > time.Microsecond {
+			t.Errorf("timeToFull(%v, %v, %v) = %v, expected %v", tt.tokens, tt.capacity, tt.rate, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Policy declares the rate limit applied to the requests matching a path pattern
+type Policy struct {
+	Name     string  `json:"name"`     // unique name, also the bucket key prefix
+	Path     string  `json:"path"`     // exact path, or a prefix ending in "/*"
+	Rate     float64 `json:"rate"`     // tokens per second
+	Capacity float64 `json:"capacity"` // maximum bucket capacity
+	Key      string  `json:"key"`      // "ip" (default) or "header:<Name>"
+}
+
+// matches reports whether the request path falls under the policy
+func (p *Policy) matches(path string) bool {
+	if prefix, ok := strings.CutSuffix(p.Path, "/*"); ok {
+		return path == prefix || strings.HasPrefix(path, prefix+"/")
+	}
+	return path == p.Path
+}
+
+// keyFunc resolves the policy key strategy into a KeyFunc
+func (p *Policy) keyFunc() (KeyFunc, error) {
+	switch {
+	case p.Key == "" || p.Key == "ip":
+		return ipKey, nil
+	case strings.HasPrefix(p.Key, "header:"):
+		header := strings.TrimPrefix(p.Key, "header:")
+		if header == "" {
+			return nil, fmt.Errorf("policy %q: key %q is missing a header name", p.Name, p.Key)
+		}
+		return HeaderKeyFunc(header), nil
+	default:
+		return nil, fmt.Errorf("policy %q: unknown key strategy %q", p.Name, p.Key)
+	}
+}
+
+// validatePolicies checks that every policy is complete and has a unique name
+func validatePolicies(policies []Policy) error {
+	var errs []error
+	seen := make(map[string]struct{}, len(policies))
+	for i, p := range policies {
+		if p.Name == "" {
+			errs = append(errs, fmt.Errorf("policy %d: name is required", i))
+		} else if _, ok := seen[p.Name]; ok {
+			errs = append(errs, fmt.Errorf("policy %q: duplicate name", p.Name))
+		} else if err := validateKeyPrefix(p.Name); err != nil {
+			errs = append(errs, fmt.Errorf("policy %q: %w", p.Name, err))
+		}
+		seen[p.Name] = struct{}{}
+
+		if !strings.HasPrefix(p.Path, "/") {
+			errs = append(errs, fmt.Errorf("policy %q: path %q must start with /", p.Name, p.Path))
+		}
+		if p.Rate <= 0 || p.Capacity <= 0 {
+			errs = append(errs, fmt.Errorf("policy %q: rate and capacity must be positive", p.Name))
+		}
+		if _, err := p.keyFunc(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// LoadPolicies builds one RateLimiter per policy, keyed by policy name. Each limiter's
+// buckets are prefixed with the policy name, so policies never share tokens.
+func LoadPolicies(manager *RedisShardManager, policies []Policy, opts ...LimiterOption) (map[string]*RateLimiter, error) {
+	if err := validatePolicies(policies); err != nil {
+		return nil, fmt.Errorf("failed to load policies: %w", err)
+	}
+
+	limiters := make(map[string]*RateLimiter, len(policies))
+	for _, p := range policies {
+		limiterOpts := append([]LimiterOption{WithKeyPrefix(p.Name)}, opts...)
+		limiters[p.Name] = NewRateLimiter(manager, p.Rate, p.Capacity, limiterOpts...)
+	}
+	return limiters, nil
+}
+
+// RateLimitConfig declares the limit of a single route registered with RateLimitWithConfig
+type RateLimitConfig struct {
+	Rate      float64 // tokens per second
+	Capacity  float64 // maximum bucket capacity
+	KeyPrefix string  // bucket key prefix keeping the route's buckets apart, empty shares the default buckets
+}
+
+// RateLimitWithConfig creates middleware enforcing cfg with its own RateLimiter, for
+// routes that need their own limits, e.g. search at 2/sec next to uploads at 0.5/sec:
+//
+//	app.Get("/api/search", RateLimitWithConfig(manager, RateLimitConfig{Rate: 2, Capacity: 10, KeyPrefix: "search"}), search)
+//	app.Post("/api/upload", RateLimitWithConfig(manager, RateLimitConfig{Rate: 0.5, Capacity: 2, KeyPrefix: "upload"}), upload)
+//
+// Routes with distinct key prefixes keep independent buckets for the same client.
+func RateLimitWithConfig(manager *RedisShardManager, cfg RateLimitConfig, opts ...Option) fiber.Handler {
+	limiter := NewRateLimiter(manager, cfg.Rate, cfg.Capacity, WithKeyPrefix(cfg.KeyPrefix))
+	return RateLimitMiddleware(limiter, opts...)
+}
+
+// routedPolicy pairs a policy with its limiter and middleware
+type routedPolicy struct {
+	policy  Policy
+	limiter *RateLimiter
+	handler fiber.Handler
+}
+
+// PolicyRouter applies the first policy whose path matches each request
+type PolicyRouter struct {
+	routes  []routedPolicy
+	headers HeaderNames // limit headers sent by the policy middleware
+}
+
+// NewPolicyRouter builds the limiters and middleware for the given policies. Policies
+// are matched in order, so more specific paths must be listed before broader prefixes.
+// The middleware options apply to every policy; the key strategy of each policy
+// overrides any WithKeyFunc option.
+func NewPolicyRouter(manager *RedisShardManager, policies []Policy, opts ...Option) (*PolicyRouter, error) {
+	limiters, err := LoadPolicies(manager, policies)
+	if err != nil {
+		return nil, err
+	}
+
+	router := &PolicyRouter{
+		routes:  make([]routedPolicy, 0, len(policies)),
+		headers: newMiddlewareOptions(opts).Headers,
+	}
+	for _, p := range policies {
+		keyFunc, _ := p.keyFunc()
+		handlerOpts := append(append([]Option{}, opts...), WithKeyFunc(keyFunc))
+		router.routes = append(router.routes, routedPolicy{
+			policy:  p,
+			limiter: limiters[p.Name],
+			handler: RateLimitMiddleware(limiters[p.Name], handlerOpts...),
+		})
+	}
+	return router, nil
+}
+
+// Limiter returns the limiter of the named policy, or nil if there is none
+func (pr *PolicyRouter) Limiter(name string) *RateLimiter {
+	for _, route := range pr.routes {
+		if route.policy.Name == name {
+			return route.limiter
+		}
+	}
+	return nil
+}
+
+// Policies returns the policies of the router in matching order, with the current
+// limits of their limiters, which SetLimits may have changed since they were loaded
+func (pr *PolicyRouter) Policies() []Policy {
+	policies := make([]Policy, len(pr.routes))
+	for i, route := range pr.routes {
+		policies[i] = route.policy
+		policies[i].Rate, policies[i].Capacity = route.limiter.Limits()
+	}
+	return policies
+}
+
+// Match returns the policy applied to the given path, or nil if no policy matches
+func (pr *PolicyRouter) Match(path string) *Policy {
+	for i := range pr.routes {
+		if pr.routes[i].policy.matches(path) {
+			return &pr.routes[i].policy
+		}
+	}
+	return nil
+}
+
+// Handler returns middleware that rate limits each request with its matching policy.
+// Requests that match no policy pass through unlimited.
+func (pr *PolicyRouter) Handler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		path := c.Path()
+		for _, route := range pr.routes {
+			if route.policy.matches(path) {
+				return route.handler(c)
+			}
+		}
+		return c.Next()
+	}
+}
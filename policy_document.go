@@ -0,0 +1,84 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// PolicyDocumentPath is the well-known URL of the policy document
+const PolicyDocumentPath = "/.well-known/ratelimit-policy"
+
+// policyDocumentVersion is the version of the policy document schema, bumped on
+// incompatible changes
+const policyDocumentVersion = 1
+
+// AlgorithmTokenBucket is the algorithm of the policies of a PolicyRouter
+const AlgorithmTokenBucket = "token-bucket"
+
+// PolicyDocument is the machine-readable description of the active rate limit
+// policies served by PolicyDocumentHandler
+type PolicyDocument struct {
+	Version  int                 `json:"version"`
+	Headers  PolicyHeaders       `json:"headers"`
+	Policies []PolicyDescription `json:"policies"`
+}
+
+// PolicyHeaders names the response headers reporting limits, empty when not sent
+type PolicyHeaders struct {
+	Limit      string `json:"limit"`
+	Remaining  string `json:"remaining"`
+	RetryAfter string `json:"retryAfter"`
+	Reset      string `json:"reset"`
+	Combined   string `json:"combined"`
+}
+
+// PolicyDescription describes the limit applied to the requests matching a path
+type PolicyDescription struct {
+	Name          string  `json:"name"`
+	Path          string  `json:"path"`          // exact path, or a prefix ending in "/*"
+	Key           string  `json:"key"`           // "ip" or "header:<Name>"
+	Algorithm     string  `json:"algorithm"`     // always "token-bucket"
+	Rate          float64 `json:"rate"`          // tokens per second
+	Capacity      float64 `json:"capacity"`      // largest burst
+	RefillSeconds float64 `json:"refillSeconds"` // time an empty bucket takes to refill
+}
+
+// Document describes the router's policies in matching order, with their current limits
+func (pr *PolicyRouter) Document() PolicyDocument {
+	doc := PolicyDocument{
+		Version: policyDocumentVersion,
+		Headers: PolicyHeaders{
+			Limit:      pr.headers.Limit,
+			Remaining:  pr.headers.Remaining,
+			RetryAfter: pr.headers.RetryAfter,
+			Reset:      pr.headers.Reset,
+			Combined:   pr.headers.Combined,
+		},
+		Policies: make([]PolicyDescription, 0, len(pr.routes)),
+	}
+	for _, p := range pr.Policies() {
+		key := p.Key
+		if key == "" {
+			key = "ip"
+		}
+		doc.Policies = append(doc.Policies, PolicyDescription{
+			Name:          p.Name,
+			Path:          p.Path,
+			Key:           key,
+			Algorithm:     AlgorithmTokenBucket,
+			Rate:          p.Rate,
+			Capacity:      p.Capacity,
+			RefillSeconds: p.Capacity / p.Rate,
+		})
+	}
+	return doc
+}
+
+// PolicyDocumentHandler serves the router's policy document as JSON, to be mounted
+// at PolicyDocumentPath so clients can discover the limits and tune their backoff
+// and concurrency. Limits are read on every request, so changes made with
+// SetLimits are reflected at once.
+func PolicyDocumentHandler(pr *PolicyRouter) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(pr.Document())
+	}
+}
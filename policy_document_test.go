@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestPolicyDocumentHandler tests the JSON document describing the router's policies
+func TestPolicyDocumentHandler(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(1.0, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	router, err := NewPolicyRouter(limiter.manager, []Policy{
+		{Name: "test_policy_search", Path: "/api/search", Rate: 2, Capacity: 10},
+		{Name: "test_policy_upload", Path: "/api/upload/*", Rate: 0.5, Capacity: 1, Key: "header:X-API-Key"},
+	}, WithHeaderNames(IETFHeaders))
+	if err != nil {
+		t.Fatalf("Failed to create policy router: %v", err)
+	}
+
+	app := fiber.New()
+	app.Get(PolicyDocumentPath, PolicyDocumentHandler(router))
+	fetch := func() map[string]interface{} {
+		resp, err := app.Test(httptest.NewRequest("GET", PolicyDocumentPath, nil))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		if resp.StatusCode != fiber.StatusOK || resp.Header.Get("Content-Type") != fiber.MIMEApplicationJSON {
+			t.Fatalf("Unexpected response %d with content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(resp.Body)
+		var doc map[string]interface{}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			t.Fatalf("Failed to decode document %s: %v", body, err)
+		}
+		return doc
+	}
+
+	expected := map[string]interface{}{
+		"version": float64(1),
+		"headers": map[string]interface{}{
+			"limit":      "RateLimit-Limit",
+			"remaining":  "RateLimit-Remaining",
+			"retryAfter": "Retry-After",
+			"reset":      "RateLimit-Reset",
+			"combined":   "RateLimit",
+		},
+		"policies": []interface{}{
+			map[string]interface{}{
+				"name": "test_policy_search", "path": "/api/search", "key": "ip", "algorithm": AlgorithmTokenBucket,
+				"rate": float64(2), "capacity": float64(10), "refillSeconds": float64(5),
+			},
+			map[string]interface{}{
+				"name": "test_policy_upload", "path": "/api/upload/*", "key": "header:X-API-Key", "algorithm": AlgorithmTokenBucket,
+				"rate": 0.5, "capacity": float64(1), "refillSeconds": float64(2),
+			},
+		},
+	}
+	if doc := fetch(); !reflect.DeepEqual(doc, expected) {
+		t.Errorf("Unexpected document:\n%v\nexpected:\n%v", doc, expected)
+	}
+
+	// Runtime limit changes show up in the next fetch
+	if err := router.Limiter("test_policy_search").SetLimits(4, 20); err != nil {
+		t.Fatalf("Failed to set limits: %v", err)
+	}
+	search := fetch()["policies"].([]interface{})[0].(map[string]interface{})
+	if search["rate"] != float64(4) || search["capacity"] != float64(20) {
+		t.Errorf("Expected the updated limits, got %v", search)
+	}
+}
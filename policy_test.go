@@ -0,0 +1,175 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestPolicyRouterIndependentBuckets tests that each policy limits its own paths with its own bucket
+func TestPolicyRouterIndependentBuckets(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.01, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	router, err := NewPolicyRouter(limiter.manager, []Policy{
+		{Name: "test_policy_search", Path: "/api/search", Rate: 0.01, Capacity: 2},
+		{Name: "test_policy_upload", Path: "/api/upload/*", Rate: 0.01, Capacity: 1, Key: "header:X-API-Key"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create policy router: %v", err)
+	}
+
+	app := fiber.New()
+	app.Use(router.Handler())
+	app.All("/*", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	request := func(path string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-API-Key", "test_key")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Error making request: %v", err)
+		}
+		return resp.StatusCode
+	}
+
+	// Exhaust the search policy
+	for i := 0; i < 2; i++ {
+		if status := request("/api/search"); status != fiber.StatusOK {
+			t.Fatalf("Search request %d: expected 200, got %d", i+1, status)
+		}
+	}
+	if status := request("/api/search"); status != fiber.StatusTooManyRequests {
+		t.Errorf("Expected search to be limited, got %d", status)
+	}
+
+	// The upload policy still has its own tokens
+	if status := request("/api/upload/files"); status != fiber.StatusOK {
+		t.Errorf("Expected upload to be allowed, got %d", status)
+	}
+	if status := request("/api/upload/files"); status != fiber.StatusTooManyRequests {
+		t.Errorf("Expected upload to be limited, got %d", status)
+	}
+
+	// Paths without a policy are not limited
+	for i := 0; i < 3; i++ {
+		if status := request("/api/other"); status != fiber.StatusOK {
+			t.Errorf("Unmatched request %d: expected 200, got %d", i+1, status)
+		}
+	}
+}
+
+// TestRateLimitWithConfigIndependentRoutes tests that exhausting one route's limit doesn't affect another
+func TestRateLimitWithConfigIndependentRoutes(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.01, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	ok := func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	}
+	app := fiber.New()
+	app.Get("/api/search", RateLimitWithConfig(limiter.manager, RateLimitConfig{Rate: 0.01, Capacity: 2, KeyPrefix: "test_search"}), ok)
+	app.Get("/api/upload", RateLimitWithConfig(limiter.manager, RateLimitConfig{Rate: 0.01, Capacity: 1, KeyPrefix: "test_upload"}), ok)
+
+	request := func(path string) int {
+		resp, err := app.Test(httptest.NewRequest("GET", path, nil))
+		if err != nil {
+			t.Fatalf("Error making request: %v", err)
+		}
+		return resp.StatusCode
+	}
+
+	// Exhaust the search route
+	for i := 0; i < 2; i++ {
+		if status := request("/api/search"); status != fiber.StatusOK {
+			t.Fatalf("Search request %d: expected 200, got %d", i+1, status)
+		}
+	}
+	if status := request("/api/search"); status != fiber.StatusTooManyRequests {
+		t.Errorf("Expected search to be limited, got %d", status)
+	}
+
+	// The upload route still has its own token for the same IP
+	if status := request("/api/upload"); status != fiber.StatusOK {
+		t.Errorf("Expected upload to be allowed, got %d", status)
+	}
+	if status := request("/api/upload"); status != fiber.StatusTooManyRequests {
+		t.Errorf("Expected upload to be limited, got %d", status)
+	}
+}
+
+// TestPolicyMatch tests exact and prefix path matching in policy order
+func TestPolicyMatch(t *testing.T) {
+	manager, err := NewRedisShardManager([]string{"localhost:6379"})
+	if err != nil {
+		t.Fatalf("Failed to create shard manager: %v", err)
+	}
+
+	router, err := NewPolicyRouter(manager, []Policy{
+		{Name: "login", Path: "/api/login", Rate: 1, Capacity: 5},
+		{Name: "api", Path: "/api/*", Rate: 10, Capacity: 100},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create policy router: %v", err)
+	}
+
+	cases := map[string]string{
+		"/api/login":    "login",
+		"/api/login/x":  "api",
+		"/api":          "api",
+		"/api/resource": "api",
+		"/apiary":       "",
+		"/":             "",
+	}
+	for path, expected := range cases {
+		name := ""
+		if p := router.Match(path); p != nil {
+			name = p.Name
+		}
+		if name != expected {
+			t.Errorf("Match(%q) = %q, expected %q", path, name, expected)
+		}
+	}
+	if router.Limiter("login") == nil || router.Limiter("missing") != nil {
+		t.Error("Expected Limiter to return only configured policies")
+	}
+}
+
+// TestLoadPoliciesValidation tests that invalid policies are rejected
+func TestLoadPoliciesValidation(t *testing.T) {
+	manager, err := NewRedisShardManager([]string{"localhost:6379"})
+	if err != nil {
+		t.Fatalf("Failed to create shard manager: %v", err)
+	}
+
+	invalid := [][]Policy{
+		{{Path: "/a", Rate: 1, Capacity: 1}},
+		{{Name: "a", Path: "a", Rate: 1, Capacity: 1}},
+		{{Name: "a", Path: "/a", Rate: 0, Capacity: 1}},
+		{{Name: "a", Path: "/a", Rate: 1, Capacity: 1, Key: "cookie:session"}},
+		{{Name: "a", Path: "/a", Rate: 1, Capacity: 1, Key: "header:"}},
+		{{Name: "a", Path: "/a", Rate: 1, Capacity: 1}, {Name: "a", Path: "/b", Rate: 1, Capacity: 1}},
+	}
+	for i, policies := range invalid {
+		if _, err := LoadPolicies(manager, policies); err == nil {
+			t.Errorf("Case %d: expected an error for %+v", i, policies)
+		}
+	}
+
+	limiters, err := LoadPolicies(manager, []Policy{{Name: "a", Path: "/a", Rate: 1, Capacity: 1}})
+	if err != nil {
+		t.Fatalf("Expected valid policies to load, got %v", err)
+	}
+	if got := limiters["a"].bucketKey("user"); got != "ratelimit:tb:a:user" {
+		t.Errorf("Expected prefixed bucket key, got %q", got)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// protocolKeyPrefix namespaces protocol-scoped keys so they can't collide with plain keys
+const protocolKeyPrefix = "proto:"
+
+// RequestProtocol returns the HTTP version of the request line, e.g. "HTTP/1.1".
+// Unlike c.Protocol(), which returns the scheme, this tells HTTP versions apart.
+// Behind a proxy it's the version spoken by the proxy, not by the client.
+func RequestProtocol(c *fiber.Ctx) string {
+	return string(c.Request().Header.Protocol())
+}
+
+// ProtocolKeyFunc returns a KeyFunc giving each protocol group its own bucket: the
+// key of base is namespaced as "proto:<group>:<key>". groups maps HTTP versions to
+// group names, e.g. {"HTTP/1.0": "h1", "HTTP/1.1": "h1", "HTTP/2.0": "h2"}, so that
+// several versions can share a bucket; versions missing from groups form their own
+// group named after the version. Requests for which base returns an empty key keep
+// the empty key and are rejected.
+func ProtocolKeyFunc(base KeyFunc, groups map[string]string) KeyFunc {
+	return func(c *fiber.Ctx) string {
+		key := base(c)
+		if key == "" {
+			return ""
+		}
+		protocol := RequestProtocol(c)
+		if group, ok := groups[protocol]; ok {
+			protocol = group
+		}
+		return protocolKeyPrefix + protocol + ":" + key
+	}
+}
+
+// ProtocolCostFunc returns a CostFunc charging each request the cost of its HTTP
+// version, e.g. {"HTTP/1.0": 3, "HTTP/1.1": 3} to make HTTP/1.x scrapers drain their
+// bucket faster. Versions missing from costs are charged defaultCost.
+func ProtocolCostFunc(costs map[string]float64, defaultCost float64) CostFunc {
+	return func(c *fiber.Ctx) (float64, error) {
+		if cost, ok := costs[RequestProtocol(c)]; ok {
+			return cost, nil
+		}
+		return defaultCost, nil
+	}
+}
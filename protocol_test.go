@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// newProtocolRequest creates a test request with the given HTTP version on its request line
+func newProtocolRequest(major, minor int) *http.Request {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.ProtoMajor, req.ProtoMinor = major, minor
+	return req
+}
+
+// TestProtocolKeyFunc tests that protocol groups are namespaced into distinct keys
+func TestProtocolKeyFunc(t *testing.T) {
+	keyFunc := ProtocolKeyFunc(func(c *fiber.Ctx) string { return c.Get("X-User") }, map[string]string{
+		"HTTP/1.0": "h1",
+		"HTTP/1.1": "h1",
+	})
+
+	var got string
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		got = keyFunc(c)
+		return nil
+	})
+
+	for _, tt := range []struct {
+		major, minor int
+		user         string
+		expected     string
+	}{
+		{1, 1, "alice", "proto:h1:alice"},
+		{1, 0, "alice", "proto:h1:alice"},
+		{2, 0, "alice", "proto:HTTP/2.0:alice"},
+		{1, 1, "", ""},
+	} {
+		req := newProtocolRequest(tt.major, tt.minor)
+		req.Header.Set("X-User", tt.user)
+		if _, err := app.Test(req); err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		if got != tt.expected {
+			t.Errorf("Key for HTTP/%d.%d user %q = %q, expected %q", tt.major, tt.minor, tt.user, got, tt.expected)
+		}
+	}
+}
+
+// TestProtocolCostFunc tests that HTTP/1.0 requests are charged a higher cost than HTTP/1.1 ones
+func TestProtocolCostFunc(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.01, 10.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	client := limiter.manager.GetClient(testClientIP)
+	client.Del(testCtx, testBucketKey(testClientIP))
+	defer client.Del(testCtx, testBucketKey(testClientIP))
+
+	app := newTestApp(RateLimitMiddleware(limiter, WithCostFunc(ProtocolCostFunc(map[string]float64{"HTTP/1.0": 4}, 1))))
+	for _, tt := range []struct {
+		minor     int
+		remaining string
+	}{
+		{1, "9"},
+		{0, "5"},
+	} {
+		resp, err := app.Test(newProtocolRequest(1, tt.minor))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		if got := resp.Header.Get("X-RateLimit-Remaining"); got != tt.remaining {
+			t.Errorf("HTTP/1.%d request: expected %s remaining, got %q", tt.minor, tt.remaining, got)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+)
+
+// NewTLSConfig builds the TLS settings of Redis connections. caFile, if set, replaces
+// the system roots with the PEM certificates it contains, e.g. a provider's private
+// CA. certFile and keyFile, set together, present a client certificate for mutual
+// TLS. skipVerify accepts any server certificate, which makes the connection open to
+// interception: only use it against self-signed test setups.
+func NewTLSConfig(skipVerify bool, caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: skipVerify,
+	}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in CA file %s", caFile)
+		}
+	}
+
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("a client certificate needs both a certificate and a key file")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// TLSConfigFromEnv builds the TLS settings of Redis connections from REDIS_TLS,
+// REDIS_TLS_SKIP_VERIFY, REDIS_TLS_CA, REDIS_TLS_CERT and REDIS_TLS_KEY, returning
+// nil for plaintext connections when REDIS_TLS isn't true
+func TLSConfigFromEnv() (*tls.Config, error) {
+	enabled, err := envBool("REDIS_TLS")
+	if err != nil {
+		return nil, err
+	}
+	skipVerify, err := envBool("REDIS_TLS_SKIP_VERIFY")
+	if err != nil {
+		return nil, err
+	}
+	caFile, certFile, keyFile := os.Getenv("REDIS_TLS_CA"), os.Getenv("REDIS_TLS_CERT"), os.Getenv("REDIS_TLS_KEY")
+
+	if !enabled {
+		if skipVerify || caFile != "" || certFile != "" || keyFile != "" {
+			return nil, fmt.Errorf("REDIS_TLS_* settings require REDIS_TLS=true")
+		}
+		return nil, nil
+	}
+	if skipVerify {
+		log.Printf("WARNING: REDIS_TLS_SKIP_VERIFY is set, Redis server certificates are not verified and connections can be intercepted")
+	}
+	return NewTLSConfig(skipVerify, caFile, certFile, keyFile)
+}
+
+// envBool parses the boolean environment variable name, false when it's unset
+func envBool(name string) (bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return false, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q, expected true or false", name, value)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestShardOptionsTLS tests that TLS applies to bare addresses and rediss:// URLs enable it on their own
+func TestShardOptionsTLS(t *testing.T) {
+	tlsConfig, err := NewTLSConfig(false, "", "", "")
+	if err != nil {
+		t.Fatalf("NewTLSConfig failed: %v", err)
+	}
+
+	options, err := shardOptions([]string{"localhost:6379", "rediss://cache.example.com:6380/0"}, ShardAuth{TLSConfig: tlsConfig})
+	if err != nil {
+		t.Fatalf("shardOptions failed: %v", err)
+	}
+	if options[0].TLSConfig != tlsConfig {
+		t.Errorf("Expected the bare address to use the TLS config, got %+v", options[0].TLSConfig)
+	}
+	if options[1].TLSConfig == nil || options[1].TLSConfig.ServerName != "cache.example.com" {
+		t.Errorf("Expected the rediss:// URL to enable TLS for its host, got %+v", options[1].TLSConfig)
+	}
+
+	plain, err := shardOptions([]string{"localhost:6379", "redis://localhost:6380/0"}, ShardAuth{})
+	if err != nil {
+		t.Fatalf("shardOptions failed: %v", err)
+	}
+	for i, opt := range plain {
+		if opt.TLSConfig != nil {
+			t.Errorf("Expected shard %d to stay plaintext, got %+v", i, opt.TLSConfig)
+		}
+	}
+}
+
+// TestTLSConfigFromEnv tests the REDIS_TLS* environment variables
+func TestTLSConfigFromEnv(t *testing.T) {
+	tlsConfig, err := TLSConfigFromEnv()
+	if err != nil || tlsConfig != nil {
+		t.Errorf("Expected plaintext without REDIS_TLS, got %+v (%v)", tlsConfig, err)
+	}
+
+	t.Setenv("REDIS_TLS_SKIP_VERIFY", "true")
+	if _, err := TLSConfigFromEnv(); err == nil {
+		t.Errorf("Expected an error for TLS settings without REDIS_TLS")
+	}
+
+	t.Setenv("REDIS_TLS", "true")
+	tlsConfig, err = TLSConfigFromEnv()
+	if err != nil {
+		t.Fatalf("TLSConfigFromEnv failed: %v", err)
+	}
+	if tlsConfig == nil || !tlsConfig.InsecureSkipVerify {
+		t.Errorf("Expected a TLS config skipping verification, got %+v", tlsConfig)
+	}
+
+	t.Setenv("REDIS_TLS", "yes please")
+	if _, err := TLSConfigFromEnv(); err == nil {
+		t.Errorf("Expected an error for an invalid REDIS_TLS")
+	}
+}
+
+// TestNewTLSConfigInvalidFiles tests that unusable certificate files are reported
+func TestNewTLSConfigInvalidFiles(t *testing.T) {
+	notPEM := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+
+	tests := []struct {
+		name                      string
+		caFile, certFile, keyFile string
+	}{
+		{"missing CA file", filepath.Join(t.TempDir(), "missing.pem"), "", ""},
+		{"CA file without certificates", notPEM, "", ""},
+		{"certificate without key", "", notPEM, ""},
+		{"invalid key pair", "", notPEM, notPEM},
+	}
+	for _, tt := range tests {
+		if _, err := NewTLSConfig(false, tt.caFile, tt.certFile, tt.keyFile); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// setupTestClient returns a client of the Redis server the tests run against
+func setupTestClient(t *testing.T) *redis.Client {
+	t.Helper()
+	limiter, cleanup, err := setupTestRateLimiter(1.0, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	t.Cleanup(cleanup)
+	return limiter.manager.shards[0]
+}
+
+// TestRedisServerVersion tests that the suite runs against the Redis version selected
+// with REDIS_EXPECT_VERSION, so a misconfigured version matrix fails loudly
+func TestRedisServerVersion(t *testing.T) {
+	client := setupTestClient(t)
+
+	expected := os.Getenv("REDIS_EXPECT_VERSION")
+	info, err := client.Info(testCtx, "server").Result()
+	if err != nil {
+		if expected == "" {
+			t.Skipf("Server doesn't report its version: %v", err)
+		}
+		t.Fatalf("INFO failed: %v", err)
+	}
+	version := ""
+	for _, line := range strings.Split(info, "\n") {
+		if strings.HasPrefix(line, "redis_version:") {
+			version = strings.TrimSpace(strings.TrimPrefix(line, "redis_version:"))
+		}
+	}
+
+	if expected == "" {
+		t.Logf("Running against Redis version %q", version)
+		return
+	}
+	if version != expected && !strings.HasPrefix(version, expected+".") {
+		t.Fatalf("Expected Redis version %s, connected to %q", expected, version)
+	}
+}
+
+// TestLuaReplyTypes tests that the number types Lua replies are converted into on
+// the server under test are all understood by parseLuaNumber
+func TestLuaReplyTypes(t *testing.T) {
+	client := setupTestClient(t)
+
+	result, err := client.Eval(testCtx, "return {7, 2.5, tostring(2.5), redis.call('TIME')}", nil).Result()
+	if err != nil {
+		t.Fatalf("EVAL failed: %v", err)
+	}
+	values, ok := result.([]interface{})
+	if !ok || len(values) != 4 {
+		t.Fatalf("Unexpected reply %v (%T)", result, result)
+	}
+
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected float64
+	}{
+		{"integer", values[0], 7},
+		// Lua numbers are truncated to integers, which is why the scripts use tostring
+		{"truncated float", values[1], 2},
+		{"string", values[2], 2.5},
+	}
+	for _, tt := range tests {
+		got, err := parseLuaNumber(tt.value)
+		if err != nil {
+			t.Errorf("%s: failed to parse %v (%T): %v", tt.name, tt.value, tt.value, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+
+	// TIME replies {seconds, microseconds}
+	time, ok := values[3].([]interface{})
+	if !ok || len(time) != 2 {
+		t.Fatalf("Unexpected TIME reply %v (%T)", values[3], values[3])
+	}
+	for _, part := range time {
+		if _, err := parseLuaNumber(part); err != nil {
+			t.Errorf("Failed to parse TIME part %v (%T): %v", part, part, err)
+		}
+	}
+}
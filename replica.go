@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// AttachReplicas connects a read replica to each shard, addresses[i] replicating
+// shard i. Entries may be host:port addresses, Unix socket paths or URLs; an empty
+// entry leaves its shard without a replica. Replicas only serve reads that tolerate
+// staleness, such as PeekStale.
+func (rsm *RedisShardManager) AttachReplicas(addresses []string) error {
+	if rsm.cluster != nil {
+		return fmt.Errorf("replicas of a Redis Cluster are managed by the cluster")
+	}
+	rsm.mu.Lock()
+	defer rsm.mu.Unlock()
+
+	if len(addresses) != len(rsm.shards) {
+		return fmt.Errorf("expected %d replica addresses, one per shard, got %d", len(rsm.shards), len(addresses))
+	}
+
+	replicas := make([]*redis.Client, len(addresses))
+	for i, addr := range addresses {
+		if addr == "" {
+			continue
+		}
+
+		opt, err := addressOptions(addr)
+		if err != nil {
+			return fmt.Errorf("failed to parse Redis URL for replica of shard %d: %w", i, err)
+		}
+		applyClientDefaults(opt)
+
+		client := redis.NewClient(opt)
+		if _, err := client.Ping(ctx).Result(); err != nil {
+			log.Printf("ERROR: Critical Redis Error: Connection failure to Redis replica at %s - %v", opt.Addr, err)
+			return fmt.Errorf("failed to connect to Redis replica at %s: %w", opt.Addr, err)
+		}
+
+		replicas[i] = client
+		fmt.Printf("Successfully connected to Redis replica of shard %d at %s\n", i, opt.Addr)
+	}
+
+	rsm.replicas = replicas
+	return nil
+}
+
+// addressOptions returns the client options for a host:port address, Unix socket path or URL
+func addressOptions(addr string) (*redis.Options, error) {
+	switch {
+	case isRedisURL(addr):
+		return redis.ParseURL(addr)
+	case isUnixSocketPath(addr):
+		return &redis.Options{Addr: addr, Network: "unix"}, nil
+	default:
+		return &redis.Options{Addr: addr}, nil
+	}
+}
+
+// GetReplicaClient returns the read replica of the shard owning the given userID,
+// falling back to the shard itself when it has no replica. In cluster mode it returns
+// the master.
+func (rsm *RedisShardManager) GetReplicaClient(userID string) *redis.Client {
+	if rsm.cluster != nil {
+		return rsm.clusterClient(userID)
+	}
+	rsm.mu.RLock()
+	defer rsm.mu.RUnlock()
+
+	index := shardFor(rsm.seed, userID, rsm.currentShardIDs())
+	if index < len(rsm.replicas) && rsm.replicas[index] != nil {
+		return rsm.replicas[index]
+	}
+	return rsm.shards[index]
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// defaultReservationTTL is how long a reservation can be cancelled before it's committed automatically
+const defaultReservationTTL = 30 * time.Second
+
+// ErrReservationNotFound is returned for reservations that don't exist, were already
+// committed or cancelled, or expired
+var ErrReservationNotFound = errors.New("reservation not found")
+
+// tokenReserveLuaScript is the Lua script for atomically consuming tokens and recording the reservation
+// KEYS[1] = bucket, KEYS[2] = reservation; ARGV as for tokenBucketLuaScript, plus
+// ARGV[11] = reservation TTL in milliseconds
+const tokenReserveLuaScript = `
+local key = KEYS[1]
+local rate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local now = serverTime()
+local requested = tonumber(ARGV[3])
+local initial = tonumber(ARGV[4])
+local ttl = tonumber(ARGV[5])
+local ttlUnit = ARGV[6]
+local trackCreatedAt = ARGV[7] == '1'
+local reservationTTL = tonumber(ARGV[11])
+
+-- Get current state from storage, new buckets start with the initial tokens
+local storedTokens, storedLastRefill, createdAt = readBucket(key)
+local tokens = tonumber(storedTokens) or initial
+local lastRefill = tonumber(storedLastRefill) or now
+if not storedTokens and trackCreatedAt then
+    createdAt = now
+end
+
+-- Refill tokens based on elapsed time and rate
+local elapsed = now - lastRefill
+if elapsed > 0 then
+    tokens = math.min(capacity, tokens + elapsed * rate)
+end
+
+-- Blocked reservations don't change the bucket
+if tokens < requested then
+    return {0, tostring(tokens)}
+end
+tokens = tokens - requested
+
+writeBucket(key, tokens, math.max(lastRefill, now), createdAt)
+-- Expire after the configured inactivity TTL
+if ttlUnit == 'ms' then
+    redis.call('PEXPIRE', key, ttl)
+else
+    redis.call('EXPIRE', key, ttl)
+end
+
+-- Remember the reserved tokens until the reservation is resolved or expires
+redis.call('SET', KEYS[2], tostring(requested), 'PX', reservationTTL)
+
+return {1, tostring(tokens)}
+`
+
+// tokenCancelLuaScript is the Lua script for atomically dropping a reservation and refunding its tokens
+// KEYS[1] = bucket, KEYS[2] = reservation; ARGV as for tokenRefundLuaScript (ARGV[3] unused)
+const tokenCancelLuaScript = `
+local key = KEYS[1]
+local rate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local now = serverTime()
+local initial = tonumber(ARGV[4])
+local ttl = tonumber(ARGV[5])
+local ttlUnit = ARGV[6]
+local trackCreatedAt = ARGV[7] == '1'
+
+local reserved = tonumber(redis.call('GET', KEYS[2]))
+if not reserved then
+    return 0
+end
+redis.call('DEL', KEYS[2])
+
+-- Get current state from storage, new buckets start with the initial tokens
+local storedTokens, storedLastRefill, createdAt = readBucket(key)
+local tokens = tonumber(storedTokens) or initial
+local lastRefill = tonumber(storedLastRefill) or now
+if not storedTokens and trackCreatedAt then
+    createdAt = now
+end
+
+-- Apply the refill owed since the last update before returning the reserved tokens
+local elapsed = now - lastRefill
+if elapsed > 0 then
+    tokens = math.min(capacity, tokens + elapsed * rate)
+end
+tokens = math.min(capacity, tokens + reserved)
+
+writeBucket(key, tokens, math.max(lastRefill, now), createdAt)
+-- Expire after the configured inactivity TTL
+if ttlUnit == 'ms' then
+    redis.call('PEXPIRE', key, ttl)
+else
+    redis.call('EXPIRE', key, ttl)
+end
+
+return 1
+`
+
+// WithReservationTTL sets how long a reservation made by Reserve can be cancelled
+// (default 30s). Reservations neither committed nor cancelled in time are committed.
+func WithReservationTTL(ttl time.Duration) LimiterOption {
+	return func(rl *RateLimiter) {
+		rl.reservationTTL = ttl
+	}
+}
+
+// reservationKey returns the Redis key of a reservation, embedding its userID like
+// the user's other keys so that it shares their slot in cluster mode
+func (rl *RateLimiter) reservationKey(reservationID string) string {
+	random, userID, _ := strings.Cut(reservationID, ":")
+	return fmt.Sprintf("ratelimit:reservation:%s:%s", random, rl.manager.keyUserID(userID))
+}
+
+// parseReservationID returns the userID a reservation was made for. IDs have the
+// form "<random hex>:<userID>" so that they route to the shard of the user's bucket.
+func parseReservationID(reservationID string) (string, error) {
+	_, userID, ok := strings.Cut(reservationID, ":")
+	if !ok || userID == "" {
+		return "", fmt.Errorf("invalid reservation ID %q: %w", reservationID, ErrReservationNotFound)
+	}
+	return userID, nil
+}
+
+// Reserve holds n tokens of userID's bucket for a multi-step operation, returning
+// the ID with which the caller later resolves the reservation: Commit once the
+// operation succeeded, or Cancel to refund the tokens if it aborted. The tokens are
+// consumed immediately and atomically with recording the reservation.
+// ErrInsufficientTokens is returned if the bucket can't cover n tokens.
+func (rl *RateLimiter) Reserve(userID string, n float64) (string, error) {
+	if n <= 0 {
+		return "", fmt.Errorf("reservation amount must be positive, got %v", n)
+	}
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to generate reservation ID: %w", err)
+	}
+	reservationID := hex.EncodeToString(random) + ":" + userID
+
+	keys := []string{rl.bucketKey(userID), rl.reservationKey(reservationID)}
+	args := append(rl.bucketArgs(n), max(1, rl.reservationTTL.Milliseconds()))
+
+	script := rl.bucketScript(tokenReserveLuaScript)
+	result, err := rl.manager.runOnShard(ctx, userID, script, keys, args...).Result()
+	if err != nil {
+		log.Printf("ERROR: Critical Redis Error: Lua reserve script execution failure for userID %s - %v", userID, err)
+		return "", fmt.Errorf("failed to execute reserve script: %w", err)
+	}
+
+	reservation, err := rl.parseAllowReply(userID, result)
+	if err != nil {
+		return "", err
+	}
+	if !reservation.Allowed {
+		return "", fmt.Errorf("failed to reserve %v tokens for userID %s with %.2f available: %w", n, userID, reservation.Remaining, ErrInsufficientTokens)
+	}
+
+	return reservationID, nil
+}
+
+// Commit resolves a reservation whose operation succeeded. The reserved tokens were
+// already consumed by Reserve, so this only forgets the reservation.
+func (rl *RateLimiter) Commit(reservationID string) error {
+	userID, err := parseReservationID(reservationID)
+	if err != nil {
+		return err
+	}
+
+	var deleted int64
+	err = rl.manager.guardUser(userID, func(client *redis.Client) error {
+		deleted, err = client.Del(ctx, rl.reservationKey(reservationID)).Result()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to commit reservation: %w", err)
+	}
+	if deleted == 0 {
+		return ErrReservationNotFound
+	}
+
+	return nil
+}
+
+// Cancel resolves a reservation whose operation aborted, atomically refunding the
+// reserved tokens to the bucket (capped at capacity)
+func (rl *RateLimiter) Cancel(reservationID string) error {
+	userID, err := parseReservationID(reservationID)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{rl.bucketKey(userID), rl.reservationKey(reservationID)}
+
+	script := rl.bucketScript(tokenCancelLuaScript)
+	cancelled, err := rl.manager.runOnShard(ctx, userID, script, keys, rl.bucketArgs(0)...).Int()
+	if err != nil {
+		log.Printf("ERROR: Critical Redis Error: Lua cancel script execution failure for userID %s - %v", userID, err)
+		return fmt.Errorf("failed to execute cancel script: %w", err)
+	}
+	if cancelled == 0 {
+		return ErrReservationNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestReserveCommitCancel tests that reserved tokens are consumed, kept on commit and
+// refunded on cancel
+func TestReserveCommitCancel(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.001, 10.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	userID := "test_reserve"
+	client := limiter.manager.GetClient(userID)
+
+	committed, err := limiter.Reserve(userID, 3)
+	if err != nil {
+		t.Fatalf("Reserve failed: %v", err)
+	}
+	cancelled, err := limiter.Reserve(userID, 4)
+	if err != nil {
+		t.Fatalf("Reserve failed: %v", err)
+	}
+	defer client.Del(testCtx, limiter.reservationKey(committed), limiter.reservationKey(cancelled))
+	assertTokens(t, limiter, userID, 3)
+
+	// Only 3 tokens are left to reserve
+	if _, err := limiter.Reserve(userID, 4); !errors.Is(err, ErrInsufficientTokens) {
+		t.Errorf("Expected ErrInsufficientTokens, got %v", err)
+	}
+	assertTokens(t, limiter, userID, 3)
+
+	if err := limiter.Commit(committed); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	assertTokens(t, limiter, userID, 3)
+
+	if err := limiter.Cancel(cancelled); err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+	assertTokens(t, limiter, userID, 7)
+
+	// Resolved reservations can't be resolved again
+	if err := limiter.Cancel(cancelled); !errors.Is(err, ErrReservationNotFound) {
+		t.Errorf("Expected ErrReservationNotFound for a second cancel, got %v", err)
+	}
+	if err := limiter.Cancel(committed); !errors.Is(err, ErrReservationNotFound) {
+		t.Errorf("Expected ErrReservationNotFound cancelling a committed reservation, got %v", err)
+	}
+	assertTokens(t, limiter, userID, 7)
+}
+
+// TestReservationTTL tests that reservations are recorded with the configured TTL
+func TestReservationTTL(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.001, 10.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	reservationID, err := limiter.Reserve("test_reserve_ttl", 1)
+	if err != nil {
+		t.Fatalf("Reserve failed: %v", err)
+	}
+	client := limiter.manager.GetClient("test_reserve_ttl")
+	defer client.Del(testCtx, limiter.reservationKey(reservationID))
+
+	ttl, err := client.PTTL(testCtx, limiter.reservationKey(reservationID)).Result()
+	if err != nil {
+		t.Fatalf("PTTL failed: %v", err)
+	}
+	if ttl <= 0 || ttl > defaultReservationTTL {
+		t.Errorf("Expected a TTL of at most %v, got %v", defaultReservationTTL, ttl)
+	}
+}
+
+// TestParseReservationID tests that reservation IDs route back to their user
+func TestParseReservationID(t *testing.T) {
+	userID, err := parseReservationID("0a1b:{team1}:alice")
+	if err != nil || userID != "{team1}:alice" {
+		t.Errorf("Expected userID {team1}:alice, got %q (%v)", userID, err)
+	}
+	for _, id := range []string{"", "0a1b", "0a1b:"} {
+		if _, err := parseReservationID(id); !errors.Is(err, ErrReservationNotFound) {
+			t.Errorf("Expected ErrReservationNotFound for %q, got %v", id, err)
+		}
+	}
+}
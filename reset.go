@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// resetScanCount is the SCAN batch size used when resetting buckets
+const resetScanCount = 1000
+
+// resetTimeout bounds the duration of a single scheduled reset
+const resetTimeout = time.Minute
+
+// Reset deletes the bucket of the given userID, e.g. to lift a ban from an admin
+// tool. The next request starts over with the initial tokens. Resetting a userID
+// that has no bucket is a no-op.
+func (rl *RateLimiter) Reset(userID string) error {
+	key := rl.bucketKey(userID)
+	if rl.blockedCache != nil {
+		rl.blockedCache.forget(key)
+	}
+
+	err := rl.manager.guardUser(userID, func(client *redis.Client) error {
+		return client.Del(ctx, key).Err()
+	})
+	if err != nil {
+		log.Printf("ERROR: Critical Redis Error: Failed to reset bucket for userID %s - %v", userID, err)
+		return fmt.Errorf("failed to reset bucket: %w", err)
+	}
+	return nil
+}
+
+// ResetAll deletes every bucket of the limiter on every shard, returning the number
+// of deleted keys. Deleted buckets start over with the initial tokens on their next
+// request. Buckets of limiters with another key prefix and the limiter's other keys,
+// such as penalties, reservations or the global bucket, are kept.
+func (rl *RateLimiter) ResetAll(ctx context.Context) (int64, error) {
+	return rl.ResetMatching(ctx, "*")
+}
+
+// ResetMatching deletes the buckets whose userID matches the Redis glob pattern on
+// every shard, e.g. "free:*" to reset a tier whose userIDs share a prefix. It returns
+// the number of deleted keys. Keys are found with SCAN, so the reset isn't atomic
+// across keys: requests made while it runs may be counted against an old bucket.
+func (rl *RateLimiter) ResetMatching(ctx context.Context, userPattern string) (int64, error) {
+	if rl.blockedCache != nil {
+		rl.blockedCache.clear()
+	}
+
+	var deleted int64
+	for i, shard := range rl.manager.Shards() {
+		iter := shard.Scan(ctx, 0, rl.bucketKeyPattern(userPattern), resetScanCount).Iterator()
+		batch := make([]string, 0, resetScanCount)
+		for iter.Next(ctx) {
+			batch = append(batch, iter.Val())
+			if len(batch) == resetScanCount {
+				n, err := shard.Del(ctx, batch...).Result()
+				deleted += n
+				if err != nil {
+					return deleted, fmt.Errorf("failed to delete buckets on shard %d: %w", i, err)
+				}
+				batch = batch[:0]
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return deleted, fmt.Errorf("failed to scan buckets on shard %d: %w", i, err)
+		}
+		if len(batch) > 0 {
+			n, err := shard.Del(ctx, batch...).Result()
+			deleted += n
+			if err != nil {
+				return deleted, fmt.Errorf("failed to delete buckets on shard %d: %w", i, err)
+			}
+		}
+	}
+	return deleted, nil
+}
+
+// bucketKeyPattern returns the SCAN pattern matching the keys of the limiter's buckets
+// whose userID matches the glob userPattern
+func (rl *RateLimiter) bucketKeyPattern(userPattern string) string {
+	prefix := globEscaper.Replace(rl.keyPrefix)
+	return bucketKeyNamespace + prefix + ":" + rl.manager.keyUserID(userPattern)
+}
+
+// globEscaper escapes the special characters of Redis glob patterns
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// schedule yields the next run time after t
+type schedule interface {
+	Next(t time.Time) time.Time
+}
+
+// ScheduleReset resets the buckets matching userPattern (see ResetMatching) at every
+// time matching the cron spec, evaluated in loc (nil for the local time zone). This
+// expresses quota resets such as "1000 requests per day, resetting at midnight"
+// that continuous refill can't. The scheduler runs in a background goroutine until
+// ctx is done; an invalid spec is returned immediately.
+//
+// Every application instance running the scheduler performs the same reset at the
+// same time. Resets are idempotent, so this is harmless apart from requests made
+// between two instances' resets being forgotten when clocks are skewed.
+func (rl *RateLimiter) ScheduleReset(ctx context.Context, spec string, loc *time.Location, userPattern string) error {
+	cronSchedule, err := ParseCronSchedule(spec)
+	if err != nil {
+		return err
+	}
+	if loc == nil {
+		loc = time.Local
+	}
+
+	log.Printf("INFO: Scheduled reset of buckets matching %q at %q (%s)", userPattern, spec, loc)
+	go rl.runResets(ctx, cronSchedule, loc, userPattern)
+	return nil
+}
+
+// runResets resets the buckets matching userPattern at every time of the schedule until ctx is done
+func (rl *RateLimiter) runResets(ctx context.Context, s schedule, loc *time.Location, userPattern string) {
+	for {
+		next := s.Next(time.Now().In(loc))
+		if next.IsZero() {
+			log.Printf("WARNING: Reset schedule for buckets matching %q has no upcoming run, stopping", userPattern)
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		resetCtx, cancel := context.WithTimeout(ctx, resetTimeout)
+		deleted, err := rl.ResetMatching(resetCtx, userPattern)
+		cancel()
+		if err != nil {
+			log.Printf("ERROR: Critical Redis Error: Scheduled reset of buckets matching %q failed after %d keys - %v", userPattern, deleted, err)
+			continue
+		}
+		log.Printf("INFO: Scheduled reset deleted %d buckets matching %q", deleted, userPattern)
+	}
+}
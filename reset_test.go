@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestReset tests that a reset bucket is full again and that unknown users can be reset
+func TestReset(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.001, 5.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	userID := "test_user_reset"
+	if err := limiter.Reset(userID); err != nil {
+		t.Fatalf("Reset of an unknown user failed: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if result, err := limiter.Allow(userID); err != nil || !result.Allowed {
+			t.Fatalf("Expected request %d to be allowed, got %+v (%v)", i+1, result, err)
+		}
+	}
+	if result, _ := limiter.Allow(userID); result == nil || result.Allowed {
+		t.Fatalf("Expected the drained bucket to block, got %+v", result)
+	}
+
+	if err := limiter.Reset(userID); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	result, err := limiter.Allow(userID)
+	if err != nil {
+		t.Fatalf("Error calling Allow: %v", err)
+	}
+	if !result.Allowed || result.Remaining < 3.99 || result.Remaining > 4.01 {
+		t.Errorf("Expected the reset bucket to start over at capacity, got %+v", result)
+	}
+}
+
+// TestResetMatching tests that only the matching buckets are deleted
+func TestResetMatching(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.001, 5.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	for _, userID := range []string{"test_free:1", "test_free:2", "test_pro:1"} {
+		if _, err := limiter.AllowN(userID, 5); err != nil {
+			t.Fatalf("AllowN failed: %v", err)
+		}
+	}
+
+	deleted, err := limiter.ResetMatching(testCtx, "test_free:*")
+	if err != nil {
+		t.Fatalf("ResetMatching failed: %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("Expected 2 deleted buckets, got %d", deleted)
+	}
+
+	// Reset buckets start over full, others keep their state
+	assertTokens(t, limiter, "test_free:1", 5)
+	assertTokens(t, limiter, "test_pro:1", 0)
+}
+
+// TestResetAllKeepsOtherKeys tests that a reset deletes the limiter's buckets only,
+// not its other keys nor the buckets of limiters with another key prefix
+func TestResetAllKeepsOtherKeys(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.001, 5.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+	WithGlobalKey("ratelimit:test_reset_global")(limiter)
+	other := NewRateLimiter(limiter.manager, 0.001, 5.0, WithKeyPrefix("test_other"))
+	window := NewSlidingWindowLimiter(limiter.manager, 5, time.Minute)
+
+	userID := "test_reset_user"
+	client := limiter.manager.GetClient(enforcementKey)
+	client.Set(testCtx, enforcementKey, "disabled", 0)
+	defer client.Del(testCtx, enforcementKey)
+
+	for name, err := range map[string]error{
+		"bucket":         firstErr(limiter.AllowN(userID, 5)),
+		"other prefix":   firstErr(other.AllowN(userID, 5)),
+		"global bucket":  firstErr(limiter.AllowGlobal(1)),
+		"sliding window": firstErr(window.Allow(userID)),
+	} {
+		if err != nil {
+			t.Fatalf("Failed to create the %s: %v", name, err)
+		}
+	}
+	if _, err := limiter.AddPenalty(testCtx, userID, time.Minute); err != nil {
+		t.Fatalf("AddPenalty failed: %v", err)
+	}
+	reservation, err := limiter.Reserve("test_reset_reserved", 1)
+	if err != nil {
+		t.Fatalf("Reserve failed: %v", err)
+	}
+
+	if _, err := limiter.ResetAll(testCtx); err != nil {
+		t.Fatalf("ResetAll failed: %v", err)
+	}
+
+	if state, _ := limiter.PeekState(userID); state == nil || state.Exists {
+		t.Errorf("Expected the bucket to be reset, got %+v", state)
+	}
+	for _, key := range []string{
+		enforcementKey,
+		other.bucketKey(userID),
+		"ratelimit:test_reset_global",
+		window.slidingWindowKey(userID),
+		limiter.penaltyKey(userID),
+		limiter.reservationKey(reservation),
+	} {
+		if exists, _ := limiter.manager.GetClient(key).Exists(testCtx, key).Result(); exists != 1 {
+			t.Errorf("Expected %s to survive the reset", key)
+		}
+	}
+}
+
+// firstErr returns the error of a check, dropping its result
+func firstErr(_ *AllowResult, err error) error {
+	return err
+}
+
+// fixedSchedule runs every interval
+type fixedSchedule struct {
+	interval time.Duration
+}
+
+func (s fixedSchedule) Next(t time.Time) time.Time {
+	return t.Add(s.interval)
+}
+
+// TestRunResets tests that the scheduler resets buckets and stops with its context
+func TestRunResets(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.001, 5.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	if _, err := limiter.AllowN("test_scheduled", 5); err != nil {
+		t.Fatalf("AllowN failed: %v", err)
+	}
+
+	schedulerCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		limiter.runResets(schedulerCtx, fixedSchedule{20 * time.Millisecond}, time.UTC, "test_scheduled")
+		close(done)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		state, err := limiter.PeekState("test_scheduled")
+		if err != nil {
+			t.Fatalf("PeekState failed: %v", err)
+		}
+		if !state.Exists {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the bucket to be reset by the scheduler")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the scheduler to stop when its context is cancelled")
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// The response mapping of limiter decisions is shared by RateLimitMiddleware and
+// HTTPMiddleware, so both frameworks send the same headers and bodies.
+
+// errorBody is the JSON body of requests rejected by the middleware
+type errorBody struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+var (
+	// missingIdentityBody rejects requests without a rate limit key (401)
+	missingIdentityBody = errorBody{
+		Error:   "Missing client identity",
+		Message: "The request does not carry the identity required for rate limiting.",
+	}
+	// rateLimitExceededBody rejects requests over the limit (429)
+	rateLimitExceededBody = errorBody{
+		Error:   "Rate limit exceeded",
+		Message: "Too many requests. Please try again later.",
+	}
+	// limiterUnavailableBody rejects requests whose limit can't be verified (503)
+	limiterUnavailableBody = errorBody{
+		Error:   "Rate limiter unavailable",
+		Message: "Rate limiting is temporarily unavailable. Please try again later.",
+	}
+)
+
+// headerValue is a response header, named by a HeaderNames field
+type headerValue struct {
+	name  string
+	value string
+}
+
+// limitHeaders returns the limit and remaining headers reporting result of a check
+// charging cost, along with the reset headers for limiters refilling at a constant
+// rate, and the limit they report (0 when the limiter has no capacity). The reset is
+// the time until the bucket is full again, rounded up to whole seconds, or with
+// ResetTimestamp the Unix time it's full again, from the result's ResetAt if set.
+// Unnamed headers, and the limit of limiters reporting no capacity, are skipped.
+func (o *MiddlewareOptions) limitHeaders(limiter Limiter, result *AllowResult, cost float64) (float64, []headerValue) {
+	var headers []headerValue
+	add := func(name, value string) {
+		if name != "" {
+			headers = append(headers, headerValue{name, value})
+		}
+	}
+
+	var limit float64
+	if cl, ok := limiter.(capacityLimiter); ok && cl.Capacity() > 0 {
+		limit = cl.Capacity()
+		add(o.Headers.Limit, fmt.Sprintf("%.0f", limit))
+	}
+	remaining := formatRemaining(reportedRemaining(result, cost, o.RemainingReporting), o.RemainingRounding)
+	add(o.Headers.Remaining, remaining)
+	if rf, ok := limiter.(refillLimiter); ok && limit > 0 {
+		untilFull := timeToFull(result.Remaining, limit, rf.Rate())
+		reset := int(math.Ceil(untilFull.Seconds()))
+		if o.ResetTimestamp {
+			resetAt := result.ResetAt
+			if resetAt.IsZero() {
+				resetAt = time.Now().Add(untilFull)
+			}
+			add(o.Headers.Reset, strconv.FormatInt(int64(math.Ceil(float64(resetAt.UnixNano())/1e9)), 10))
+		} else {
+			add(o.Headers.Reset, strconv.Itoa(reset))
+		}
+		add(o.Headers.Combined, fmt.Sprintf("limit=%.0f, remaining=%s, reset=%d", limit, remaining, reset))
+	}
+	return limit, headers
+}
+
+// bypassHeaders returns the names of the quota headers removed from requests let
+// through without a verified rate limit
+func (h HeaderNames) bypassHeaders() []string {
+	return []string{h.Limit, h.Remaining, h.RetryAfter, h.Reset, h.Combined}
+}
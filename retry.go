@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// WithRetry retries checks that failed to reach Redis up to maxRetries times before
+// the failure mode applies, so that a brief network blip doesn't let a flood through
+// or turn requests away. The first retry waits backoff, doubled after each retry, plus
+// up to 50% random jitter so that instances don't retry in lockstep. Retries stop
+// early when the next wait would outlast the request context. Retries are disabled
+// by default.
+//
+// Only dial errors are retried: the script never reached Redis, so retrying it can't
+// charge a bucket twice. Errors after the script was sent, such as a read timeout or
+// a connection dropped before the reply, may have charged the bucket and are never
+// retried.
+func WithRetry(maxRetries int, backoff time.Duration) LimiterOption {
+	return func(rl *RateLimiter) {
+		rl.maxRetries = maxRetries
+		rl.retryBackoff = backoff
+	}
+}
+
+// isDialError reports whether err is a failure to connect to Redis, before any
+// command was sent
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// runScript runs script on client, the given shard of manager, retrying dial errors
+// as configured by WithRetry. Each attempt holds a slot of the shard's concurrency
+// cap, which is released while backing off.
+func (rl *RateLimiter) runScript(ctx context.Context, manager *RedisShardManager, shard int, client *redis.Client, userID string, script *redis.Script, keys []string, args ...interface{}) (interface{}, error) {
+	run := func() (interface{}, error) {
+		release, err := rl.acquireShard(ctx, manager, shard)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return script.Run(ctx, client, keys, args...).Result()
+	}
+	result, err := run()
+
+	backoff := rl.retryBackoff
+	for attempt := 1; attempt <= rl.maxRetries && isDialError(err); attempt++ {
+		wait := backoff + time.Duration(globalInt63n(int64(float64(backoff)*backoffJitterFraction)+1))
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			break
+		}
+
+		log.Printf("WARNING: Retrying rate limit check for userID %s in %v (retry %d of %d) - %v", userID, wait, attempt, rl.maxRetries, err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		result, err = run()
+		backoff *= 2
+	}
+	return result, err
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// backoffJitterFraction bounds the jitter added by BackoffWithJitter to this
+// fraction of RetryAfter
+const backoffJitterFraction = 0.5
+
+// globalInt63n draws from the global math/rand source, which is safe for concurrent use
+var globalInt63n = rand.Int63n
+
+// RetryAfterFunc computes how long a caller has to wait before a request costing
+// requested tokens can succeed, given the tokens remaining in the bucket and the
+// refill rate in tokens per second
+type RetryAfterFunc func(remaining, requested, rate float64) time.Duration
+
+// DefaultRetryAfter returns the time needed to refill the missing
+// (requested - remaining) tokens at the given rate
+func DefaultRetryAfter(remaining, requested, rate float64) time.Duration {
+	tokensNeeded := requested - remaining
+	if tokensNeeded <= 0 {
+		return 0
+	}
+	return time.Duration(tokensNeeded / rate * float64(time.Second))
+}
+
+// retryAfterHeaderSeconds converts a retry-after duration into the whole seconds
+// sent to clients, rounded up so clients never retry before a token is available,
+// and to at least 1 second for practical purposes. A blocked client is never told to
+// retry immediately, even when the rate exceeds the capacity and the actual wait is
+// a few milliseconds; AllowResult.RetryAfter keeps the exact wait.
+func retryAfterHeaderSeconds(d time.Duration) int {
+	seconds := math.Ceil(d.Seconds())
+	if seconds < 1.0 {
+		seconds = 1.0
+	}
+	return int(seconds)
+}
+
+// BackoffWithJitter returns a suggested wait before retrying: RetryAfter plus a random
+// jitter of up to half of RetryAfter, so clients blocked at the same moment don't all
+// retry at the same moment. The wait is never shorter than RetryAfter. rand may be nil
+// to use the global math/rand source; a *rand.Rand isn't safe for concurrent use.
+func (r *AllowResult) BackoffWithJitter(rand *rand.Rand) time.Duration {
+	maxJitter := int64(float64(r.RetryAfter) * backoffJitterFraction)
+	if maxJitter <= 0 {
+		return r.RetryAfter
+	}
+
+	var jitter int64
+	if rand != nil {
+		jitter = rand.Int63n(maxJitter + 1)
+	} else {
+		jitter = globalInt63n(maxJitter + 1)
+	}
+	return r.RetryAfter + time.Duration(jitter)
+}
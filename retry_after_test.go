@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"math/rand"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestDefaultRetryAfter tests the default retry-after computation for various request costs
+func TestDefaultRetryAfter(t *testing.T) {
+	tests := []struct {
+		name      string
+		remaining float64
+		requested float64
+		rate      float64
+		expected  time.Duration
+	}{
+		{"unit cost with empty bucket", 0, 1, 5, 200 * time.Millisecond},
+		{"unit cost with partial token", 0.5, 1, 1, 500 * time.Millisecond},
+		{"cost of three with one token left", 1, 3, 2, time.Second},
+		{"cost of five with empty bucket", 0, 5, 0.5, 10 * time.Second},
+		{"enough tokens remaining", 4, 3, 5, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DefaultRetryAfter(tt.remaining, tt.requested, tt.rate)
+			if got != tt.expected {
+				t.Errorf("DefaultRetryAfter(%v, %v, %v) = %v, expected %v", tt.remaining, tt.requested, tt.rate, got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestRetryAfterFuncOption tests that a custom retry-after function replaces the default
+func TestRetryAfterFuncOption(t *testing.T) {
+	margin := 250 * time.Millisecond
+	withMargin := func(remaining, requested, rate float64) time.Duration {
+		return DefaultRetryAfter(remaining, requested, rate) + margin
+	}
+
+	limiter := NewRateLimiter(nil, 5.0, 10.0, WithRetryAfterFunc(withMargin))
+	got := limiter.retryAfter(0, 1, limiter.rate)
+	if expected := 200*time.Millisecond + margin; got != expected {
+		t.Errorf("Expected custom retry-after %v, got %v", expected, got)
+	}
+
+	defaultLimiter := NewRateLimiter(nil, 5.0, 10.0)
+	if got := defaultLimiter.retryAfter(0, 1, defaultLimiter.rate); got != 200*time.Millisecond {
+		t.Errorf("Expected default retry-after %v, got %v", 200*time.Millisecond, got)
+	}
+}
+
+// TestBackoffWithJitter tests that the jittered backoff stays within RetryAfter and 1.5x RetryAfter
+func TestBackoffWithJitter(t *testing.T) {
+	result := &AllowResult{RetryAfter: 2 * time.Second}
+	rng := rand.New(rand.NewSource(1))
+
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		backoff := result.BackoffWithJitter(rng)
+		if backoff < 2*time.Second || backoff > 3*time.Second {
+			t.Fatalf("Backoff %v outside of [2s, 3s]", backoff)
+		}
+		seen[backoff] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("Expected jittered backoffs to differ, got %v", seen)
+	}
+
+	// The global source is used without a *rand.Rand
+	if backoff := result.BackoffWithJitter(nil); backoff < 2*time.Second || backoff > 3*time.Second {
+		t.Errorf("Backoff %v outside of [2s, 3s]", backoff)
+	}
+
+	// Allowed results have nothing to wait for
+	if backoff := (&AllowResult{Allowed: true}).BackoffWithJitter(rng); backoff != 0 {
+		t.Errorf("Expected no backoff for an allowed result, got %v", backoff)
+	}
+}
+
+// TestAllowResultRetryAfter tests that blocked results carry the wait computed by the limiter
+func TestAllowResultRetryAfter(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.5, 2.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	result, err := limiter.AllowN("test_retry_after", 2)
+	if err != nil {
+		t.Fatalf("AllowN failed: %v", err)
+	}
+	if result.RetryAfter != 0 {
+		t.Errorf("Expected no retry-after for an allowed request, got %v", result.RetryAfter)
+	}
+
+	// Two missing tokens at 0.5 tokens/sec take just under 4 seconds
+	result, err = limiter.AllowN("test_retry_after", 2)
+	if err != nil {
+		t.Fatalf("AllowN failed: %v", err)
+	}
+	if result.Allowed || result.RetryAfter < 3900*time.Millisecond || result.RetryAfter > 4*time.Second {
+		t.Errorf("Expected a blocked result with a retry-after just under 4s, got %+v", result)
+	}
+}
+
+// TestMiddlewareRetryAfterFromResult tests that the header rounds the exact wait of the
+// limiter's result up to whole seconds, without recomputing it
+func TestMiddlewareRetryAfterFromResult(t *testing.T) {
+	tests := []struct {
+		retryAfter time.Duration
+		expected   string
+	}{
+		{200 * time.Millisecond, "1"},
+		{time.Second, "1"},
+		{2300 * time.Millisecond, "3"},
+		{10 * time.Second, "10"},
+	}
+	for _, tt := range tests {
+		limiter := &fakeCapacityLimiter{}
+		limiter.result = AllowResult{Allowed: false, Remaining: 0.4, RetryAfter: tt.retryAfter}
+		app := newTestApp(RateLimitMiddleware(limiter))
+
+		resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		if got := resp.Header.Get(CanonicalHeaders.RetryAfter); got != tt.expected {
+			t.Errorf("Retry-after %v: expected header %s, got %q", tt.retryAfter, tt.expected, got)
+		}
+	}
+}
+
+// TestRateAboveCapacity tests the startup warning and that the sub-second wait of a
+// bucket refilling faster than its capacity is kept exact but never advertised as 0
+func TestRateAboveCapacity(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	limiter, cleanup, err := setupTestRateLimiter(100.0, 2.0)
+	log.SetOutput(os.Stderr)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	if !strings.Contains(logs.String(), "WARNING: Rate (100.00 tokens/sec) exceeds capacity (2.00 tokens)") {
+		t.Errorf("Expected a rate above capacity warning, got %q", logs.String())
+	}
+
+	// Once drained, a request costing the whole capacity waits at most the 20ms the
+	// bucket takes to refill
+	userID := "test_rate_above_capacity"
+	if result, err := limiter.AllowN(userID, 2); err != nil || !result.Allowed {
+		t.Fatalf("Expected the full bucket to allow 2 tokens, got %+v (%v)", result, err)
+	}
+	result, err := limiter.AllowN(userID, 2)
+	if err != nil {
+		t.Fatalf("AllowN failed: %v", err)
+	}
+	if result.Allowed {
+		t.Fatalf("Expected the drained bucket to block, got %+v", result)
+	}
+	if result.RetryAfter <= 0 || result.RetryAfter > 20*time.Millisecond {
+		t.Errorf("Expected an exact sub-second wait of at most 20ms, got %v", result.RetryAfter)
+	}
+	if got := retryAfterHeaderSeconds(result.RetryAfter); got != 1 {
+		t.Errorf("Expected the header to advertise 1 second, got %d", got)
+	}
+}
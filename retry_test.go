@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// flakyHook is a go-redis hook failing the first failures commands with err, before
+// they're sent, and counting every attempt
+type flakyHook struct {
+	err      error
+	failures int64
+	attempts atomic.Int64
+}
+
+func (h *flakyHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if h.attempts.Add(1) <= h.failures {
+		return ctx, h.err
+	}
+	return ctx, nil
+}
+
+func (h *flakyHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (h *flakyHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h *flakyHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+// dialError is the error of a connection Redis refused
+var dialError = &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+
+// newFlakyLimiter returns a limiter with the given options on a client of addr failing
+// its first commands as hook says
+func newFlakyLimiter(addr string, hook *flakyHook, opts ...LimiterOption) (*RateLimiter, func()) {
+	client := redis.NewClient(&redis.Options{Addr: addr, DialTimeout: 100 * time.Millisecond, MaxRetries: -1})
+	client.AddHook(hook)
+	return NewRateLimiter(&RedisShardManager{shards: []*redis.Client{client}}, 1.0, 10.0, opts...), func() { client.Close() }
+}
+
+// TestIsDialError tests that only failures to connect are classified as dial errors
+func TestIsDialError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"refused", dialError, true},
+		{"wrapped", errors.Join(errors.New("check failed"), dialError), true},
+		{"read timeout", &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}, false},
+		{"redis error", redis.Nil, false},
+		{"nil", nil, false},
+	}
+	for _, tt := range tests {
+		if got := isDialError(tt.err); got != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+}
+
+// TestRetryDialErrors tests that checks failing to connect are retried with backoff
+// until the retries are exhausted, then fail
+func TestRetryDialErrors(t *testing.T) {
+	hook := &flakyHook{}
+	limiter, cleanup := newFlakyLimiter("127.0.0.1:1", hook, WithRetry(2, 10*time.Millisecond))
+	defer cleanup()
+
+	start := time.Now()
+	if _, err := limiter.Allow("test_user_retry"); !isDialError(err) {
+		t.Fatalf("Expected the dial error after the retries, got %v", err)
+	}
+	if attempts := hook.attempts.Load(); attempts != 3 {
+		t.Errorf("Expected 1 attempt and 2 retries, got %d attempts", attempts)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("Expected the retries to back off for at least 10ms + 20ms, took %v", elapsed)
+	}
+}
+
+// TestRetryWithinDeadline tests that no retry is attempted past the request deadline
+func TestRetryWithinDeadline(t *testing.T) {
+	hook := &flakyHook{}
+	limiter, cleanup := newFlakyLimiter("127.0.0.1:1", hook, WithRetry(5, 50*time.Millisecond))
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := limiter.AllowCtx(ctx, "test_user_retry"); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if attempts := hook.attempts.Load(); attempts != 1 {
+		t.Errorf("Expected no retry outlasting the deadline, got %d attempts", attempts)
+	}
+	if elapsed := time.Since(start); elapsed > 30*time.Millisecond {
+		t.Errorf("Expected the check to fail before the deadline, took %v", elapsed)
+	}
+}
+
+// TestRetrySkipsSentScripts tests that errors the script may have run despite, and
+// logical errors, aren't retried
+func TestRetrySkipsSentScripts(t *testing.T) {
+	for _, err := range []error{
+		&net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded},
+		errors.New("ERR Error running script"),
+	} {
+		hook := &flakyHook{err: err, failures: 1}
+		limiter, cleanup := newFlakyLimiter("127.0.0.1:1", hook, WithRetry(3, time.Millisecond))
+		if _, got := limiter.Allow("test_user_retry"); got == nil {
+			t.Errorf("Expected %v to fail the check", err)
+		}
+		if attempts := hook.attempts.Load(); attempts != 1 {
+			t.Errorf("Expected %v not to be retried, got %d attempts", err, attempts)
+		}
+		cleanup()
+	}
+}
+
+// TestRetryRecovers tests that a check succeeding on retry is charged once
+func TestRetryRecovers(t *testing.T) {
+	setup, cleanup, err := setupTestRateLimiter(1.0, 10.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	redisAddr := os.Getenv("REDIS_ADDR")
+	if redisAddr == "" {
+		redisAddr = "localhost:6379"
+	}
+	userID := "test_user_retry_recovers"
+	setup.manager.GetClient(userID).Del(testCtx, testBucketKey(userID))
+
+	hook := &flakyHook{err: dialError, failures: 2}
+	limiter, closeClient := newFlakyLimiter(redisAddr, hook, WithRetry(3, time.Millisecond))
+	defer closeClient()
+
+	result, err := limiter.Allow(userID)
+	if err != nil || !result.Allowed {
+		t.Fatalf("Expected the check to be allowed on retry, got %+v (%v)", result, err)
+	}
+	if result.Remaining < 8.99 || result.Remaining > 9.01 {
+		t.Errorf("Expected the check charged once, leaving 9 tokens, got %v", result.Remaining)
+	}
+	if attempts := hook.attempts.Load(); attempts != 3 {
+		t.Errorf("Expected 2 failed attempts and 1 success, got %d attempts", attempts)
+	}
+}
+
+// newDroppingServer starts a fake Redis server answering PING and dropping the
+// connection on every other command, as if the reply was lost, and returns its address
+// and the number of scripts it was sent with EVAL or EVALSHA
+func newDroppingServer(t *testing.T) (string, *atomic.Int64) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	var commands atomic.Int64
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					// Read a command sent as a RESP array of bulk strings
+					var args []string
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					var n int
+					fmt.Sscanf(line, "*%d", &n)
+					for i := 0; i < n; i++ {
+						if _, err := reader.ReadString('\n'); err != nil {
+							return
+						}
+						arg, err := reader.ReadString('\n')
+						if err != nil {
+							return
+						}
+						args = append(args, strings.TrimSpace(arg))
+					}
+					if len(args) == 0 || !strings.EqualFold(args[0], "PING") {
+						if len(args) > 0 && strings.HasPrefix(strings.ToUpper(args[0]), "EVAL") {
+							commands.Add(1)
+						}
+						return
+					}
+					if _, err := conn.Write([]byte("+PONG\r\n")); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String(), &commands
+}
+
+// TestShardClientsDontRetry tests that a script whose reply was lost is sent once with
+// the client options of the shard managers, even with WithRetry, as it may have
+// charged the bucket
+func TestShardClientsDontRetry(t *testing.T) {
+	addr, commands := newDroppingServer(t)
+	manager, err := NewRedisShardManager([]string{addr})
+	if err != nil {
+		t.Fatalf("Failed to create the shard manager: %v", err)
+	}
+	defer manager.Close()
+	limiter := NewRateLimiter(manager, 1.0, 10.0, WithRetry(3, time.Millisecond))
+
+	if _, err := limiter.Allow("test_user_no_retry"); err == nil {
+		t.Fatal("Expected the lost reply to fail the check")
+	}
+	if sent := commands.Load(); sent != 1 {
+		t.Errorf("Expected the script sent once, got %d", sent)
+	}
+}
+
+// TestRetryReleasesShardSlot tests that a check backing off before a retry doesn't
+// hold a slot of the shard's concurrency cap
+func TestRetryReleasesShardSlot(t *testing.T) {
+	setup, cleanup, err := setupTestRateLimiter(1.0, 10.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	redisAddr := os.Getenv("REDIS_ADDR")
+	if redisAddr == "" {
+		redisAddr = "localhost:6379"
+	}
+	userID := "test_user_retry_slot"
+	setup.manager.GetClient(userID).Del(testCtx, testBucketKey(userID))
+
+	hook := &flakyHook{err: dialError, failures: 1}
+	limiter, closeClient := newFlakyLimiter(redisAddr, hook, WithRetry(1, 200*time.Millisecond), WithMaxConcurrentChecks(1, 0))
+	defer closeClient()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := limiter.Allow(userID)
+		done <- err
+	}()
+	for hook.attempts.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The first check is backing off, leaving the only slot free
+	if result, err := limiter.Allow(userID); err != nil || !result.Allowed {
+		t.Errorf("Expected a check during the backoff to get the slot, got %+v (%v)", result, err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Expected the retried check to succeed, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// RemainingRounding controls how the fractional remaining token count is converted
+// to the integer sent in the X-RateLimit-Remaining header
+type RemainingRounding int
+
+const (
+	// RemainingFloor rounds down, never over-reporting the quota left (default)
+	RemainingFloor RemainingRounding = iota
+	// RemainingRound rounds to the nearest integer
+	RemainingRound
+	// RemainingCeil rounds up, never under-reporting the quota left
+	RemainingCeil
+)
+
+// formatRemaining formats the remaining tokens for the header using the given rounding,
+// reporting buckets in debt as 0
+func formatRemaining(remaining float64, rounding RemainingRounding) string {
+	switch rounding {
+	case RemainingRound:
+		remaining = math.Round(remaining)
+	case RemainingCeil:
+		remaining = math.Ceil(remaining)
+	default:
+		remaining = math.Floor(remaining)
+	}
+	return fmt.Sprintf("%.0f", max(0, remaining))
+}
+
+// RemainingReporting controls which quota the X-RateLimit-Remaining header reports
+type RemainingReporting int
+
+const (
+	// RemainingPostConsumption reports the tokens left after the request was charged,
+	// so the first request on a fresh bucket reports capacity - cost (default)
+	RemainingPostConsumption RemainingReporting = iota
+	// RemainingPreConsumption reports the tokens available before the request was
+	// charged, so the first request on a fresh bucket reports capacity
+	RemainingPreConsumption
+)
+
+// reportedRemaining returns the remaining tokens to report for a decision charging cost.
+// Blocked requests aren't charged, so both modes report the same value for them.
+func reportedRemaining(result *AllowResult, cost float64, reporting RemainingReporting) float64 {
+	if reporting == RemainingPreConsumption && result.Allowed {
+		return result.Remaining + cost
+	}
+	return result.Remaining
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+// TestFormatRemaining tests each rounding policy for the remaining header
+func TestFormatRemaining(t *testing.T) {
+	tests := []struct {
+		remaining float64
+		rounding  RemainingRounding
+		expected  string
+	}{
+		{4.7, RemainingFloor, "4"},
+		{4.2, RemainingRound, "4"},
+		{4.5, RemainingRound, "5"},
+		{4.2, RemainingCeil, "5"},
+		{4.0, RemainingCeil, "4"},
+		{0.9, RemainingFloor, "0"},
+	}
+
+	for _, tt := range tests {
+		if got := formatRemaining(tt.remaining, tt.rounding); got != tt.expected {
+			t.Errorf("formatRemaining(%v, %d) = %s, expected %s", tt.remaining, tt.rounding, got, tt.expected)
+		}
+	}
+}
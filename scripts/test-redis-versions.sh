@@ -0,0 +1,16 @@
+#!/bin/sh
+# Runs the test suite against every Redis version in docker-compose.test.yml.
+# Extra arguments are passed to go test, e.g. -run TestRateLimit.
+set -e
+
+cd "$(dirname "$0")/.."
+
+docker compose -f docker-compose.test.yml up -d --wait
+trap 'docker compose -f docker-compose.test.yml down' EXIT
+
+for target in 6:6380 7:6381; do
+	version=${target%%:*}
+	port=${target#*:}
+	echo "==> Redis $version (localhost:$port)"
+	REDIS_ADDR="localhost:$port" REDIS_EXPECT_VERSION="$version" go test -count=1 "$@" ./...
+done
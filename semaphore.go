@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrShardBusy is returned by checks that waited too long for a free slot of their
+// shard's concurrency cap
+var ErrShardBusy = errors.New("too many concurrent checks on shard")
+
+// shardSemaphores caps the checks in flight on each shard
+type shardSemaphores struct {
+	limit int
+	wait  time.Duration
+
+	mu    sync.Mutex
+	slots map[semaphoreKey]chan struct{}
+}
+
+// semaphoreKey identifies a shard of a manager's current shard set
+type semaphoreKey struct {
+	manager    *RedisShardManager
+	generation time.Time // time of the manager's last UpdateShards
+	shard      int
+}
+
+// WithMaxConcurrentChecks caps the Allow checks in flight on each shard at perShard
+// (default unlimited), so that a spike of goroutines queues in process instead of
+// stampeding the connection pool and Redis. A check waits for a free slot for at
+// most wait, or until its context ends, and then fails with ErrShardBusy; a zero
+// wait fails at once when the shard is full. RateLimitMiddleware applies the
+// failure mode to it like to any other Redis error, or the timeout failure mode
+// when the request context ended. UpdateShards starts the caps of the new shard
+// set afresh.
+func WithMaxConcurrentChecks(perShard int, wait time.Duration) LimiterOption {
+	return func(rl *RateLimiter) {
+		if perShard <= 0 {
+			rl.semaphores = nil
+			return
+		}
+		rl.semaphores = &shardSemaphores{
+			limit: perShard,
+			wait:  wait,
+			slots: make(map[semaphoreKey]chan struct{}),
+		}
+	}
+}
+
+// acquire takes a slot of the semaphore of key, returning the function releasing it
+func (s *shardSemaphores) acquire(ctx context.Context, key semaphoreKey) (func(), error) {
+	s.mu.Lock()
+	slots, ok := s.slots[key]
+	if !ok {
+		// Drop the semaphores of the shard set the manager replaced
+		for other := range s.slots {
+			if other.manager == key.manager && other.generation != key.generation {
+				delete(s.slots, other)
+			}
+		}
+		slots = make(chan struct{}, s.limit)
+		s.slots[key] = slots
+	}
+	s.mu.Unlock()
+
+	release := func() { <-slots }
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if s.wait <= 0 {
+		return nil, fmt.Errorf("no free shard slot: %w", ErrShardBusy)
+	}
+
+	timer := time.NewTimer(s.wait)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("failed to acquire shard slot: %w", ctx.Err())
+	case <-timer.C:
+		return nil, fmt.Errorf("failed to acquire shard slot within %v: %w", s.wait, ErrShardBusy)
+	}
+}
+
+// acquireShard takes a slot of the concurrency cap of manager's shard, a no-op
+// without WithMaxConcurrentChecks
+func (rl *RateLimiter) acquireShard(ctx context.Context, manager *RedisShardManager, shard int) (func(), error) {
+	if rl.semaphores == nil {
+		return func() {}, nil
+	}
+	manager.mu.RLock()
+	generation := manager.updatedAt
+	manager.mu.RUnlock()
+	return rl.semaphores.acquire(ctx, semaphoreKey{manager: manager, generation: generation, shard: shard})
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// inFlightHook is a go-redis hook recording the peak number of commands in flight,
+// slowing each command down so concurrent ones overlap
+type inFlightHook struct {
+	current, peak atomic.Int64
+}
+
+func (h *inFlightHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	n := h.current.Add(1)
+	for {
+		peak := h.peak.Load()
+		if n <= peak || h.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	return ctx, nil
+}
+
+func (h *inFlightHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	h.current.Add(-1)
+	return nil
+}
+
+func (h *inFlightHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h *inFlightHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+// TestMaxConcurrentChecks tests that no more than the cap of checks reach a shard at once
+func TestMaxConcurrentChecks(t *testing.T) {
+	_, cleanup, err := setupTestRateLimiter(1.0, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	redisAddr := os.Getenv("REDIS_ADDR")
+	if redisAddr == "" {
+		redisAddr = "localhost:6379"
+	}
+	client := redis.NewClient(&redis.Options{Addr: redisAddr, PoolSize: 50})
+	defer client.Close()
+	hook := &inFlightHook{}
+	client.AddHook(hook)
+	manager := &RedisShardManager{shards: []*redis.Client{client}}
+
+	for _, tt := range []struct {
+		name    string
+		cap     int
+		maxPeak int64
+	}{
+		{"capped", 3, 3},
+		{"unlimited", 0, 50},
+	} {
+		hook.peak.Store(0)
+		limiter := NewRateLimiter(manager, 1000, 1000, WithMaxConcurrentChecks(tt.cap, time.Second))
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := limiter.Allow("test_user_semaphore"); err != nil {
+					t.Errorf("Error calling Allow: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if peak := hook.peak.Load(); peak > tt.maxPeak || (tt.cap == 0 && peak <= 3) {
+			t.Errorf("%s: unexpected peak of %d commands in flight", tt.name, peak)
+		}
+	}
+}
+
+// TestMaxConcurrentChecksWait tests that checks waiting past the wait time or their
+// context fail without reaching Redis
+func TestMaxConcurrentChecksWait(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(1.0, 5.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+	WithMaxConcurrentChecks(1, 20*time.Millisecond)(limiter)
+
+	userID := "test_user_semaphore_wait"
+	release, err := limiter.acquireShard(testCtx, limiter.manager, limiter.manager.shardIndex(userID))
+	if err != nil {
+		t.Fatalf("Failed to acquire the only slot: %v", err)
+	}
+
+	if _, err := limiter.Allow(userID); !errors.Is(err, ErrShardBusy) {
+		t.Errorf("Expected ErrShardBusy while the slot is held, got %v", err)
+	}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := limiter.AllowCtx(cancelled, userID); !isContextError(err) {
+		t.Errorf("Expected a context error for a cancelled check, got %v", err)
+	}
+
+	release()
+	if result, err := limiter.Allow(userID); err != nil || !result.Allowed {
+		t.Errorf("Expected the check to run once the slot is free, got %+v (%v)", result, err)
+	}
+}
+
+// TestMaxConcurrentChecksZeroWait tests that a zero wait fails at once on a full shard
+func TestMaxConcurrentChecksZeroWait(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(1.0, 5.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+	WithMaxConcurrentChecks(1, 0)(limiter)
+
+	userID := "test_user_semaphore_zero_wait"
+	release, err := limiter.acquireShard(testCtx, limiter.manager, limiter.manager.shardIndex(userID))
+	if err != nil {
+		t.Fatalf("Failed to acquire the only slot: %v", err)
+	}
+	defer release()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := limiter.Allow(userID)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrShardBusy) {
+			t.Errorf("Expected ErrShardBusy while the slot is held, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a zero wait to fail at once, the check is still waiting")
+	}
+}
+
+// TestMaxConcurrentChecksUpdateShards tests that the caps start afresh with the new
+// shard set
+func TestMaxConcurrentChecksUpdateShards(t *testing.T) {
+	redisAddr := os.Getenv("REDIS_ADDR")
+	if redisAddr == "" {
+		redisAddr = "localhost:6379"
+	}
+	manager, err := NewRedisShardManager([]string{redisAddr})
+	if err != nil {
+		t.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	defer manager.Close()
+
+	limiter := NewRateLimiter(manager, 1.0, 5.0, WithMaxConcurrentChecks(1, 0))
+	userID := "test_user_semaphore_update"
+	defer manager.GetClient(userID).Del(testCtx, testBucketKey(userID))
+	release, err := limiter.acquireShard(testCtx, manager, manager.shardIndex(userID))
+	if err != nil {
+		t.Fatalf("Failed to acquire the only slot: %v", err)
+	}
+	if _, err := limiter.Allow(userID); !errors.Is(err, ErrShardBusy) {
+		t.Fatalf("Expected ErrShardBusy while the slot is held, got %v", err)
+	}
+
+	if err := manager.UpdateShards([]string{redisAddr}); err != nil {
+		t.Fatalf("Failed to update shards: %v", err)
+	}
+	if result, err := limiter.Allow(userID); err != nil || !result.Allowed {
+		t.Errorf("Expected a free slot on the new shard set, got %+v (%v)", result, err)
+	}
+	release()
+}
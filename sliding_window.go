@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// slidingWindowLuaScript is the Lua script for atomically trimming a user's request
+// log to the window and recording the request if it fits in the limit, at the time
+// of the Redis server in milliseconds
+// KEYS[1] = request log; ARGV[1] = window in milliseconds, ARGV[2] = limit,
+// ARGV[3] = requests, ARGV[4] = unique member prefix
+const slidingWindowLuaScript = `
+local key = KEYS[1]
+local now = math.floor(serverTime() * 1000)
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local requested = tonumber(ARGV[3])
+local member = ARGV[4]
+
+-- Drop the requests that left the window
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local count = redis.call('ZCARD', key)
+
+if count + requested > limit then
+    -- The request fits once enough of the oldest entries left the window; a request
+    -- larger than the limit never fits and waits a full window
+    local excess = count + requested - limit
+    local retryAfter = window
+    if excess <= count then
+        local entry = redis.call('ZRANGE', key, excess - 1, excess - 1, 'WITHSCORES')
+        retryAfter = tonumber(entry[2]) + window - now
+    end
+    return {0, count, retryAfter}
+end
+
+for i = 1, requested do
+    redis.call('ZADD', key, now, member .. ':' .. i)
+end
+redis.call('PEXPIRE', key, window)
+return {1, count + requested, 0}
+`
+
+// slidingWindowScript is the precomputed slidingWindowLuaScript on top of serverTime,
+// hashed once for EVALSHA
+var slidingWindowScript = redis.NewScript(serverTimeLuaScript + slidingWindowLuaScript)
+
+// SlidingWindowLimiter allows each user up to limit requests in any rolling window,
+// e.g. 100 requests per minute counted back from every request. Unlike the token
+// bucket, it never allows a burst over the limit after a quiet period. The timestamp
+// of every allowed request within the window is kept in a per-user sorted set, so
+// memory grows with the limit.
+type SlidingWindowLimiter struct {
+	manager *RedisShardManager
+	limit   int64         // maximum requests per window
+	window  time.Duration // length of the rolling window
+}
+
+// NewSlidingWindowLimiter creates a limiter allowing each user up to limit requests
+// in any rolling window. It panics unless limit and window are positive.
+func NewSlidingWindowLimiter(manager *RedisShardManager, limit int, window time.Duration) *SlidingWindowLimiter {
+	if limit <= 0 || window <= 0 {
+		panic(fmt.Sprintf("sliding window limit and window must be positive, got %d per %v", limit, window))
+	}
+	return &SlidingWindowLimiter{
+		manager: manager,
+		limit:   int64(limit),
+		window:  window,
+	}
+}
+
+// slidingWindowKey returns the Redis key of the given userID's request log
+func (sw *SlidingWindowLimiter) slidingWindowKey(userID string) string {
+	return fmt.Sprintf("ratelimit:sw:%s", sw.manager.keyUserID(userID))
+}
+
+// Capacity returns the number of requests allowed per window
+func (sw *SlidingWindowLimiter) Capacity() float64 {
+	return float64(sw.limit)
+}
+
+// Allow checks whether a request of userID fits in the current window, recording it
+// if so. Remaining is the number of requests left in the window and RetryAfter, when
+// blocked, the time until enough requests left the window.
+func (sw *SlidingWindowLimiter) Allow(userID string) (*AllowResult, error) {
+	return sw.AllowNCtx(ctx, userID, 1)
+}
+
+// AllowN is like Allow for n requests at once, n must be a whole number
+func (sw *SlidingWindowLimiter) AllowN(userID string, n float64) (*AllowResult, error) {
+	return sw.AllowNCtx(ctx, userID, n)
+}
+
+// AllowCtx is like Allow but uses the caller's context for the Redis call
+func (sw *SlidingWindowLimiter) AllowCtx(ctx context.Context, userID string) (*AllowResult, error) {
+	return sw.AllowNCtx(ctx, userID, 1)
+}
+
+// AllowNCtx is like AllowN but uses the caller's context for the Redis call
+func (sw *SlidingWindowLimiter) AllowNCtx(ctx context.Context, userID string, n float64) (*AllowResult, error) {
+	if n <= 0 || n != math.Trunc(n) {
+		return nil, fmt.Errorf("request count must be a positive whole number, got %v", n)
+	}
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return nil, fmt.Errorf("failed to generate request ID: %w", err)
+	}
+
+	// Round the window up to whole milliseconds, so it's never 0
+	windowMillis := max(1, (sw.window + time.Millisecond - 1).Milliseconds())
+
+	values, err := sw.manager.runOnShard(ctx, userID, slidingWindowScript, []string{sw.slidingWindowKey(userID)}, windowMillis, sw.limit, int64(n), hex.EncodeToString(random)).Int64Slice()
+	if err != nil {
+		log.Printf("ERROR: Critical Redis Error: Lua sliding window script execution failure for userID %s - %v", userID, err)
+		return nil, fmt.Errorf("failed to execute sliding window script: %w", err)
+	}
+	if len(values) != 3 {
+		return nil, fmt.Errorf("unexpected sliding window script result: %v", values)
+	}
+
+	result := &AllowResult{
+		Allowed:   values[0] == 1,
+		Remaining: float64(max(0, sw.limit-values[1])),
+	}
+	if !result.Allowed {
+		result.RetryAfter = time.Duration(values[2]) * time.Millisecond
+	}
+	return result, nil
+}
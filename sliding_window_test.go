@@ -0,0 +1,161 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestSlidingWindowLimiter creates a sliding window limiter on the test Redis,
+// deleting the request log of userID before and after the test
+func newTestSlidingWindowLimiter(t *testing.T, userID string, limit int, window time.Duration) *SlidingWindowLimiter {
+	base, cleanup, err := setupTestRateLimiter(1.0, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	limiter := NewSlidingWindowLimiter(base.manager, limit, window)
+	client := limiter.manager.GetClient(userID)
+	client.Del(testCtx, limiter.slidingWindowKey(userID))
+	t.Cleanup(func() {
+		client.Del(testCtx, limiter.slidingWindowKey(userID))
+		cleanup()
+	})
+	return limiter
+}
+
+// TestSlidingWindowRolling tests that requests are counted over the rolling window and
+// allowed again as the oldest ones leave it
+func TestSlidingWindowRolling(t *testing.T) {
+	userID := "test_user_sliding"
+	limiter := newTestSlidingWindowLimiter(t, userID, 3, 300*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		result, err := limiter.Allow(userID)
+		if err != nil {
+			t.Fatalf("Error calling Allow: %v", err)
+		}
+		if !result.Allowed || result.Remaining != float64(2-i) {
+			t.Errorf("Request %d: expected allowed with %d remaining, got %+v", i+1, 2-i, result)
+		}
+		if i == 0 {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	// The fourth request waits for the first one to leave the window, about 200ms
+	result, err := limiter.Allow(userID)
+	if err != nil {
+		t.Fatalf("Error calling Allow: %v", err)
+	}
+	if result.Allowed || result.Remaining != 0 {
+		t.Fatalf("Expected the request over the limit to be blocked, got %+v", result)
+	}
+	if result.RetryAfter < 100*time.Millisecond || result.RetryAfter > 200*time.Millisecond {
+		t.Errorf("Expected a retry-after of about 200ms, got %v", result.RetryAfter)
+	}
+
+	// Two requests at once only fit once the later two left as well
+	if result, _ := limiter.AllowN(userID, 2); result == nil || result.Allowed || result.RetryAfter < 200*time.Millisecond {
+		t.Errorf("Expected 2 requests to wait for both later entries, got %+v", result)
+	}
+
+	time.Sleep(result.RetryAfter + 10*time.Millisecond)
+	if result, err := limiter.Allow(userID); err != nil || !result.Allowed || result.Remaining != 0 {
+		t.Errorf("Expected one request to fit once the first left the window, got %+v (%v)", result, err)
+	}
+}
+
+// TestSlidingWindowConcurrency tests that concurrent callers never overcount
+func TestSlidingWindowConcurrency(t *testing.T) {
+	userID := "test_user_sliding_concurrent"
+	limiter := newTestSlidingWindowLimiter(t, userID, 10, time.Minute)
+
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := limiter.Allow(userID)
+			if err != nil {
+				t.Errorf("Error calling Allow: %v", err)
+				return
+			}
+			if result.Allowed {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed.Load() != 10 {
+		t.Errorf("Expected exactly 10 allowed requests, got %d", allowed.Load())
+	}
+	if count, _ := limiter.manager.GetClient(userID).ZCard(testCtx, limiter.slidingWindowKey(userID)).Result(); count != 10 {
+		t.Errorf("Expected 10 logged requests, got %d", count)
+	}
+}
+
+// TestSlidingWindowAllowN tests request counts that aren't whole or exceed the limit
+func TestSlidingWindowAllowN(t *testing.T) {
+	userID := "test_user_sliding_n"
+	limiter := newTestSlidingWindowLimiter(t, userID, 3, time.Minute)
+
+	for _, n := range []float64{0, -1, 1.5} {
+		if _, err := limiter.AllowN(userID, n); err == nil {
+			t.Errorf("Expected an error for %v requests", n)
+		}
+	}
+	result, err := limiter.AllowN(userID, 4)
+	if err != nil {
+		t.Fatalf("Error calling AllowN: %v", err)
+	}
+	if result.Allowed || result.Remaining != 3 || result.RetryAfter != time.Minute {
+		t.Errorf("Expected more requests than the limit to be blocked for a window, got %+v", result)
+	}
+}
+
+// TestSlidingWindowInvalid tests that a non-positive limit or window is rejected
+func TestSlidingWindowInvalid(t *testing.T) {
+	for _, tt := range []struct {
+		limit  int
+		window time.Duration
+	}{
+		{0, time.Minute},
+		{-1, time.Minute},
+		{10, 0},
+		{10, -time.Second},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected a panic for %d per %v", tt.limit, tt.window)
+				}
+			}()
+			NewSlidingWindowLimiter(nil, tt.limit, tt.window)
+		}()
+	}
+}
+
+// TestSlidingWindowServerTime tests that requests are logged at the Redis server's time
+func TestSlidingWindowServerTime(t *testing.T) {
+	userID := "test_user_sliding_server_time"
+	limiter := newTestSlidingWindowLimiter(t, userID, 3, time.Minute)
+	if _, err := limiter.Allow(userID); err != nil {
+		t.Fatalf("Error calling Allow: %v", err)
+	}
+
+	client := limiter.manager.GetClient(userID)
+	serverTime, err := client.Time(testCtx).Result()
+	if err != nil {
+		t.Fatalf("TIME failed: %v", err)
+	}
+	entries, err := client.ZRangeWithScores(testCtx, limiter.slidingWindowKey(userID), 0, -1).Result()
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Expected one logged request, got %v (%v)", entries, err)
+	}
+	if drift := float64(serverTime.UnixMilli()) - entries[0].Score; drift < 0 || drift > 1000 {
+		t.Errorf("Expected the request logged at the Redis server time, %vms behind TIME", drift)
+	}
+}
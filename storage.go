@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// StoredBucket is the raw state of a bucket as stored in Redis, missing fields are empty
+type StoredBucket struct {
+	Tokens     string
+	LastRefill string
+	CreatedAt  string
+}
+
+// BucketStorage defines how bucket state is laid out in Redis.
+//
+// Lua returns Lua code defining two functions, prepended to every script reading or
+// writing buckets: readBucket(key) returns the stored tokens, lastRefill and createdAt
+// of a bucket as strings, or false for missing fields and buckets, and
+// writeBucket(key, tokens, lastRefill, createdAt) stores them without changing the
+// key's TTL, skipping createdAt when it's false. Read does the same as readBucket
+// outside of scripts, e.g. on read replicas, returning nil for a missing bucket.
+type BucketStorage interface {
+	Lua() string
+	Read(ctx context.Context, client *redis.Client, key string) (*StoredBucket, error)
+}
+
+// WithBucketStorage sets how buckets are stored in Redis (default HashStorage).
+// Buckets written with one storage can't be read by another, so switching storage
+// on a live deployment starts every user over with a fresh bucket.
+func WithBucketStorage(storage BucketStorage) LimiterOption {
+	return func(rl *RateLimiter) {
+		rl.storage = storage
+	}
+}
+
+// HashStorage stores each bucket as a Redis hash with tokens, lastRefill and
+// createdAt fields
+type HashStorage struct{}
+
+// hashStorageLuaScript defines the bucket accessors of HashStorage
+const hashStorageLuaScript = `
+local function readBucket(key)
+    local bucket = redis.call('HMGET', key, 'tokens', 'lastRefill', 'createdAt')
+    return bucket[1], bucket[2], bucket[3]
+end
+
+local function writeBucket(key, tokens, lastRefill, createdAt)
+    if createdAt then
+        redis.call('HSET', key, 'tokens', tokens, 'lastRefill', lastRefill, 'createdAt', createdAt)
+    else
+        redis.call('HSET', key, 'tokens', tokens, 'lastRefill', lastRefill)
+    end
+end
+`
+
+// Lua returns the HMGET/HSET bucket accessors
+func (HashStorage) Lua() string {
+	return hashStorageLuaScript
+}
+
+// Read reads the bucket's fields with HMGET
+func (HashStorage) Read(ctx context.Context, client *redis.Client, key string) (*StoredBucket, error) {
+	values, err := client.HMGet(ctx, key, "tokens", "lastRefill", "createdAt").Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bucket hash: %w", err)
+	}
+	if values[0] == nil {
+		return nil, nil
+	}
+
+	fields := make([]string, len(values))
+	for i, value := range values {
+		fields[i], _ = value.(string)
+	}
+	return &StoredBucket{Tokens: fields[0], LastRefill: fields[1], CreatedAt: fields[2]}, nil
+}
+
+// SerializationCodec is a Lua library built into Redis encoding bucket state
+type SerializationCodec string
+
+const (
+	// CodecMessagePack encodes buckets with cmsgpack
+	CodecMessagePack SerializationCodec = "cmsgpack"
+	// CodecJSON encodes buckets with cjson
+	CodecJSON SerializationCodec = "cjson"
+)
+
+// SerializedStorage stores each bucket as a single string value holding the array
+// [tokens, lastRefill, createdAt] encoded with Codec, so that scripts read and write
+// a bucket with one GET and one SET instead of hash field operations. Values are
+// encoded as decimal strings, since Lua formats numbers with too few digits for
+// timestamps. Writes use SET ... KEEPTTL, which requires Redis 6.0 or later.
+type SerializedStorage struct {
+	Codec SerializationCodec
+}
+
+// serializedStorageLuaScript defines the bucket accessors of SerializedStorage, the
+// codec's decode and encode functions are substituted for %[1]s and %[2]s
+const serializedStorageLuaScript = `
+local function bucketValue(value)
+    if type(value) == 'number' then
+        return string.format('%%.17g', value)
+    end
+    return value
+end
+
+local function readBucket(key)
+    local raw = redis.call('GET', key)
+    if not raw then
+        return false, false, false
+    end
+    local bucket = %[1]s(raw)
+    return bucket[1] or false, bucket[2] or false, bucket[3] or false
+end
+
+local function writeBucket(key, tokens, lastRefill, createdAt)
+    local bucket = {bucketValue(tokens), bucketValue(lastRefill)}
+    if createdAt then
+        bucket[3] = bucketValue(createdAt)
+    end
+    redis.call('SET', key, %[2]s(bucket), 'KEEPTTL')
+end
+`
+
+// Lua returns the GET/SET bucket accessors for the codec
+func (s SerializedStorage) Lua() string {
+	if s.Codec == CodecJSON {
+		return fmt.Sprintf(serializedStorageLuaScript, "cjson.decode", "cjson.encode")
+	}
+	return fmt.Sprintf(serializedStorageLuaScript, "cmsgpack.unpack", "cmsgpack.pack")
+}
+
+// Read reads the bucket's value with GET and decodes it
+func (s SerializedStorage) Read(ctx context.Context, client *redis.Client, key string) (*StoredBucket, error) {
+	raw, err := client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read serialized bucket: %w", err)
+	}
+
+	var fields []string
+	if s.Codec == CodecJSON {
+		err = json.Unmarshal(raw, &fields)
+	} else {
+		fields, err = decodeMsgpackStrings(raw)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode serialized bucket: %w", err)
+	}
+
+	bucket := &StoredBucket{}
+	for i, field := range []*string{&bucket.Tokens, &bucket.LastRefill, &bucket.CreatedAt} {
+		if i < len(fields) {
+			*field = fields[i]
+		}
+	}
+	return bucket, nil
+}
+
+// decodeMsgpackStrings decodes a MessagePack array of strings, as written by
+// SerializedStorage with CodecMessagePack
+func decodeMsgpackStrings(data []byte) ([]string, error) {
+	// readLength reads a big-endian length of size bytes at data[pos:]
+	readLength := func(pos, size int) (int, error) {
+		if pos+size > len(data) {
+			return 0, fmt.Errorf("truncated length at offset %d", pos)
+		}
+		switch size {
+		case 1:
+			return int(data[pos]), nil
+		case 2:
+			return int(binary.BigEndian.Uint16(data[pos:])), nil
+		default:
+			return int(binary.BigEndian.Uint32(data[pos:])), nil
+		}
+	}
+
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty value")
+	}
+	var count, pos int
+	var err error
+	switch b := data[0]; {
+	case b >= 0x90 && b <= 0x9f:
+		count, pos = int(b&0x0f), 1
+	case b == 0xdc:
+		count, err = readLength(1, 2)
+		pos = 3
+	case b == 0xdd:
+		count, err = readLength(1, 4)
+		pos = 5
+	default:
+		return nil, fmt.Errorf("expected an array, got type byte 0x%02x", b)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		if pos >= len(data) {
+			return nil, fmt.Errorf("truncated array at element %d", i)
+		}
+		var length int
+		switch b := data[pos]; {
+		case b >= 0xa0 && b <= 0xbf:
+			length, pos = int(b&0x1f), pos+1
+		case b == 0xd9 || b == 0xc4:
+			length, err = readLength(pos+1, 1)
+			pos += 2
+		case b == 0xda || b == 0xc5:
+			length, err = readLength(pos+1, 2)
+			pos += 3
+		case b == 0xdb || b == 0xc6:
+			length, err = readLength(pos+1, 4)
+			pos += 5
+		default:
+			return nil, fmt.Errorf("expected a string at element %d, got type byte 0x%02x", i, b)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if pos+length > len(data) {
+			return nil, fmt.Errorf("truncated string at element %d", i)
+		}
+		values = append(values, string(data[pos:pos+length]))
+		pos += length
+	}
+	return values, nil
+}
+
+// serverTimeLuaScript defines serverTime(), the current time of the Redis server in
+// seconds with microsecond precision. Bucket scripts take their time from it rather
+// than from the application servers, whose clocks may disagree. Scripts calling TIME
+// before writing need effects replication, the default since Redis 5.
+const serverTimeLuaScript = `
+local function serverTime()
+    local time = redis.call('TIME')
+    return tonumber(time[1]) + tonumber(time[2]) / 1000000
+end
+`
+
+// bucketScript returns the script src, which reads or writes buckets, on top of
+// serverTime and the limiter's storage accessors. The scripts of bucketScripts are built once by
+// compileScripts, so checks neither rebuild the source nor rehash it for EVALSHA.
+func (rl *RateLimiter) bucketScript(src string) *redis.Script {
+	if script, ok := rl.compiledScripts[src]; ok {
+		return script
+	}
+	return redis.NewScript(serverTimeLuaScript + rl.storage.Lua() + src)
+}
+
+// compileScripts builds the bucket scripts on top of the limiter's storage accessors
+func (rl *RateLimiter) compileScripts() {
+	rl.compiledScripts = make(map[string]*redis.Script, len(bucketScripts))
+	for _, src := range bucketScripts {
+		rl.compiledScripts[src] = redis.NewScript(serverTimeLuaScript + rl.storage.Lua() + src)
+	}
+}
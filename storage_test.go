@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// testSerializedStorage runs the bucket operations of a limiter using storage and
+// checks that buckets are stored as a single string value
+func testSerializedStorage(t *testing.T, storage SerializedStorage) {
+	base, cleanup, err := setupTestRateLimiter(1.0, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	limiter := NewRateLimiter(base.manager, 0.001, 5.0, WithBucketStorage(storage), WithCreatedAt())
+	userID, otherID := "test_user_serialized", "test_user_serialized_other"
+	key := limiter.bucketKey(userID)
+	client := limiter.manager.GetClient(userID)
+
+	result, err := limiter.AllowN(userID, 2)
+	if err != nil {
+		if strings.Contains(err.Error(), string(storage.Codec)) {
+			t.Skipf("Redis lacks the %s library: %v", storage.Codec, err)
+		}
+		t.Fatalf("Error calling AllowN: %v", err)
+	}
+	if !result.Allowed || result.Remaining < 2.99 || result.Remaining > 3.01 {
+		t.Errorf("Expected 3 remaining tokens, got %+v", result)
+	}
+	if kind, _ := client.Type(testCtx, key).Result(); kind != "string" {
+		t.Errorf("Expected the bucket to be a string, got %s", kind)
+	}
+	if ttl, _ := client.TTL(testCtx, key).Result(); ttl <= 0 {
+		t.Errorf("Expected the bucket to expire, got TTL %v", ttl)
+	}
+
+	// The state round-trips through the script and Go decoders
+	state, err := limiter.PeekState(userID)
+	if err != nil {
+		t.Fatalf("Error calling PeekState: %v", err)
+	}
+	if !state.Exists || state.Tokens < 2.99 || state.Tokens > 3.01 || state.LastRefill.IsZero() || state.CreatedAt.IsZero() {
+		t.Errorf("Unexpected bucket state %+v", state)
+	}
+	stale, err := limiter.PeekStale(userID)
+	if err != nil {
+		t.Fatalf("Error calling PeekStale: %v", err)
+	}
+	if !stale.Allowed || stale.Remaining < 2.99 || stale.Remaining > 3.01 {
+		t.Errorf("Expected PeekStale to decode 3 tokens, got %+v", stale)
+	}
+
+	// Refunds, reservations and transfers use the same storage
+	if err := limiter.Refund(userID, 1); err != nil {
+		t.Fatalf("Error calling Refund: %v", err)
+	}
+	reservation, err := limiter.Reserve(userID, 3)
+	if err != nil {
+		t.Fatalf("Error calling Reserve: %v", err)
+	}
+	if err := limiter.Cancel(reservation); err != nil {
+		t.Fatalf("Error calling Cancel: %v", err)
+	}
+	if err := limiter.Transfer(userID, otherID, 4); err != nil {
+		t.Fatalf("Error calling Transfer: %v", err)
+	}
+	for id, expected := range map[string]float64{userID: 0, otherID: 5} {
+		state, err := limiter.PeekState(id)
+		if err != nil {
+			t.Fatalf("Error calling PeekState: %v", err)
+		}
+		if state.Tokens < expected-0.01 || state.Tokens > expected+0.01 {
+			t.Errorf("Expected %v tokens for %s, got %v", expected, id, state.Tokens)
+		}
+	}
+	if result, _ := limiter.Allow(userID); result == nil || result.Allowed {
+		t.Errorf("Expected the drained bucket to block, got %+v", result)
+	}
+}
+
+// TestSerializedStorageJSON tests buckets serialized with cjson
+func TestSerializedStorageJSON(t *testing.T) {
+	testSerializedStorage(t, SerializedStorage{Codec: CodecJSON})
+
+	// Values are stored as full-precision decimal strings
+	base, cleanup, err := setupTestRateLimiter(1.0, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+	limiter := NewRateLimiter(base.manager, 1.0, 5.0, WithBucketStorage(SerializedStorage{Codec: CodecJSON}))
+	userID := "test_user_serialized_json"
+	if _, err := limiter.Allow(userID); err != nil {
+		t.Fatalf("Error calling Allow: %v", err)
+	}
+	raw, err := limiter.manager.GetClient(userID).Get(testCtx, limiter.bucketKey(userID)).Bytes()
+	if err != nil {
+		t.Fatalf("Failed to read bucket: %v", err)
+	}
+	var fields []string
+	if err := json.Unmarshal(raw, &fields); err != nil || len(fields) != 2 || fields[0] != "4" || !strings.Contains(fields[1], ".") {
+		t.Errorf("Unexpected serialized bucket %s (%v)", raw, err)
+	}
+}
+
+// TestSerializedStorageMessagePack tests buckets serialized with cmsgpack, skipped on
+// servers without it such as miniredis
+func TestSerializedStorageMessagePack(t *testing.T) {
+	testSerializedStorage(t, SerializedStorage{Codec: CodecMessagePack})
+}
+
+// TestDecodeMsgpackStrings tests decoding the arrays written by cmsgpack
+func TestDecodeMsgpackStrings(t *testing.T) {
+	long := strings.Repeat("x", 40)
+	tests := []struct {
+		name     string
+		data     []byte
+		expected []string
+	}{
+		{"fixstr", []byte{0x92, 0xa1, '4', 0xa2, '1', '5'}, []string{"4", "15"}},
+		{"str8", append([]byte{0x91, 0xd9, 40}, long...), []string{long}},
+		{"str16 in array16", append([]byte{0xdc, 0x00, 0x01, 0xda, 0x00, 40}, long...), []string{long}},
+		{"empty", []byte{0x90}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeMsgpackStrings(tt.data)
+			if err != nil {
+				t.Fatalf("Failed to decode: %v", err)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.expected, ",") || len(got) != len(tt.expected) {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+
+	for _, data := range [][]byte{{}, {0xa1, '4'}, {0x92, 0xa1, '4'}, {0x91, 0xa5, '4'}, {0x91, 0x01}} {
+		if _, err := decodeMsgpackStrings(data); err == nil {
+			t.Errorf("Expected an error decoding % x", data)
+		}
+	}
+}
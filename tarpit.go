@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// windowCounterLuaScript increments a per-user counter, starting its window on the first increment
+// KEYS[1] = counter key
+// ARGV[1] = window length, ARGV[2] = window unit ('ms' or 's')
+const windowCounterLuaScript = `
+local key = KEYS[1]
+local window = tonumber(ARGV[1])
+local windowUnit = ARGV[2]
+
+local count = redis.call('INCR', key)
+if count == 1 then
+    if windowUnit == 'ms' then
+        redis.call('PEXPIRE', key, window)
+    else
+        redis.call('EXPIRE', key, window)
+    end
+end
+
+return count
+`
+
+// windowCounterScript is the precomputed windowCounterLuaScript, hashed once for EVALSHA
+var windowCounterScript = redis.NewScript(windowCounterLuaScript)
+
+// TarpitConfig configures the response delay applied to repeat offenders
+type TarpitConfig struct {
+	// Threshold is the number of penalties a user may collect before allowed responses are delayed
+	Threshold int64
+	// Step is the delay added per penalty above the threshold
+	Step time.Duration
+	// MaxDelay caps the delay of a single response
+	MaxDelay time.Duration
+	// Window is how long penalties are remembered, counted from the first penalty
+	Window time.Duration
+}
+
+// delay returns the response delay for a user with the given number of penalties
+func (t *TarpitConfig) delay(penalties int64) time.Duration {
+	if penalties <= t.Threshold {
+		return 0
+	}
+	// Compare in penalties to avoid overflowing the duration
+	excess := penalties - t.Threshold
+	if t.Step <= 0 || excess > int64(t.MaxDelay/t.Step) {
+		return t.MaxDelay
+	}
+	if d := time.Duration(excess) * t.Step; d < t.MaxDelay {
+		return d
+	}
+	return t.MaxDelay
+}
+
+// penaltyKey returns the Redis key of the given userID's penalty counter
+func (rl *RateLimiter) penaltyKey(userID string) string {
+	return fmt.Sprintf("ratelimit:penalty:%s", rl.manager.keyUserID(userID))
+}
+
+// AddPenalty records that the given userID exceeded the limit, returning the number of
+// penalties within the current window. The counter lives on the same shard as the bucket.
+func (rl *RateLimiter) AddPenalty(ctx context.Context, userID string, window time.Duration) (int64, error) {
+	return rl.incrWindowCounter(ctx, userID, rl.penaltyKey(userID), window)
+}
+
+// incrWindowCounter atomically increments the counter at key on userID's shard, returning
+// its new value. The counter expires window after its first increment.
+func (rl *RateLimiter) incrWindowCounter(ctx context.Context, userID, key string, window time.Duration) (int64, error) {
+	windowValue, windowUnit := keyExpiry(window)
+
+	count, err := rl.manager.runOnShard(ctx, userID, windowCounterScript, []string{key}, windowValue, windowUnit).Int64()
+	if err != nil {
+		log.Printf("ERROR: Critical Redis Error: Lua counter script execution failure for key %s - %v", key, err)
+		return 0, fmt.Errorf("failed to execute counter script: %w", err)
+	}
+
+	return count, nil
+}
+
+// Penalties returns the number of penalties the given userID collected within the current window
+func (rl *RateLimiter) Penalties(ctx context.Context, userID string) (int64, error) {
+	var penalties int64
+	err := rl.manager.guardUser(userID, func(client *redis.Client) error {
+		var err error
+		penalties, err = client.Get(ctx, rl.penaltyKey(userID)).Int64()
+		return err
+	})
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read penalty counter: %w", err)
+	}
+
+	return penalties, nil
+}
+
+// tarpitWait blocks for d, returning early with the context's error if ctx is done first
+func tarpitWait(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestTarpitDelay tests the delay computation including the threshold and cap
+func TestTarpitDelay(t *testing.T) {
+	cfg := TarpitConfig{Threshold: 2, Step: 100 * time.Millisecond, MaxDelay: 350 * time.Millisecond}
+
+	tests := []struct {
+		penalties int64
+		expected  time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, 100 * time.Millisecond},
+		{5, 300 * time.Millisecond},
+		{6, 350 * time.Millisecond},
+		{1 << 62, 350 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		if got := cfg.delay(tt.penalties); got != tt.expected {
+			t.Errorf("delay(%d) = %v, expected %v", tt.penalties, got, tt.expected)
+		}
+	}
+}
+
+// TestTarpitWaitCancelled tests that the delay ends as soon as the context is cancelled
+func TestTarpitWaitCancelled(t *testing.T) {
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	if err := tarpitWait(cancelledCtx, time.Minute); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the wait to end immediately, took %v", elapsed)
+	}
+}
+
+// TestMiddlewareTarpit tests that blocked requests add penalties and that allowed
+// responses of a repeat offender are delayed
+func TestMiddlewareTarpit(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.01, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	client := limiter.manager.GetClient(testClientIP)
+	client.Del(testCtx, limiter.bucketKey(testClientIP), limiter.penaltyKey(testClientIP))
+	defer client.Del(testCtx, limiter.bucketKey(testClientIP), limiter.penaltyKey(testClientIP))
+
+	cfg := TarpitConfig{Threshold: 1, Step: 100 * time.Millisecond, MaxDelay: 200 * time.Millisecond, Window: time.Minute}
+	app := newTestApp(RateLimitMiddleware(limiter, WithTarpit(cfg)))
+
+	// Use up the bucket, then get blocked twice
+	for i, expected := range []int{fiber.StatusOK, fiber.StatusTooManyRequests, fiber.StatusTooManyRequests} {
+		resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		if resp.StatusCode != expected {
+			t.Fatalf("Request %d: expected status %d, got %d", i+1, expected, resp.StatusCode)
+		}
+	}
+
+	penalties, err := limiter.Penalties(testCtx, testClientIP)
+	if err != nil {
+		t.Fatalf("Penalties failed: %v", err)
+	}
+	if penalties != 2 {
+		t.Fatalf("Expected 2 penalties, got %d", penalties)
+	}
+
+	// Refill the bucket; the next allowed response is delayed by one step
+	if err := limiter.Refund(testClientIP, 1); err != nil {
+		t.Fatalf("Refund failed: %v", err)
+	}
+
+	start := time.Now()
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil), 5000)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	elapsed := time.Since(start)
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("Expected request to be allowed, got status %d", resp.StatusCode)
+	}
+	if elapsed < cfg.Step {
+		t.Errorf("Expected response to be delayed by at least %v, took %v", cfg.Step, elapsed)
+	}
+}
+
+// TestMiddlewareTarpitCutShort tests that a request whose delay ended with its context
+// is rejected instead of reaching the handler
+func TestMiddlewareTarpitCutShort(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(1.0, 10.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	client := limiter.manager.GetClient(testClientIP)
+	client.Del(testCtx, limiter.bucketKey(testClientIP), limiter.penaltyKey(testClientIP))
+	defer client.Del(testCtx, limiter.bucketKey(testClientIP), limiter.penaltyKey(testClientIP))
+	if _, err := limiter.AddPenalty(testCtx, testClientIP, time.Minute); err != nil {
+		t.Fatalf("AddPenalty failed: %v", err)
+	}
+
+	handled := false
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(c.UserContext(), 20*time.Millisecond)
+		defer cancel()
+		c.SetUserContext(ctx)
+		return c.Next()
+	})
+	cfg := TarpitConfig{Step: time.Second, MaxDelay: time.Second, Window: time.Minute}
+	app.Get("/", RateLimitMiddleware(limiter, WithTarpit(cfg)), func(c *fiber.Ctx) error {
+		handled = true
+		return c.SendString("ok")
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil), 5000)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusServiceUnavailable || handled {
+		t.Errorf("Expected 503 without reaching the handler, got %d (handled %v)", resp.StatusCode, handled)
+	}
+}
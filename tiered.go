@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// tokenTieredLuaScript is the Lua script for atomically charging the first affordable
+// of several costs. ARGV as for tokenBucketLuaScript, with ARGV[3] unused, followed by
+// the costs from ARGV[11] on
+const tokenTieredLuaScript = `
+local key = KEYS[1]
+local rate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local now = serverTime()
+local initial = tonumber(ARGV[4])
+local ttl = tonumber(ARGV[5])
+local ttlUnit = ARGV[6]
+local trackCreatedAt = ARGV[7] == '1'
+local precision = tonumber(ARGV[9])
+local maxDebt = tonumber(ARGV[10])
+
+-- Round a token count as in tokenBucketLuaScript
+local function round(value)
+    if precision < 0 then
+        return value
+    end
+    local scale = 10 ^ precision
+    return math.floor(value * scale + 0.5) / scale
+end
+
+-- Get current state from storage and apply the refill owed since the last update
+local storedTokens, storedLastRefill, createdAt = readBucket(key)
+local tokens = tonumber(storedTokens) or initial
+local lastRefill = tonumber(storedLastRefill) or now
+if not storedTokens and trackCreatedAt then
+    createdAt = now
+end
+local elapsed = now - lastRefill
+if elapsed > 0 then
+    tokens = math.min(capacity, tokens + elapsed * rate)
+end
+
+-- Charge the first cost the bucket can cover, 0 means none was granted. As in
+-- tokenBucketLuaScript, a cost over the capacity is never granted, even with debt.
+local tier = 0
+for i = 11, #ARGV do
+    local cost = tonumber(ARGV[i])
+    if cost <= capacity and tokens - cost >= -maxDebt then
+        tokens = tokens - cost
+        tier = i - 10
+        break
+    end
+end
+tokens = round(tokens)
+
+writeBucket(key, tokens, math.max(lastRefill, now), createdAt)
+if ttlUnit == 'ms' then
+    redis.call('PEXPIRE', key, ttl)
+else
+    redis.call('EXPIRE', key, ttl)
+end
+
+return {tier, tostring(tokens)}
+`
+
+// AllowTiered charges userID the first of costs its bucket can afford, in a single
+// atomic check, e.g. AllowTiered(userID, []float64{5, 1}) grants a full response for
+// 5 tokens or, failing that, a degraded one for 1. It returns the granted cost, or 0
+// with a blocked result when no tier is affordable; the retry-after of a blocked
+// result is the wait for the cheapest tier. Costs are tried in the given order.
+// Like Allow, the check goes through the blocked cache and the shard's concurrency
+// cap.
+func (rl *RateLimiter) AllowTiered(userID string, costs []float64) (chosen float64, result *AllowResult, err error) {
+	return rl.AllowTieredCtx(context.Background(), userID, costs)
+}
+
+// AllowTieredCtx is AllowTiered with a caller-supplied context propagated to Redis
+func (rl *RateLimiter) AllowTieredCtx(ctx context.Context, userID string, costs []float64) (chosen float64, result *AllowResult, err error) {
+	if len(costs) == 0 {
+		return 0, nil, fmt.Errorf("at least one cost tier is required")
+	}
+	cheapest := costs[0]
+	for _, cost := range costs {
+		if cost <= 0 {
+			return 0, nil, fmt.Errorf("cost tiers must be positive, got %v", cost)
+		}
+		cheapest = min(cheapest, cost)
+	}
+
+	// A bucket known to be blocked for the cheapest tier can't afford any
+	key := rl.bucketKey(userID)
+	if rl.blockedCache != nil {
+		if cached, ok := rl.blockedCache.get(key, cheapest, time.Now()); ok {
+			rl.logDecision(userID, cached, nil)
+			return 0, cached, nil
+		}
+	}
+
+	release, err := rl.acquireShard(ctx, rl.manager, rl.manager.shardIndex(userID))
+	if err != nil {
+		log.Printf("WARNING: Tiered rate limit check for userID %s not sent to Redis - %v", userID, err)
+		rl.logDecision(userID, nil, err)
+		return 0, nil, err
+	}
+
+	args := rl.bucketArgs(0)
+	for _, cost := range costs {
+		args = append(args, cost)
+	}
+
+	script := rl.bucketScript(tokenTieredLuaScript)
+	reply, err := rl.manager.runOnShard(ctx, userID, script, []string{key}, args...).Result()
+	release()
+	if err != nil {
+		log.Printf("ERROR: Critical Redis Error: Lua tiered script execution failure for userID %s - %v", userID, err)
+		rl.logDecision(userID, nil, err)
+		return 0, nil, fmt.Errorf("failed to execute tiered rate limit script: %w", err)
+	}
+
+	// The reply has the {tier, tokens} shape of the token bucket script, with the
+	// 1-based index of the granted tier in place of the allowed flag
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return 0, nil, fmt.Errorf("unexpected result format from Lua tiered script")
+	}
+	tier, err := parseLuaNumber(values[0])
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to parse granted tier: %w", err)
+	}
+	remaining, err := parseLuaNumber(values[1])
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to parse remaining tokens: %w", err)
+	}
+
+	result = &AllowResult{Allowed: tier > 0, Remaining: remaining, ResetAt: rl.resetAt(remaining, time.Now())}
+	if result.Allowed {
+		chosen = costs[int(tier)-1]
+	} else {
+		result.RetryAfter = rl.retryAfter(remaining, rl.debtRequirement(cheapest), rl.Rate())
+		if rl.blockedCache != nil {
+			rl.blockedCache.add(key, cheapest, result, time.Now())
+		}
+	}
+	rl.logDecision(userID, result, nil)
+
+	return chosen, result, nil
+}
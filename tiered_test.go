@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestAllowTiered tests that the first affordable cost is charged and that nothing
+// is charged when no tier is affordable
+func TestAllowTiered(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.01, 6.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	userID := "test_user_tiered"
+	costs := []float64{5, 1}
+	tests := []struct {
+		chosen    float64
+		remaining float64
+	}{
+		{5, 1}, // full tier from the fresh bucket
+		{1, 0}, // degraded tier once the full one is unaffordable
+		{0, 0}, // neither tier fits, nothing is charged
+	}
+	for i, tt := range tests {
+		chosen, result, err := limiter.AllowTiered(userID, costs)
+		if err != nil {
+			t.Fatalf("Call %d: error calling AllowTiered: %v", i+1, err)
+		}
+		if chosen != tt.chosen || result.Allowed != (tt.chosen > 0) || result.Remaining < tt.remaining-0.01 || result.Remaining > tt.remaining+0.01 {
+			t.Errorf("Call %d: expected tier %v with %v remaining, got %v and %+v", i+1, tt.chosen, tt.remaining, chosen, result)
+		}
+	}
+
+	// The retry-after of a blocked call is the wait for the cheapest tier
+	_, result, _ := limiter.AllowTiered(userID, costs)
+	if result.RetryAfter < 99*time.Second || result.RetryAfter > 101*time.Second {
+		t.Errorf("Expected a retry-after of about 100s for 1 token at 0.01/s, got %v", result.RetryAfter)
+	}
+}
+
+// TestAllowTieredOverCapacity tests that tiers costing more than the capacity are
+// skipped, even when debt would cover them
+func TestAllowTieredOverCapacity(t *testing.T) {
+	base, cleanup, err := setupTestRateLimiter(1.0, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	limiter := NewRateLimiter(base.manager, 0.01, 3.0, WithAllowDebt(10))
+	userID := "test_user_tiered_capacity"
+	limiter.manager.GetClient(userID).Del(testCtx, testBucketKey(userID))
+
+	chosen, result, err := limiter.AllowTiered(userID, []float64{5, 2})
+	if err != nil {
+		t.Fatalf("Error calling AllowTiered: %v", err)
+	}
+	if chosen != 2 || result.Remaining < 0.99 || result.Remaining > 1.01 {
+		t.Errorf("Expected the tier of 2 charged with 1 remaining, got %v and %+v", chosen, result)
+	}
+}
+
+// TestAllowTieredValidation tests that empty and non-positive tiers are rejected
+func TestAllowTieredValidation(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(1.0, 5.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	for _, costs := range [][]float64{nil, {5, 0}, {-1}} {
+		if _, _, err := limiter.AllowTiered("test_user_tiered_invalid", costs); err == nil {
+			t.Errorf("Expected an error for costs %v", costs)
+		}
+	}
+}
+
+// TestAllowTieredBlockedCache tests that a blocked tiered check is cached and answers
+// the next ones without reaching Redis
+func TestAllowTieredBlockedCache(t *testing.T) {
+	base, cleanup, err := setupTestRateLimiter(1.0, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	limiter := NewRateLimiter(base.manager, 0.01, 2.0, WithBlockedCache(10))
+	userID := "test_user_tiered_cache"
+	client := limiter.manager.GetClient(userID)
+	client.Del(testCtx, testBucketKey(userID))
+
+	costs := []float64{5, 2}
+	if chosen, _, err := limiter.AllowTiered(userID, costs); err != nil || chosen != 2 {
+		t.Fatalf("Expected the degraded tier from the fresh bucket, got %v (%v)", chosen, err)
+	}
+	if chosen, result, err := limiter.AllowTiered(userID, costs); err != nil || chosen != 0 || result.Allowed {
+		t.Fatalf("Expected no affordable tier, got %v and %+v (%v)", chosen, result, err)
+	}
+
+	// The refilled bucket isn't seen while the decision is cached
+	client.Del(testCtx, testBucketKey(userID))
+	if chosen, result, err := limiter.AllowTiered(userID, costs); err != nil || chosen != 0 || result.RetryAfter <= 0 {
+		t.Errorf("Expected the cached blocked decision, got %v and %+v (%v)", chosen, result, err)
+	}
+	if exists, _ := client.Exists(testCtx, testBucketKey(userID)).Result(); exists != 0 {
+		t.Error("Expected the cached decision not to reach Redis")
+	}
+}
+
+// TestAllowTieredShardBusy tests that tiered checks wait for a slot of the shard's
+// concurrency cap
+func TestAllowTieredShardBusy(t *testing.T) {
+	base, cleanup, err := setupTestRateLimiter(1.0, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	limiter := NewRateLimiter(base.manager, 1.0, 5.0, WithMaxConcurrentChecks(1, 10*time.Millisecond))
+	userID := "test_user_tiered_busy"
+	release, err := limiter.acquireShard(testCtx, limiter.manager, limiter.manager.shardIndex(userID))
+	if err != nil {
+		t.Fatalf("Failed to take the shard's slot: %v", err)
+	}
+
+	if _, _, err := limiter.AllowTiered(userID, []float64{1}); !errors.Is(err, ErrShardBusy) {
+		t.Errorf("Expected ErrShardBusy while the slot is taken, got %v", err)
+	}
+	release()
+	if chosen, _, err := limiter.AllowTiered(userID, []float64{1}); err != nil || chosen != 1 {
+		t.Errorf("Expected the check through once the slot is free, got %v (%v)", chosen, err)
+	}
+}
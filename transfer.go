@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrInsufficientTokens is returned by Transfer when the source bucket holds fewer tokens than requested
+var ErrInsufficientTokens = errors.New("insufficient tokens")
+
+// tokenTransferLuaScript is the Lua script for atomically moving tokens between two buckets
+// KEYS[1] = source bucket, KEYS[2] = destination bucket; ARGV as for tokenBucketLuaScript
+const tokenTransferLuaScript = `
+local rate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local now = serverTime()
+local transferred = tonumber(ARGV[3])
+local initial = tonumber(ARGV[4])
+local ttl = tonumber(ARGV[5])
+local ttlUnit = ARGV[6]
+local trackCreatedAt = ARGV[7] == '1'
+
+-- Load a bucket and apply the refill owed since its last update
+local function load(key)
+    local storedTokens, storedLastRefill, createdAt = readBucket(key)
+    local tokens = tonumber(storedTokens) or initial
+    local lastRefill = tonumber(storedLastRefill) or now
+    local elapsed = now - lastRefill
+    if elapsed > 0 then
+        tokens = math.min(capacity, tokens + elapsed * rate)
+    end
+    if not storedTokens and trackCreatedAt then
+        createdAt = now
+    end
+    return tokens, math.max(lastRefill, now), createdAt
+end
+
+-- Write a bucket back and refresh its inactivity TTL
+local function store(key, tokens, lastRefill, createdAt)
+    writeBucket(key, tokens, lastRefill, createdAt)
+    if ttlUnit == 'ms' then
+        redis.call('PEXPIRE', key, ttl)
+    else
+        redis.call('EXPIRE', key, ttl)
+    end
+end
+
+local fromTokens, fromLastRefill, fromCreatedAt = load(KEYS[1])
+if fromTokens < transferred then
+    return {0, tostring(fromTokens)}
+end
+local toTokens, toLastRefill, toCreatedAt = load(KEYS[2])
+
+-- Move the tokens, anything above the destination's capacity is dropped
+store(KEYS[1], fromTokens - transferred, fromLastRefill, fromCreatedAt)
+store(KEYS[2], math.min(capacity, toTokens + transferred), toLastRefill, toCreatedAt)
+
+return {1, tostring(fromTokens - transferred)}
+`
+
+// Transfer atomically moves n tokens from fromUserID's bucket to toUserID's bucket,
+// e.g. to reallocate unused team quota. The destination is capped at capacity, so
+// tokens beyond it are lost. ErrInsufficientTokens is returned, and nothing is
+// moved, if the source holds fewer than n tokens.
+//
+// The script touches both buckets, so both must live on the same shard: colocate
+// them with a shared hash tag, e.g. "{team1}:alice" and "{team1}:bob". Transfer
+// returns an error without touching Redis for userIDs on different shards.
+func (rl *RateLimiter) Transfer(fromUserID, toUserID string, n float64) error {
+	if n <= 0 {
+		return fmt.Errorf("transfer amount must be positive, got %v", n)
+	}
+	if fromUserID == toUserID {
+		return fmt.Errorf("cannot transfer tokens from userID %s to itself", fromUserID)
+	}
+	if !rl.manager.colocated(fromUserID, toUserID) {
+		return fmt.Errorf("userIDs %s and %s are on different shards, colocate them with a shared {hash tag}", fromUserID, toUserID)
+	}
+
+	keys := []string{rl.bucketKey(fromUserID), rl.bucketKey(toUserID)}
+
+	script := rl.bucketScript(tokenTransferLuaScript)
+	result, err := rl.manager.runOnShard(ctx, fromUserID, script, keys, rl.bucketArgs(n)...).Result()
+	if err != nil {
+		log.Printf("ERROR: Critical Redis Error: Lua transfer script execution failure for userIDs %s -> %s - %v", fromUserID, toUserID, err)
+		return fmt.Errorf("failed to execute transfer script: %w", err)
+	}
+
+	// The result has the same {ok, tokens} shape as the token bucket script
+	transfer, err := rl.parseAllowReply(fromUserID, result)
+	if err != nil {
+		return err
+	}
+	if !transfer.Allowed {
+		return fmt.Errorf("failed to transfer %v tokens from userID %s with %.2f available: %w", n, fromUserID, transfer.Remaining, ErrInsufficientTokens)
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// TestTransfer tests that tokens move atomically and the destination is capped at capacity
+func TestTransfer(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.001, 10.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	from, to := "test_{team1}:alice", "test_{team1}:bob"
+
+	// alice uses 2 tokens, bob uses 8
+	if _, err := limiter.AllowN(from, 2); err != nil {
+		t.Fatalf("AllowN failed: %v", err)
+	}
+	if _, err := limiter.AllowN(to, 8); err != nil {
+		t.Fatalf("AllowN failed: %v", err)
+	}
+
+	if err := limiter.Transfer(from, to, 5); err != nil {
+		t.Fatalf("Transfer failed: %v", err)
+	}
+	assertTokens(t, limiter, from, 3)
+	assertTokens(t, limiter, to, 7)
+
+	// bob can receive at most 3 more tokens, the rest is dropped
+	if err := limiter.Transfer(from, to, 3); err != nil {
+		t.Fatalf("Transfer failed: %v", err)
+	}
+	assertTokens(t, limiter, from, 0)
+	assertTokens(t, limiter, to, 10)
+
+	// alice has nothing left to give, and nothing changes
+	err = limiter.Transfer(from, to, 1)
+	if !errors.Is(err, ErrInsufficientTokens) {
+		t.Fatalf("Expected ErrInsufficientTokens, got %v", err)
+	}
+	assertTokens(t, limiter, from, 0)
+}
+
+// TestTransferDifferentShards tests that userIDs on different shards are rejected
+func TestTransferDifferentShards(t *testing.T) {
+	manager := &RedisShardManager{shards: []*redis.Client{
+		redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"}),
+		redis.NewClient(&redis.Options{Addr: "127.0.0.1:2"}),
+	}}
+	limiter := NewRateLimiter(manager, 1, 10)
+
+	// Find two userIDs that hash to different shards
+	other := ""
+	for i := 0; other == ""; i++ {
+		if id := fmt.Sprintf("user%d", i); manager.shardIndex(id) != manager.shardIndex("user") {
+			other = id
+		}
+	}
+
+	if err := limiter.Transfer("user", other, 1); err == nil {
+		t.Errorf("Expected an error for userIDs on different shards")
+	}
+}
+
+// TestHashTag tests the part of userIDs used for shard selection
+func TestHashTag(t *testing.T) {
+	tests := map[string]string{
+		"alice":         "alice",
+		"{team1}:alice": "team1",
+		"org:{team1}:x": "team1",
+		"{}:alice":      "{}:alice",
+		"{team1:alice":  "{team1:alice",
+	}
+	for userID, expected := range tests {
+		if got := hashTag(userID); got != expected {
+			t.Errorf("hashTag(%q) = %q, expected %q", userID, got, expected)
+		}
+	}
+}
+
+// assertTokens checks the tokens of userID's bucket, allowing for refill during the test
+func assertTokens(t *testing.T, limiter *RateLimiter, userID string, expected float64) {
+	t.Helper()
+	state, err := limiter.PeekState(userID)
+	if err != nil {
+		t.Fatalf("PeekState failed: %v", err)
+	}
+	if state.Tokens < expected-0.01 || state.Tokens > expected+0.01 {
+		t.Errorf("Expected %s to have %.2f tokens, got %.4f", userID, expected, state.Tokens)
+	}
+}
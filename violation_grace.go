@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// violationKey returns the Redis key of the given userID's violation counter
+func (rl *RateLimiter) violationKey(userID string) string {
+	return fmt.Sprintf("ratelimit:violations:%s", rl.manager.keyUserID(userID))
+}
+
+// AddViolation records a request of userID that exceeded the limit, returning the number
+// of violations within the current window
+func (rl *RateLimiter) AddViolation(ctx context.Context, userID string, window time.Duration) (int64, error) {
+	return rl.incrWindowCounter(ctx, userID, rl.violationKey(userID), window)
+}
+
+// violationWindow returns the violation grace window, defaulting to the time an empty
+// bucket takes to refill completely
+func (o *MiddlewareOptions) violationWindow(limiter *RateLimiter) time.Duration {
+	if o.ViolationWindow > 0 {
+		return o.ViolationWindow
+	}
+	return refillWindow(limiter)
+}
+
+// refillWindow returns the time an empty bucket of limiter takes to refill completely,
+// in whole seconds and at least one
+func refillWindow(limiter *RateLimiter) time.Duration {
+	seconds := math.Ceil(limiter.Capacity() / limiter.Rate())
+	if math.IsInf(seconds, 0) || math.IsNaN(seconds) || seconds < 1 {
+		seconds = 1
+	}
+	return time.Duration(seconds) * time.Second
+}
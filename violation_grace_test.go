@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestMiddlewareViolationGrace tests the transition from graced violations to 429
+func TestMiddlewareViolationGrace(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.01, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	client := limiter.manager.GetClient(testClientIP)
+	client.Del(testCtx, limiter.bucketKey(testClientIP), limiter.violationKey(testClientIP))
+	defer client.Del(testCtx, limiter.bucketKey(testClientIP), limiter.violationKey(testClientIP))
+
+	app := newTestApp(RateLimitMiddleware(limiter, WithViolationGrace(2, time.Minute)))
+
+	tests := []struct {
+		status  int
+		warning string
+	}{
+		{fiber.StatusOK, ""},
+		{fiber.StatusOK, "rate limit exceeded, 1 of 2 grace requests used"},
+		{fiber.StatusOK, "rate limit exceeded, 2 of 2 grace requests used"},
+		{fiber.StatusTooManyRequests, ""},
+		{fiber.StatusTooManyRequests, ""},
+	}
+
+	for i, tt := range tests {
+		resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("Request %d: expected status %d, got %d", i+1, tt.status, resp.StatusCode)
+		}
+		if got := resp.Header.Get("X-RateLimit-Warning"); got != tt.warning {
+			t.Errorf("Request %d: expected warning %q, got %q", i+1, tt.warning, got)
+		}
+	}
+
+	// Graced requests don't consume tokens and the violations are counted in Redis
+	violations, err := client.Get(testCtx, limiter.violationKey(testClientIP)).Int()
+	if err != nil {
+		t.Fatalf("Failed to read violation counter: %v", err)
+	}
+	if violations != 4 {
+		t.Errorf("Expected 4 recorded violations, got %d", violations)
+	}
+
+	// A new window restores the grace
+	client.Del(testCtx, limiter.violationKey(testClientIP))
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusOK || resp.Header.Get("X-RateLimit-Warning") == "" {
+		t.Errorf("Expected a graced request in the new window, got status %d", resp.StatusCode)
+	}
+}
+
+// TestViolationGraceNotBlocked tests that graced requests are neither penalized nor
+// told to retry, unlike the requests rejected after the grace
+func TestViolationGraceNotBlocked(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.01, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	keys := []string{limiter.bucketKey(testClientIP), limiter.violationKey(testClientIP), limiter.penaltyKey(testClientIP)}
+	client := limiter.manager.GetClient(testClientIP)
+	client.Del(testCtx, keys...)
+	defer client.Del(testCtx, keys...)
+
+	tarpit := TarpitConfig{Threshold: 10, Step: time.Millisecond, MaxDelay: time.Millisecond, Window: time.Minute}
+	app := newTestApp(RateLimitMiddleware(limiter, WithViolationGrace(1, time.Minute), WithTarpit(tarpit)))
+
+	tests := []struct {
+		status     int
+		retryAfter bool
+		penalties  int64
+	}{
+		{fiber.StatusOK, false, 0},
+		{fiber.StatusOK, false, 0},
+		{fiber.StatusTooManyRequests, true, 1},
+	}
+	for i, tt := range tests {
+		resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("Request %d: expected status %d, got %d", i+1, tt.status, resp.StatusCode)
+		}
+		if got := resp.Header.Get("X-RateLimit-Retry-After") != ""; got != tt.retryAfter {
+			t.Errorf("Request %d: expected retry-after header %v, got %v", i+1, tt.retryAfter, got)
+		}
+		if penalties, _ := limiter.Penalties(testCtx, testClientIP); penalties != tt.penalties {
+			t.Errorf("Request %d: expected %d penalties, got %d", i+1, tt.penalties, penalties)
+		}
+	}
+}
+
+// TestViolationWindowDefault tests that the default window is the time to refill the bucket
+func TestViolationWindowDefault(t *testing.T) {
+	limiter := NewRateLimiter(&RedisShardManager{}, 2, 10)
+	options := newMiddlewareOptions([]Option{WithViolationGrace(3, 0)})
+	if got := options.violationWindow(limiter); got != 5*time.Second {
+		t.Errorf("Expected a 5s window, got %v", got)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// warmupConcurrency bounds the number of shards loaded concurrently by Warmup
+const warmupConcurrency = 8
+
+// bucketScripts are the Lua scripts reading or writing buckets, which run on top of
+// the accessors of the limiter's BucketStorage
+var bucketScripts = []string{
+	tokenBucketLuaScript,
+	tokenRefundLuaScript,
+	tokenPeekLuaScript,
+	tokenTransferLuaScript,
+	tokenReserveLuaScript,
+	tokenCancelLuaScript,
+	compositeLuaScript,
+	bucketTakeLuaScript,
+	bucketMergeLuaScript,
+	tokenTieredLuaScript,
+}
+
+// counterScripts are the other Lua scripts run by the limiter, as sent to Redis
+var counterScripts = []string{
+	windowCounterLuaScript,
+	distinctLuaScript,
+	dedupLuaScript,
+	serverTimeLuaScript + slidingWindowLuaScript,
+	serverTimeLuaScript + fixedWindowLuaScript,
+	serverTimeLuaScript + leakyBucketLuaScript,
+}
+
+// scripts returns the sources of every Lua script run by the limiter, preloaded by Warmup
+func (rl *RateLimiter) scripts() []string {
+	return storageScripts(rl.storage)
+}
+
+// storageScripts returns the sources of every Lua script run by a limiter storing its
+// buckets with storage
+func storageScripts(storage BucketStorage) []string {
+	scripts := make([]string, 0, len(bucketScripts)+len(counterScripts))
+	for _, src := range bucketScripts {
+		scripts = append(scripts, serverTimeLuaScript+storage.Lua()+src)
+	}
+	return append(scripts, counterScripts...)
+}
+
+// preloadScripts loads the scripts of limiters with the default HashStorage into the
+// given shards, best effort: a shard that fails is logged and falls back to EVAL on
+// its first checks
+func preloadScripts(clients []*redis.Client) {
+	for i, err := range loadShards(ctx, clients, storageScripts(HashStorage{})) {
+		if err != nil {
+			log.Printf("WARNING: Failed to preload scripts into shard %d - %v", i, err)
+		}
+	}
+}
+
+// Warmup loads the limiter's Lua scripts into every shard with SCRIPT LOAD, so the
+// first requests don't pay for sending the full script and a broken shard is found
+// at boot. NewRedisShardManager already preloads the scripts of the default bucket
+// storage, best effort; Warmup also covers other storages and the masters of a
+// cluster, and reports failures. Shards are loaded concurrently by a bounded pool of
+// workers, so startup time doesn't grow with the shard count. All shard failures are
+// returned together.
+func (rl *RateLimiter) Warmup(ctx context.Context) error {
+	clients := rl.manager.Shards()
+	errs := loadShards(ctx, clients, rl.scripts())
+	for i, err := range errs {
+		if err != nil {
+			errs[i] = fmt.Errorf("failed to load scripts into shard %d: %w", i, err)
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		log.Printf("ERROR: Critical Redis Error: Script warmup failed - %v", err)
+		return err
+	}
+
+	log.Printf("INFO: Loaded %d scripts into %d shards", len(rl.scripts()), len(clients))
+	return nil
+}
+
+// loadShards loads the given scripts into every client concurrently, by a bounded pool
+// of workers, returning the error of each client
+func loadShards(ctx context.Context, clients []*redis.Client, scripts []string) []error {
+	shards := make(chan int)
+	errs := make([]error, len(clients))
+
+	var wg sync.WaitGroup
+	for w := 0; w < warmupConcurrency && w < len(clients); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range shards {
+				errs[i] = loadScripts(ctx, clients[i], scripts)
+			}
+		}()
+	}
+	for i := range clients {
+		shards <- i
+	}
+	close(shards)
+	wg.Wait()
+	return errs
+}
+
+// loadScripts loads the given scripts into one shard in a single round trip
+func loadScripts(ctx context.Context, client *redis.Client, scripts []string) error {
+	pipe := client.Pipeline()
+	for _, src := range scripts {
+		redis.NewScript(src).Load(ctx, pipe)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
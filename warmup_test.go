@@ -0,0 +1,121 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// TestWarmup tests that every script is cached on the shards after Warmup
+func TestWarmup(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(5.0, 10.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	for _, shard := range limiter.manager.shards {
+		shard.ScriptFlush(testCtx)
+	}
+
+	if err := limiter.Warmup(testCtx); err != nil {
+		t.Fatalf("Warmup failed: %v", err)
+	}
+
+	scripts := limiter.scripts()
+	hashes := make([]string, len(scripts))
+	for i, src := range scripts {
+		hashes[i] = redis.NewScript(src).Hash()
+	}
+	for i, shard := range limiter.manager.shards {
+		exists, err := shard.ScriptExists(testCtx, hashes...).Result()
+		if err != nil {
+			t.Fatalf("ScriptExists failed: %v", err)
+		}
+		for j, ok := range exists {
+			if !ok {
+				t.Errorf("Expected script %d to be loaded on shard %d", j, i)
+			}
+		}
+	}
+}
+
+// TestShardManagerPreloadsScripts tests that creating a shard manager loads the scripts
+// of the default bucket storage into its shards
+func TestShardManagerPreloadsScripts(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(5.0, 10.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+	for _, shard := range limiter.manager.shards {
+		shard.ScriptFlush(testCtx)
+	}
+
+	manager, err := NewRedisShardManagerFromURLs([]string{"redis://localhost:6379/0"})
+	if err != nil {
+		t.Fatalf("Failed to create shard manager: %v", err)
+	}
+	defer manager.Close()
+
+	scripts := storageScripts(HashStorage{})
+	hashes := make([]string, len(scripts))
+	for i, src := range scripts {
+		hashes[i] = redis.NewScript(src).Hash()
+	}
+	exists, err := manager.shards[0].ScriptExists(testCtx, hashes...).Result()
+	if err != nil {
+		t.Fatalf("ScriptExists failed: %v", err)
+	}
+	for i, ok := range exists {
+		if !ok {
+			t.Errorf("Expected script %d to be preloaded", i)
+		}
+	}
+}
+
+// TestWarmupCoversScripts tests that the scripts precomputed for the other limiters
+// are among those Warmup loads
+func TestWarmupCoversScripts(t *testing.T) {
+	loaded := make(map[string]bool)
+	for _, src := range NewRateLimiter(nil, 1.0, 1.0).scripts() {
+		loaded[redis.NewScript(src).Hash()] = true
+	}
+	for name, script := range map[string]*redis.Script{
+		"window counter": windowCounterScript,
+		"distinct":       distinctScript,
+		"dedup":          dedupScript,
+		"sliding window": slidingWindowScript,
+		"fixed window":   fixedWindowScript,
+		"leaky bucket":   leakyBucketScript,
+	} {
+		if !loaded[script.Hash()] {
+			t.Errorf("Expected the %s script to be loaded by Warmup", name)
+		}
+	}
+}
+
+// TestWarmupAggregatesErrors tests that the failures of all shards are reported
+func TestWarmupAggregatesErrors(t *testing.T) {
+	shards := make([]*redis.Client, 12)
+	for i := range shards {
+		shards[i] = redis.NewClient(&redis.Options{
+			Addr:        "127.0.0.1:1",
+			DialTimeout: 100 * time.Millisecond,
+			MaxRetries:  -1,
+		})
+	}
+	limiter := NewRateLimiter(&RedisShardManager{shards: shards}, 5.0, 10.0)
+
+	err := limiter.Warmup(testCtx)
+	if err == nil {
+		t.Fatalf("Expected Warmup to fail for unreachable shards")
+	}
+	for _, shard := range []string{"shard 0:", "shard 11:"} {
+		if !strings.Contains(err.Error(), shard) {
+			t.Errorf("Expected the error to mention %s, got %v", shard, err)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BlockEvent is the JSON body posted to the block webhook
+type BlockEvent struct {
+	UserID       string    `json:"userID"`
+	Timestamp    time.Time `json:"timestamp"`
+	RecentBlocks int64     `json:"recentBlocks"` // blocks of the user within the webhook window
+	Shard        int       `json:"shard"`
+}
+
+// WebhookConfig configures the block webhook, zero fields use the defaults
+type WebhookConfig struct {
+	URL          string        // endpoint receiving the events
+	MinBlocks    int64         // recent blocks before a user is reported (default 1)
+	Window       time.Duration // window over which blocks are counted (default 1 minute)
+	QueueSize    int           // pending events before new ones are dropped (default 1000)
+	MaxRetries   int           // delivery retries after the first attempt (default 3, negative for none)
+	RetryBackoff time.Duration // delay before the first retry, doubled after each (default 500ms)
+	Timeout      time.Duration // timeout of each delivery attempt (default 5s)
+	Client       *http.Client  // HTTP client used for deliveries (default http.DefaultClient)
+}
+
+// blockNotice is a block queued for the webhook worker
+type blockNotice struct {
+	limiter *RateLimiter
+	userID  string
+	at      time.Time
+}
+
+// BlockWebhook reports blocked users to an external sink, such as a SIEM, from a
+// background worker. Blocks are queued without waiting, so a slow or failing sink
+// never delays request handling; when the queue is full, new blocks are dropped.
+type BlockWebhook struct {
+	config  WebhookConfig
+	queue   chan blockNotice
+	done    chan struct{}
+	closing sync.Once
+	dropped atomic.Int64
+}
+
+// NewBlockWebhook starts the worker delivering block events to cfg.URL
+func NewBlockWebhook(cfg WebhookConfig) *BlockWebhook {
+	if cfg.MinBlocks <= 0 {
+		cfg.MinBlocks = 1
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = time.Minute
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1000
+	}
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	} else if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = 3
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = 500 * time.Millisecond
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+
+	w := &BlockWebhook{
+		config: cfg,
+		queue:  make(chan blockNotice, cfg.QueueSize),
+		done:   make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// notify queues a block of userID without waiting, reporting whether it was queued
+func (w *BlockWebhook) notify(limiter *RateLimiter, userID string) (queued bool) {
+	defer func() {
+		// Blocks racing with Close are dropped like those of a full queue
+		if recover() != nil {
+			queued = false
+		}
+	}()
+
+	select {
+	case w.queue <- blockNotice{limiter: limiter, userID: userID, at: time.Now()}:
+		return true
+	default:
+		if dropped := w.dropped.Add(1); dropped == 1 || dropped%1000 == 0 {
+			log.Printf("WARNING: Block webhook queue full, %d events dropped so far", dropped)
+		}
+		return false
+	}
+}
+
+// Dropped returns the number of blocks dropped because the queue was full
+func (w *BlockWebhook) Dropped() int64 {
+	return w.dropped.Load()
+}
+
+// Close stops accepting blocks and waits until the queued ones are delivered or ctx ends
+func (w *BlockWebhook) Close(ctx context.Context) error {
+	w.closing.Do(func() {
+		close(w.queue)
+	})
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to drain block webhook queue: %w", ctx.Err())
+	}
+}
+
+// run counts each queued block and delivers the events of users over MinBlocks
+func (w *BlockWebhook) run() {
+	defer close(w.done)
+	for notice := range w.queue {
+		key := fmt.Sprintf("ratelimit:blocks:%s", notice.limiter.manager.keyUserID(notice.userID))
+		blocks, err := notice.limiter.incrWindowCounter(context.Background(), notice.userID, key, w.config.Window)
+		if err != nil {
+			log.Printf("WARNING: Failed to count blocks for userID %s - %v", notice.userID, err)
+			continue
+		}
+		if blocks < w.config.MinBlocks {
+			continue
+		}
+
+		w.deliver(BlockEvent{
+			UserID:       notice.userID,
+			Timestamp:    notice.at,
+			RecentBlocks: blocks,
+			Shard:        notice.limiter.manager.shardIndex(notice.userID),
+		})
+	}
+}
+
+// deliver posts the event, retrying with exponential backoff on network errors,
+// 429 and 5xx responses
+func (w *BlockWebhook) deliver(event BlockEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("ERROR: Failed to encode block event for userID %s - %v", event.UserID, err)
+		return
+	}
+
+	backoff := w.config.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		retryable, err := w.post(body)
+		if err == nil {
+			return
+		}
+		if !retryable || attempt == w.config.MaxRetries {
+			log.Printf("WARNING: Failed to deliver block event for userID %s after %d attempts - %v", event.UserID, attempt+1, err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post sends one delivery attempt, reporting whether a failure is worth retrying
+func (w *BlockWebhook) post(body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), w.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.config.Client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("failed to post webhook: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retryable, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return false, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// newWebhookSink starts a server recording delivered events, failing the first failures attempts
+func newWebhookSink(t *testing.T, failures int32) (*httptest.Server, func() []BlockEvent) {
+	var mu sync.Mutex
+	var events []BlockEvent
+	var attempts atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var event BlockEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("Failed to decode event: %v", err)
+		}
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []BlockEvent {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]BlockEvent(nil), events...)
+	}
+}
+
+// TestBlockWebhookDelivery tests that blocked users are reported once over MinBlocks, retrying failed deliveries
+func TestBlockWebhookDelivery(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.01, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	client := limiter.manager.GetClient(testClientIP)
+	for _, key := range []string{testBucketKey(testClientIP), "ratelimit:blocks:" + testClientIP} {
+		client.Del(testCtx, key)
+		defer client.Del(testCtx, key)
+	}
+
+	server, events := newWebhookSink(t, 2)
+	webhook := NewBlockWebhook(WebhookConfig{URL: server.URL, MinBlocks: 2, RetryBackoff: time.Millisecond})
+	app := newTestApp(RateLimitMiddleware(limiter, WithBlockWebhook(webhook)))
+
+	// One allowed request, then three blocked ones
+	for i := 0; i < 4; i++ {
+		if _, err := app.Test(httptest.NewRequest("GET", "/", nil)); err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := webhook.Close(ctx); err != nil {
+		t.Fatalf("Failed to close webhook: %v", err)
+	}
+
+	// The first block is below MinBlocks; the second is delivered after two failed attempts
+	got := events()
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 events, got %d: %+v", len(got), got)
+	}
+	for i, event := range got {
+		if event.UserID != testClientIP || event.RecentBlocks != int64(i+2) || event.Timestamp.IsZero() {
+			t.Errorf("Unexpected event %d: %+v", i, event)
+		}
+		if event.Shard != limiter.manager.shardIndex(testClientIP) {
+			t.Errorf("Expected shard %d, got %d", limiter.manager.shardIndex(testClientIP), event.Shard)
+		}
+	}
+}
+
+// TestBlockWebhookQueueFull tests that a slow sink drops blocks instead of delaying requests
+func TestBlockWebhookQueueFull(t *testing.T) {
+	limiter, cleanup, err := setupTestRateLimiter(0.01, 1.0)
+	if err != nil {
+		t.Fatalf("Failed to setup test rate limiter: %v", err)
+	}
+	defer cleanup()
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+
+	webhook := NewBlockWebhook(WebhookConfig{URL: server.URL, QueueSize: 1, MaxRetries: -1})
+	userID := "test_user_webhook_queue"
+	defer limiter.manager.GetClient(userID).Del(testCtx, "ratelimit:blocks:"+userID)
+
+	start := time.Now()
+	queued := 0
+	for i := 0; i < 10; i++ {
+		if webhook.notify(limiter, userID) {
+			queued++
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Expected notify not to wait for the sink, took %v", elapsed)
+	}
+	if queued >= 10 || webhook.Dropped() != int64(10-queued) {
+		t.Errorf("Expected blocks to be dropped, queued %d, dropped %d", queued, webhook.Dropped())
+	}
+
+	close(release)
+	if err := webhook.Close(context.Background()); err != nil {
+		t.Fatalf("Failed to close webhook: %v", err)
+	}
+	if webhook.notify(limiter, userID) {
+		t.Error("Expected blocks after Close to be dropped")
+	}
+}
+
+// TestBlockWebhookNotRetried tests that client errors aren't retried
+func TestBlockWebhookNotRetried(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(fiber.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	webhook := NewBlockWebhook(WebhookConfig{URL: server.URL, RetryBackoff: time.Millisecond})
+	webhook.deliver(BlockEvent{UserID: "test_user_webhook_client_error"})
+	webhook.Close(context.Background())
+
+	if got := attempts.Load(); got != 1 {
+		t.Errorf("Expected 1 attempt for a 400 response, got %d", got)
+	}
+}